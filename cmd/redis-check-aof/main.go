@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lojhan/redis-clone/internal/persistence"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the AOF at the last valid command")
+	timestamp := flag.Int64("truncate-to-timestamp", 0, "truncate the AOF before the first command logged after this unix time")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: redis-check-aof [--fix|--truncate-to-timestamp <unix>] <file.manifest|file.aof>")
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || (*fix && *timestamp != 0) {
+		flag.Usage()
+		os.Exit(1)
+	}
+	path := flag.Arg(0)
+
+	files := []string{path}
+	if strings.HasSuffix(path, ".manifest") {
+		dir, name := filepath.Dir(path), strings.TrimSuffix(filepath.Base(path), ".manifest")
+		m, err := persistence.LoadManifest(dir, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read manifest: %v\n", err)
+			os.Exit(1)
+		}
+		files = files[:0]
+		for _, info := range m.Files() {
+			files = append(files, filepath.Join(dir, info.Name))
+		}
+		fmt.Printf("Checking multi part AOF %s: %d files\n", path, len(files))
+	}
+
+	for i, file := range files {
+		var check *persistence.AOFCheck
+		var err error
+		if *timestamp != 0 {
+			check, err = persistence.CheckAOFUntil(file, *timestamp)
+		} else {
+			check, err = persistence.CheckAOF(file)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot check AOF file %s: %v\n", file, err)
+			os.Exit(1)
+		}
+		report(file, check)
+		if check.PastTimestamp {
+			truncateToTimestamp(file, check, *timestamp, i == len(files)-1)
+			return
+		}
+		if check.Err == nil {
+			continue
+		}
+
+		if !*fix {
+			fmt.Println("AOF is not valid. Use the --fix option to try fixing it.")
+			os.Exit(1)
+		}
+		// Truncating any file but the last would lose the commands after it.
+		if !check.Fixable || i != len(files)-1 {
+			fmt.Printf("AOF %s can't be fixed by truncating it.\n", file)
+			os.Exit(1)
+		}
+		if err := os.Truncate(file, check.Valid); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to truncate AOF: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Successfully truncated AOF %s to %d bytes\n", file, check.Valid)
+		return
+	}
+	fmt.Println("AOF is valid")
+	if *timestamp != 0 {
+		fmt.Printf("No commands were logged after timestamp %d, nothing to truncate\n", *timestamp)
+	}
+}
+
+func truncateToTimestamp(file string, check *persistence.AOFCheck, timestamp int64, last bool) {
+	// Cutting an earlier file would leave the later ones replaying on top.
+	if !last {
+		fmt.Printf("AOF %s has commands logged after timestamp %d, but only the last file can be truncated.\n", file, timestamp)
+		os.Exit(1)
+	}
+	if err := os.Truncate(file, check.Valid); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to truncate AOF: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Successfully truncated AOF %s to timestamp %d (%d bytes)\n", file, timestamp, check.Valid)
+}
+
+func report(file string, check *persistence.AOFCheck) {
+	if check.Preamble != nil {
+		fmt.Printf("[info] %s has an RDB preamble: version %d, %d keys\n",
+			file, check.Preamble.Version, check.Preamble.TotalKeys())
+	}
+
+	if check.Err != nil {
+		var rdbErr *persistence.RDBError
+		if errors.As(check.Err, &rdbErr) {
+			fmt.Printf("[offset %d] RDB preamble error: %v\n", rdbErr.Offset, rdbErr.Err)
+			if rdbErr.Key != "" {
+				fmt.Printf("[additional info] Reading key '%s'\n", rdbErr.Key)
+			}
+		} else {
+			fmt.Printf("[offset %d] %v\n", check.ErrOffset, check.Err)
+		}
+	}
+
+	fmt.Printf("AOF analyzed: filename=%s, size=%d, commands=%d, ok_up_to=%d, diff=%d\n",
+		file, check.Size, check.Commands, check.Valid, check.Size-check.Valid)
+}
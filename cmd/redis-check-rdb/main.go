@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/lojhan/redis-clone/internal/persistence"
+)
+
+func main() {
+	if len(os.Args) != 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Fprintln(os.Stderr, "Usage: redis-check-rdb <rdb-file-name>")
+		os.Exit(1)
+	}
+	file := os.Args[1]
+
+	fmt.Printf("[info] Checking RDB file %s\n", file)
+	stats, err := persistence.CheckRDB(file)
+	if stats == nil {
+		fmt.Fprintf(os.Stderr, "Cannot check RDB file: %v\n", err)
+		os.Exit(1)
+	}
+
+	printRDBStats(stats)
+
+	if err != nil {
+		fmt.Println("--- RDB ERROR DETECTED ---")
+		var rdbErr *persistence.RDBError
+		if errors.As(err, &rdbErr) {
+			fmt.Printf("[offset %d] %v\n", rdbErr.Offset, rdbErr.Err)
+			if rdbErr.Key != "" {
+				fmt.Printf("[additional info] Reading key '%s'\n", rdbErr.Key)
+			}
+		} else {
+			fmt.Println(err)
+		}
+		fmt.Println("[info] The RDB file is not valid")
+		os.Exit(1)
+	}
+
+	if stats.Checksum == 0 {
+		fmt.Println("[info] RDB file was saved without a checksum")
+	} else {
+		fmt.Printf("[info] Checksum OK: %016x\n", stats.Checksum)
+	}
+	fmt.Println("\\o/ RDB looks OK! \\o/")
+}
+
+func printRDBStats(stats *persistence.RDBStats) {
+	if stats.Version != 0 {
+		fmt.Printf("[info] RDB version %d\n", stats.Version)
+	}
+
+	aux := make([]string, 0, len(stats.Aux))
+	for key := range stats.Aux {
+		aux = append(aux, key)
+	}
+	sort.Strings(aux)
+	for _, key := range aux {
+		fmt.Printf("[info] AUX FIELD %s = '%s'\n", key, stats.Aux[key])
+	}
+
+	fmt.Printf("[info] %d keys read, %d expires, %d already expired\n",
+		stats.TotalKeys(), stats.Expires, stats.AlreadyExpired)
+
+	types := make([]string, 0, len(stats.Keys))
+	for name := range stats.Keys {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	for _, name := range types {
+		fmt.Printf("[info] %s: %d keys\n", name, stats.Keys[name])
+	}
+
+	if stats.Functions > 0 {
+		fmt.Printf("[info] %d function libraries\n", stats.Functions)
+	}
+	if stats.ModuleAux > 0 {
+		fmt.Printf("[info] %d module aux fields skipped\n", stats.ModuleAux)
+	}
+}
@@ -1,57 +1,138 @@
 package main
 
 import (
-	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/lojhan/redis-clone/internal/command"
+	"github.com/lojhan/redis-clone/internal/config"
 	"github.com/lojhan/redis-clone/internal/persistence"
 	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/scripting"
 	"github.com/lojhan/redis-clone/internal/server"
 	"github.com/lojhan/redis-clone/internal/store"
 )
 
 func main() {
-	port := flag.String("port", "6379", "Port to listen on")
-	rdbFile := flag.String("dbfilename", "dump.rdb", "RDB file name")
-	aofFile := flag.String("appendfilename", "appendonly.aof", "AOF file name")
-	useAof := flag.Bool("appendonly", false, "Enable AOF persistence")
-	aofSyncPolicy := flag.String("appendfsync", "everysec", "AOF fsync policy: always, everysec, no")
-	maxMemory := flag.Int64("maxmemory", 0, "Maximum memory in bytes (0 = no limit)")
-	maxMemoryPolicy := flag.String("maxmemory-policy", "noeviction", "Eviction policy: noeviction, allkeys-lru, volatile-lru, allkeys-random, volatile-random, volatile-ttl")
-	maxMemorySamples := flag.Int("maxmemory-samples", 5, "Number of samples for approximate LRU")
-	flag.Parse()
+	cfg := config.New()
+
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+		fmt.Fprintln(os.Stderr, "Usage: redis-server [/path/to/redis.conf] [--directive value ...]")
+		os.Exit(0)
+	}
+
+	var configFile string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		configFile = args[0]
+		args = args[1:]
+	}
+
+	if err := cfg.LoadServerConfig(configFile, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if configFile != "" {
+		log.Printf("Configuration loaded from %s", cfg.File())
+	}
 
 	srv := server.NewServer()
+	srv.SetRequirePass(cfg.Get("requirepass"))
+	srv.SetScriptTimeLimit(time.Duration(cfg.GetInt("lua-time-limit")) * time.Millisecond)
 	dataStore := store.NewStore()
+	srv.SetOOMCheck(dataStore.EvictIfNeeded)
 
-	if *maxMemory > 0 {
-		evictionConfig := store.NewEvictionConfig(
-			*maxMemory,
-			store.EvictionPolicy(*maxMemoryPolicy),
-			*maxMemorySamples,
+	if maxMemory := cfg.GetInt("maxmemory"); maxMemory > 0 {
+		dataStore.ConfigureEviction(
+			maxMemory,
+			store.EvictionPolicy(cfg.Get("maxmemory-policy")),
+			int(cfg.GetInt("maxmemory-samples")),
 		)
-		dataStore.SetEvictionConfig(evictionConfig)
 		log.Printf("Memory eviction enabled: maxmemory=%d bytes, policy=%s, samples=%d",
-			*maxMemory, *maxMemoryPolicy, *maxMemorySamples)
+			maxMemory, cfg.Get("maxmemory-policy"), cfg.GetInt("maxmemory-samples"))
 	}
 
-	dataStore.SetKeyModifiedHandler(srv.MarkKeyModified)
+	persistenceManager := command.NewPersistenceManager(
+		cfg.Get("dir"), cfg.Get("dbfilename"), cfg.Get("appendfilename"), cfg.Get("appenddirname"))
+	persistenceManager.SetSavePoints(command.ParseSavePoints(cfg.Get("save")))
+	persistenceManager.SetStopWritesOnError(cfg.GetBool("stop-writes-on-bgsave-error"))
+	persistenceManager.SetAOFRDBPreamble(cfg.GetBool("aof-use-rdb-preamble"))
+	persistenceManager.SetRDBCompression(cfg.GetBool("rdbcompression"))
+	persistenceManager.SetRDBChecksum(cfg.GetBool("rdbchecksum"))
+	srv.SetWriteCheck(persistenceManager.CheckWrites)
+
+	dataStore.SetKeyModifiedHandler(func(key string) {
+		srv.MarkKeyModified(key)
+		persistenceManager.AddDirty(1)
+	})
+	dataStore.SetKeyRemovedHandler(srv.PropagateDel)
+	srv.SetEffects(command.Effects(dataStore))
 
 	srv.RegisterCommand("PING", command.PingCommand)
 	srv.RegisterCommand("ECHO", command.EchoCommand)
-	srv.RegisterCommand("COMMAND", command.CommandCommand)
-	srv.RegisterCommand("INFO", command.InfoCommand)
-	srv.RegisterCommand("CONFIG", command.ConfigCommand)
+	srv.RegisterCommand("INFO", command.InfoCommandWithSections(command.InfoSection{
+		Name: "persistence",
+		Render: func() string {
+			status := "ok"
+			if !persistenceManager.LastBgSaveOK() {
+				status = "err"
+			}
+			return fmt.Sprintf("# Persistence\r\nrdb_changes_since_last_save:%d\r\nrdb_bgsave_in_progress:%d\r\nrdb_last_save_time:%d\r\nrdb_last_bgsave_status:%s\r\n"+
+				"aof_enabled:%d\r\naof_rewrite_in_progress:%d\r\naof_rewrite_scheduled:%d\r\n",
+				persistenceManager.Dirty(), flag(persistenceManager.BgSaveInProgress()), persistenceManager.LastSave().Unix(), status,
+				flag(cfg.GetBool("appendonly")), flag(persistenceManager.AOFRewriteInProgress()), flag(persistenceManager.AOFRewriteScheduled()))
+		},
+	}, command.InfoSection{
+		Name: "memory",
+		Render: func() string {
+			var mem runtime.MemStats
+			runtime.ReadMemStats(&mem)
+			running, resized, reclaimed := dataStore.ResizeStats()
+			return fmt.Sprintf("# Memory\r\nused_memory:%d\r\nused_memory_rss:%d\r\nused_memory_dataset:%d\r\n"+
+				"active_defrag_running:%d\r\nactive_defrag_resized_maps:%d\r\nactive_defrag_reclaimed_bytes:%d\r\n",
+				mem.HeapAlloc, residentMemory(&mem), dataStore.GetMemoryUsage(), flag(running), resized, reclaimed)
+		},
+	}, command.InfoSection{
+		Name:   "replication",
+		Render: srv.ReplicationInfo,
+	}, command.InfoSection{
+		Name:   "cluster",
+		Render: srv.ClusterInfo,
+	}, command.InfoSection{
+		Name: "stats",
+		Render: func() string {
+			stats := srv.Stats()
+			return fmt.Sprintf("# Stats\r\ntotal_connections_received:%d\r\ntotal_commands_processed:%d\r\nrejected_calls:%d\r\nevicted_keys:%d\r\n"+
+				"sync_full:%d\r\nsync_partial_ok:%d\r\nsync_partial_err:%d\r\n",
+				stats.ConnectionsReceived, stats.CommandsProcessed, stats.RejectedCalls, dataStore.EvictedKeys(),
+				stats.SyncFull, stats.SyncPartialOK, stats.SyncPartialErr)
+		},
+	}))
+	srv.RegisterCommand("CONFIG", command.ConfigCommand(cfg, func() {
+		srv.ResetStats()
+		dataStore.ResetStats()
+	}))
 
 	srv.RegisterCommand("SET", command.SetCommand(dataStore))
 	srv.RegisterCommand("GET", command.GetCommand(dataStore))
 	srv.RegisterCommand("DEL", command.DelCommand(dataStore))
 	srv.RegisterCommand("EXISTS", command.ExistsCommand(dataStore))
 	srv.RegisterCommand("TYPE", command.TypeCommand(dataStore))
+	srv.RegisterCommand("PEXPIREAT", command.PExpireAtCommand(dataStore))
+	srv.RegisterCommand("DUMP", command.DumpCommand(dataStore))
+	srv.RegisterCommand("RESTORE", command.RestoreCommand(dataStore))
+	srv.RegisterCommand("RESTORE-ASKING", command.RestoreCommand(dataStore))
+	migrateCache := command.NewMigrateCache()
+	srv.RegisterCommand("MIGRATE", command.MigrateCommand(dataStore, srv.Call, migrateCache, cfg.GetBool("cluster-enabled")))
 	srv.RegisterCommand("INCR", command.IncrCommand(dataStore))
 	srv.RegisterCommand("DECR", command.DecrCommand(dataStore))
 
@@ -85,17 +166,29 @@ func main() {
 	srv.RegisterCommand("ZRANK", command.ZRankCommand(dataStore))
 	srv.RegisterCommand("ZRANGE", command.ZRangeCommand(dataStore))
 
-	srv.RegisterCommand("SAVE", command.SaveCommand(dataStore))
-	srv.RegisterCommand("BGSAVE", command.BGSaveCommand(dataStore))
-	srv.RegisterCommand("LASTSAVE", command.LastSaveCommand())
-	srv.RegisterCommand("BGREWRITEAOF", command.BGRewriteAOFCommand(dataStore))
-	srv.RegisterCommand("SHUTDOWN", command.ShutdownCommand(dataStore))
+	scriptEngine := scripting.NewEngine(srv.Call, srv.IsWriteCommand)
+	scriptEngine.SetOOMCheck(dataStore.IsMemoryExceeded)
+	scriptEngine.SetUnlock(srv.ScriptUnlock)
+
+	srv.RegisterCommand("SAVE", command.SaveCommand(dataStore, scriptEngine.LibraryCodes, persistenceManager))
+	srv.RegisterCommand("BGSAVE", command.BGSaveCommand(dataStore, scriptEngine.LibraryCodes, persistenceManager))
+	srv.RegisterCommand("LASTSAVE", command.LastSaveCommand(persistenceManager))
+	srv.RegisterCommand("BGREWRITEAOF", command.BGRewriteAOFCommand(dataStore, scriptEngine.LibraryCodes, persistenceManager))
+	srv.RegisterCommand("SHUTDOWN", command.ShutdownCommand(dataStore, scriptEngine.LibraryCodes, persistenceManager))
 	srv.RegisterCommand("DBSIZE", command.DBSizeCommand(dataStore))
 	srv.RegisterCommand("FLUSHDB", command.FlushDBCommand(dataStore))
 	srv.RegisterCommand("FLUSHALL", command.FlushAllCommand(dataStore))
 
-	if *useAof {
-		log.Printf("Loading AOF file: %s", *aofFile)
+	srv.RegisterCommand("EVAL", command.EvalCommand(scriptEngine))
+	srv.RegisterCommand("EVALSHA", command.EvalShaCommand(scriptEngine))
+	srv.RegisterCommand("SCRIPT", command.ScriptCommand(scriptEngine))
+	srv.RegisterCommand("FUNCTION", command.FunctionCommand(scriptEngine))
+	srv.RegisterCommand("FCALL", command.FCallCommand(scriptEngine))
+	srv.RegisterCommand("FCALL_RO", command.FCallROCommand(scriptEngine))
+
+	aofDir, aofName := persistenceManager.AOFDir(), persistenceManager.AOFFilename()
+	if cfg.GetBool("appendonly") {
+		log.Printf("Loading AOF %s from %s", aofName, aofDir)
 
 		executeCommand := func(values []resp.Value) resp.Value {
 			if len(values) == 0 {
@@ -112,21 +205,35 @@ func main() {
 			return handler(args)
 		}
 
-		if err := persistence.LoadAOF(*aofFile, dataStore, executeCommand); err != nil {
-			log.Printf("Warning: Failed to load AOF file: %v", err)
+		loadOpts := persistence.AOFLoadOptions{
+			Truncated: cfg.GetBool("aof-load-truncated"),
+			Corrupt:   cfg.GetBool("aof-load-corrupt"),
+		}
+		stats, err := persistence.LoadAOFDir(aofDir, aofName, dataStore, executeCommand, loadOpts)
+		if err != nil {
+			log.Fatalf("Bad AOF: %v. Make a backup of the AOF directory and run redis-check-aof --fix on the manifest to repair it", err)
+		}
+		for _, damaged := range stats.Truncated {
+			log.Printf("Warning: %v. Loaded anyway: truncated %s to %d bytes", damaged, damaged.File, damaged.Valid)
+		}
+		if stats.Failed > 0 {
+			log.Printf("Warning: %d of %d AOF commands failed during replay, first error: %s", stats.Failed, stats.Commands, stats.FirstError)
+		}
+
+		keyCount := len(dataStore.Keys())
+		if keyCount > 0 {
+			log.Printf("Loaded %d keys from AOF file (%d commands)", keyCount, stats.Commands)
 		} else {
-			keyCount := len(dataStore.Keys())
-			if keyCount > 0 {
-				log.Printf("Loaded %d keys from AOF file", keyCount)
-			} else {
-				log.Println("No data found in AOF file")
-			}
+			log.Println("No data found in AOF file")
 		}
 	} else {
 
-		log.Printf("Loading RDB file: %s", *rdbFile)
-		if err := persistence.LoadRDB(*rdbFile, dataStore); err != nil {
-			log.Printf("Warning: Failed to load RDB file: %v", err)
+		rdbFile := persistenceManager.RDBPath()
+		log.Printf("Loading RDB file: %s", rdbFile)
+		// Starting with part of the data would overwrite the file on the
+		// next save, so a file that can't be loaded is fatal.
+		if err := persistence.LoadRDBWithFunctions(rdbFile, dataStore, scriptEngine.RestoreLibrary, persistenceManager.RDBOptions()); err != nil {
+			log.Fatalf("Failed to load RDB file: %v", err)
 		} else {
 			keyCount := len(dataStore.Keys())
 			if keyCount > 0 {
@@ -137,29 +244,218 @@ func main() {
 		}
 	}
 
-	if *useAof {
-
-		policy := persistence.AOFSyncAlways
-		if *aofSyncPolicy == "everysec" {
-			policy = persistence.AOFSyncEverySec
-		} else if *aofSyncPolicy == "no" {
-			policy = persistence.AOFSyncNo
-		}
+	persistenceManager.ResetDirty()
+	srv.AddCronJob(command.SaveCron(dataStore, scriptEngine.LibraryCodes, persistenceManager))
+	srv.AddCronJob(func() { dataStore.Resize(resizeBudget) })
+	srv.AddCronJob(command.MigrateCron(migrateCache))
 
-		aof, err := persistence.NewAOFWriter(*aofFile, policy)
+	var aof *persistence.AOF
+	if cfg.GetBool("appendonly") {
+		var err error
+		aof, err = persistence.OpenAOF(aofDir, aofName, persistence.AOFSyncPolicy(cfg.Get("appendfsync")))
 		if err != nil {
-			log.Fatalf("Failed to create AOF writer: %v", err)
+			log.Fatalf("Failed to open AOF: %v", err)
 		}
-		srv.SetAOFWriter(aof)
-		log.Printf("AOF logging enabled (sync policy: %s)", *aofSyncPolicy)
+		aof.SetTimestamps(cfg.GetBool("aof-timestamp-enabled"))
+		srv.SetAOF(aof)
+		persistenceManager.SetAOF(aof)
+		log.Printf("AOF logging enabled (sync policy: %s)", cfg.Get("appendfsync"))
+	}
 
-		defer func() {
-			if err := aof.Close(); err != nil {
-				log.Printf("Error closing AOF: %v", err)
+	srv.SetMasterAuth(cfg.Get("masterauth"))
+	srv.SetReplicaReadOnly(cfg.GetBool("replica-read-only"))
+	srv.SetReplDisklessSync(cfg.GetBool("repl-diskless-sync"))
+	srv.SetReplBacklogSize(int(cfg.GetInt("repl-backlog-size")))
+	srv.SetReplTimeout(time.Duration(cfg.GetInt("repl-timeout")) * time.Second)
+	srv.SetDataset(server.Dataset{
+		Snapshot: func() func(io.Writer) error {
+			snapshot := dataStore.BeginSnapshot()
+			libraries := scriptEngine.LibraryCodes()
+			opts := persistenceManager.RDBOptions()
+			return func(w io.Writer) error {
+				defer snapshot.Release()
+				return persistence.WriteRDBStream(w, snapshot.Store(), libraries, opts)
+			}
+		},
+		Load: func(r io.Reader) (func(), error) {
+			loaded := store.NewStore()
+			var libraries []string
+			err := persistence.ReadRDBStream(r, loaded, func(code string) error {
+				libraries = append(libraries, code)
+				return nil
+			})
+			if err != nil {
+				return nil, err
 			}
-		}()
+			return func() {
+				dataStore.Replace(loaded)
+				if err := scriptEngine.FunctionRestore(libraries, "FLUSH"); err != nil {
+					log.Printf("Failed to load the functions sent by master: %v", err)
+				}
+				// The AOF must start over from the new data set.
+				if aof != nil {
+					if _, err := command.BackgroundRewriteAOF(dataStore, scriptEngine.LibraryCodes(), persistenceManager); err != nil {
+						log.Printf("Failed to rewrite the AOF after syncing with master: %v", err)
+					}
+				}
+			}, nil
+		},
+		Dir: func() string {
+			return filepath.Dir(persistenceManager.RDBPath())
+		},
+	})
+	if cfg.GetBool("cluster-enabled") {
+		dataStore.TrackSlots()
+		srv.SetClusterNodeTimeout(time.Duration(cfg.GetInt("cluster-node-timeout")) * time.Millisecond)
+		srv.SetClusterPort(int(cfg.GetInt("cluster-port")))
+		nodesFile := filepath.Join(cfg.Get("dir"), cfg.Get("cluster-config-file"))
+		if err := srv.EnableCluster(dataStore, nodesFile); err != nil {
+			log.Fatalf("Failed to enable cluster mode: %v", err)
+		}
+		log.Printf("Cluster mode enabled (config file %s)", nodesFile)
+	}
+	if master := strings.Fields(cfg.Get("replicaof")); len(master) == 2 {
+		srv.ReplicaOf(master[0], master[1])
 	}
 
+	defer func() {
+		if aof == nil {
+			return
+		}
+		if err := aof.Close(); err != nil {
+			log.Printf("Error closing AOF: %v", err)
+		}
+	}()
+
+	cfg.OnChange("dir", func(value string) error {
+		persistenceManager.SetDir(value)
+		return nil
+	})
+	cfg.OnChange("dbfilename", func(value string) error {
+		persistenceManager.SetDBFilename(value)
+		return nil
+	})
+	cfg.OnChange("save", func(value string) error {
+		persistenceManager.SetSavePoints(command.ParseSavePoints(value))
+		return nil
+	})
+	cfg.OnChange("stop-writes-on-bgsave-error", func(value string) error {
+		persistenceManager.SetStopWritesOnError(value == "yes")
+		return nil
+	})
+	cfg.OnChange("aof-use-rdb-preamble", func(value string) error {
+		persistenceManager.SetAOFRDBPreamble(value == "yes")
+		return nil
+	})
+	cfg.OnChange("rdbcompression", func(value string) error {
+		persistenceManager.SetRDBCompression(value == "yes")
+		return nil
+	})
+	cfg.OnChange("requirepass", func(value string) error {
+		srv.SetRequirePass(value)
+		return nil
+	})
+	cfg.OnChange("lua-time-limit", func(value string) error {
+		ms, _ := strconv.Atoi(value)
+		srv.SetScriptTimeLimit(time.Duration(ms) * time.Millisecond)
+		return nil
+	})
+	cfg.OnChange("maxmemory", func(value string) error {
+		maxMemory, _ := strconv.ParseInt(value, 10, 64)
+		_, policy, samples := dataStore.EvictionSettings()
+		dataStore.ConfigureEviction(maxMemory, policy, samples)
+		return nil
+	})
+	cfg.OnChange("maxmemory-policy", func(value string) error {
+		maxMemory, _, samples := dataStore.EvictionSettings()
+		dataStore.ConfigureEviction(maxMemory, store.EvictionPolicy(value), samples)
+		return nil
+	})
+	cfg.OnChange("maxmemory-samples", func(value string) error {
+		samples, _ := strconv.Atoi(value)
+		maxMemory, policy, _ := dataStore.EvictionSettings()
+		dataStore.ConfigureEviction(maxMemory, policy, samples)
+		return nil
+	})
+	cfg.OnChange("masterauth", func(value string) error {
+		srv.SetMasterAuth(value)
+		return nil
+	})
+	cfg.OnChange("replica-read-only", func(value string) error {
+		srv.SetReplicaReadOnly(value == "yes")
+		return nil
+	})
+	cfg.OnChange("repl-diskless-sync", func(value string) error {
+		srv.SetReplDisklessSync(value == "yes")
+		return nil
+	})
+	cfg.OnChange("repl-backlog-size", func(value string) error {
+		size, _ := strconv.Atoi(value)
+		srv.SetReplBacklogSize(size)
+		return nil
+	})
+	cfg.OnChange("cluster-node-timeout", func(value string) error {
+		ms, _ := strconv.Atoi(value)
+		srv.SetClusterNodeTimeout(time.Duration(ms) * time.Millisecond)
+		return nil
+	})
+	cfg.OnChange("repl-timeout", func(value string) error {
+		seconds, _ := strconv.Atoi(value)
+		srv.SetReplTimeout(time.Duration(seconds) * time.Second)
+		return nil
+	})
+	fsync := cfg.Get("appendfsync")
+	cfg.OnChange("appendfsync", func(value string) error {
+		fsync = value
+		if aof == nil {
+			return nil
+		}
+		return aof.SetSyncPolicy(persistence.AOFSyncPolicy(value))
+	})
+	timestamps := cfg.GetBool("aof-timestamp-enabled")
+	cfg.OnChange("aof-timestamp-enabled", func(value string) error {
+		timestamps = value == "yes"
+		if aof != nil {
+			aof.SetTimestamps(timestamps)
+		}
+		return nil
+	})
+	cfg.OnChange("appendonly", func(value string) error {
+		if value == "no" {
+			if aof != nil {
+				srv.SetAOF(nil)
+				persistenceManager.SetAOF(nil)
+				err := aof.Close()
+				aof = nil
+				return err
+			}
+			return nil
+		}
+		if aof != nil {
+			return nil
+		}
+		if persistenceManager.AOFRewriteInProgress() {
+			return fmt.Errorf("Background AOF rewrite in progress, try again later")
+		}
+
+		// The AOF only holds the dataset once the rewrite started here
+		// finishes; until then its files are left as they were.
+		writer, err := persistence.StartAOF(persistenceManager.AOFDir(), persistenceManager.AOFFilename(), persistence.AOFSyncPolicy(fsync))
+		if err != nil {
+			return err
+		}
+		writer.SetTimestamps(timestamps)
+		persistenceManager.SetAOF(writer)
+		if _, err := command.BackgroundRewriteAOF(dataStore, scriptEngine.LibraryCodes(), persistenceManager); err != nil {
+			persistenceManager.SetAOF(nil)
+			writer.Close()
+			return err
+		}
+		aof = writer
+		srv.SetAOF(aof)
+		return nil
+	})
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
@@ -172,8 +468,32 @@ func main() {
 		os.Exit(0)
 	}()
 
-	log.Printf("Starting Redis clone server on port %s", *port)
-	if err := srv.Start(*port); err != nil {
+	port := cfg.Get("port")
+	log.Printf("Starting Redis clone server on port %s", port)
+	if err := srv.Start(port); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 }
+
+// Time each cron run gives the pass that shrinks sparse maps.
+const resizeBudget = time.Millisecond
+
+// residentMemory returns the resident set size of the process, or the
+// memory obtained from the OS where procfs is not available.
+func residentMemory(mem *runtime.MemStats) uint64 {
+	if statm, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if fields := strings.Fields(string(statm)); len(fields) > 1 {
+			if pages, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	return mem.Sys
+}
+
+func flag(enabled bool) int {
+	if enabled {
+		return 1
+	}
+	return 0
+}
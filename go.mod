@@ -3,7 +3,11 @@ module github.com/lojhan/redis-clone
 go 1.23.2
 
 require (
-	github.com/panjf2000/gnet/v2 v2.5.0 // indirect
+	github.com/panjf2000/gnet/v2 v2.5.0
+	github.com/yuin/gopher-lua v1.1.2
+)
+
+require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
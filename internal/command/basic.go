@@ -3,6 +3,7 @@ package command
 import (
 	"strings"
 
+	"github.com/lojhan/redis-clone/internal/config"
 	"github.com/lojhan/redis-clone/internal/resp"
 )
 
@@ -34,11 +35,6 @@ func EchoCommand(args []resp.Value) resp.Value {
 	return args[0]
 }
 
-func CommandCommand(args []resp.Value) resp.Value {
-
-	return resp.ArrayValue()
-}
-
 func InfoCommand(args []resp.Value) resp.Value {
 	section := "server"
 	if len(args) > 0 {
@@ -67,30 +63,102 @@ func InfoCommand(args []resp.Value) resp.Value {
 	return resp.BulkStringValue(info)
 }
 
-func ConfigCommand(args []resp.Value) resp.Value {
-	if len(args) == 0 {
-		return resp.ErrorValue("ERR wrong number of arguments for 'config' command")
-	}
+type InfoSection struct {
+	Name   string
+	Render func() string
+}
 
-	if args[0].Type != resp.BulkString {
-		return resp.ErrorValue("ERR invalid argument type")
-	}
+// InfoCommandWithSections extends INFO with sections whose contents come
+// from the running server; INFO without arguments includes all of them.
+func InfoCommandWithSections(sections ...InfoSection) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) == 0 {
+			info := InfoCommand(args).Str
+			for _, section := range sections {
+				info += "\r\n" + section.Render()
+			}
+			return resp.BulkStringValue(info)
+		}
 
-	subcommand := strings.ToUpper(args[0].Str)
+		if args[0].Type == resp.BulkString {
+			name := strings.ToLower(args[0].Str)
+			for _, section := range sections {
+				if section.Name == name {
+					return resp.BulkStringValue(section.Render())
+				}
+			}
+		}
+		return InfoCommand(args)
+	}
+}
 
-	switch subcommand {
-	case "GET":
-		if len(args) < 2 {
-			return resp.ErrorValue("ERR wrong number of arguments for 'config|get' command")
+func ConfigCommand(cfg *config.Config, resetStats func()) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) == 0 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'config' command")
 		}
 
-		return resp.ArrayValue()
-	case "SET":
-		if len(args) < 3 {
-			return resp.ErrorValue("ERR wrong number of arguments for 'config|set' command")
+		subcommand := strings.ToUpper(args[0].Str)
+
+		switch subcommand {
+		case "GET":
+			if len(args) < 2 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'config|get' command")
+			}
+
+			seen := make(map[string]bool)
+			var values []resp.Value
+			for _, pattern := range args[1:] {
+				pairs := cfg.Match(pattern.Str)
+				for i := 0; i < len(pairs); i += 2 {
+					if seen[pairs[i]] {
+						continue
+					}
+					seen[pairs[i]] = true
+					values = append(values, resp.BulkStringValue(pairs[i]), resp.BulkStringValue(pairs[i+1]))
+				}
+			}
+			return resp.Value{Type: resp.Map, Array: values}
+
+		case "SET":
+			if len(args) < 3 || len(args)%2 == 0 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'config|set' command")
+			}
+
+			pairs := make([]string, len(args)-1)
+			for i, arg := range args[1:] {
+				pairs[i] = arg.Str
+			}
+			if err := cfg.Set(pairs...); err != nil {
+				return resp.ErrorValue(err.Error())
+			}
+			return resp.OKValue()
+
+		case "REWRITE":
+			if len(args) != 1 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'config|rewrite' command")
+			}
+
+			if err := cfg.Rewrite(); err != nil {
+				if err == config.ErrNoConfigFile {
+					return resp.ErrorValue("ERR " + err.Error())
+				}
+				return resp.ErrorValue("ERR Rewriting config file: " + err.Error())
+			}
+			return resp.OKValue()
+
+		case "RESETSTAT":
+			if len(args) != 1 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'config|resetstat' command")
+			}
+
+			if resetStats != nil {
+				resetStats()
+			}
+			return resp.OKValue()
+
+		default:
+			return resp.ErrorValue("ERR unknown subcommand '" + args[0].Str + "'. Try CONFIG HELP.")
 		}
-		return resp.OKValue()
-	default:
-		return resp.ErrorValue("ERR unknown subcommand '" + subcommand + "'. Try CONFIG HELP.")
 	}
 }
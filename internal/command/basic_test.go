@@ -4,6 +4,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/lojhan/redis-clone/internal/config"
 	"github.com/lojhan/redis-clone/internal/resp"
 )
 
@@ -97,14 +98,6 @@ func TestEchoCommand(t *testing.T) {
 	}
 }
 
-func TestCommandCommand(t *testing.T) {
-	result := CommandCommand([]resp.Value{})
-
-	if result.Type != resp.Array {
-		t.Errorf("Expected Array type, got %v", result.Type)
-	}
-}
-
 func TestInfoCommand(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -147,6 +140,28 @@ func TestInfoCommand(t *testing.T) {
 	}
 }
 
+func TestInfoCommandWithSections(t *testing.T) {
+	info := InfoCommandWithSections(InfoSection{
+		Name:   "stats",
+		Render: func() string { return "# Stats\r\ntotal_commands_processed:7\r\n" },
+	})
+
+	result := info([]resp.Value{resp.BulkStringValue("STATS")})
+	if !strings.Contains(result.Str, "total_commands_processed:7") {
+		t.Errorf("Expected stats section, got %q", result.Str)
+	}
+
+	result = info([]resp.Value{})
+	if !strings.Contains(result.Str, "# Server") || !strings.Contains(result.Str, "# Stats") {
+		t.Errorf("Expected default INFO to include all sections, got %q", result.Str)
+	}
+
+	result = info([]resp.Value{resp.BulkStringValue("clients")})
+	if !strings.Contains(result.Str, "# Clients") {
+		t.Errorf("Expected built-in sections to keep working, got %q", result.Str)
+	}
+}
+
 func TestConfigCommand(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -159,34 +174,50 @@ func TestConfigCommand(t *testing.T) {
 			expectError: true,
 		},
 		{
-			name: "CONFIG GET",
-			args: []resp.Value{
-				resp.BulkStringValue("GET"),
-				resp.BulkStringValue("maxmemory"),
-			},
+			name:        "CONFIG GET",
+			args:        bulkArgs("GET", "maxmemory"),
 			expectError: false,
 		},
 		{
-			name: "CONFIG SET",
-			args: []resp.Value{
-				resp.BulkStringValue("SET"),
-				resp.BulkStringValue("maxmemory"),
-				resp.BulkStringValue("100mb"),
-			},
+			name:        "CONFIG SET",
+			args:        bulkArgs("SET", "maxmemory", "100mb"),
 			expectError: false,
 		},
 		{
-			name: "CONFIG unknown subcommand",
-			args: []resp.Value{
-				resp.BulkStringValue("UNKNOWN"),
-			},
+			name:        "CONFIG SET invalid value",
+			args:        bulkArgs("SET", "maxmemory", "lots"),
+			expectError: true,
+		},
+		{
+			name:        "CONFIG SET unknown parameter",
+			args:        bulkArgs("SET", "no-such-option", "1"),
+			expectError: true,
+		},
+		{
+			name:        "CONFIG SET immutable parameter",
+			args:        bulkArgs("SET", "port", "7000"),
+			expectError: true,
+		},
+		{
+			name:        "CONFIG REWRITE without config file",
+			args:        bulkArgs("REWRITE"),
+			expectError: true,
+		},
+		{
+			name:        "CONFIG RESETSTAT",
+			args:        bulkArgs("RESETSTAT"),
+			expectError: false,
+		},
+		{
+			name:        "CONFIG unknown subcommand",
+			args:        bulkArgs("UNKNOWN"),
 			expectError: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := ConfigCommand(tt.args)
+			result := ConfigCommand(config.New(), nil)(tt.args)
 
 			if tt.expectError {
 				if result.Type != resp.Error {
@@ -200,3 +231,51 @@ func TestConfigCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigGetSet(t *testing.T) {
+	cfg := config.New()
+	configCmd := ConfigCommand(cfg, nil)
+
+	var applied string
+	cfg.OnChange("maxmemory-policy", func(value string) error {
+		applied = value
+		return nil
+	})
+
+	result := configCmd(bulkArgs("SET", "maxmemory", "1mb", "maxmemory-policy", "ALLKEYS-LRU"))
+	if result.Type == resp.Error {
+		t.Fatalf("Unexpected error: %s", result.Str)
+	}
+	if applied != "allkeys-lru" {
+		t.Errorf("Expected apply hook to receive allkeys-lru, got %q", applied)
+	}
+
+	result = configCmd(bulkArgs("GET", "maxmemory*"))
+	if result.Type != resp.Map {
+		t.Fatalf("Expected map reply, got %v", result.Type)
+	}
+
+	expected := []string{"maxmemory", "1048576", "maxmemory-policy", "allkeys-lru", "maxmemory-samples", "5"}
+	if len(result.Array) != len(expected) {
+		t.Fatalf("Expected %d elements, got %d", len(expected), len(result.Array))
+	}
+	for i, value := range expected {
+		if result.Array[i].Str != value {
+			t.Errorf("Element %d: expected %q, got %q", i, value, result.Array[i].Str)
+		}
+	}
+
+	result = configCmd(bulkArgs("GET", "maxmemory", "max*"))
+	if len(result.Array) != 6 {
+		t.Errorf("Expected overlapping patterns to be deduplicated, got %d elements", len(result.Array))
+	}
+}
+
+func TestConfigResetStat(t *testing.T) {
+	reset := false
+	result := ConfigCommand(config.New(), func() { reset = true })(bulkArgs("RESETSTAT"))
+
+	if result.Type == resp.Error || !reset {
+		t.Errorf("Expected RESETSTAT to reset stats, got %v", result)
+	}
+}
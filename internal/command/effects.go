@@ -0,0 +1,79 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+// Effects returns how a write command that just ran is propagated to the
+// AOF and to replicas, so that replaying it gives the same data whenever it
+// is replayed: SPOP becomes the SREM of the member it popped, and relative
+// TTLs become the absolute times they resolved to. MIGRATE propagates
+// nothing itself, the DEL it calls is propagated instead. Other commands
+// are propagated as they were called.
+func Effects(s *store.Store) func(args []resp.Value, result resp.Value) [][]resp.Value {
+	return func(args []resp.Value, result resp.Value) [][]resp.Value {
+		switch strings.ToUpper(args[0].Str) {
+		case "SPOP":
+			if result.Null {
+				return nil
+			}
+			return [][]resp.Value{bulkStrings("SREM", args[1].Str, result.Str)}
+
+		case "SET":
+			if result.Null {
+				return nil
+			}
+			for _, arg := range args[3:] {
+				if option := strings.ToUpper(arg.Str); option == "EX" || option == "PX" {
+					return [][]resp.Value{setEffect(s, args[1].Str, args[2].Str)}
+				}
+			}
+
+		case "RESTORE", "RESTORE-ASKING":
+			if args[2].Str == "0" || hasOption(args[4:], "ABSTTL") {
+				break
+			}
+			_, expiry, exists := s.Object(args[1].Str)
+			if !exists {
+				// The key was restored already expired.
+				if hasOption(args[4:], "REPLACE") {
+					return [][]resp.Value{bulkStrings("DEL", args[1].Str)}
+				}
+				return nil
+			}
+			restore := append([]resp.Value(nil), args...)
+			restore[2] = resp.BulkStringValue(strconv.FormatInt(expiry.UnixMilli(), 10))
+			return [][]resp.Value{append(restore, resp.BulkStringValue("ABSTTL"))}
+
+		case "MIGRATE":
+			return nil
+		}
+		return [][]resp.Value{args}
+	}
+}
+
+// setEffect returns the SET recreating key as it is now, with its expiry as
+// a unix time.
+func setEffect(s *store.Store, key, value string) []resp.Value {
+	_, expiry, exists := s.Object(key)
+	if !exists {
+		return bulkStrings("DEL", key)
+	}
+	if expiry.IsZero() {
+		return bulkStrings("SET", key, value)
+	}
+	return bulkStrings("SET", key, value, "PXAT", strconv.FormatInt(expiry.UnixMilli(), 10))
+}
+
+func hasOption(args []resp.Value, option string) bool {
+	for _, arg := range args {
+		if strings.EqualFold(arg.Str, option) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+func effectStrings(commands [][]resp.Value) []string {
+	var effects []string
+	for _, cmd := range commands {
+		var args []string
+		for _, arg := range cmd {
+			args = append(args, arg.Str)
+		}
+		effects = append(effects, strings.Join(args, " "))
+	}
+	return effects
+}
+
+func TestEffects(t *testing.T) {
+	s := store.NewStore()
+	effects := Effects(s)
+	set := SetCommand(s)
+	spop := SPopCommand(s)
+
+	s.SAdd("set", "member")
+	args := bulkStrings("SPOP", "set")
+	if got := effectStrings(effects(args, spop(args[1:]))); len(got) != 1 || got[0] != "SREM set member" {
+		t.Errorf("Expected SPOP to propagate as SREM, got %v", got)
+	}
+	if got := effects(args, spop(args[1:])); len(got) != 0 {
+		t.Errorf("Expected an SPOP of nothing not to propagate, got %v", got)
+	}
+
+	args = bulkStrings("SET", "key", "value", "EX", "100")
+	set(args[1:])
+	_, expiry, _ := s.Object("key")
+	want := "SET key value PXAT " + strconv.FormatInt(expiry.UnixMilli(), 10)
+	if got := effectStrings(effects(args, resp.OKValue())); len(got) != 1 || got[0] != want {
+		t.Errorf("Expected %q, got %v", want, got)
+	}
+	args = bulkStrings("SET", "key", "value", "NX")
+	if got := effects(args, set(args[1:])); len(got) != 0 {
+		t.Errorf("Expected a SET NX that didn't set not to propagate, got %v", got)
+	}
+
+	payload := DumpCommand(s)(bulkStrings("key")).Str
+	args = bulkStrings("RESTORE", "copy", "5000", payload)
+	RestoreCommand(s)(args[1:])
+	_, expiry, _ = s.Object("copy")
+	got := effects(args, resp.OKValue())
+	if len(got) != 1 || got[0][2].Str != strconv.FormatInt(expiry.UnixMilli(), 10) || got[0][4].Str != "ABSTTL" {
+		t.Errorf("Expected RESTORE with an absolute TTL, got %v", effectStrings(got))
+	}
+	if time.Until(expiry) > 5*time.Second {
+		t.Errorf("Unexpected expiry %v", expiry)
+	}
+
+	if got := effects(bulkStrings("MIGRATE", "host", "6379", "key", "0", "1000"), resp.OKValue()); len(got) != 0 {
+		t.Errorf("Expected MIGRATE not to propagate itself, got %v", got)
+	}
+	args = bulkStrings("LPUSH", "list", "a")
+	if got := effectStrings(effects(args, resp.IntegerValue(1))); len(got) != 1 || got[0] != "LPUSH list a" {
+		t.Errorf("Expected other commands to propagate as they are, got %v", got)
+	}
+}
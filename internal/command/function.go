@@ -0,0 +1,201 @@
+package command
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/lojhan/redis-clone/internal/persistence"
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/scripting"
+)
+
+func FunctionCommand(e *scripting.Engine) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) == 0 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'function' command")
+		}
+
+		subcommand := strings.ToUpper(args[0].Str)
+
+		switch subcommand {
+		case "LOAD":
+			if len(args) < 2 || len(args) > 3 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'function|load' command")
+			}
+
+			replace := false
+			if len(args) == 3 {
+				if strings.ToUpper(args[1].Str) != "REPLACE" {
+					return resp.ErrorValue("ERR Unknown option given: " + args[1].Str)
+				}
+				replace = true
+			}
+
+			name, err := e.FunctionLoad(args[len(args)-1].Str, replace)
+			if err != nil {
+				return resp.ErrorValue(err.Error())
+			}
+			return resp.BulkStringValue(name)
+
+		case "DELETE":
+			if len(args) != 2 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'function|delete' command")
+			}
+
+			if err := e.FunctionDelete(args[1].Str); err != nil {
+				return resp.ErrorValue(err.Error())
+			}
+			return resp.OKValue()
+
+		case "FLUSH":
+			if len(args) > 2 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'function|flush' command")
+			}
+			if len(args) == 2 {
+				mode := strings.ToUpper(args[1].Str)
+				if mode != "ASYNC" && mode != "SYNC" {
+					return resp.ErrorValue("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+				}
+			}
+
+			e.FunctionFlush()
+			return resp.OKValue()
+
+		case "KILL":
+			if len(args) != 1 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'function|kill' command")
+			}
+
+			if err := e.KillFunction(); err != nil {
+				return resp.ErrorValue(err.Error())
+			}
+			return resp.OKValue()
+
+		case "LIST":
+			return functionList(e, args[1:])
+
+		case "DUMP":
+			if len(args) != 1 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'function|dump' command")
+			}
+
+			payload := persistence.EncodeFunctionsPayload(e.LibraryCodes())
+			return resp.BulkStringValue(string(payload))
+
+		case "RESTORE":
+			if len(args) < 2 || len(args) > 3 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'function|restore' command")
+			}
+
+			policy := "APPEND"
+			if len(args) == 3 {
+				policy = strings.ToUpper(args[2].Str)
+				if policy != "APPEND" && policy != "REPLACE" && policy != "FLUSH" {
+					return resp.ErrorValue("ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+				}
+			}
+
+			codes, err := persistence.DecodeFunctionsPayload([]byte(args[1].Str))
+			if err != nil {
+				return resp.ErrorValue(err.Error())
+			}
+
+			if err := e.FunctionRestore(codes, policy); err != nil {
+				return resp.ErrorValue(err.Error())
+			}
+			return resp.OKValue()
+
+		default:
+			return resp.ErrorValue("ERR unknown subcommand '" + subcommand + "'. Try FUNCTION HELP.")
+		}
+	}
+}
+
+func functionList(e *scripting.Engine, args []resp.Value) resp.Value {
+	pattern := ""
+	withCode := false
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Str) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if i+1 >= len(args) {
+				return resp.ErrorValue("ERR library name argument was not given")
+			}
+			i++
+			pattern = args[i].Str
+		default:
+			return resp.ErrorValue("ERR Unknown argument " + args[i].Str)
+		}
+	}
+
+	libs := e.Libraries(pattern)
+	results := make([]resp.Value, len(libs))
+	for i, lib := range libs {
+		functions := make([]resp.Value, 0, len(lib.Functions))
+		for _, name := range sortedFunctionNames(lib) {
+			f := lib.Functions[name]
+
+			description := resp.NullBulkStringValue()
+			if f.Description != "" {
+				description = resp.BulkStringValue(f.Description)
+			}
+
+			flags := make([]resp.Value, len(f.Flags))
+			for j, flag := range f.Flags {
+				flags[j] = resp.SimpleStringValue(flag)
+			}
+
+			functions = append(functions, resp.ArrayValue(
+				resp.BulkStringValue("name"), resp.BulkStringValue(f.Name),
+				resp.BulkStringValue("description"), description,
+				resp.BulkStringValue("flags"), resp.ArrayValue(flags...),
+			))
+		}
+
+		entry := []resp.Value{
+			resp.BulkStringValue("library_name"), resp.BulkStringValue(lib.Name),
+			resp.BulkStringValue("engine"), resp.BulkStringValue("LUA"),
+			resp.BulkStringValue("functions"), resp.ArrayValue(functions...),
+		}
+		if withCode {
+			entry = append(entry, resp.BulkStringValue("library_code"), resp.BulkStringValue(lib.Code))
+		}
+		results[i] = resp.ArrayValue(entry...)
+	}
+
+	return resp.ArrayValue(results...)
+}
+
+func sortedFunctionNames(lib *scripting.Library) []string {
+	names := make([]string, 0, len(lib.Functions))
+	for name := range lib.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func FCallCommand(e *scripting.Engine) func([]resp.Value) resp.Value {
+	return fcall(e, "fcall", false)
+}
+
+func FCallROCommand(e *scripting.Engine) func([]resp.Value) resp.Value {
+	return fcall(e, "fcall_ro", true)
+}
+
+func fcall(e *scripting.Engine, name string, readOnly bool) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) < 2 {
+			return resp.ErrorValue("ERR wrong number of arguments for '" + name + "' command")
+		}
+
+		keys, argv, err := parseKeysAndArgs(args[1:])
+		if err != nil {
+			return resp.ErrorValue(err.Error())
+		}
+
+		return e.FCall(args[0].Str, keys, argv, readOnly)
+	}
+}
@@ -0,0 +1,153 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+const testFunctionLibrary = `#!lua name=counter
+redis.register_function('bump', function(keys, args)
+  return redis.call('INCR', keys[1])
+end)
+redis.register_function{
+  function_name = 'read',
+  callback = function(keys, args) return redis.call('GET', keys[1]) end,
+  flags = {'no-writes'},
+  description = 'read the counter',
+}
+`
+
+func TestFunctionLoadAndFCall(t *testing.T) {
+	s := store.NewStore()
+	e := newTestScriptEngine(s)
+	function := FunctionCommand(e)
+	fcall := FCallCommand(e)
+	fcallRO := FCallROCommand(e)
+
+	result := function(bulkArgs("LOAD", testFunctionLibrary))
+	if result.Type != resp.BulkString || result.Str != "counter" {
+		t.Fatalf("Expected library name, got %+v", result)
+	}
+
+	result = function(bulkArgs("LOAD", testFunctionLibrary))
+	if result.Type != resp.Error {
+		t.Errorf("Expected error loading existing library, got %+v", result)
+	}
+
+	result = function(bulkArgs("LOAD", "REPLACE", testFunctionLibrary))
+	if result.Type != resp.BulkString {
+		t.Errorf("Expected LOAD REPLACE to succeed, got %+v", result)
+	}
+
+	result = fcall(bulkArgs("bump", "1", "hits"))
+	if result.Type != resp.Integer || result.Int != 1 {
+		t.Errorf("Expected 1, got %+v", result)
+	}
+
+	result = fcallRO(bulkArgs("read", "1", "hits"))
+	if result.Type != resp.BulkString || result.Str != "1" {
+		t.Errorf("Expected '1', got %+v", result)
+	}
+
+	result = fcallRO(bulkArgs("bump", "1", "hits"))
+	if result.Type != resp.Error {
+		t.Errorf("Expected FCALL_RO to reject write function, got %+v", result)
+	}
+}
+
+func TestFunctionList(t *testing.T) {
+	function := FunctionCommand(newTestScriptEngine(store.NewStore()))
+	function(bulkArgs("LOAD", testFunctionLibrary))
+
+	result := function(bulkArgs("LIST", "WITHCODE"))
+	if result.Type != resp.Array || len(result.Array) != 1 {
+		t.Fatalf("Expected one library, got %+v", result)
+	}
+
+	lib := result.Array[0].Array
+	if lib[0].Str != "library_name" || lib[1].Str != "counter" {
+		t.Errorf("Unexpected library entry: %+v", lib)
+	}
+
+	functions := lib[5].Array
+	if len(functions) != 2 || functions[0].Array[1].Str != "bump" || functions[1].Array[1].Str != "read" {
+		t.Errorf("Unexpected functions: %+v", functions)
+	}
+
+	readFlags := functions[1].Array[5].Array
+	if len(readFlags) != 1 || readFlags[0].Str != "no-writes" {
+		t.Errorf("Expected no-writes flag, got %+v", readFlags)
+	}
+
+	if len(lib) != 8 || lib[7].Str != testFunctionLibrary {
+		t.Errorf("Expected library code with WITHCODE, got %+v", lib)
+	}
+
+	result = function(bulkArgs("LIST", "LIBRARYNAME", "nomatch*"))
+	if len(result.Array) != 0 {
+		t.Errorf("Expected no libraries for non-matching pattern, got %+v", result)
+	}
+}
+
+func TestFunctionDumpRestore(t *testing.T) {
+	source := FunctionCommand(newTestScriptEngine(store.NewStore()))
+	source(bulkArgs("LOAD", testFunctionLibrary))
+
+	payload := source(bulkArgs("DUMP"))
+	if payload.Type != resp.BulkString {
+		t.Fatalf("Expected bulk payload, got %+v", payload)
+	}
+
+	s := store.NewStore()
+	e := newTestScriptEngine(s)
+	target := FunctionCommand(e)
+
+	result := target(bulkArgs("RESTORE", payload.Str))
+	if result.Type != resp.SimpleString {
+		t.Fatalf("Expected OK from RESTORE, got %+v", result)
+	}
+
+	result = FCallCommand(e)(bulkArgs("bump", "1", "k"))
+	if result.Int != 1 {
+		t.Errorf("Expected restored function to run, got %+v", result)
+	}
+
+	result = target(bulkArgs("RESTORE", payload.Str))
+	if result.Type != resp.Error || !strings.Contains(result.Str, "already exists") {
+		t.Errorf("Expected APPEND restore conflict, got %+v", result)
+	}
+
+	result = target(bulkArgs("RESTORE", payload.Str, "REPLACE"))
+	if result.Type != resp.SimpleString {
+		t.Errorf("Expected REPLACE restore to succeed, got %+v", result)
+	}
+
+	result = target(bulkArgs("RESTORE", "garbage"))
+	if result.Type != resp.Error {
+		t.Errorf("Expected error for bad payload, got %+v", result)
+	}
+}
+
+func TestFunctionDeleteAndFlush(t *testing.T) {
+	e := newTestScriptEngine(store.NewStore())
+	function := FunctionCommand(e)
+	function(bulkArgs("LOAD", testFunctionLibrary))
+
+	if result := function(bulkArgs("DELETE", "counter")); result.Type != resp.SimpleString {
+		t.Errorf("Expected OK from DELETE, got %+v", result)
+	}
+	if result := function(bulkArgs("DELETE", "counter")); result.Type != resp.Error {
+		t.Errorf("Expected error deleting missing library, got %+v", result)
+	}
+
+	function(bulkArgs("LOAD", testFunctionLibrary))
+	if result := function(bulkArgs("FLUSH")); result.Type != resp.SimpleString {
+		t.Errorf("Expected OK from FLUSH, got %+v", result)
+	}
+	if result := FCallCommand(e)(bulkArgs("bump", "1", "k")); result.Type != resp.Error {
+		t.Errorf("Expected function to be gone after FLUSH, got %+v", result)
+	}
+}
@@ -236,7 +236,7 @@ func HGetAllCommand(s *store.Store) func([]resp.Value) resp.Value {
 		}
 
 		return resp.Value{
-			Type:  resp.Array,
+			Type:  resp.Map,
 			Array: result,
 		}
 	}
@@ -180,8 +180,8 @@ func TestHGetAllCommand(t *testing.T) {
 		{Type: resp.BulkString, Str: "hash1"},
 	})
 
-	if result.Type != resp.Array {
-		t.Fatal("Expected array result")
+	if result.Type != resp.Map {
+		t.Fatal("Expected map result")
 	}
 
 	if len(result.Array) != 4 {
@@ -0,0 +1,420 @@
+package command
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/persistence"
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+const defaultMigrateTimeout = time.Second
+
+func DumpCommand(s *store.Store) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) != 1 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'dump' command")
+		}
+
+		obj, _, exists := s.Object(args[0].Str)
+		if !exists {
+			return resp.NullBulkStringValue()
+		}
+		payload, err := persistence.EncodeObjectPayload(obj)
+		if err != nil {
+			return resp.ErrorValue("ERR " + err.Error())
+		}
+		return resp.BulkStringValue(string(payload))
+	}
+}
+
+// RestoreCommand serves RESTORE and RESTORE-ASKING, creating a key from a
+// DUMP payload.
+func RestoreCommand(s *store.Store) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) < 3 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'restore' command")
+		}
+
+		key := args[0].Str
+		ttl, err := strconv.ParseInt(args[1].Str, 10, 64)
+		if err != nil {
+			return resp.ErrorValue("ERR value is not an integer or out of range")
+		}
+		if ttl < 0 {
+			return resp.ErrorValue("ERR Invalid TTL value, must be >= 0")
+		}
+
+		replace, absTTL := false, false
+		idle, freq := int64(-1), int64(-1)
+		for i := 3; i < len(args); i++ {
+			switch option := strings.ToUpper(args[i].Str); {
+			case option == "REPLACE":
+				replace = true
+			case option == "ABSTTL":
+				absTTL = true
+			case option == "IDLETIME" && i+1 < len(args) && freq == -1:
+				i++
+				if idle, err = strconv.ParseInt(args[i].Str, 10, 64); err != nil {
+					return resp.ErrorValue("ERR value is not an integer or out of range")
+				}
+				if idle < 0 {
+					return resp.ErrorValue("ERR Invalid IDLETIME value, must be >= 0")
+				}
+			case option == "FREQ" && i+1 < len(args) && idle == -1:
+				i++
+				if freq, err = strconv.ParseInt(args[i].Str, 10, 64); err != nil {
+					return resp.ErrorValue("ERR value is not an integer or out of range")
+				}
+				if freq < 0 || freq > 255 {
+					return resp.ErrorValue("ERR Invalid FREQ value, must be >= 0 and <= 255")
+				}
+			default:
+				return resp.ErrorValue("ERR syntax error")
+			}
+		}
+
+		if !replace && s.Exists(key) {
+			return resp.ErrorValue("BUSYKEY Target key name already exists.")
+		}
+		obj, err := persistence.DecodeObjectPayload([]byte(args[2].Str))
+		if err != nil {
+			return resp.ErrorValue(err.Error())
+		}
+
+		var expiry time.Time
+		if ttl > 0 {
+			if absTTL {
+				expiry = time.UnixMilli(ttl)
+			} else {
+				expiry = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+			}
+			// A key restored already expired is not created at all.
+			if !expiry.After(time.Now()) {
+				if replace {
+					s.Delete(key)
+				}
+				return resp.OKValue()
+			}
+		}
+		if idle >= 0 {
+			obj.LRU = uint32(time.Now().Unix() - idle)
+		}
+
+		if !s.RestoreObject(key, obj, expiry, replace) {
+			return resp.ErrorValue("BUSYKEY Target key name already exists.")
+		}
+		return resp.OKValue()
+	}
+}
+
+// MigrateCommand moves keys to another instance: they are serialized the
+// way DUMP does, restored on the target, and deleted here once the target
+// accepted them, unless COPY is given. The deletion goes through call, so
+// that it is what gets propagated. Clients never see the keys half moved,
+// as the whole exchange happens while the command runs. In cluster mode
+// the target is sent RESTORE-ASKING, as the slot being migrated is still
+// owned by this node.
+func MigrateCommand(s *store.Store, call func([]resp.Value) resp.Value, cache *MigrateCache, cluster bool) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) < 5 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'migrate' command")
+		}
+
+		addr := net.JoinHostPort(args[0].Str, args[1].Str)
+		keys := []string{args[2].Str}
+		db, err := strconv.Atoi(args[3].Str)
+		if err != nil {
+			return resp.ErrorValue("ERR value is not an integer or out of range")
+		}
+		timeoutMs, err := strconv.ParseInt(args[4].Str, 10, 64)
+		if err != nil {
+			return resp.ErrorValue("ERR value is not an integer or out of range")
+		}
+		timeout := time.Duration(timeoutMs) * time.Millisecond
+		if timeout <= 0 {
+			timeout = defaultMigrateTimeout
+		}
+
+		copyKeys, replace := false, false
+		var auth []string
+	options:
+		for i := 5; i < len(args); i++ {
+			switch option := strings.ToUpper(args[i].Str); {
+			case option == "COPY":
+				copyKeys = true
+			case option == "REPLACE":
+				replace = true
+			case option == "AUTH" && i+1 < len(args):
+				auth = []string{args[i+1].Str}
+				i++
+			case option == "AUTH2" && i+2 < len(args):
+				auth = []string{args[i+1].Str, args[i+2].Str}
+				i += 2
+			case option == "KEYS":
+				if args[2].Str != "" {
+					return resp.ErrorValue("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+				}
+				keys = nil
+				for _, arg := range args[i+1:] {
+					keys = append(keys, arg.Str)
+				}
+				break options
+			default:
+				return resp.ErrorValue("ERR syntax error")
+			}
+		}
+
+		restore := "RESTORE"
+		if cluster {
+			restore = "RESTORE-ASKING"
+		}
+		var restores [][]resp.Value
+		var moved []string
+		for _, key := range keys {
+			obj, expiry, exists := s.Object(key)
+			if !exists {
+				continue
+			}
+			payload, err := persistence.EncodeObjectPayload(obj)
+			if err != nil {
+				return resp.ErrorValue("ERR " + err.Error())
+			}
+			var ttl int64
+			if !expiry.IsZero() {
+				ttl = max(time.Until(expiry).Milliseconds(), 1)
+			}
+			cmd := bulkStrings(restore, key, strconv.FormatInt(ttl, 10), string(payload))
+			if replace {
+				cmd = append(cmd, resp.BulkStringValue("REPLACE"))
+			}
+			restores = append(restores, cmd)
+			moved = append(moved, key)
+		}
+		if len(moved) == 0 {
+			return resp.SimpleStringValue("NOKEY")
+		}
+
+		// Once the commands were written they may have run on the target,
+		// so only a failed write on a cached connection is retried.
+		var replies []resp.Value
+		for retry := true; ; retry = false {
+			conn, cached, err := cache.get(addr, timeout)
+			if err != nil {
+				return resp.ErrorValue("IOERR error or timeout connecting to the client")
+			}
+			var written bool
+			replies, written, err = conn.exchange(migrateCommands(conn, auth, db, restores), timeout)
+			if err == nil {
+				break
+			}
+			cache.drop(addr)
+			if written {
+				return resp.ErrorValue("IOERR error or timeout reading to target instance")
+			}
+			if !retry || !cached || isTimeout(err) {
+				return resp.ErrorValue("IOERR error or timeout writing to target instance")
+			}
+		}
+
+		// An AUTH or SELECT that failed means nothing was restored.
+		sent := len(replies) - len(moved)
+		for _, reply := range replies[:sent] {
+			if reply.Type == resp.Error {
+				return resp.ErrorValue("ERR Target instance replied with error: " + reply.Str)
+			}
+		}
+
+		// Only the keys the target accepted are deleted here.
+		var firstError string
+		del := bulkStrings("DEL")
+		for i, reply := range replies[sent:] {
+			if reply.Type == resp.Error {
+				if firstError == "" {
+					firstError = reply.Str
+				}
+				continue
+			}
+			del = append(del, resp.BulkStringValue(moved[i]))
+		}
+		if !copyKeys && len(del) > 1 {
+			if reply := call(del); reply.Type == resp.Error {
+				return reply
+			}
+		}
+		if firstError != "" {
+			return resp.ErrorValue("ERR Target instance replied with error: " + firstError)
+		}
+		return resp.OKValue()
+	}
+}
+
+// migrateCommands prepends to restores the AUTH and SELECT the connection
+// needs.
+func migrateCommands(conn *migrateConn, auth []string, db int, restores [][]resp.Value) [][]resp.Value {
+	var commands [][]resp.Value
+	if len(auth) > 0 {
+		commands = append(commands, bulkStrings(append([]string{"AUTH"}, auth...)...))
+	}
+	if conn.db != db {
+		commands = append(commands, bulkStrings("SELECT", strconv.Itoa(db)))
+	}
+	return append(commands, restores...)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+const (
+	migrateCacheSize   = 64
+	migrateIdleTimeout = 10 * time.Second
+)
+
+// MigrateCache keeps the connections MIGRATE opened to its targets, so that
+// moving many keys one call at a time doesn't dial for every call. Idle
+// connections are closed by MigrateCron.
+type MigrateCache struct {
+	mu    sync.Mutex
+	conns map[string]*migrateConn
+}
+
+type migrateConn struct {
+	conn   net.Conn
+	parser *resp.Parser
+	// The database selected on the target, or -1 when unknown.
+	db      int
+	lastUse time.Time
+}
+
+func NewMigrateCache() *MigrateCache {
+	return &MigrateCache{conns: make(map[string]*migrateConn)}
+}
+
+// get returns the cached connection to addr, or a new one, and whether it
+// was cached. A cached connection the target closed is replaced.
+func (c *MigrateCache) get(addr string, timeout time.Duration) (*migrateConn, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if mc, exists := c.conns[addr]; exists {
+		if mc.alive() {
+			mc.lastUse = time.Now()
+			return mc, true, nil
+		}
+		mc.conn.Close()
+		delete(c.conns, addr)
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(c.conns) >= migrateCacheSize {
+		for other, mc := range c.conns {
+			mc.conn.Close()
+			delete(c.conns, other)
+			break
+		}
+	}
+	mc := &migrateConn{conn: conn, parser: resp.NewParser(conn), lastUse: time.Now()}
+	c.conns[addr] = mc
+	return mc, false, nil
+}
+
+func (c *MigrateCache) drop(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if mc, exists := c.conns[addr]; exists {
+		mc.conn.Close()
+		delete(c.conns, addr)
+	}
+}
+
+// Len returns the number of cached connections.
+func (c *MigrateCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.conns)
+}
+
+// MigrateCron returns the periodic job that closes MIGRATE connections left
+// idle for 10 seconds.
+func MigrateCron(cache *MigrateCache) func() {
+	return func() {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		for addr, mc := range cache.conns {
+			if time.Since(mc.lastUse) > migrateIdleTimeout {
+				mc.conn.Close()
+				delete(cache.conns, addr)
+			}
+		}
+	}
+}
+
+// alive reports whether the idle connection is still usable, peeking at it
+// without blocking: anything to read means the target closed it, or sent
+// something no command asked for.
+func (mc *migrateConn) alive() bool {
+	sc, ok := mc.conn.(syscall.Conn)
+	if !ok {
+		return true
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	alive := false
+	raw.Read(func(fd uintptr) bool {
+		var b [1]byte
+		_, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		alive = err == syscall.EAGAIN
+		return true
+	})
+	return alive
+}
+
+// exchange pipelines commands and returns their replies, and whether the
+// commands were written when it fails. The database a SELECT switched to is
+// remembered for the next call.
+func (mc *migrateConn) exchange(commands [][]resp.Value, timeout time.Duration) ([]resp.Value, bool, error) {
+	mc.conn.SetDeadline(time.Now().Add(timeout))
+	var request []byte
+	for _, cmd := range commands {
+		request = append(request, resp.SerializeArray(cmd)...)
+	}
+	if _, err := mc.conn.Write(request); err != nil {
+		return nil, false, err
+	}
+
+	replies := make([]resp.Value, len(commands))
+	for i, cmd := range commands {
+		reply, err := mc.parser.Parse()
+		if err != nil {
+			return nil, true, err
+		}
+		if cmd[0].Str == "SELECT" {
+			mc.db = -1
+			if reply.Type != resp.Error {
+				mc.db, _ = strconv.Atoi(cmd[1].Str)
+			}
+		}
+		replies[i] = reply
+	}
+	return replies, true, nil
+}
+
+func bulkStrings(values ...string) []resp.Value {
+	args := make([]resp.Value, len(values))
+	for i, value := range values {
+		args[i] = resp.BulkStringValue(value)
+	}
+	return args
+}
@@ -0,0 +1,252 @@
+package command
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+func TestDumpRestore(t *testing.T) {
+	s := store.NewStore()
+	s.HSet("h", "field", "value")
+	dump := DumpCommand(s)
+	restore := RestoreCommand(s)
+
+	payload := dump(bulkStrings("h"))
+	if payload.Type != resp.BulkString || payload.Null {
+		t.Fatalf("Expected a payload, got %+v", payload)
+	}
+	if result := dump(bulkStrings("missing")); !result.Null {
+		t.Errorf("Expected null for a missing key, got %+v", result)
+	}
+
+	if result := restore(bulkStrings("h", "0", payload.Str)); result.Str != "BUSYKEY Target key name already exists." {
+		t.Errorf("Expected BUSYKEY, got %+v", result)
+	}
+	if result := restore(bulkStrings("copy", "5000", payload.Str)); result.Str != "OK" {
+		t.Fatalf("Expected OK, got %+v", result)
+	}
+	if value, exists := s.HGet("copy", "field"); !exists || value != "value" {
+		t.Errorf("Expected the restored hash, got %q", value)
+	}
+	if _, expiry, _ := s.Object("copy"); time.Until(expiry) <= 0 || time.Until(expiry) > 5*time.Second {
+		t.Errorf("Expected a TTL of up to 5s, got expiry %v", expiry)
+	}
+
+	if result := restore(bulkStrings("h", "0", payload.Str, "REPLACE", "IDLETIME", "100")); result.Str != "OK" {
+		t.Errorf("Expected REPLACE to overwrite, got %+v", result)
+	}
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	if result := restore(bulkStrings("gone", past, payload.Str, "ABSTTL")); result.Str != "OK" || s.Exists("gone") {
+		t.Errorf("Expected a key restored already expired to be skipped, got %+v", result)
+	}
+
+	corrupt := payload.Str[:len(payload.Str)-1] + "x"
+	if result := restore(bulkStrings("bad", "0", corrupt)); result.Str != "ERR DUMP payload version or checksum are wrong" {
+		t.Errorf("Expected a checksum error, got %+v", result)
+	}
+	if result := restore(bulkStrings("bad", "-1", payload.Str)); result.Str != "ERR Invalid TTL value, must be >= 0" {
+		t.Errorf("Expected a TTL error, got %+v", result)
+	}
+}
+
+// migrateTarget is a minimal server applying RESTORE to its own store. A
+// connection that sent an AUTH with a password other than "secret" is
+// refused until it sends the right one. With hangup set, connections are
+// closed on RESTORE without replying.
+type migrateTarget struct {
+	t        *testing.T
+	store    *store.Store
+	listener net.Listener
+	mu       sync.Mutex
+	conns    []net.Conn
+	hangup   bool
+}
+
+func startMigrateTarget(t *testing.T) *migrateTarget {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	target := &migrateTarget{t: t, store: store.NewStore(), listener: listener}
+	t.Cleanup(func() {
+		listener.Close()
+		target.closeConns()
+	})
+	go target.serve()
+	return target
+}
+
+func (m *migrateTarget) serve() {
+	restore := RestoreCommand(m.store)
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		m.conns = append(m.conns, conn)
+		m.mu.Unlock()
+
+		go func() {
+			parser := resp.NewParser(conn)
+			serializer := resp.NewSerializer(conn)
+			denied := false
+			for {
+				cmd, err := parser.Parse()
+				if err != nil {
+					return
+				}
+				reply := resp.OKValue()
+				switch strings.ToUpper(cmd.Array[0].Str) {
+				case "AUTH":
+					denied = cmd.Array[len(cmd.Array)-1].Str != "secret"
+					if denied {
+						reply = resp.ErrorValue("WRONGPASS invalid username-password pair or user is disabled.")
+					}
+				case "RESTORE":
+					m.mu.Lock()
+					hangup := m.hangup
+					m.mu.Unlock()
+					if hangup {
+						conn.Close()
+						return
+					}
+					if denied {
+						reply = resp.ErrorValue("NOAUTH Authentication required.")
+					} else {
+						reply = restore(cmd.Array[1:])
+					}
+				}
+				serializer.Serialize(reply)
+			}
+		}()
+	}
+}
+
+func (m *migrateTarget) accepted() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.conns)
+}
+
+func (m *migrateTarget) closeConns() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, conn := range m.conns {
+		conn.Close()
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	target := startMigrateTarget(t)
+	host, port, _ := net.SplitHostPort(target.listener.Addr().String())
+	s := store.NewStore()
+	del := DelCommand(s)
+	cache := NewMigrateCache()
+	migrate := MigrateCommand(s, func(args []resp.Value) resp.Value { return del(args[1:]) }, cache, false)
+	run := func(args ...string) resp.Value {
+		return migrate(bulkStrings(append([]string{host, port}, args...)...))
+	}
+
+	s.Set("a", "1")
+	if result := run("a", "0", "1000"); result.Str != "OK" {
+		t.Fatalf("Expected OK, got %+v", result)
+	}
+	if s.Exists("a") {
+		t.Error("Expected the migrated key to be deleted")
+	}
+	if value, _ := target.store.Get("a"); value != "1" {
+		t.Errorf("Expected the key on the target, got %q", value)
+	}
+	if result := run("a", "0", "1000"); result.Str != "NOKEY" {
+		t.Errorf("Expected NOKEY, got %+v", result)
+	}
+
+	s.Set("a", "2")
+	if result := run("a", "0", "1000"); !strings.HasPrefix(result.Str, "ERR Target instance replied with error: BUSYKEY") {
+		t.Errorf("Expected BUSYKEY from the target, got %+v", result)
+	}
+	if !s.Exists("a") {
+		t.Error("Expected a key the target refused to be kept")
+	}
+	if result := run("a", "0", "1000", "COPY", "REPLACE"); result.Str != "OK" {
+		t.Fatalf("Expected OK, got %+v", result)
+	}
+	if value, _ := target.store.Get("a"); value != "2" || !s.Exists("a") {
+		t.Errorf("Expected COPY REPLACE to overwrite the target and keep the key, got %q", value)
+	}
+
+	s.Set("b", "3")
+	if result := run("", "0", "1000", "AUTH", "wrong", "KEYS", "b"); result.Str != "ERR Target instance replied with error: WRONGPASS invalid username-password pair or user is disabled." {
+		t.Errorf("Expected WRONGPASS, got %+v", result)
+	}
+	if result := run("", "0", "1000", "AUTH2", "default", "secret", "KEYS", "b", "missing"); result.Str != "OK" {
+		t.Errorf("Expected OK, got %+v", result)
+	}
+	if s.Exists("b") || !target.store.Exists("b") {
+		t.Error("Expected b to be moved")
+	}
+	if result := run("b", "0", "1000", "KEYS", "c"); !strings.HasPrefix(result.Str, "ERR When using MIGRATE KEYS option") {
+		t.Errorf("Expected an error for a key with KEYS, got %+v", result)
+	}
+
+	// A DEL that fails fails the call, as the key was not moved.
+	failing := MigrateCommand(s, func(args []resp.Value) resp.Value {
+		return resp.ErrorValue("READONLY You can't write against a read only replica.")
+	}, cache, false)
+	s.Set("e", "5")
+	if result := failing(bulkStrings(host, port, "e", "0", "1000", "REPLACE")); !strings.HasPrefix(result.Str, "READONLY") {
+		t.Errorf("Expected the error of the DEL, got %+v", result)
+	}
+	if !s.Exists("e") {
+		t.Error("Expected the key to be kept when its DEL fails")
+	}
+
+	if accepted := target.accepted(); accepted != 1 || cache.Len() != 1 {
+		t.Errorf("Expected one cached connection for every call, got %d connections", accepted)
+	}
+
+	// A cached connection the target closed is replaced.
+	target.closeConns()
+	s.Set("c", "4")
+	if result := run("c", "0", "1000"); result.Str != "OK" {
+		t.Errorf("Expected OK over a new connection, got %+v", result)
+	}
+	if accepted := target.accepted(); accepted != 2 {
+		t.Errorf("Expected a second connection, got %d", accepted)
+	}
+
+	// The RESTORE may have run on the target when the reply is lost, so the
+	// call is not retried.
+	target.mu.Lock()
+	target.hangup = true
+	target.mu.Unlock()
+	s.Set("f", "6")
+	if result := run("f", "0", "1000"); result.Str != "IOERR error or timeout reading to target instance" {
+		t.Errorf("Expected IOERR, got %+v", result)
+	}
+	if accepted := target.accepted(); accepted != 2 || !s.Exists("f") {
+		t.Errorf("Expected no retry and the key kept, got %d connections", accepted)
+	}
+
+	target.listener.Close()
+	target.closeConns()
+	if result := run("c", "0", "1000"); result.Str != "NOKEY" {
+		t.Errorf("Expected NOKEY, got %+v", result)
+	}
+	s.Set("d", "5")
+	if result := run("d", "0", "100"); !strings.HasPrefix(result.Str, "IOERR") {
+		t.Errorf("Expected IOERR, got %+v", result)
+	}
+	if !s.Exists("d") {
+		t.Error("Expected the key to be kept when the target is down")
+	}
+}
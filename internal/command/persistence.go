@@ -1,34 +1,327 @@
 package command
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/lojhan/redis-clone/internal/persistence"
 	"github.com/lojhan/redis-clone/internal/resp"
 	"github.com/lojhan/redis-clone/internal/store"
 )
 
-const DefaultRDBFile = "dump.rdb"
+type SavePoint struct {
+	Seconds int64
+	Changes int64
+}
+
+// ParseSavePoints converts a "save" config value ("3600 1 300 100") into
+// save points. The value is expected to be validated already.
+func ParseSavePoints(value string) []SavePoint {
+	fields := strings.Fields(value)
+	points := make([]SavePoint, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		seconds, _ := strconv.ParseInt(fields[i], 10, 64)
+		changes, _ := strconv.ParseInt(fields[i+1], 10, 64)
+		points = append(points, SavePoint{Seconds: seconds, Changes: changes})
+	}
+	return points
+}
+
+const bgSaveRetryDelay = 5 * time.Second
 
-var (
-	bgSaveMu      sync.Mutex
-	bgSaveRunning bool
+var ErrMisconf = errors.New("MISCONF Redis is configured to save RDB snapshots, but it's currently unable to persist to disk. " +
+	"Commands that may modify the data set are disabled, because this instance is configured to report errors during writes " +
+	"if RDB snapshotting fails (stop-writes-on-bgsave-error option). Please check the Redis logs for details about the RDB error.")
+
+type childKind int
+
+const (
+	childNone childKind = iota
+	childRDB
+	childAOF
 )
 
-func SaveCommand(s *store.Store) func([]resp.Value) resp.Value {
+// PersistenceManager owns everything one server instance needs to persist
+// its data: the working directory and file names, the number of changes
+// since the last save, the outcome of background saves and which
+// background job (the "child", after the forked process Redis uses) is
+// running. Only one child runs at a time.
+type PersistenceManager struct {
+	mu                  sync.Mutex
+	dir                 string
+	dbFilename          string
+	aofFilename         string
+	aofDirname          string
+	aofRDBPreamble      bool
+	rdbOptions          persistence.RDBOptions
+	points              []SavePoint
+	stopWritesOnError   bool
+	dirty               int64
+	dirtyAtStart        int64
+	child               childKind
+	aofRewriteScheduled bool
+	lastSave            time.Time
+	lastAttempt         time.Time
+	lastBgSaveOK        bool
+	aof                 *persistence.AOF
+}
+
+func NewPersistenceManager(dir, dbFilename, aofFilename, aofDirname string) *PersistenceManager {
+	return &PersistenceManager{
+		dir:               dir,
+		dbFilename:        dbFilename,
+		aofFilename:       aofFilename,
+		aofDirname:        aofDirname,
+		aofRDBPreamble:    true,
+		rdbOptions:        persistence.DefaultRDBOptions,
+		stopWritesOnError: true,
+		lastSave:          time.Now(),
+		lastBgSaveOK:      true,
+	}
+}
+
+func (pm *PersistenceManager) SetDir(dir string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.dir = dir
+}
+
+func (pm *PersistenceManager) SetDBFilename(name string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.dbFilename = name
+}
+
+// SetAOF sets the live AOF, or nil when AOF is off, so that background
+// rewrites can switch it to a new base file.
+func (pm *PersistenceManager) SetAOF(aof *persistence.AOF) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.aof = aof
+}
+
+func (pm *PersistenceManager) RDBPath() string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return filepath.Join(pm.dir, pm.dbFilename)
+}
+
+// AOFDir returns the directory holding the multi-part AOF files.
+func (pm *PersistenceManager) AOFDir() string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return filepath.Join(pm.dir, pm.aofDirname)
+}
+
+func (pm *PersistenceManager) AOFFilename() string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.aofFilename
+}
+
+func (pm *PersistenceManager) SetAOFRDBPreamble(enabled bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.aofRDBPreamble = enabled
+}
+
+func (pm *PersistenceManager) AOFRDBPreamble() bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.aofRDBPreamble
+}
+
+func (pm *PersistenceManager) SetRDBCompression(enabled bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.rdbOptions.Compression = enabled
+}
+
+func (pm *PersistenceManager) SetRDBChecksum(enabled bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.rdbOptions.Checksum = enabled
+}
+
+func (pm *PersistenceManager) RDBOptions() persistence.RDBOptions {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.rdbOptions
+}
+
+func (pm *PersistenceManager) SetSavePoints(points []SavePoint) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.points = points
+}
+
+func (pm *PersistenceManager) SetStopWritesOnError(enabled bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.stopWritesOnError = enabled
+}
+
+func (pm *PersistenceManager) AddDirty(n int64) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.dirty += n
+}
+
+func (pm *PersistenceManager) ResetDirty() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.dirty = 0
+}
+
+func (pm *PersistenceManager) Dirty() int64 {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.dirty
+}
+
+func (pm *PersistenceManager) LastSave() time.Time {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.lastSave
+}
+
+func (pm *PersistenceManager) LastBgSaveOK() bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.lastBgSaveOK
+}
+
+func (pm *PersistenceManager) BgSaveInProgress() bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.child == childRDB
+}
+
+func (pm *PersistenceManager) AOFRewriteInProgress() bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.child == childAOF
+}
+
+func (pm *PersistenceManager) AOFRewriteScheduled() bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.aofRewriteScheduled
+}
+
+// CheckWrites returns ErrMisconf when the last background save failed and
+// stop-writes-on-bgsave-error is enabled with save points configured.
+func (pm *PersistenceManager) CheckWrites() error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.stopWritesOnError && len(pm.points) > 0 && !pm.lastBgSaveOK {
+		return ErrMisconf
+	}
+	return nil
+}
+
+// beginBgSave claims the child slot for a background save and returns the
+// file to write, or false if another child is running.
+func (pm *PersistenceManager) beginBgSave() (string, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.child != childNone {
+		return "", false
+	}
+	pm.child = childRDB
+	pm.dirtyAtStart = pm.dirty
+	pm.lastAttempt = time.Now()
+	return filepath.Join(pm.dir, pm.dbFilename), true
+}
+
+func (pm *PersistenceManager) finishBgSave(err error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.child = childNone
+	pm.lastBgSaveOK = err == nil
+	if err == nil {
+		pm.dirty -= pm.dirtyAtStart
+		pm.lastSave = time.Now()
+	}
+}
+
+// beginAOFRewrite claims the child slot for an AOF rewrite and returns the
+// live AOF, if any. If a background save is running the rewrite is
+// scheduled to start once it finishes instead.
+func (pm *PersistenceManager) beginAOFRewrite() (*persistence.AOF, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.child != childNone {
+		if pm.child == childRDB {
+			pm.aofRewriteScheduled = true
+		}
+		return nil, false
+	}
+	pm.child = childAOF
+	pm.aofRewriteScheduled = false
+	return pm.aof, true
+}
+
+func (pm *PersistenceManager) finishAOFRewrite() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.child = childNone
+}
+
+func (pm *PersistenceManager) saved() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.dirty = 0
+	pm.lastSave = time.Now()
+	pm.lastBgSaveOK = true
+}
+
+// dueSavePoint reports the first save point whose change count and elapsed
+// time are both reached. After a failed background save it waits
+// bgSaveRetryDelay before trying again.
+func (pm *PersistenceManager) dueSavePoint(now time.Time) (SavePoint, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pm.child != childNone {
+		return SavePoint{}, false
+	}
+	if !pm.lastBgSaveOK && now.Sub(pm.lastAttempt) < bgSaveRetryDelay {
+		return SavePoint{}, false
+	}
+
+	elapsed := int64(now.Sub(pm.lastSave) / time.Second)
+	for _, point := range pm.points {
+		if pm.dirty >= point.Changes && elapsed >= point.Seconds {
+			return point, true
+		}
+	}
+	return SavePoint{}, false
+}
+
+func SaveCommand(s *store.Store, functions func() []string, pm *PersistenceManager) func([]resp.Value) resp.Value {
 	return func(args []resp.Value) resp.Value {
 		if len(args) != 0 {
 			return resp.ErrorValue("ERR wrong number of arguments for 'save' command")
 		}
 
-		if err := persistence.SaveRDB(DefaultRDBFile, s); err != nil {
+		if pm.BgSaveInProgress() {
+			return resp.ErrorValue("ERR Background save already in progress")
+		}
+
+		if err := persistence.SaveRDBWithFunctions(pm.RDBPath(), s, functions(), pm.RDBOptions()); err != nil {
 			log.Printf("SAVE failed: %v", err)
 			return resp.ErrorValue(fmt.Sprintf("ERR save failed: %v", err))
 		}
+		pm.saved()
 
 		log.Println("DB saved on disk")
 		return resp.Value{
@@ -38,38 +331,45 @@ func SaveCommand(s *store.Store) func([]resp.Value) resp.Value {
 	}
 }
 
-func BGSaveCommand(s *store.Store) func([]resp.Value) resp.Value {
+// BackgroundSave takes a copy-on-write snapshot of the store and writes it
+// to disk in a goroutine while writers carry on. It returns false if a
+// background save or AOF rewrite is already running.
+func BackgroundSave(s *store.Store, libraries []string, pm *PersistenceManager) bool {
+	file, ok := pm.beginBgSave()
+	if !ok {
+		return false
+	}
+
+	opts := pm.RDBOptions()
+	snapshot := s.BeginSnapshot()
+
+	go func() {
+		defer snapshot.Release()
+
+		err := persistence.SaveRDBWithFunctions(file, snapshot.Store(), libraries, opts)
+		if err != nil {
+			log.Printf("Background save failed: %v", err)
+		} else {
+			log.Println("Background saving completed successfully")
+		}
+		pm.finishBgSave(err)
+	}()
+
+	return true
+}
+
+func BGSaveCommand(s *store.Store, functions func() []string, pm *PersistenceManager) func([]resp.Value) resp.Value {
 	return func(args []resp.Value) resp.Value {
 		if len(args) != 0 {
 			return resp.ErrorValue("ERR wrong number of arguments for 'bgsave' command")
 		}
 
-		bgSaveMu.Lock()
-		if bgSaveRunning {
-			bgSaveMu.Unlock()
+		if pm.AOFRewriteInProgress() {
+			return resp.ErrorValue("ERR Another child process is active (AOF?): can't BGSAVE right now")
+		}
+		if !BackgroundSave(s, functions(), pm) {
 			return resp.ErrorValue("ERR Background save already in progress")
 		}
-		bgSaveRunning = true
-		bgSaveMu.Unlock()
-
-		go func() {
-			defer func() {
-				bgSaveMu.Lock()
-				bgSaveRunning = false
-				bgSaveMu.Unlock()
-			}()
-
-			data, expires := s.Snapshot()
-
-			tempStore := store.NewStore()
-			tempStore.RestoreSnapshot(data, expires)
-
-			if err := persistence.SaveRDB(DefaultRDBFile, tempStore); err != nil {
-				log.Printf("Background save failed: %v", err)
-			} else {
-				log.Println("Background saving completed successfully")
-			}
-		}()
 
 		return resp.Value{
 			Type: resp.SimpleString,
@@ -78,62 +378,107 @@ func BGSaveCommand(s *store.Store) func([]resp.Value) resp.Value {
 	}
 }
 
-func LastSaveCommand() func([]resp.Value) resp.Value {
-	return func(args []resp.Value) resp.Value {
-		if len(args) != 0 {
-			return resp.ErrorValue("ERR wrong number of arguments for 'lastsave' command")
+// SaveCron returns the periodic job that starts a scheduled AOF rewrite once
+// no other child is running, or a background save when one of the
+// configured save points is reached.
+func SaveCron(s *store.Store, functions func() []string, pm *PersistenceManager) func() {
+	return func() {
+		if pm.AOFRewriteScheduled() {
+			BackgroundRewriteAOF(s, functions(), pm)
+			return
 		}
 
-		info, err := os.Stat(DefaultRDBFile)
-		if err != nil {
+		point, due := pm.dueSavePoint(time.Now())
+		if !due {
+			return
+		}
 
-			return resp.Value{
-				Type: resp.Integer,
-				Int:  0,
-			}
+		log.Printf("%d changes in %d seconds. Saving...", point.Changes, point.Seconds)
+		BackgroundSave(s, functions(), pm)
+	}
+}
+
+func LastSaveCommand(pm *PersistenceManager) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) != 0 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'lastsave' command")
 		}
 
 		return resp.Value{
 			Type: resp.Integer,
-			Int:  info.ModTime().Unix(),
+			Int:  pm.LastSave().Unix(),
 		}
 	}
 }
 
-var (
-	bgRewriteMu      sync.Mutex
-	bgRewriteRunning bool
-)
+// BackgroundRewriteAOF writes a new AOF base file from a copy-on-write
+// snapshot in a goroutine. A running AOF first switches to a new incr file,
+// so commands logged meanwhile are kept without buffering them. It returns
+// false if the rewrite could not start now; while a background save runs
+// the rewrite is scheduled for when it completes.
+func BackgroundRewriteAOF(s *store.Store, libraries []string, pm *PersistenceManager) (bool, error) {
+	aof, ok := pm.beginAOFRewrite()
+	if !ok {
+		return false, nil
+	}
 
-const DefaultAOFFile = "appendonly.aof"
+	dir, name, preamble, opts := pm.AOFDir(), pm.AOFFilename(), pm.AOFRDBPreamble(), pm.RDBOptions()
+	var tmpFile string
+	if aof != nil {
+		var err error
+		if tmpFile, err = aof.StartRewrite(preamble); err != nil {
+			pm.finishAOFRewrite()
+			log.Printf("Can't rewrite append only file in background: %v", err)
+			return false, err
+		}
+	}
+	snapshot := s.BeginSnapshot()
 
-func BGRewriteAOFCommand(s *store.Store) func([]resp.Value) resp.Value {
+	go func() {
+		defer func() {
+			snapshot.Release()
+			pm.finishAOFRewrite()
+		}()
+
+		var err error
+		if aof == nil {
+			err = persistence.RewriteAOFDir(dir, name, snapshot.Store(), libraries, preamble, opts)
+		} else if err = persistence.WriteAOFBase(tmpFile, snapshot.Store(), libraries, preamble, opts); err != nil {
+			aof.AbortRewrite(tmpFile)
+		} else {
+			err = aof.FinishRewrite(tmpFile)
+		}
+
+		if err != nil {
+			log.Printf("Background AOF rewrite failed: %v", err)
+		} else {
+			log.Println("Background AOF rewrite completed successfully")
+		}
+	}()
+
+	return true, nil
+}
+
+func BGRewriteAOFCommand(s *store.Store, functions func() []string, pm *PersistenceManager) func([]resp.Value) resp.Value {
 	return func(args []resp.Value) resp.Value {
 		if len(args) != 0 {
 			return resp.ErrorValue("ERR wrong number of arguments for 'bgrewriteaof' command")
 		}
 
-		bgRewriteMu.Lock()
-		if bgRewriteRunning {
-			bgRewriteMu.Unlock()
-			return resp.ErrorValue("ERR Background AOF rewrite already in progress")
+		if pm.AOFRewriteInProgress() {
+			return resp.ErrorValue("ERR Background append only file rewriting already in progress")
 		}
-		bgRewriteRunning = true
-		bgRewriteMu.Unlock()
 
-		go func() {
-			defer func() {
-				bgRewriteMu.Lock()
-				bgRewriteRunning = false
-				bgRewriteMu.Unlock()
-			}()
-
-			if err := persistence.RewriteAOF(DefaultAOFFile, s); err != nil {
-				log.Printf("Background AOF rewrite failed: %v", err)
-			} else {
-				log.Println("Background AOF rewrite completed successfully")
+		started, err := BackgroundRewriteAOF(s, functions(), pm)
+		if err != nil {
+			return resp.ErrorValue("ERR Can't execute an AOF background rewriting. Please check the server logs for more information.")
+		}
+		if !started {
+			return resp.Value{
+				Type: resp.SimpleString,
+				Str:  "Background append only file rewriting scheduled",
 			}
-		}()
+		}
 
 		return resp.Value{
 			Type: resp.SimpleString,
@@ -142,12 +487,12 @@ func BGRewriteAOFCommand(s *store.Store) func([]resp.Value) resp.Value {
 	}
 }
 
-func ShutdownCommand(s *store.Store) func([]resp.Value) resp.Value {
+func ShutdownCommand(s *store.Store, functions func() []string, pm *PersistenceManager) func([]resp.Value) resp.Value {
 	return func(args []resp.Value) resp.Value {
 
 		save := true
 		if len(args) > 0 {
-			arg := args[0].Str
+			arg := strings.ToUpper(args[0].Str)
 			if arg == "NOSAVE" {
 				save = false
 			} else if arg != "SAVE" {
@@ -157,9 +502,10 @@ func ShutdownCommand(s *store.Store) func([]resp.Value) resp.Value {
 
 		if save {
 			log.Println("Saving DB before shutdown...")
-			if err := persistence.SaveRDB(DefaultRDBFile, s); err != nil {
+			if err := persistence.SaveRDBWithFunctions(pm.RDBPath(), s, functions(), pm.RDBOptions()); err != nil {
 				log.Printf("Warning: Failed to save DB: %v", err)
 			} else {
+				pm.saved()
 				log.Println("DB saved")
 			}
 		}
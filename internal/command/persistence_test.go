@@ -0,0 +1,353 @@
+package command
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/persistence"
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+func TestParseSavePoints(t *testing.T) {
+	points := ParseSavePoints("3600 1 300 100")
+	if len(points) != 2 || points[0] != (SavePoint{3600, 1}) || points[1] != (SavePoint{300, 100}) {
+		t.Errorf("Unexpected save points: %v", points)
+	}
+
+	if len(ParseSavePoints("")) != 0 {
+		t.Error("Expected an empty value to disable save points")
+	}
+}
+
+func TestDueSavePoint(t *testing.T) {
+	state := NewPersistenceManager(t.TempDir(), "dump.rdb", "appendonly.aof", "appendonlydir")
+	state.SetSavePoints([]SavePoint{{Seconds: 60, Changes: 10}, {Seconds: 1, Changes: 1000}})
+	now := state.LastSave()
+
+	state.AddDirty(10)
+	if _, due := state.dueSavePoint(now.Add(30 * time.Second)); due {
+		t.Error("Save point should not be due before its interval elapses")
+	}
+
+	point, due := state.dueSavePoint(now.Add(61 * time.Second))
+	if !due || point.Seconds != 60 {
+		t.Errorf("Expected the 60 second save point to be due, got %v %v", point, due)
+	}
+
+	state.AddDirty(990)
+	point, due = state.dueSavePoint(now.Add(2 * time.Second))
+	if !due || point.Changes != 1000 {
+		t.Errorf("Expected the 1000 changes save point to be due, got %v %v", point, due)
+	}
+}
+
+func TestFailedBackgroundSave(t *testing.T) {
+	state := NewPersistenceManager(t.TempDir(), "dump.rdb", "appendonly.aof", "appendonlydir")
+	state.SetSavePoints([]SavePoint{{Seconds: 0, Changes: 1}})
+	state.AddDirty(5)
+
+	if _, ok := state.beginBgSave(); !ok {
+		t.Fatal("Expected background save to start")
+	}
+	if _, ok := state.beginBgSave(); ok {
+		t.Error("Only one background save may run at a time")
+	}
+	state.finishBgSave(errors.New("disk full"))
+
+	if state.LastBgSaveOK() || state.Dirty() != 5 {
+		t.Errorf("Failed save should keep changes, got ok=%v dirty=%d", state.LastBgSaveOK(), state.Dirty())
+	}
+	if state.CheckWrites() != ErrMisconf {
+		t.Error("Expected writes to be refused after a failed background save")
+	}
+	if _, due := state.dueSavePoint(time.Now()); due {
+		t.Error("Expected retry to wait after a failed background save")
+	}
+	if _, due := state.dueSavePoint(time.Now().Add(bgSaveRetryDelay)); !due {
+		t.Error("Expected retry once the delay has passed")
+	}
+
+	state.SetStopWritesOnError(false)
+	if state.CheckWrites() != nil {
+		t.Error("Writes should be allowed with stop-writes-on-bgsave-error disabled")
+	}
+
+	state.SetStopWritesOnError(true)
+	state.SetSavePoints(nil)
+	if state.CheckWrites() != nil {
+		t.Error("Writes should be allowed when no save points are configured")
+	}
+}
+
+func TestSaveCronTriggersBackgroundSave(t *testing.T) {
+	s := store.NewStore()
+	s.Set("key", "value")
+
+	state := NewPersistenceManager(t.TempDir(), "dump.rdb", "appendonly.aof", "appendonlydir")
+	state.SetSavePoints([]SavePoint{{Seconds: 0, Changes: 1}})
+	cron := SaveCron(s, func() []string { return nil }, state)
+
+	cron()
+	if state.BgSaveInProgress() {
+		t.Fatal("Cron should not save without changes")
+	}
+
+	state.AddDirty(1)
+	before := state.LastSave()
+	cron()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for state.BgSaveInProgress() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !state.LastBgSaveOK() || state.Dirty() != 0 || !state.LastSave().After(before) {
+		t.Errorf("Expected a successful save, got ok=%v dirty=%d", state.LastBgSaveOK(), state.Dirty())
+	}
+	if _, err := os.Stat(state.RDBPath()); err != nil {
+		t.Errorf("Expected RDB file to be written: %v", err)
+	}
+}
+
+func TestSaveCommandResetsChanges(t *testing.T) {
+	state := NewPersistenceManager(t.TempDir(), "dump.rdb", "appendonly.aof", "appendonlydir")
+	state.AddDirty(3)
+
+	result := SaveCommand(store.NewStore(), func() []string { return nil }, state)([]resp.Value{})
+	if result.Type == resp.Error {
+		t.Fatalf("SAVE failed: %s", result.Str)
+	}
+	if state.Dirty() != 0 {
+		t.Errorf("Expected SAVE to reset changes, got %d", state.Dirty())
+	}
+}
+
+func TestBackgroundSaveDuringWrites(t *testing.T) {
+	s := store.NewStore()
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		s.RPush("list", key)
+		s.HSet("hash", key, key)
+		s.SAdd("set", key)
+		s.ZAdd("zset", float64(i), key)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := strconv.Itoa(i % 2000)
+				switch (i + w) % 5 {
+				case 0:
+					s.RPush("list", key)
+					s.LPop("list")
+				case 1:
+					s.HSet("hash", key, "changed")
+					s.HDel("hash", key)
+				case 2:
+					s.SAdd("set", key)
+					s.SRem("set", key)
+				case 3:
+					s.ZAdd("zset", float64(-i), key)
+					s.ZRem("zset", key)
+				case 4:
+					s.Set("str:"+key, key)
+					s.Delete("str:" + key)
+				}
+			}
+		}(w)
+	}
+
+	state := NewPersistenceManager(t.TempDir(), "dump.rdb", "appendonly.aof", "appendonlydir")
+	for i := 0; i < 5; i++ {
+		if !BackgroundSave(s, nil, state) {
+			t.Fatal("Expected background save to start")
+		}
+		for state.BgSaveInProgress() {
+			time.Sleep(time.Millisecond)
+		}
+		if !state.LastBgSaveOK() {
+			t.Fatal("Background save failed")
+		}
+
+		loaded := store.NewStore()
+		if err := persistence.LoadRDB(state.RDBPath(), loaded); err != nil {
+			t.Fatalf("Saved RDB does not load: %v", err)
+		}
+		for _, key := range []string{"list", "hash", "set", "zset"} {
+			if !loaded.Exists(key) {
+				t.Errorf("Snapshot %d is missing %s", i, key)
+			}
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestLastSaveAndFilesFollowManager(t *testing.T) {
+	dir := t.TempDir()
+	state := NewPersistenceManager(dir, "first.rdb", "appendonly.aof", "appendonlydir")
+	save := SaveCommand(store.NewStore(), func() []string { return nil }, state)
+
+	before := LastSaveCommand(state)([]resp.Value{}).Int
+	time.Sleep(1100 * time.Millisecond)
+	if result := save([]resp.Value{}); result.Type == resp.Error {
+		t.Fatalf("SAVE failed: %s", result.Str)
+	}
+	after := LastSaveCommand(state)([]resp.Value{}).Int
+	if after <= before {
+		t.Errorf("Expected LASTSAVE to advance after SAVE, got %d then %d", before, after)
+	}
+
+	state.SetDBFilename("second.rdb")
+	save([]resp.Value{})
+	for _, name := range []string{"first.rdb", "second.rdb"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s in the configured dir: %v", name, err)
+		}
+	}
+}
+
+func TestSeparateManagersDoNotShareFiles(t *testing.T) {
+	a := NewPersistenceManager(t.TempDir(), "dump.rdb", "appendonly.aof", "appendonlydir")
+	b := NewPersistenceManager(t.TempDir(), "dump.rdb", "appendonly.aof", "appendonlydir")
+	if a.RDBPath() == b.RDBPath() {
+		t.Fatal("Expected each manager to write in its own dir")
+	}
+
+	if _, ok := a.beginBgSave(); !ok {
+		t.Fatal("Expected background save to start")
+	}
+	if _, ok := b.beginBgSave(); !ok {
+		t.Error("A background save on one manager should not block another")
+	}
+}
+
+func TestAOFRewriteScheduledDuringBackgroundSave(t *testing.T) {
+	state := NewPersistenceManager(t.TempDir(), "dump.rdb", "appendonly.aof", "appendonlydir")
+	s := store.NewStore()
+	s.Set("key", "value")
+	rewrite := BGRewriteAOFCommand(s, func() []string { return nil }, state)
+
+	if _, ok := state.beginBgSave(); !ok {
+		t.Fatal("Expected background save to start")
+	}
+	result := rewrite([]resp.Value{})
+	if result.Str != "Background append only file rewriting scheduled" || !state.AOFRewriteScheduled() {
+		t.Fatalf("Expected rewrite to be scheduled, got %q", result.Str)
+	}
+
+	result = BGSaveCommand(s, func() []string { return nil }, state)([]resp.Value{})
+	if result.Type != resp.Error {
+		t.Error("Expected BGSAVE to be refused while another child runs")
+	}
+
+	state.finishBgSave(nil)
+	SaveCron(s, func() []string { return nil }, state)()
+	if state.AOFRewriteScheduled() {
+		t.Error("Expected cron to start the scheduled rewrite")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for state.AOFRewriteInProgress() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := persistence.LoadManifest(state.AOFDir(), state.AOFFilename()); err != nil {
+		t.Errorf("Expected AOF to be rewritten in the configured dir: %v", err)
+	}
+}
+
+func TestBackgroundRewriteAOFKeepsLiveWrites(t *testing.T) {
+	state := NewPersistenceManager(t.TempDir(), "dump.rdb", "appendonly.aof", "appendonlydir")
+	aof, err := persistence.OpenAOF(state.AOFDir(), state.AOFFilename(), persistence.AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.SetAOF(aof)
+
+	set := func(key string) []resp.Value {
+		return []resp.Value{{Type: resp.BulkString, Str: "SET"}, {Type: resp.BulkString, Str: key}, {Type: resp.BulkString, Str: "1"}}
+	}
+
+	s := store.NewStore()
+	s.Set("before", "1")
+	if started, err := BackgroundRewriteAOF(s, nil, state); !started || err != nil {
+		t.Fatal("Expected AOF rewrite to start")
+	}
+	aof.Append(set("during"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for state.AOFRewriteInProgress() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	aof.Append(set("after"))
+	aof.Close()
+
+	loaded := store.NewStore()
+	_, err = persistence.LoadAOFDir(state.AOFDir(), state.AOFFilename(), loaded, func(values []resp.Value) resp.Value {
+		loaded.Set(values[1].Str, values[2].Str)
+		return resp.Value{Type: resp.SimpleString, Str: "OK"}
+	}, persistence.AOFLoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"before", "during", "after"} {
+		if !loaded.Exists(key) {
+			t.Errorf("Expected %s in the rewritten AOF", key)
+		}
+	}
+}
+
+func TestBackgroundRewriteAOFWithoutPreambleKeepsTTL(t *testing.T) {
+	state := NewPersistenceManager(t.TempDir(), "dump.rdb", "appendonly.aof", "appendonlydir")
+	state.SetAOFRDBPreamble(false)
+	aof, err := persistence.OpenAOF(state.AOFDir(), state.AOFFilename(), persistence.AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.SetAOF(aof)
+
+	s := store.NewStore()
+	expiry := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	s.SetWithExpiry("key", "value", expiry)
+	if started, err := BackgroundRewriteAOF(s, nil, state); !started || err != nil {
+		t.Fatal("Expected AOF rewrite to start")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for state.AOFRewriteInProgress() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	aof.Close()
+
+	loaded := store.NewStore()
+	handlers := map[string]func([]resp.Value) resp.Value{
+		"SET":       SetCommand(loaded),
+		"PEXPIREAT": PExpireAtCommand(loaded),
+	}
+	_, err = persistence.LoadAOFDir(state.AOFDir(), state.AOFFilename(), loaded, func(values []resp.Value) resp.Value {
+		return handlers[values[0].Str](values[1:])
+	}, persistence.AOFLoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ttl, exists := loaded.Object("key"); !exists || !ttl.Equal(expiry) {
+		t.Errorf("Expected the key to be reloaded with its TTL %v, got %v", expiry, ttl)
+	}
+}
@@ -0,0 +1,130 @@
+package command
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/scripting"
+)
+
+func EvalCommand(e *scripting.Engine) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) < 2 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'eval' command")
+		}
+
+		keys, argv, err := parseKeysAndArgs(args[1:])
+		if err != nil {
+			return resp.ErrorValue(err.Error())
+		}
+
+		return e.Eval(args[0].Str, keys, argv)
+	}
+}
+
+func EvalShaCommand(e *scripting.Engine) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) < 2 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'evalsha' command")
+		}
+
+		keys, argv, err := parseKeysAndArgs(args[1:])
+		if err != nil {
+			return resp.ErrorValue(err.Error())
+		}
+
+		return e.EvalSha(args[0].Str, keys, argv)
+	}
+}
+
+func ScriptCommand(e *scripting.Engine) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) == 0 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'script' command")
+		}
+
+		subcommand := strings.ToUpper(args[0].Str)
+
+		switch subcommand {
+		case "LOAD":
+			if len(args) != 2 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'script|load' command")
+			}
+
+			sha, err := e.Load(args[1].Str)
+			if err != nil {
+				return resp.ErrorValue(err.Error())
+			}
+			return resp.BulkStringValue(sha)
+
+		case "EXISTS":
+			if len(args) < 2 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'script|exists' command")
+			}
+
+			results := make([]resp.Value, len(args)-1)
+			for i, arg := range args[1:] {
+				if e.Exists(arg.Str) {
+					results[i] = resp.IntegerValue(1)
+				} else {
+					results[i] = resp.IntegerValue(0)
+				}
+			}
+			return resp.ArrayValue(results...)
+
+		case "FLUSH":
+			if len(args) > 2 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'script|flush' command")
+			}
+			if len(args) == 2 {
+				mode := strings.ToUpper(args[1].Str)
+				if mode != "ASYNC" && mode != "SYNC" {
+					return resp.ErrorValue("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+				}
+			}
+
+			e.Flush()
+			return resp.OKValue()
+
+		case "KILL":
+			if len(args) != 1 {
+				return resp.ErrorValue("ERR wrong number of arguments for 'script|kill' command")
+			}
+
+			if err := e.Kill(); err != nil {
+				return resp.ErrorValue(err.Error())
+			}
+			return resp.OKValue()
+
+		default:
+			return resp.ErrorValue("ERR unknown subcommand '" + subcommand + "'. Try SCRIPT HELP.")
+		}
+	}
+}
+
+func parseKeysAndArgs(args []resp.Value) ([]string, []string, error) {
+	numKeys, err := strconv.Atoi(args[0].Str)
+	if err != nil {
+		return nil, nil, errors.New("ERR value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return nil, nil, errors.New("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(args)-1 {
+		return nil, nil, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+
+	keys := make([]string, numKeys)
+	for i := range numKeys {
+		keys[i] = args[1+i].Str
+	}
+
+	argv := make([]string, len(args)-1-numKeys)
+	for i := range argv {
+		argv[i] = args[1+numKeys+i].Str
+	}
+
+	return keys, argv, nil
+}
@@ -0,0 +1,132 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/scripting"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+func newTestScriptEngine(s *store.Store) *scripting.Engine {
+	handlers := map[string]func([]resp.Value) resp.Value{
+		"SET":  SetCommand(s),
+		"GET":  GetCommand(s),
+		"INCR": IncrCommand(s),
+	}
+
+	call := func(args []resp.Value) resp.Value {
+		handler, exists := handlers[strings.ToUpper(args[0].Str)]
+		if !exists {
+			return resp.ErrorValue("ERR unknown command")
+		}
+		return handler(args[1:])
+	}
+
+	return scripting.NewEngine(call, nil)
+}
+
+func bulkArgs(args ...string) []resp.Value {
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.BulkStringValue(arg)
+	}
+	return values
+}
+
+func TestEvalCommand(t *testing.T) {
+	s := store.NewStore()
+	eval := EvalCommand(newTestScriptEngine(s))
+
+	result := eval(bulkArgs("redis.call('SET', KEYS[1], ARGV[1]); return redis.call('INCR', KEYS[1])", "1", "counter", "41"))
+	if result.Type != resp.Integer || result.Int != 42 {
+		t.Errorf("Expected 42, got %+v", result)
+	}
+
+	value, _ := s.Get("counter")
+	if value != "42" {
+		t.Errorf("Expected counter=42 in store, got %q", value)
+	}
+}
+
+func TestEvalCommandNumKeysValidation(t *testing.T) {
+	eval := EvalCommand(newTestScriptEngine(store.NewStore()))
+
+	tests := []struct {
+		args     []resp.Value
+		expected string
+	}{
+		{bulkArgs("return 1"), "ERR wrong number of arguments for 'eval' command"},
+		{bulkArgs("return 1", "abc"), "ERR value is not an integer or out of range"},
+		{bulkArgs("return 1", "-1"), "ERR Number of keys can't be negative"},
+		{bulkArgs("return 1", "2", "k1"), "ERR Number of keys can't be greater than number of args"},
+	}
+
+	for _, tt := range tests {
+		result := eval(tt.args)
+		if result.Type != resp.Error || result.Str != tt.expected {
+			t.Errorf("Expected %q, got %+v", tt.expected, result)
+		}
+	}
+}
+
+func TestEvalShaCommand(t *testing.T) {
+	e := newTestScriptEngine(store.NewStore())
+	evalsha := EvalShaCommand(e)
+	script := ScriptCommand(e)
+
+	result := evalsha(bulkArgs(scripting.SHA1Hex("return 'hi'"), "0"))
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "NOSCRIPT") {
+		t.Errorf("Expected NOSCRIPT, got %+v", result)
+	}
+
+	result = script(bulkArgs("LOAD", "return 'hi'"))
+	if result.Type != resp.BulkString || result.Str != scripting.SHA1Hex("return 'hi'") {
+		t.Fatalf("Expected sha from SCRIPT LOAD, got %+v", result)
+	}
+
+	result = evalsha(bulkArgs(result.Str, "0"))
+	if result.Type != resp.BulkString || result.Str != "hi" {
+		t.Errorf("Expected 'hi', got %+v", result)
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	script := ScriptCommand(newTestScriptEngine(store.NewStore()))
+
+	sha := script(bulkArgs("LOAD", "return 1")).Str
+
+	result := script(bulkArgs("EXISTS", sha, "0000000000000000000000000000000000000000"))
+	if result.Type != resp.Array || len(result.Array) != 2 {
+		t.Fatalf("Expected 2-element array, got %+v", result)
+	}
+	if result.Array[0].Int != 1 || result.Array[1].Int != 0 {
+		t.Errorf("Unexpected SCRIPT EXISTS result: %+v", result.Array)
+	}
+
+	result = script(bulkArgs("FLUSH"))
+	if result.Type != resp.SimpleString || result.Str != "OK" {
+		t.Errorf("Expected OK from SCRIPT FLUSH, got %+v", result)
+	}
+
+	result = script(bulkArgs("EXISTS", sha))
+	if result.Array[0].Int != 0 {
+		t.Error("Script should be gone after SCRIPT FLUSH")
+	}
+
+	result = script(bulkArgs("KILL"))
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "NOTBUSY") {
+		t.Errorf("Expected NOTBUSY, got %+v", result)
+	}
+
+	result = script(bulkArgs("FLUSH", "LATER"))
+	if result.Type != resp.Error {
+		t.Errorf("Expected error for invalid flush mode, got %+v", result)
+	}
+
+	result = script(bulkArgs("NOPE"))
+	if result.Type != resp.Error {
+		t.Errorf("Expected error for unknown subcommand, got %+v", result)
+	}
+}
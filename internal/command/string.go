@@ -38,7 +38,7 @@ func SetCommand(s *store.Store) func([]resp.Value) resp.Value {
 				nx = true
 			case "XX":
 				xx = true
-			case "EX":
+			case "EX", "PX", "EXAT", "PXAT":
 
 				if i+1 >= len(args) {
 					return resp.ErrorValue("ERR syntax error")
@@ -47,27 +47,21 @@ func SetCommand(s *store.Store) func([]resp.Value) resp.Value {
 				if args[i].Type != resp.BulkString {
 					return resp.ErrorValue("ERR invalid argument type")
 				}
-				seconds, err := strconv.ParseInt(args[i].Str, 10, 64)
-				if err != nil || seconds <= 0 {
+				amount, err := strconv.ParseInt(args[i].Str, 10, 64)
+				if err != nil || amount <= 0 {
 					return resp.ErrorValue("ERR invalid expire time in 'set' command")
 				}
-				expiryTime := time.Now().Add(time.Duration(seconds) * time.Second)
-				expiry = &expiryTime
-
-			case "PX":
-
-				if i+1 >= len(args) {
-					return resp.ErrorValue("ERR syntax error")
+				var expiryTime time.Time
+				switch option {
+				case "EX":
+					expiryTime = time.Now().Add(time.Duration(amount) * time.Second)
+				case "PX":
+					expiryTime = time.Now().Add(time.Duration(amount) * time.Millisecond)
+				case "EXAT":
+					expiryTime = time.Unix(amount, 0)
+				case "PXAT":
+					expiryTime = time.UnixMilli(amount)
 				}
-				i++
-				if args[i].Type != resp.BulkString {
-					return resp.ErrorValue("ERR invalid argument type")
-				}
-				millis, err := strconv.ParseInt(args[i].Str, 10, 64)
-				if err != nil || millis <= 0 {
-					return resp.ErrorValue("ERR invalid expire time in 'set' command")
-				}
-				expiryTime := time.Now().Add(time.Duration(millis) * time.Millisecond)
 				expiry = &expiryTime
 
 			default:
@@ -188,6 +182,61 @@ func ExistsCommand(s *store.Store) func([]resp.Value) resp.Value {
 	}
 }
 
+func PExpireAtCommand(s *store.Store) func([]resp.Value) resp.Value {
+	return func(args []resp.Value) resp.Value {
+		if len(args) < 2 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'pexpireat' command")
+		}
+
+		key := args[0].Str
+		ms, err := strconv.ParseInt(args[1].Str, 10, 64)
+		if err != nil {
+			return resp.ErrorValue("ERR value is not an integer or out of range")
+		}
+
+		var nx, xx, gt, lt bool
+		for _, arg := range args[2:] {
+			switch strings.ToUpper(arg.Str) {
+			case "NX":
+				nx = true
+			case "XX":
+				xx = true
+			case "GT":
+				gt = true
+			case "LT":
+				lt = true
+			default:
+				return resp.ErrorValue("ERR Unsupported option " + arg.Str)
+			}
+		}
+		if nx && (xx || gt || lt) {
+			return resp.ErrorValue("ERR NX and XX, GT or LT options at the same time are not compatible")
+		}
+		if gt && lt {
+			return resp.ErrorValue("ERR GT and LT options at the same time are not compatible")
+		}
+
+		_, current, exists := s.Object(key)
+		if !exists {
+			return resp.IntegerValue(0)
+		}
+
+		// A key without a TTL never expires, so it is greater than any time.
+		expiry := time.UnixMilli(ms)
+		hasTTL := !current.IsZero()
+		if (nx && hasTTL) || (xx && !hasTTL) ||
+			(gt && (!hasTTL || !expiry.After(current))) ||
+			(lt && hasTTL && !expiry.Before(current)) {
+			return resp.IntegerValue(0)
+		}
+
+		if !s.PExpireAt(key, expiry) {
+			return resp.IntegerValue(0)
+		}
+		return resp.IntegerValue(1)
+	}
+}
+
 func TypeCommand(s *store.Store) func([]resp.Value) resp.Value {
 	return func(args []resp.Value) resp.Value {
 		if len(args) != 1 {
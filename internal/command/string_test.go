@@ -1,6 +1,7 @@
 package command
 
 import (
+	"strconv"
 	"testing"
 	"time"
 
@@ -262,6 +263,48 @@ func TestTypeCommand(t *testing.T) {
 	}
 }
 
+func TestPExpireAtCommand(t *testing.T) {
+	s := store.NewStore()
+	pexpireat := PExpireAtCommand(s)
+	at := func(d time.Duration) resp.Value {
+		return resp.BulkStringValue(strconv.FormatInt(time.Now().Add(d).UnixMilli(), 10))
+	}
+
+	if result := pexpireat([]resp.Value{resp.BulkStringValue("missing"), at(time.Hour)}); result.Int != 0 {
+		t.Errorf("Expected 0 for a missing key, got %+v", result)
+	}
+
+	s.Set("key", "value")
+	tests := []struct {
+		args     []resp.Value
+		expected int64
+	}{
+		{[]resp.Value{at(time.Hour), resp.BulkStringValue("XX")}, 0},
+		{[]resp.Value{at(time.Hour), resp.BulkStringValue("GT")}, 0},
+		{[]resp.Value{at(time.Hour), resp.BulkStringValue("NX")}, 1},
+		{[]resp.Value{at(2 * time.Hour), resp.BulkStringValue("NX")}, 0},
+		{[]resp.Value{at(2 * time.Hour), resp.BulkStringValue("LT")}, 0},
+		{[]resp.Value{at(2 * time.Hour), resp.BulkStringValue("gt")}, 1},
+	}
+	for i, tt := range tests {
+		result := pexpireat(append([]resp.Value{resp.BulkStringValue("key")}, tt.args...))
+		if result.Type != resp.Integer || result.Int != tt.expected {
+			t.Errorf("Case %d: expected %d, got %+v", i, tt.expected, result)
+		}
+	}
+
+	if result := pexpireat([]resp.Value{resp.BulkStringValue("key"), at(time.Hour), resp.BulkStringValue("NX"), resp.BulkStringValue("GT")}); result.Type != resp.Error {
+		t.Errorf("Expected NX and GT to be refused together, got %+v", result)
+	}
+
+	if result := pexpireat([]resp.Value{resp.BulkStringValue("key"), at(-time.Second)}); result.Int != 1 {
+		t.Errorf("Expected 1, got %+v", result)
+	}
+	if s.Exists("key") {
+		t.Error("Expected a time in the past to delete the key")
+	}
+}
+
 func TestIncrCommand(t *testing.T) {
 	s := store.NewStore()
 	incrCmd := IncrCommand(s)
@@ -136,8 +136,8 @@ func ZScoreCommand(s *store.Store) func([]resp.Value) resp.Value {
 		}
 
 		return resp.Value{
-			Type: resp.BulkString,
-			Str:  strconv.FormatFloat(score, 'f', -1, 64),
+			Type:   resp.Double,
+			Double: score,
 		}
 	}
 }
@@ -128,8 +128,8 @@ func TestZScoreCommand(t *testing.T) {
 		{Type: resp.BulkString, Str: "one"},
 	})
 
-	if result.Type != resp.BulkString || result.Str != "1.5" {
-		t.Errorf("Expected score '1.5', got '%s'", result.Str)
+	if result.Type != resp.Double || result.Double != 1.5 {
+		t.Errorf("Expected score 1.5, got %v", result.Double)
 	}
 
 	result = zscore([]resp.Value{
@@ -0,0 +1,544 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+type ParamType int
+
+const (
+	TypeString ParamType = iota
+	TypeBool
+	TypeInt
+	TypeMemory
+	TypeEnum
+)
+
+var ErrNoConfigFile = errors.New("The server is running without a config file")
+
+type ApplyFunc func(value string) error
+
+type Param struct {
+	Name      string
+	Type      ParamType
+	Default   string
+	Enum      []string
+	Min       int64
+	Max       int64
+	Immutable bool
+	Normalize func(value string) (string, error)
+
+	value string
+	apply ApplyFunc
+}
+
+type Config struct {
+	mu     sync.RWMutex
+	params map[string]*Param
+	file   string
+}
+
+func New() *Config {
+	c := &Config{params: make(map[string]*Param)}
+	for _, p := range defaultParams() {
+		c.Register(p)
+	}
+	return c
+}
+
+func defaultParams() []*Param {
+	return []*Param{
+		{Name: "port", Type: TypeInt, Default: "6379", Min: 0, Max: 65535, Immutable: true},
+		{Name: "dir", Type: TypeString, Default: ".", Normalize: normalizeDir},
+		{Name: "dbfilename", Type: TypeString, Default: "dump.rdb", Normalize: normalizeFilename},
+		{Name: "save", Type: TypeString, Default: "3600 1 300 100 60 10000", Normalize: normalizeSave},
+		{Name: "stop-writes-on-bgsave-error", Type: TypeBool, Default: "yes"},
+		{Name: "rdbcompression", Type: TypeBool, Default: "yes"},
+		{Name: "rdbchecksum", Type: TypeBool, Default: "yes", Immutable: true},
+		{Name: "appendonly", Type: TypeBool, Default: "no"},
+		{Name: "appendfilename", Type: TypeString, Default: "appendonly.aof", Immutable: true, Normalize: normalizeFilename},
+		{Name: "appenddirname", Type: TypeString, Default: "appendonlydir", Immutable: true, Normalize: normalizeFilename},
+		{Name: "aof-use-rdb-preamble", Type: TypeBool, Default: "yes"},
+		{Name: "appendfsync", Type: TypeEnum, Default: "everysec", Enum: []string{"always", "everysec", "no"}},
+		{Name: "aof-load-truncated", Type: TypeBool, Default: "yes"},
+		{Name: "aof-load-corrupt", Type: TypeBool, Default: "no"},
+		{Name: "aof-timestamp-enabled", Type: TypeBool, Default: "no"},
+		{Name: "maxmemory", Type: TypeMemory, Default: "0", Min: 0, Max: 1<<63 - 1},
+		{Name: "maxmemory-policy", Type: TypeEnum, Default: "noeviction", Enum: []string{
+			"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl",
+			"allkeys-lru", "allkeys-lfu", "allkeys-random", "noeviction",
+		}},
+		{Name: "maxmemory-samples", Type: TypeInt, Default: "5", Min: 1, Max: 64},
+		{Name: "requirepass", Type: TypeString, Default: ""},
+		{Name: "lua-time-limit", Type: TypeInt, Default: "5000", Min: 0, Max: 1<<31 - 1},
+		{Name: "replicaof", Type: TypeString, Default: "", Immutable: true, Normalize: normalizeReplicaOf},
+		{Name: "masterauth", Type: TypeString, Default: ""},
+		{Name: "replica-read-only", Type: TypeBool, Default: "yes"},
+		{Name: "repl-diskless-sync", Type: TypeBool, Default: "yes"},
+		{Name: "repl-backlog-size", Type: TypeMemory, Default: "1mb", Min: 16 * 1024, Max: 1<<63 - 1},
+		{Name: "repl-timeout", Type: TypeInt, Default: "60", Min: 1, Max: 1<<31 - 1},
+		{Name: "cluster-enabled", Type: TypeBool, Default: "no", Immutable: true},
+		{Name: "cluster-config-file", Type: TypeString, Default: "nodes.conf", Immutable: true, Normalize: normalizeFilename},
+		{Name: "cluster-node-timeout", Type: TypeInt, Default: "15000", Min: 1, Max: 1<<31 - 1},
+		{Name: "cluster-port", Type: TypeInt, Default: "0", Min: 0, Max: 65535, Immutable: true},
+	}
+}
+
+func (c *Config) Register(p *Param) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p.value = p.Default
+	c.params[p.Name] = p
+}
+
+// OnChange installs the hook that applies a new value to the running server.
+// Hooks run on CONFIG SET only, not while the config is being loaded.
+func (c *Config) OnChange(name string, apply ApplyFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, exists := c.params[name]; exists {
+		p.apply = apply
+	}
+}
+
+func (c *Config) SetFile(file string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file = file
+}
+
+func (c *Config) File() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.file
+}
+
+func (c *Config) Get(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if p, exists := c.params[strings.ToLower(name)]; exists {
+		return p.value
+	}
+	return ""
+}
+
+func (c *Config) GetInt(name string) int64 {
+	n, _ := strconv.ParseInt(c.Get(name), 10, 64)
+	return n
+}
+
+func (c *Config) GetBool(name string) bool {
+	return c.Get(name) == "yes"
+}
+
+// Match returns name/value pairs for every parameter matching the glob
+// pattern, sorted by name.
+func (c *Config) Match(pattern string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	pattern = strings.ToLower(pattern)
+	var names []string
+	for name := range c.params {
+		if matched, _ := path.Match(pattern, name); matched {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names)*2)
+	for _, name := range names {
+		pairs = append(pairs, name, c.params[name].value)
+	}
+	return pairs
+}
+
+// Load stores a value without running its apply hook; it is used while the
+// server is starting up, before the subsystems the hooks reconfigure exist.
+func (c *Config) Load(name, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, exists := c.params[strings.ToLower(name)]
+	if !exists {
+		return fmt.Errorf("Bad directive or wrong number of arguments")
+	}
+	normalized, err := p.normalize(value)
+	if err != nil {
+		return err
+	}
+	p.value = normalized
+	return nil
+}
+
+// Set applies name/value pairs atomically: every value is validated first,
+// and if any apply hook fails the values already applied are rolled back.
+func (c *Config) Set(pairs ...string) error {
+	changes, err := c.store(pairs)
+	if err != nil {
+		return err
+	}
+
+	// Hooks run without the lock, as they may take a while.
+	for i, change := range changes {
+		if change.apply == nil {
+			continue
+		}
+		if err := change.apply(change.value); err != nil {
+			c.mu.Lock()
+			for _, change := range changes {
+				change.param.value = change.previous
+			}
+			c.mu.Unlock()
+			for j := i - 1; j >= 0; j-- {
+				if changes[j].apply != nil {
+					changes[j].apply(changes[j].previous)
+				}
+			}
+			return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", change.param.Name, err)
+		}
+	}
+	return nil
+}
+
+type change struct {
+	param           *Param
+	previous, value string
+	apply           ApplyFunc
+}
+
+// store validates name/value pairs and stores the values, returning what
+// changed so the apply hooks can run once the lock is released.
+func (c *Config) store(pairs []string) ([]change, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("ERR wrong number of arguments for 'config|set' command")
+	}
+
+	params := make([]*Param, 0, len(pairs)/2)
+	values := make([]string, 0, len(pairs)/2)
+	seen := make(map[string]bool)
+
+	for i := 0; i < len(pairs); i += 2 {
+		name := strings.ToLower(pairs[i])
+		p, exists := c.params[name]
+		if !exists {
+			return nil, fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", pairs[i])
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", pairs[i])
+		}
+		seen[name] = true
+		if p.Immutable {
+			return nil, fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", pairs[i])
+		}
+
+		value, err := p.normalize(pairs[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", pairs[i], err)
+		}
+		params = append(params, p)
+		values = append(values, value)
+	}
+
+	changes := make([]change, len(params))
+	for i, p := range params {
+		changes[i] = change{param: p, previous: p.value, value: values[i], apply: p.apply}
+		p.value = values[i]
+	}
+	return changes, nil
+}
+
+func (p *Param) normalize(value string) (string, error) {
+	if p.Normalize != nil {
+		return p.Normalize(value)
+	}
+
+	switch p.Type {
+	case TypeBool:
+		switch strings.ToLower(value) {
+		case "yes":
+			return "yes", nil
+		case "no":
+			return "no", nil
+		}
+		return "", errors.New("argument must be 'yes' or 'no'")
+
+	case TypeInt, TypeMemory:
+		var n int64
+		var err error
+		if p.Type == TypeMemory {
+			n, err = ParseMemory(value)
+		} else {
+			n, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			if p.Type == TypeMemory {
+				return "", errors.New("argument must be a memory value")
+			}
+			return "", errors.New("argument couldn't be parsed into an integer")
+		}
+		if n < p.Min || n > p.Max {
+			return "", fmt.Errorf("argument must be between %d and %d inclusive", p.Min, p.Max)
+		}
+		return strconv.FormatInt(n, 10), nil
+
+	case TypeEnum:
+		lower := strings.ToLower(value)
+		for _, option := range p.Enum {
+			if option == lower {
+				return lower, nil
+			}
+		}
+		return "", fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(p.Enum, ", "))
+	}
+	return value, nil
+}
+
+// normalizeSave validates a list of "<seconds> <changes>" save points; an
+// empty value disables automatic snapshots.
+func normalizeSave(value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return "", errors.New("Invalid save parameters")
+	}
+	for _, field := range fields {
+		if n, err := strconv.ParseInt(field, 10, 64); err != nil || n < 0 {
+			return "", errors.New("Invalid save parameters")
+		}
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// normalizeDir requires the working directory to exist and stores it as an
+// absolute path.
+func normalizeDir(value string) (string, error) {
+	abs, err := filepath.Abs(value)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", value)
+	}
+	return abs, nil
+}
+
+// normalizeReplicaOf checks a "<host> <port>" master address.
+func normalizeReplicaOf(value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	if len(fields) != 2 {
+		return "", errors.New("must be <masterip> <masterport>")
+	}
+	if port, err := strconv.Atoi(fields[1]); err != nil || port < 0 || port > 65535 {
+		return "", errors.New("Invalid master port")
+	}
+	return fields[0] + " " + fields[1], nil
+}
+
+// normalizeFilename rejects paths; persistence files always live in dir.
+func normalizeFilename(value string) (string, error) {
+	if value == "" || filepath.Base(value) != value {
+		return "", errors.New("can't be a path, just a filename")
+	}
+	return value, nil
+}
+
+// ParseMemory parses a redis.conf memory value such as 1gb, 512k or 100.
+// Units without a trailing b are powers of 1000, with it powers of 1024.
+func ParseMemory(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	multiplier := int64(1)
+
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			multiplier = unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64/multiplier || n < math.MinInt64/multiplier {
+		return 0, strconv.ErrRange
+	}
+	return n * multiplier, nil
+}
+
+// Rewrite updates the config file in place: lines for known directives are
+// replaced with their current value (duplicates dropped), comments and
+// unknown lines are kept, and non-default values missing from the file are
+// appended at the end.
+func (c *Config) Rewrite() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.file == "" {
+		return ErrNoConfigFile
+	}
+
+	data, err := os.ReadFile(c.file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var lines []string
+	written := make(map[string]bool)
+	signed := false
+	if len(data) > 0 {
+		for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+			if line == rewriteSignature {
+				signed = true
+			}
+			args, err := resp.SplitArgs(strings.TrimSpace(line))
+			if err != nil || len(args) == 0 || strings.HasPrefix(args[0], "#") {
+				lines = append(lines, line)
+				continue
+			}
+
+			name := strings.ToLower(args[0])
+			p, exists := c.params[name]
+			if !exists {
+				lines = append(lines, line)
+				continue
+			}
+			if written[name] {
+				continue
+			}
+			written[name] = true
+			lines = append(lines, formatDirective(p)...)
+		}
+	}
+
+	var names []string
+	for name, p := range c.params {
+		if !written[name] && p.value != p.Default {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		if !signed {
+			lines = append(lines, rewriteSignature)
+		}
+		for _, name := range names {
+			lines = append(lines, formatDirective(c.params[name])...)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), "redis-conf-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.file)
+}
+
+const rewriteSignature = "# Generated by CONFIG REWRITE"
+
+func formatDirective(p *Param) []string {
+	name, value := p.Name, p.value
+	if p.Type == TypeMemory {
+		value = formatMemory(value)
+	}
+	if name == "save" && value != "" {
+		fields := strings.Fields(value)
+		lines := make([]string, 0, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			lines = append(lines, "save "+fields[i]+" "+fields[i+1])
+		}
+		return lines
+	}
+	if name == "replicaof" && value != "" {
+		return []string{"replicaof " + value}
+	}
+	return []string{name + " " + quoteValue(value)}
+}
+
+func formatMemory(value string) string {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n == 0 {
+		return value
+	}
+	for _, unit := range []struct {
+		suffix string
+		factor int64
+	}{{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}} {
+		if n%unit.factor == 0 {
+			return strconv.FormatInt(n/unit.factor, 10) + unit.suffix
+		}
+	}
+	return value
+}
+
+func quoteValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\r\n\"'\\") {
+		return value
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if c < 0x20 || c > 0x7e {
+				fmt.Fprintf(&sb, `\x%02x`, c)
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
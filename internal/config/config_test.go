@@ -0,0 +1,256 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaults(t *testing.T) {
+	cfg := New()
+
+	if cfg.Get("port") != "6379" {
+		t.Errorf("Expected default port 6379, got %q", cfg.Get("port"))
+	}
+	if cfg.GetBool("appendonly") {
+		t.Error("Expected appendonly to default to no")
+	}
+	if cfg.GetInt("maxmemory-samples") != 5 {
+		t.Errorf("Expected 5 samples, got %d", cfg.GetInt("maxmemory-samples"))
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cfg := New()
+
+	pairs := cfg.Match("append*")
+	expected := []string{"appenddirname", "appendonlydir", "appendfilename", "appendonly.aof", "appendfsync", "everysec", "appendonly", "no"}
+	if strings.Join(pairs, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, pairs)
+	}
+
+	if len(cfg.Match("*")) != 2*len(defaultParams()) {
+		t.Errorf("Expected * to match every parameter")
+	}
+
+	if len(cfg.Match("MAXMEMORY")) != 2 {
+		t.Errorf("Expected matching to be case-insensitive")
+	}
+}
+
+func TestSetValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+		err      string
+	}{
+		{"appendonly", "YES", "yes", ""},
+		{"appendonly", "maybe", "", "must be 'yes' or 'no'"},
+		{"maxmemory", "100mb", "104857600", ""},
+		{"maxmemory", "2k", "2000", ""},
+		{"maxmemory", "-1", "", "between"},
+		{"maxmemory", "big", "", "memory value"},
+		{"maxmemory", "99999999999gb", "", "memory value"},
+		{"maxmemory-samples", "0", "", "between 1 and 64"},
+		{"maxmemory-samples", "ten", "", "integer"},
+		{"maxmemory-policy", "allkeys-random", "allkeys-random", ""},
+		{"maxmemory-policy", "lru", "", "must be one of"},
+		{"appendfsync", "Always", "always", ""},
+		{"dbfilename", "backup.rdb", "backup.rdb", ""},
+		{"dbfilename", "../dump.rdb", "", "just a filename"},
+		{"dir", "/no/such/dir", "", "no such file"},
+		{"port", "7000", "", "immutable"},
+		{"rdbcompression", "no", "no", ""},
+		{"rdbchecksum", "no", "", "immutable"},
+		{"aof-load-truncated", "no", "no", ""},
+		{"repl-backlog-size", "10mb", "10485760", ""},
+		{"repl-backlog-size", "1k", "", "between"},
+		{"replicaof", "127.0.0.1 6380", "", "immutable"},
+		{"nosuchoption", "1", "", "Unknown option"},
+	}
+
+	for _, tt := range tests {
+		cfg := New()
+		err := cfg.Set(tt.name, tt.value)
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s=%s: expected error containing %q, got %v", tt.name, tt.value, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s=%s: unexpected error %v", tt.name, tt.value, err)
+			continue
+		}
+		if cfg.Get(tt.name) != tt.expected {
+			t.Errorf("%s=%s: expected %q, got %q", tt.name, tt.value, tt.expected, cfg.Get(tt.name))
+		}
+	}
+}
+
+func TestSetDir(t *testing.T) {
+	dir := t.TempDir()
+	cfg := New()
+
+	if err := cfg.Set("dir", dir+"/."); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Get("dir") != dir {
+		t.Errorf("Expected dir to be stored as %q, got %q", dir, cfg.Get("dir"))
+	}
+
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0644)
+	if err := cfg.Set("dir", file); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("Expected a file to be rejected, got %v", err)
+	}
+}
+
+func TestSetIsAtomic(t *testing.T) {
+	cfg := New()
+
+	if err := cfg.Set("maxmemory", "1mb", "maxmemory-samples", "100"); err == nil {
+		t.Fatal("Expected validation error")
+	}
+	if cfg.Get("maxmemory") != "0" {
+		t.Errorf("Expected maxmemory to be unchanged, got %q", cfg.Get("maxmemory"))
+	}
+
+	if err := cfg.Set("maxmemory", "1mb", "MAXMEMORY", "2mb"); err == nil {
+		t.Error("Expected duplicate parameter error")
+	}
+}
+
+func TestSetRollsBackFailedApply(t *testing.T) {
+	cfg := New()
+
+	var applied []string
+	cfg.OnChange("maxmemory", func(value string) error {
+		applied = append(applied, value)
+		return nil
+	})
+	cfg.OnChange("maxmemory-policy", func(value string) error {
+		return errors.New("cannot apply")
+	})
+
+	err := cfg.Set("maxmemory", "1024", "maxmemory-policy", "allkeys-lru")
+	if err == nil || !strings.Contains(err.Error(), "cannot apply") {
+		t.Fatalf("Expected apply error, got %v", err)
+	}
+
+	if cfg.Get("maxmemory") != "0" || cfg.Get("maxmemory-policy") != "noeviction" {
+		t.Errorf("Expected values to be rolled back, got %q %q", cfg.Get("maxmemory"), cfg.Get("maxmemory-policy"))
+	}
+	if strings.Join(applied, ",") != "1024,0" {
+		t.Errorf("Expected maxmemory hook to be re-applied with the old value, got %v", applied)
+	}
+}
+
+func TestSetHookReadsConfig(t *testing.T) {
+	cfg := New()
+
+	var seen string
+	cfg.OnChange("maxmemory", func(value string) error {
+		seen = cfg.Get("maxmemory")
+		return nil
+	})
+	if err := cfg.Set("maxmemory", "1024"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if seen != "1024" {
+		t.Errorf("Expected the hook to see the new value, got %q", seen)
+	}
+}
+
+func TestLoadSkipsHooks(t *testing.T) {
+	cfg := New()
+	cfg.OnChange("maxmemory", func(value string) error {
+		t.Error("Load should not run apply hooks")
+		return nil
+	})
+
+	if err := cfg.Load("maxmemory", "1gb"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.GetInt("maxmemory") != 1<<30 {
+		t.Errorf("Expected 1gb, got %d", cfg.GetInt("maxmemory"))
+	}
+
+	if err := cfg.Load("port", "7000"); err != nil {
+		t.Errorf("Load should accept immutable parameters: %v", err)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	cfg := New()
+	if err := cfg.Rewrite(); err != ErrNoConfigFile {
+		t.Errorf("Expected ErrNoConfigFile, got %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "redis.conf")
+	original := "# my settings\nport 7000\nmaxmemory 1mb\nsome-future-option on\nmaxmemory 2mb\n"
+	if err := os.WriteFile(file, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.SetFile(file)
+	cfg.Load("port", "7000")
+	if err := cfg.Set("maxmemory", "4mb", "requirepass", "secret pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.Rewrite(); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "# my settings\nport 7000\nmaxmemory 4mb\nsome-future-option on\n" +
+		"# Generated by CONFIG REWRITE\nrequirepass \"secret pass\"\n"
+	if string(data) != expected {
+		t.Errorf("Unexpected rewritten file:\n%s", data)
+	}
+
+	if err := cfg.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := os.ReadFile(file)
+	if string(again) != expected {
+		t.Errorf("Rewrite should be idempotent, got:\n%s", again)
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	tests := map[string]int64{
+		"100":  100,
+		"1k":   1000,
+		"1kb":  1024,
+		"1M":   1000000,
+		"1mb":  1 << 20,
+		"2GB":  2 << 30,
+		"512b": 512,
+	}
+
+	for input, expected := range tests {
+		n, err := ParseMemory(input)
+		if err != nil || n != expected {
+			t.Errorf("ParseMemory(%q) = %d, %v; expected %d", input, n, err, expected)
+		}
+	}
+
+	if _, err := ParseMemory("1tb"); err == nil {
+		t.Error("Expected error for unsupported unit")
+	}
+	for _, input := range []string{"99999999999gb", "-99999999999gb"} {
+		if _, err := ParseMemory(input); err == nil {
+			t.Errorf("Expected an overflow error for %q", input)
+		}
+	}
+}
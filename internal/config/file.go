@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+const maxIncludeDepth = 16
+
+type FileError struct {
+	File string
+	Line int
+	Text string
+	Msg  string
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("*** FATAL CONFIG FILE ERROR ***\nReading %s, at line %d\n>>> '%s'\n%s",
+		e.File, e.Line, e.Text, e.Msg)
+}
+
+type loader struct {
+	cfg       *Config
+	savesSeen bool
+	saves     []string
+}
+
+// LoadServerConfig loads the redis.conf style file (if any) and then the
+// command line options, which are applied after the file so they override
+// it. Errors carry the file (or "the command line") and line number.
+func (c *Config) LoadServerConfig(file string, args []string) error {
+	options, err := ParseOptions(args)
+	if err != nil {
+		return err
+	}
+
+	l := &loader{cfg: c}
+	if file != "" {
+		if err := l.loadFile(file, 0); err != nil {
+			return err
+		}
+
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		c.SetFile(abs)
+
+		// Save points given on the command line replace the file's instead
+		// of adding to them.
+		if err := l.finish(); err != nil {
+			return err
+		}
+		l.savesSeen = false
+	}
+
+	if err := l.loadString("the command line", options, 0); err != nil {
+		return err
+	}
+	return l.finish()
+}
+
+// ParseOptions turns "--directive value ..." command line arguments into
+// config file lines. Every argument up to the next "--directive" belongs to
+// the previous directive, so "--save 900 1 --port 7000" is two lines.
+func ParseOptions(args []string) (string, error) {
+	var lines []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			if len(arg) == 2 {
+				return "", fmt.Errorf("invalid option '%s'", arg)
+			}
+			lines = append(lines, arg[2:])
+			continue
+		}
+		if len(lines) == 0 {
+			return "", fmt.Errorf("invalid option '%s': options must start with --", arg)
+		}
+		lines[len(lines)-1] += " " + quoteValue(arg)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (l *loader) loadFile(file string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("Fatal error, too many nested includes while reading '%s'", file)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("Fatal error, can't open config file '%s': %v", file, err)
+	}
+	return l.loadString("'"+file+"'", string(data), depth)
+}
+
+func (l *loader) loadString(source, data string, depth int) error {
+	for i, text := range strings.Split(data, "\n") {
+		line := strings.TrimSpace(text)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if err := l.loadLine(line, depth); err != nil {
+			if fileErr, ok := err.(*FileError); ok {
+				return fileErr
+			}
+			return &FileError{File: source, Line: i + 1, Text: line, Msg: err.Error()}
+		}
+	}
+	return nil
+}
+
+func (l *loader) loadLine(line string, depth int) error {
+	args, err := resp.SplitArgs(line)
+	if err != nil {
+		return fmt.Errorf("Unbalanced quotes in configuration line")
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	name := strings.ToLower(args[0])
+	switch name {
+	case "include":
+		if len(args) != 2 {
+			return fmt.Errorf("Bad directive or wrong number of arguments")
+		}
+		return l.loadFile(args[1], depth+1)
+
+	case "save":
+		// The first save line replaces the default save points; later ones
+		// add to it, and `save ""` clears them.
+		if !l.savesSeen {
+			l.savesSeen = true
+			l.saves = nil
+		}
+		value := strings.Join(args[1:], " ")
+		if strings.TrimSpace(value) == "" {
+			l.saves = nil
+			return nil
+		}
+		normalized, err := normalizeSave(value)
+		if err != nil {
+			return err
+		}
+		l.saves = append(l.saves, normalized)
+		return nil
+
+	case "replicaof", "slaveof":
+		if len(args) != 3 {
+			return fmt.Errorf("Bad directive or wrong number of arguments")
+		}
+		return l.cfg.Load("replicaof", args[1]+" "+args[2])
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("Bad directive or wrong number of arguments")
+	}
+	return l.cfg.Load(name, args[1])
+}
+
+func (l *loader) finish() error {
+	if !l.savesSeen {
+		return nil
+	}
+	return l.cfg.Load("save", strings.Join(l.saves, " "))
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "redis.conf", `# Example
+port 7000
+maxmemory 1gb
+MAXMEMORY-POLICY allkeys-lru
+
+requirepass "my secret"
+appendonly yes
+save 900 1
+save 300 10
+replicaof 10.0.0.1 6380
+`)
+
+	cfg := New()
+	if err := cfg.LoadServerConfig(file, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"port":             "7000",
+		"maxmemory":        "1073741824",
+		"maxmemory-policy": "allkeys-lru",
+		"requirepass":      "my secret",
+		"appendonly":       "yes",
+		"save":             "900 1 300 10",
+		"replicaof":        "10.0.0.1 6380",
+	}
+	for name, value := range expected {
+		if cfg.Get(name) != value {
+			t.Errorf("%s: expected %q, got %q", name, value, cfg.Get(name))
+		}
+	}
+
+	if !filepath.IsAbs(cfg.File()) {
+		t.Errorf("Expected config file path to be absolute, got %q", cfg.File())
+	}
+}
+
+func TestCommandLineOverridesFile(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "redis.conf", "port 7000\nappendfsync always\nsave 900 1\n")
+
+	cfg := New()
+	err := cfg.LoadServerConfig(file, []string{"--port", "7001", "--save", "60", "100", "--requirepass", "two words"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if cfg.Get("port") != "7001" {
+		t.Errorf("Expected command line port, got %q", cfg.Get("port"))
+	}
+	if cfg.Get("appendfsync") != "always" {
+		t.Errorf("Expected file value to be kept, got %q", cfg.Get("appendfsync"))
+	}
+	if cfg.Get("save") != "60 100" {
+		t.Errorf("Expected command line save points to replace the file's, got %q", cfg.Get("save"))
+	}
+	if cfg.Get("requirepass") != "two words" {
+		t.Errorf("Expected quoted value to survive, got %q", cfg.Get("requirepass"))
+	}
+}
+
+func TestLoadWithoutFile(t *testing.T) {
+	cfg := New()
+	if err := cfg.LoadServerConfig("", []string{"--maxmemory", "10mb"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.GetInt("maxmemory") != 10<<20 {
+		t.Errorf("Expected 10mb, got %d", cfg.GetInt("maxmemory"))
+	}
+	if cfg.File() != "" {
+		t.Errorf("Expected no config file, got %q", cfg.File())
+	}
+	if cfg.Get("save") != "3600 1 300 100 60 10000" {
+		t.Errorf("Expected default save points, got %q", cfg.Get("save"))
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	common := writeConfig(t, dir, "common.conf", "maxmemory-samples 10\nport 7000\n")
+	file := writeConfig(t, dir, "redis.conf", "include "+common+"\nport 7002\n")
+
+	cfg := New()
+	if err := cfg.LoadServerConfig(file, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Get("maxmemory-samples") != "10" || cfg.Get("port") != "7002" {
+		t.Errorf("Unexpected values: samples=%q port=%q", cfg.Get("maxmemory-samples"), cfg.Get("port"))
+	}
+
+	loop := writeConfig(t, dir, "loop.conf", "include "+filepath.Join(dir, "loop.conf")+"\n")
+	if err := New().LoadServerConfig(loop, nil); err == nil || !strings.Contains(err.Error(), "nested includes") {
+		t.Errorf("Expected include loop to be rejected, got %v", err)
+	}
+}
+
+func TestSaveDirectives(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := New()
+	if err := cfg.LoadServerConfig(writeConfig(t, dir, "a.conf", "save \"\"\n"), nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Get("save") != "" {
+		t.Errorf("Expected save points to be disabled, got %q", cfg.Get("save"))
+	}
+
+	cfg = New()
+	if err := cfg.LoadServerConfig(writeConfig(t, dir, "b.conf", "save 900 1 300 10\n"), nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Get("save") != "900 1 300 10" {
+		t.Errorf("Expected multiple save points on one line, got %q", cfg.Get("save"))
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		line     int
+		msg      string
+	}{
+		{"unknown directive", "port 7000\n\nno-such-directive yes\n", 3, "Bad directive"},
+		{"wrong arity", "# comment\nport\n", 2, "Bad directive"},
+		{"invalid bool", "appendonly maybe\n", 1, "'yes' or 'no'"},
+		{"invalid memory", "maxmemory lots\n", 1, "memory value"},
+		{"invalid enum", "port 1\nmaxmemory-policy lru\n", 2, "must be one of"},
+		{"invalid save", "save 900\n", 1, "Invalid save parameters"},
+		{"unbalanced quotes", "requirepass \"oops\n", 1, "Unbalanced quotes"},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		file := writeConfig(t, dir, "bad.conf", tt.contents)
+		err := New().LoadServerConfig(file, nil)
+
+		fileErr, ok := err.(*FileError)
+		if !ok {
+			t.Errorf("%s: expected *FileError, got %v", tt.name, err)
+			continue
+		}
+		if fileErr.Line != tt.line || !strings.Contains(fileErr.Msg, tt.msg) {
+			t.Errorf("%s: expected line %d %q, got line %d %q", tt.name, tt.line, tt.msg, fileErr.Line, fileErr.Msg)
+		}
+		if !strings.Contains(err.Error(), "at line") || !strings.Contains(err.Error(), "bad.conf") {
+			t.Errorf("%s: error should name the file and line: %s", tt.name, err)
+		}
+	}
+}
+
+func TestIncludedFileErrorsNameTheIncludedFile(t *testing.T) {
+	dir := t.TempDir()
+	inner := writeConfig(t, dir, "inner.conf", "port 7000\nport abc\n")
+	file := writeConfig(t, dir, "redis.conf", "include "+inner+"\n")
+
+	err := New().LoadServerConfig(file, nil)
+	fileErr, ok := err.(*FileError)
+	if !ok {
+		t.Fatalf("Expected *FileError, got %v", err)
+	}
+	if !strings.Contains(fileErr.File, "inner.conf") || fileErr.Line != 2 {
+		t.Errorf("Expected error at inner.conf:2, got %s:%d", fileErr.File, fileErr.Line)
+	}
+}
+
+func TestCommandLineErrors(t *testing.T) {
+	if _, err := ParseOptions([]string{"port", "7000"}); err == nil {
+		t.Error("Expected error for option without --")
+	}
+
+	err := New().LoadServerConfig("", []string{"--port", "7000", "--bogus", "1"})
+	fileErr, ok := err.(*FileError)
+	if !ok || fileErr.File != "the command line" || fileErr.Line != 2 {
+		t.Errorf("Expected command line error at line 2, got %v", err)
+	}
+}
+
+func TestRewriteSavePoints(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "redis.conf", "save 900 1\nport 7000\nsave 300 10\n")
+
+	cfg := New()
+	if err := cfg.LoadServerConfig(file, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Set("save", "60 5 10 1000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(file)
+	if string(data) != "save 60 5\nsave 10 1000\nport 7000\n" {
+		t.Errorf("Unexpected rewritten file:\n%s", data)
+	}
+
+	reloaded := New()
+	if err := reloaded.LoadServerConfig(file, nil); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Get("save") != "60 5 10 1000" {
+		t.Errorf("Expected save points to round-trip, got %q", reloaded.Get("save"))
+	}
+}
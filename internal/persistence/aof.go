@@ -2,9 +2,12 @@ package persistence
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,6 +31,13 @@ type AOFWriter struct {
 	lastSync   time.Time
 	stopChan   chan struct{}
 	syncTicker *time.Ticker
+	// timestamps enables #TS annotations, written before the first command
+	// of every second; lastTimestamp is the second last annotated.
+	timestamps    bool
+	lastTimestamp int64
+	// Bytes appended so far, and how many of them are known to be on disk.
+	written int64
+	synced  int64
 }
 
 func NewAOFWriter(filepath string, policy AOFSyncPolicy) (*AOFWriter, error) {
@@ -46,7 +56,7 @@ func NewAOFWriter(filepath string, policy AOFSyncPolicy) (*AOFWriter, error) {
 
 	if policy == AOFSyncEverySec {
 		aof.syncTicker = time.NewTicker(1 * time.Second)
-		go aof.backgroundSync()
+		go aof.backgroundSync(aof.syncTicker, aof.stopChan)
 	}
 
 	return aof, nil
@@ -56,22 +66,27 @@ func (a *AOFWriter) Append(command []resp.Value) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	if a.timestamps {
+		if now := time.Now().Unix(); now != a.lastTimestamp {
+			n, err := fmt.Fprintf(a.writer, "#TS:%d\r\n", now)
+			if err != nil {
+				return fmt.Errorf("failed to write to AOF buffer: %w", err)
+			}
+			a.written += int64(n)
+			a.lastTimestamp = now
+		}
+	}
+
 	data := resp.SerializeArray(command)
 
 	if _, err := a.writer.Write(data); err != nil {
 		return fmt.Errorf("failed to write to AOF buffer: %w", err)
 	}
+	a.written += int64(len(data))
 
 	switch a.syncPolicy {
 	case AOFSyncAlways:
-
-		if err := a.writer.Flush(); err != nil {
-			return fmt.Errorf("failed to flush AOF buffer: %w", err)
-		}
-		if err := a.file.Sync(); err != nil {
-			return fmt.Errorf("failed to sync AOF to disk: %w", err)
-		}
-		a.lastSync = time.Now()
+		return a.sync()
 	case AOFSyncEverySec:
 
 		if err := a.writer.Flush(); err != nil {
@@ -84,17 +99,84 @@ func (a *AOFWriter) Append(command []resp.Value) error {
 	return nil
 }
 
-func (a *AOFWriter) backgroundSync() {
+func (a *AOFWriter) SetSyncPolicy(policy AOFSyncPolicy) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if policy == a.syncPolicy {
+		return nil
+	}
+
+	if err := a.sync(); err != nil {
+		return err
+	}
+
+	if a.syncTicker != nil {
+		a.syncTicker.Stop()
+		close(a.stopChan)
+		a.syncTicker = nil
+		a.stopChan = make(chan struct{})
+	}
+
+	a.syncPolicy = policy
+	if policy == AOFSyncEverySec {
+		a.syncTicker = time.NewTicker(1 * time.Second)
+		go a.backgroundSync(a.syncTicker, a.stopChan)
+	}
+	return nil
+}
+
+func (a *AOFWriter) SyncPolicy() AOFSyncPolicy {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.syncPolicy
+}
+
+// SetTimestamps turns #TS:<unix> annotations on or off. They let
+// redis-check-aof cut the log at a point in time and are skipped on load.
+func (a *AOFWriter) SetTimestamps(enabled bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.timestamps = enabled
+	a.lastTimestamp = 0
+}
+
+// sync flushes the buffer and fsyncs the file. Callers must hold the lock.
+func (a *AOFWriter) sync() error {
+	if err := a.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush AOF buffer: %w", err)
+	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync AOF to disk: %w", err)
+	}
+	a.lastSync = time.Now()
+	a.synced = a.written
+	return nil
+}
+
+// Offsets returns how many bytes were appended and how many of them were
+// fsynced. A writer that replaced another continues from its offsets.
+func (a *AOFWriter) Offsets() (written, synced int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.written, a.synced
+}
+
+func (a *AOFWriter) resume(prev *AOFWriter) {
+	written, synced := prev.Offsets()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.written, a.synced = written, synced
+}
+
+func (a *AOFWriter) backgroundSync(ticker *time.Ticker, stop chan struct{}) {
 	for {
 		select {
-		case <-a.syncTicker.C:
+		case <-ticker.C:
 			a.mu.Lock()
-
-			a.writer.Flush()
-			a.file.Sync()
-			a.lastSync = time.Now()
+			a.sync()
 			a.mu.Unlock()
-		case <-a.stopChan:
+		case <-stop:
 			return
 		}
 	}
@@ -115,6 +197,7 @@ func (a *AOFWriter) Close() error {
 	if err := a.file.Sync(); err != nil {
 		return fmt.Errorf("failed to sync AOF on close: %w", err)
 	}
+	a.synced = a.written
 	if err := a.file.Close(); err != nil {
 		return fmt.Errorf("failed to close AOF file: %w", err)
 	}
@@ -122,8 +205,159 @@ func (a *AOFWriter) Close() error {
 	return nil
 }
 
+// AOFLoadOptions control how damaged AOF files are loaded.
+type AOFLoadOptions struct {
+	// Truncated accepts a last file ending in an incomplete command, as
+	// left by a crash in the middle of a write, and truncates it after the
+	// last complete command.
+	Truncated bool
+	// Corrupt accepts invalid data anywhere in the last file, loading the
+	// commands before it and truncating the file there.
+	Corrupt bool
+}
+
+// AOFLoadStats summarize a loaded AOF.
+type AOFLoadStats struct {
+	Commands int
+	// Failed counts replayed commands that returned an error, the first of
+	// which is kept in FirstError.
+	Failed     int
+	FirstError string
+	// Truncated lists the files whose damaged tail was cut off.
+	Truncated []*AOFError
+}
+
+// AOFError reports invalid data in an AOF file.
+type AOFError struct {
+	File string
+	// Offset of the invalid data, and length of the prefix holding only
+	// complete commands and transactions.
+	Offset int64
+	Valid  int64
+	// Truncated is set when the file just ends early.
+	Truncated bool
+	Err       error
+}
+
+func (e *AOFError) Error() string {
+	return fmt.Sprintf("%s at offset %d: %v", e.File, e.Offset, e.Err)
+}
+
+func (e *AOFError) Unwrap() error {
+	return e.Err
+}
+
+var errAOFTruncated = errors.New("unexpected end of file")
+
+type countingReader struct {
+	r      io.Reader
+	offset int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.offset += int64(n)
+	return n, err
+}
+
+// scanAOF parses the commands following any RDB preamble, calling fn with
+// each of them and with the commands of a MULTI block once its EXEC is read.
+// offset reports how much of the file has been consumed. Annotation lines
+// starting with '#' are passed to annotate, if set, which can stop the scan
+// before them by returning false. It returns the number of entries parsed,
+// the length of the valid prefix and, when the file isn't valid to the end,
+// an *AOFError.
+func scanAOF(reader *bufio.Reader, offset func() int64, fn func([]resp.Value), annotate func(string) bool) (int, int64, error) {
+	// NewParser keeps using reader, so offset stays accurate.
+	parser := resp.NewParser(reader)
+	valid := offset()
+	multi := int64(-1)
+	var queued [][]resp.Value
+	entries := 0
+
+	fail := func(at int64, err error) (int, int64, error) {
+		return entries, valid, &AOFError{Offset: at, Valid: valid, Truncated: err == errAOFTruncated, Err: err}
+	}
+
+	for {
+		start := offset()
+		if b, err := reader.Peek(1); err == io.EOF {
+			break
+		} else if err != nil {
+			return entries, valid, err
+		} else if b[0] == '#' {
+			line, err := reader.ReadString('\n')
+			if err == io.EOF {
+				return fail(start, errAOFTruncated)
+			} else if err != nil {
+				return entries, valid, err
+			}
+			if annotate != nil && !annotate(strings.TrimRight(line, "\r\n")) {
+				return entries, valid, nil
+			}
+			if multi < 0 {
+				valid = offset()
+			}
+			continue
+		} else if b[0] != '*' {
+			return fail(start, fmt.Errorf("expected '*', got '%c'", b[0]))
+		}
+
+		value, err := parser.Parse()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fail(start, errAOFTruncated)
+		}
+		if err != nil {
+			return fail(start, err)
+		}
+		if len(value.Array) == 0 {
+			return fail(start, fmt.Errorf("empty command"))
+		}
+		entries++
+
+		switch strings.ToUpper(value.Array[0].Str) {
+		case "MULTI":
+			if multi >= 0 {
+				return fail(start, fmt.Errorf("nested MULTI"))
+			}
+			multi = start
+			continue
+		case "EXEC":
+			if multi < 0 {
+				return fail(start, fmt.Errorf("EXEC without MULTI"))
+			}
+			for _, command := range queued {
+				fn(command)
+			}
+			multi, queued = -1, nil
+		default:
+			if multi >= 0 {
+				queued = append(queued, value.Array)
+				continue
+			}
+			fn(value.Array)
+		}
+		valid = offset()
+	}
+
+	if multi >= 0 {
+		// A crash in the middle of a transaction leaves it unterminated.
+		return entries, valid, &AOFError{Offset: multi, Valid: valid, Truncated: true,
+			Err: fmt.Errorf("reached EOF before reading EXEC for MULTI")}
+	}
+	return entries, valid, nil
+}
+
+// LoadAOF replays a single AOF file, failing on any damage.
 func LoadAOF(filepath string, st *store.Store, executeCommand func([]resp.Value) resp.Value) error {
-	file, err := os.Open(filepath)
+	return loadAOFFile(filepath, st, executeCommand, AOFLoadOptions{}, true, &AOFLoadStats{})
+}
+
+// loadAOFFile replays the AOF file at path into stats. A damaged tail the
+// options accept is cut off the file; last says whether path is the file
+// commands are appended to.
+func loadAOFFile(path string, st *store.Store, executeCommand func([]resp.Value) resp.Value, opts AOFLoadOptions, last bool, stats *AOFLoadStats) error {
+	file, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -132,39 +366,87 @@ func LoadAOF(filepath string, st *store.Store, executeCommand func([]resp.Value)
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	parser := resp.NewParser(reader)
+	counter := &countingReader{r: file}
+	reader := bufio.NewReader(counter)
+	offset := func() int64 {
+		return counter.offset - int64(reader.Buffered())
+	}
 
-	commandCount := 0
-	for {
-		value, err := parser.Parse()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("failed to parse AOF at command %d: %w", commandCount+1, err)
+	// A file starting with the RDB magic has an RDB preamble holding the
+	// dataset, followed by the commands logged after it.
+	if magic, _ := reader.Peek(5); string(magic) == "REDIS" {
+		loadFunction := func(code string) error {
+			result := executeCommand([]resp.Value{
+				{Type: resp.BulkString, Str: "FUNCTION"},
+				{Type: resp.BulkString, Str: "LOAD"},
+				{Type: resp.BulkString, Str: "REPLACE"},
+				{Type: resp.BulkString, Str: code},
+			})
+			if result.Type == resp.Error {
+				return fmt.Errorf("%s", result.Str)
+			}
+			return nil
 		}
-
-		if value.Type != resp.Array {
-			return fmt.Errorf("invalid AOF entry at command %d: expected array, got %c", commandCount+1, value.Type)
+		if err := readRDB(NewRDBReader(reader), st, loadFunction, true); err != nil {
+			return fmt.Errorf("%s: failed to load RDB preamble: %w", filepath.Base(path), err)
 		}
+	}
 
-		result := executeCommand(value.Array)
-
+	_, _, err = scanAOF(reader, offset, func(command []resp.Value) {
+		stats.Commands++
+		result := executeCommand(command)
 		if result.Type == resp.Error {
-
+			if stats.Failed == 0 {
+				stats.FirstError = fmt.Sprintf("%s: %s", strings.ToUpper(command[0].Str), result.Str)
+			}
+			stats.Failed++
 		}
+	}, nil)
 
-		commandCount++
+	var aofErr *AOFError
+	if !errors.As(err, &aofErr) {
+		return err
 	}
+	aofErr.File = filepath.Base(path)
 
+	// Files after a truncated one would be replayed on top of a gap, so
+	// only the last file can be cut.
+	if !last {
+		return fmt.Errorf("%w; only the last AOF file can be truncated", aofErr)
+	}
+	if !opts.Corrupt && (!aofErr.Truncated || !opts.Truncated) {
+		return aofErr
+	}
+
+	if err := os.Truncate(path, aofErr.Valid); err != nil {
+		return fmt.Errorf("failed to truncate AOF file: %w", err)
+	}
+	stats.Truncated = append(stats.Truncated, aofErr)
 	return nil
 }
 
 func RewriteAOF(filepath string, st *store.Store) error {
+	return RewriteAOFWithFunctions(filepath, st, nil)
+}
 
+func RewriteAOFWithFunctions(filepath string, st *store.Store, functions []string) error {
 	tmpFile := filepath + ".tmp"
-	file, err := os.Create(tmpFile)
+	if err := WriteAOFSnapshot(tmpFile, st, functions); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	if err := os.Rename(tmpFile, filepath); err != nil {
+		return fmt.Errorf("failed to rename AOF file: %w", err)
+	}
+
+	return nil
+}
+
+// WriteAOFSnapshot writes the commands that rebuild the dataset and function
+// libraries to file, replacing its contents.
+func WriteAOFSnapshot(filepath string, st *store.Store, functions []string) error {
+	file, err := os.Create(filepath)
 	if err != nil {
 		return fmt.Errorf("failed to create temp AOF file: %w", err)
 	}
@@ -172,6 +454,18 @@ func RewriteAOF(filepath string, st *store.Store) error {
 
 	writer := bufio.NewWriter(file)
 
+	for _, code := range functions {
+		cmd := resp.SerializeArray([]resp.Value{
+			{Type: resp.BulkString, Str: "FUNCTION"},
+			{Type: resp.BulkString, Str: "LOAD"},
+			{Type: resp.BulkString, Str: "REPLACE"},
+			{Type: resp.BulkString, Str: code},
+		})
+		if _, err := writer.Write(cmd); err != nil {
+			return fmt.Errorf("failed to write function library: %w", err)
+		}
+	}
+
 	data, expires := st.Snapshot()
 
 	for key, obj := range data {
@@ -187,12 +481,6 @@ func RewriteAOF(filepath string, st *store.Store) error {
 		return fmt.Errorf("failed to sync AOF: %w", err)
 	}
 
-	file.Close()
-
-	if err := os.Rename(tmpFile, filepath); err != nil {
-		return fmt.Errorf("failed to rename AOF file: %w", err)
-	}
-
 	return nil
 }
 
@@ -2,6 +2,8 @@ package persistence
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -160,6 +162,38 @@ func TestAOFSyncPolicies(t *testing.T) {
 	}
 }
 
+func TestAOFSetSyncPolicy(t *testing.T) {
+	filename := "test_aof_switch.aof"
+	defer os.Remove(filename)
+
+	aof, err := NewAOFWriter(filename, AOFSyncEverySec)
+	if err != nil {
+		t.Fatalf("Failed to create AOF writer: %v", err)
+	}
+
+	cmd := []resp.Value{
+		{Type: resp.BulkString, Str: "SET"},
+		{Type: resp.BulkString, Str: "test"},
+		{Type: resp.BulkString, Str: "value"},
+	}
+
+	for _, policy := range []AOFSyncPolicy{AOFSyncAlways, AOFSyncNo, AOFSyncEverySec} {
+		if err := aof.SetSyncPolicy(policy); err != nil {
+			t.Fatalf("Failed to switch to %s: %v", policy, err)
+		}
+		if aof.SyncPolicy() != policy {
+			t.Errorf("Expected policy %s, got %s", policy, aof.SyncPolicy())
+		}
+		if err := aof.Append(cmd); err != nil {
+			t.Fatalf("Failed to append command: %v", err)
+		}
+	}
+
+	if err := aof.Close(); err != nil {
+		t.Fatalf("Failed to close AOF: %v", err)
+	}
+}
+
 func TestAOFRewrite(t *testing.T) {
 	filename := "test_rewrite.aof"
 	defer os.Remove(filename)
@@ -278,3 +312,285 @@ func TestLoadNonExistentAOF(t *testing.T) {
 		t.Errorf("Loading non-existent AOF should not error: %v", err)
 	}
 }
+
+func TestAOFRewriteWithFunctions(t *testing.T) {
+	filename := "test_rewrite_functions.aof"
+	defer os.Remove(filename)
+
+	st := store.NewStore()
+	st.Set("key", "value")
+
+	library := "#!lua name=lib\nredis.register_function('f', function() return 1 end)\n"
+	if err := RewriteAOFWithFunctions(filename, st, []string{library}); err != nil {
+		t.Fatalf("Failed to rewrite AOF: %v", err)
+	}
+
+	var commands [][]string
+	executeCommand := func(values []resp.Value) resp.Value {
+		args := make([]string, len(values))
+		for i, v := range values {
+			args[i] = v.Str
+		}
+		commands = append(commands, args)
+		return resp.OKValue()
+	}
+
+	if err := LoadAOF(filename, store.NewStore(), executeCommand); err != nil {
+		t.Fatalf("Failed to load AOF: %v", err)
+	}
+
+	if len(commands) != 2 {
+		t.Fatalf("Expected 2 commands, got %v", commands)
+	}
+	first := commands[0]
+	if len(first) != 4 || first[0] != "FUNCTION" || first[1] != "LOAD" || first[2] != "REPLACE" || first[3] != library {
+		t.Errorf("Expected FUNCTION LOAD REPLACE first, got %v", first)
+	}
+	if commands[1][0] != "SET" {
+		t.Errorf("Expected SET after functions, got %v", commands[1])
+	}
+}
+
+func TestLoadAOFWithRDBPreamble(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "preamble.aof")
+
+	st := store.NewStore()
+	st.Set("string_key", "hello")
+	st.RPush("list_key", "a", "b", "c")
+	st.HSet("hash_key", "field", "value")
+	st.ZAdd("zset_key", 1.5, "m1")
+	if err := WriteRDB(filename, st, []string{"#!lua name=lib"}, DefaultRDBOptions); err != nil {
+		t.Fatalf("Failed to write preamble: %v", err)
+	}
+
+	aof, err := NewAOFWriter(filename, AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.Append([]resp.Value{
+		{Type: resp.BulkString, Str: "SET"},
+		{Type: resp.BulkString, Str: "tail_key"},
+		{Type: resp.BulkString, Str: "after"},
+	})
+	aof.Close()
+
+	loaded := store.NewStore()
+	var functions []string
+	var replayed []string
+	err = LoadAOF(filename, loaded, func(values []resp.Value) resp.Value {
+		switch values[0].Str {
+		case "FUNCTION":
+			functions = append(functions, values[3].Str)
+		case "SET":
+			loaded.Set(values[1].Str, values[2].Str)
+		}
+		replayed = append(replayed, values[0].Str)
+		return resp.Value{Type: resp.SimpleString, Str: "OK"}
+	})
+	if err != nil {
+		t.Fatalf("Failed to load AOF: %v", err)
+	}
+
+	if len(replayed) != 2 {
+		t.Errorf("Expected only the function and the tail to be replayed as commands, got %v", replayed)
+	}
+	if len(functions) != 1 || functions[0] != "#!lua name=lib" {
+		t.Errorf("Expected the preamble's function library to be loaded, got %v", functions)
+	}
+	if value, _ := loaded.Get("string_key"); value != "hello" {
+		t.Errorf("string_key: got %q, want hello", value)
+	}
+	if n, _ := loaded.LLen("list_key"); n != 3 {
+		t.Errorf("list_key length: got %d, want 3", n)
+	}
+	if score, ok := loaded.ZScore("zset_key", "m1"); !ok || score != 1.5 {
+		t.Errorf("zset_key score: got %v", score)
+	}
+	if value, _ := loaded.Get("tail_key"); value != "after" {
+		t.Errorf("tail_key: got %q, want after", value)
+	}
+}
+
+func TestLoadAOFDamagedFile(t *testing.T) {
+	set := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	multi := "*1\r\n$5\r\nMULTI\r\n"
+	exec := "*1\r\n$4\r\nEXEC\r\n"
+
+	tests := []struct {
+		name     string
+		content  string
+		opts     AOFLoadOptions
+		commands int
+		// size is the file size after loading, -1 if loading must fail.
+		size int
+	}{
+		{"truncated tail", set + set[:10], AOFLoadOptions{Truncated: true}, 1, len(set)},
+		{"truncated tail not allowed", set + set[:10], AOFLoadOptions{}, 1, -1},
+		{"unterminated MULTI", set + multi + set, AOFLoadOptions{Truncated: true}, 1, len(set)},
+		{"transaction", multi + set + set + exec, AOFLoadOptions{}, 2, len(multi + set + set + exec)},
+		{"corrupt middle", set + "garbage\r\n" + set, AOFLoadOptions{Truncated: true}, 1, -1},
+		{"corrupt middle allowed", set + "garbage\r\n" + set, AOFLoadOptions{Corrupt: true}, 1, len(set)},
+	}
+
+	for _, tt := range tests {
+		file := writeAOFFile(t, tt.content)
+		commands := 0
+		err := loadAOFFile(file, store.NewStore(), func([]resp.Value) resp.Value {
+			commands++
+			return resp.Value{Type: resp.SimpleString, Str: "OK"}
+		}, tt.opts, true, &AOFLoadStats{})
+
+		if tt.size < 0 {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		info, _ := os.Stat(file)
+		if commands != tt.commands || info.Size() != int64(tt.size) {
+			t.Errorf("%s: expected %d commands and %d bytes, got %d and %d", tt.name, tt.commands, tt.size, commands, info.Size())
+		}
+	}
+}
+
+func TestLoadAOFDirOnlyTruncatesLastFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "appendonlydir")
+	aof, err := OpenAOF(dir, "appendonly.aof", AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.Append(setCommand("a", "1"))
+	aof.Close()
+	if err := RewriteAOFDir(dir, "appendonly.aof", store.NewStore(), nil, false, DefaultRDBOptions); err != nil {
+		t.Fatal(err)
+	}
+	aof, err = OpenAOF(dir, "appendonly.aof", AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.Append(setCommand("b", "2"))
+	aof.Close()
+
+	m := aof.Manifest()
+	incr := filepath.Join(dir, m.Incrs[0].Name)
+	data, _ := os.ReadFile(incr)
+	os.WriteFile(incr, data[:len(data)-3], 0644)
+
+	opts := AOFLoadOptions{Truncated: true}
+	stats, err := LoadAOFDir(dir, "appendonly.aof", store.NewStore(), func([]resp.Value) resp.Value { return resp.Value{} }, opts)
+	if err != nil || len(stats.Truncated) != 1 || stats.Truncated[0].File != m.Incrs[0].Name {
+		t.Errorf("Expected the last file to be truncated, got %+v, %v", stats, err)
+	}
+
+	base := filepath.Join(dir, m.Base.Name)
+	data, _ = os.ReadFile(base)
+	os.WriteFile(base, append(data, "*3\r\n$3"...), 0644)
+	if _, err := LoadAOFDir(dir, "appendonly.aof", store.NewStore(), func([]resp.Value) resp.Value { return resp.Value{} }, opts); err == nil {
+		t.Error("Expected a truncated base file to fail loading")
+	}
+}
+
+func TestLoadAOFCountsFailedCommands(t *testing.T) {
+	set := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	incr := "*2\r\n$4\r\nincr\r\n$1\r\na\r\n"
+	file := writeAOFFile(t, set+incr+set+incr)
+
+	stats := &AOFLoadStats{}
+	err := loadAOFFile(file, store.NewStore(), func(values []resp.Value) resp.Value {
+		if values[0].Str == "incr" {
+			return resp.Value{Type: resp.Error, Str: "ERR value is not an integer or out of range"}
+		}
+		return resp.Value{Type: resp.SimpleString, Str: "OK"}
+	}, AOFLoadOptions{}, true, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Commands != 4 || stats.Failed != 2 || stats.FirstError != "INCR: ERR value is not an integer or out of range" {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestAOFTimestampAnnotations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := NewAOFWriter(filename, AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.SetTimestamps(true)
+	aof.Append(setCommand("a", "1"))
+	aof.Append(setCommand("b", "2"))
+	aof.SetTimestamps(false)
+	aof.Append(setCommand("c", "3"))
+	aof.Close()
+
+	data, _ := os.ReadFile(filename)
+	if n := strings.Count(string(data), "#TS:"); n != 1 || !strings.HasPrefix(string(data), "#TS:") {
+		t.Errorf("Expected a single annotation before the first command, got %q", data)
+	}
+
+	loaded := store.NewStore()
+	err = LoadAOF(filename, loaded, func(values []resp.Value) resp.Value {
+		loaded.Set(values[1].Str, values[2].Str)
+		return resp.Value{Type: resp.SimpleString, Str: "OK"}
+	})
+	if err != nil {
+		t.Fatalf("Failed to load annotated AOF: %v", err)
+	}
+	if loaded.Size() != 3 {
+		t.Errorf("Expected 3 keys, got %d", loaded.Size())
+	}
+}
+
+func TestAOFWriterOffsets(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "appendonly.aof")
+	aof, err := NewAOFWriter(filename, AOFSyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aof.Close()
+
+	aof.Append(setCommand("a", "1"))
+	size := int64(len(resp.SerializeArray(setCommand("a", "1"))))
+	if written, synced := aof.Offsets(); written != size || synced != 0 {
+		t.Errorf("Expected %d bytes written and none synced, got %d and %d", size, written, synced)
+	}
+
+	aof.SetSyncPolicy(AOFSyncAlways)
+	if written, synced := aof.Offsets(); synced != written {
+		t.Errorf("Expected changing the policy to sync everything, got %d of %d", synced, written)
+	}
+
+	aof.Append(setCommand("b", "2"))
+	if written, synced := aof.Offsets(); written != 2*size || synced != written {
+		t.Errorf("Expected %d bytes written and synced, got %d and %d", 2*size, written, synced)
+	}
+}
+
+func TestAOFOffsetsSurviveRewrite(t *testing.T) {
+	aof, err := OpenAOF(t.TempDir(), "appendonly.aof", AOFSyncNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aof.Close()
+
+	aof.Append(setCommand("a", "1"))
+	before, _ := aof.Offsets()
+
+	rewritten, err := aof.StartRewrite(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aof.AbortRewrite(rewritten)
+
+	if written, synced := aof.Offsets(); written != before || synced != before {
+		t.Errorf("Expected the new incr file to continue from %d, synced, got %d and %d", before, written, synced)
+	}
+	aof.Append(setCommand("b", "2"))
+	if written, _ := aof.Offsets(); written <= before {
+		t.Errorf("Expected the offset to grow past %d, got %d", before, written)
+	}
+}
@@ -0,0 +1,177 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+// RDBError reports where in an RDB stream loading failed.
+type RDBError struct {
+	Offset int64
+	// Key being read when the error happened, if any.
+	Key string
+	Err error
+}
+
+func (e *RDBError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("at offset %d, reading key '%s': %v", e.Offset, e.Key, e.Err)
+	}
+	return fmt.Sprintf("at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *RDBError) Unwrap() error {
+	return e.Err
+}
+
+// RDBStats describes what a checked RDB stream holds.
+type RDBStats struct {
+	Version int
+	Aux     map[string]string
+	// Keys counts the keys read by type.
+	Keys           map[string]int
+	Expires        int
+	AlreadyExpired int
+	Functions      int
+	ModuleAux      int
+	// Checksum stored in the file, zero if it was saved without one.
+	Checksum uint64
+}
+
+func newRDBStats() *RDBStats {
+	return &RDBStats{Aux: make(map[string]string), Keys: make(map[string]int)}
+}
+
+// TotalKeys returns the number of keys of all types.
+func (s *RDBStats) TotalKeys() int {
+	total := 0
+	for _, n := range s.Keys {
+		total += n
+	}
+	return total
+}
+
+func typeName(objType store.ObjectType) string {
+	switch objType {
+	case store.ObjString:
+		return "string"
+	case store.ObjList:
+		return "list"
+	case store.ObjHash:
+		return "hash"
+	case store.ObjSet:
+		return "set"
+	case store.ObjZSet:
+		return "zset"
+	}
+	return "unknown"
+}
+
+// CheckRDB reads the RDB file without loading it, returning what it holds
+// and the first problem found as an *RDBError.
+func CheckRDB(filepath string) (*RDBStats, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := NewRDBReader(bufio.NewReader(file))
+	reader.stats = newRDBStats()
+	return reader.stats, readRDB(reader, nil, nil, true)
+}
+
+// AOFCheck is the result of checking an AOF file.
+type AOFCheck struct {
+	Size     int64
+	Commands int
+	// Preamble describes the RDB preamble, if the file starts with one.
+	Preamble *RDBStats
+	// Valid is the length of the longest prefix holding only complete
+	// commands and transactions.
+	Valid int64
+	// Err is the first problem found, at ErrOffset.
+	Err       error
+	ErrOffset int64
+	// Fixable reports whether truncating the file to Valid bytes fixes it,
+	// which is not the case when the RDB preamble is corrupt.
+	Fixable bool
+	// PastTimestamp is set when CheckAOFUntil stopped at an annotation later
+	// than its timestamp. Valid is then where to cut the file.
+	PastTimestamp bool
+}
+
+// CheckAOF parses a single AOF file without executing it. The returned
+// error is only set if the file could not be read at all.
+func CheckAOF(filepath string) (*AOFCheck, error) {
+	return checkAOF(filepath, nil)
+}
+
+// CheckAOFUntil is like CheckAOF, but stops at the first #TS annotation
+// later than timestamp.
+func CheckAOFUntil(filepath string, timestamp int64) (*AOFCheck, error) {
+	past := false
+	check, err := checkAOF(filepath, func(annotation string) bool {
+		ts, ok := strings.CutPrefix(annotation, "#TS:")
+		if !ok {
+			return true
+		}
+		unix, err := strconv.ParseInt(ts, 10, 64)
+		past = err == nil && unix > timestamp
+		return !past
+	})
+	if check != nil {
+		check.PastTimestamp = past
+	}
+	return check, err
+}
+
+func checkAOF(filepath string, annotate func(string) bool) (*AOFCheck, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	check := &AOFCheck{Size: info.Size()}
+
+	counter := &countingReader{r: file}
+	reader := bufio.NewReader(counter)
+	offset := func() int64 {
+		return counter.offset - int64(reader.Buffered())
+	}
+
+	if magic, _ := reader.Peek(5); string(magic) == "REDIS" {
+		rdb := NewRDBReader(reader)
+		rdb.stats = newRDBStats()
+		check.Preamble = rdb.stats
+		if err := readRDB(rdb, nil, nil, true); err != nil {
+			check.Err = err
+			check.ErrOffset = rdb.reader.offset
+			return check, nil
+		}
+	}
+
+	check.Fixable = true
+	check.Commands, check.Valid, err = scanAOF(reader, offset, func([]resp.Value) {}, annotate)
+
+	var aofErr *AOFError
+	if errors.As(err, &aofErr) {
+		check.Err = aofErr.Err
+		check.ErrOffset = aofErr.Offset
+	} else if err != nil {
+		return nil, err
+	}
+	return check, nil
+}
@@ -0,0 +1,163 @@
+package persistence
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+func TestCheckRDB(t *testing.T) {
+	stats, err := CheckRDB("testdata/redis7.rdb")
+	if err != nil {
+		t.Fatalf("CheckRDB failed: %v", err)
+	}
+
+	if stats.Version != 11 || stats.Aux["redis-ver"] != "7.2.4" {
+		t.Errorf("Expected version 11 and redis-ver 7.2.4, got %d and %q", stats.Version, stats.Aux["redis-ver"])
+	}
+	want := map[string]int{"string": 7, "list": 2, "hash": 1, "zset": 1, "set": 2, "module": 1}
+	for name, n := range want {
+		if stats.Keys[name] != n {
+			t.Errorf("%s: got %d keys, want %d", name, stats.Keys[name], n)
+		}
+	}
+	if stats.Expires != 2 || stats.AlreadyExpired != 1 {
+		t.Errorf("Expected 2 expires, 1 already expired, got %d and %d", stats.Expires, stats.AlreadyExpired)
+	}
+	if stats.Functions != 1 || stats.ModuleAux != 1 || stats.Checksum == 0 {
+		t.Errorf("Expected a function, module aux and checksum, got %+v", stats)
+	}
+}
+
+func TestCheckRDBReportsOffsetAndKey(t *testing.T) {
+	s := store.NewStore()
+	s.RPush("mylist", "a", "b", "c")
+	file := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := SaveRDB(file, s); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(file)
+	cut := strings.Index(string(data), "mylist") + len("mylist") + 3
+	os.WriteFile(file, data[:cut], 0644)
+
+	_, err := CheckRDB(file)
+	var rdbErr *RDBError
+	if !errors.As(err, &rdbErr) {
+		t.Fatalf("Expected an RDBError, got %v", err)
+	}
+	if rdbErr.Key != "mylist" || rdbErr.Offset != int64(cut) {
+		t.Errorf("Expected key mylist at offset %d, got %q at %d", cut, rdbErr.Key, rdbErr.Offset)
+	}
+}
+
+func writeAOFFile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "appendonly.aof")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestCheckAOF(t *testing.T) {
+	set := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	multi := "*1\r\n$5\r\nMULTI\r\n"
+	exec := "*1\r\n$4\r\nEXEC\r\n"
+
+	tests := []struct {
+		name    string
+		content string
+		valid   int
+		err     string
+	}{
+		{"valid", set + multi + set + exec + set, len(set + multi + set + exec + set), ""},
+		{"empty", "", 0, ""},
+		{"truncated", set + set[:10], len(set), "unexpected end of file"},
+		{"unterminated MULTI", set + multi + set, len(set), "EXEC for MULTI"},
+		{"truncated in MULTI", set + multi + set[:5], len(set), "unexpected end of file"},
+		{"garbage", set + "garbage\r\n", len(set), "expected '*'"},
+		{"EXEC without MULTI", set + exec, len(set), "EXEC without MULTI"},
+		{"annotations", "#TS:100\r\n" + set + multi + "#TS:101\r\n" + set + exec, len("#TS:100\r\n" + set + multi + "#TS:101\r\n" + set + exec), ""},
+		{"truncated annotation", set + "#TS:1", len(set), "unexpected end of file"},
+	}
+
+	for _, tt := range tests {
+		check, err := CheckAOF(writeAOFFile(t, tt.content))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if tt.err == "" && check.Err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, check.Err)
+		}
+		if tt.err != "" && (check.Err == nil || !strings.Contains(check.Err.Error(), tt.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.err, check.Err)
+		}
+		if check.Valid != int64(tt.valid) || !check.Fixable {
+			t.Errorf("%s: expected %d valid bytes, got %d (fixable %v)", tt.name, tt.valid, check.Valid, check.Fixable)
+		}
+	}
+}
+
+func TestCheckAOFWithRDBPreamble(t *testing.T) {
+	st := store.NewStore()
+	st.Set("key", "value")
+	file := filepath.Join(t.TempDir(), "appendonly.aof")
+	if err := WriteRDB(file, st, nil, DefaultRDBOptions); err != nil {
+		t.Fatal(err)
+	}
+	rdb, _ := os.ReadFile(file)
+	set := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	os.WriteFile(file, append(rdb, set+set[:4]...), 0644)
+
+	check, err := CheckAOF(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Preamble == nil || check.Preamble.TotalKeys() != 1 {
+		t.Errorf("Expected a preamble with one key, got %+v", check.Preamble)
+	}
+	if check.Commands != 1 || check.Valid != int64(len(rdb)+len(set)) || check.ErrOffset != check.Valid {
+		t.Errorf("Expected 1 command valid up to %d, got %+v", len(rdb)+len(set), check)
+	}
+
+	rdb[len(rdb)-1] ^= 0xFF
+	os.WriteFile(file, rdb, 0644)
+	check, _ = CheckAOF(file)
+	if check.Err == nil || check.Fixable {
+		t.Errorf("Expected an unfixable preamble error, got %+v", check)
+	}
+}
+
+func TestCheckAOFUntil(t *testing.T) {
+	set := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	flush := "*1\r\n$8\r\nFLUSHALL\r\n"
+	content := "#TS:100\r\n" + set + "#TS:200\r\n" + set + "#TS:300\r\n" + flush
+
+	tests := []struct {
+		timestamp int64
+		past      bool
+		valid     int
+		commands  int
+	}{
+		{50, true, 0, 0},
+		{100, true, len("#TS:100\r\n" + set), 1},
+		{299, true, len("#TS:100\r\n" + set + "#TS:200\r\n" + set), 2},
+		{300, false, len(content), 3},
+	}
+	for _, tt := range tests {
+		check, err := CheckAOFUntil(writeAOFFile(t, content), tt.timestamp)
+		if err != nil || check.Err != nil {
+			t.Fatalf("%d: %v, %v", tt.timestamp, err, check.Err)
+		}
+		if check.PastTimestamp != tt.past || check.Valid != int64(tt.valid) || check.Commands != tt.commands {
+			t.Errorf("%d: expected past %v, %d valid bytes and %d commands, got %v, %d and %d",
+				tt.timestamp, tt.past, tt.valid, tt.commands, check.PastTimestamp, check.Valid, check.Commands)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package persistence
+
+import "hash/crc64"
+
+// Redis checksums RDB files and DUMP payloads with the Jones polynomial,
+// reflected, without the initial and final inversion hash/crc64 applies,
+// so those are undone around crc64.Update.
+var jonesTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func crc64Jones(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, jonesTable, p)
+}
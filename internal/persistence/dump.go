@@ -0,0 +1,107 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+var ErrBadPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+
+func EncodeFunctionsPayload(functions []string) []byte {
+	var buf bytes.Buffer
+	writer := NewRDBWriter(&buf, DefaultRDBOptions)
+
+	for _, code := range functions {
+		writer.WriteFunction(code)
+	}
+
+	return appendPayloadFooter(buf.Bytes())
+}
+
+func DecodeFunctionsPayload(payload []byte) ([]string, error) {
+	body, err := checkPayloadFooter(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := NewRDBReader(bytes.NewReader(body))
+	var functions []string
+	for {
+		opcode, err := reader.readByte()
+		if err != nil {
+			return functions, nil
+		}
+		if opcode != opFunction2 {
+			return nil, ErrBadPayload
+		}
+
+		code, err := reader.readString()
+		if err != nil {
+			return nil, ErrBadPayload
+		}
+		functions = append(functions, code)
+	}
+}
+
+// EncodeObjectPayload serializes obj the way DUMP does: its RDB type and
+// value, followed by the payload footer.
+func EncodeObjectPayload(obj *store.RedisObject) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := NewRDBWriter(&buf, DefaultRDBOptions)
+	if err := writer.writeType(obj.Type); err != nil {
+		return nil, err
+	}
+	if err := writer.writeValue(obj); err != nil {
+		return nil, err
+	}
+	return appendPayloadFooter(buf.Bytes()), nil
+}
+
+// DecodeObjectPayload reads back an object serialized by DUMP.
+func DecodeObjectPayload(payload []byte) (*store.RedisObject, error) {
+	body, err := checkPayloadFooter(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := NewRDBReader(bytes.NewReader(body))
+	valueType, err := reader.readByte()
+	if err != nil {
+		return nil, ErrBadPayload
+	}
+	obj, err := reader.readObject(valueType)
+	if err != nil {
+		return nil, ErrBadPayload
+	}
+	if _, err := reader.readByte(); err != io.EOF {
+		return nil, ErrBadPayload
+	}
+	return obj, nil
+}
+
+// appendPayloadFooter adds the RDB version and a CRC64 of the body and
+// version, as DUMP payloads end with.
+func appendPayloadFooter(body []byte) []byte {
+	payload := binary.LittleEndian.AppendUint16(body, RDBVersion)
+	return binary.LittleEndian.AppendUint64(payload, crc64Jones(0, payload))
+}
+
+func checkPayloadFooter(payload []byte) ([]byte, error) {
+	if len(payload) < 10 {
+		return nil, ErrBadPayload
+	}
+
+	footer := payload[len(payload)-10:]
+	if binary.LittleEndian.Uint16(footer) > rdbMaxVersion {
+		return nil, ErrBadPayload
+	}
+	if binary.LittleEndian.Uint64(footer[2:]) != crc64Jones(0, payload[:len(payload)-8]) {
+		return nil, ErrBadPayload
+	}
+
+	return payload[:len(payload)-10], nil
+}
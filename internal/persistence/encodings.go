@@ -0,0 +1,220 @@
+package persistence
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Decoders for the compact blobs Redis stores small collections in. They
+// all return the elements as strings, integers formatted in decimal, in the
+// order they appear (field, value, field, value... for hashes and member,
+// score... for sorted sets).
+
+var errBlobCorrupt = errors.New("corrupt encoded collection")
+
+// decodeZiplist decodes a ziplist: <zlbytes><zltail><zllen> entries <0xFF>,
+// where each entry is <prevlen><encoding><data>.
+func decodeZiplist(blob []byte) ([]string, error) {
+	if len(blob) < 11 || binary.LittleEndian.Uint32(blob) != uint32(len(blob)) || blob[len(blob)-1] != 0xFF {
+		return nil, errBlobCorrupt
+	}
+
+	var elements []string
+	p := 10
+	for blob[p] != 0xFF {
+		// prevlen is 1 byte, or 0xFE followed by a 4 byte length.
+		if blob[p] == 0xFE {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(blob)-1 {
+			return nil, errBlobCorrupt
+		}
+
+		enc := blob[p]
+		p++
+		var value string
+		var err error
+		switch {
+		case enc>>6 == 0:
+			value, p, err = blobString(blob, p, int(enc&0x3F))
+		case enc>>6 == 1:
+			if p >= len(blob) {
+				return nil, errBlobCorrupt
+			}
+			value, p, err = blobString(blob, p+1, int(enc&0x3F)<<8|int(blob[p]))
+		case enc == 0x80:
+			if p+4 > len(blob) {
+				return nil, errBlobCorrupt
+			}
+			value, p, err = blobString(blob, p+4, int(binary.BigEndian.Uint32(blob[p:])))
+		case enc == 0xC0:
+			value, p, err = blobInt(blob, p, 2)
+		case enc == 0xD0:
+			value, p, err = blobInt(blob, p, 4)
+		case enc == 0xE0:
+			value, p, err = blobInt(blob, p, 8)
+		case enc == 0xF0:
+			value, p, err = blobInt(blob, p, 3)
+		case enc == 0xFE:
+			value, p, err = blobInt(blob, p, 1)
+		case enc >= 0xF1 && enc <= 0xFD:
+			value = strconv.Itoa(int(enc&0x0F) - 1)
+		default:
+			return nil, errBlobCorrupt
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p >= len(blob) {
+			return nil, errBlobCorrupt
+		}
+		elements = append(elements, value)
+	}
+
+	if p != len(blob)-1 {
+		return nil, errBlobCorrupt
+	}
+	return elements, nil
+}
+
+// decodeListpack decodes a listpack: <total bytes><num elements> entries
+// <0xFF>, where each entry is <encoding><data><backlen>.
+func decodeListpack(blob []byte) ([]string, error) {
+	if len(blob) < 7 || binary.LittleEndian.Uint32(blob) != uint32(len(blob)) || blob[len(blob)-1] != 0xFF {
+		return nil, errBlobCorrupt
+	}
+
+	var elements []string
+	p := 6
+	for blob[p] != 0xFF {
+		start := p
+		enc := blob[p]
+		p++
+
+		var value string
+		var err error
+		switch {
+		case enc&0x80 == 0:
+			value = strconv.Itoa(int(enc))
+		case enc&0xC0 == 0x80:
+			value, p, err = blobString(blob, p, int(enc&0x3F))
+		case enc&0xE0 == 0xC0:
+			if p >= len(blob) {
+				return nil, errBlobCorrupt
+			}
+			n := int(enc&0x1F)<<8 | int(blob[p])
+			if n >= 1<<12 {
+				n -= 1 << 13
+			}
+			value = strconv.Itoa(n)
+			p++
+		case enc&0xF0 == 0xE0:
+			if p >= len(blob) {
+				return nil, errBlobCorrupt
+			}
+			value, p, err = blobString(blob, p+1, int(enc&0x0F)<<8|int(blob[p]))
+		case enc == 0xF0:
+			if p+4 > len(blob) {
+				return nil, errBlobCorrupt
+			}
+			value, p, err = blobString(blob, p+4, int(binary.LittleEndian.Uint32(blob[p:])))
+		case enc == 0xF1:
+			value, p, err = blobInt(blob, p, 2)
+		case enc == 0xF2:
+			value, p, err = blobInt(blob, p, 3)
+		case enc == 0xF3:
+			value, p, err = blobInt(blob, p, 4)
+		case enc == 0xF4:
+			value, p, err = blobInt(blob, p, 8)
+		default:
+			return nil, errBlobCorrupt
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p += listpackBacklenSize(p - start)
+		if p >= len(blob) {
+			return nil, errBlobCorrupt
+		}
+		elements = append(elements, value)
+	}
+
+	if p != len(blob)-1 {
+		return nil, errBlobCorrupt
+	}
+	return elements, nil
+}
+
+// listpackBacklenSize returns how many bytes encode the length of an entry
+// of entryLen bytes, using the same thresholds as lpEncodeBacklen.
+func listpackBacklenSize(entryLen int) int {
+	switch {
+	case entryLen <= 127:
+		return 1
+	case entryLen < 16383:
+		return 2
+	case entryLen < 2097151:
+		return 3
+	case entryLen < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// decodeIntset decodes an intset: <encoding><length> followed by sorted
+// little endian integers of encoding bytes each.
+func decodeIntset(blob []byte) ([]string, error) {
+	if len(blob) < 8 {
+		return nil, errBlobCorrupt
+	}
+	width := int(binary.LittleEndian.Uint32(blob))
+	length := int(binary.LittleEndian.Uint32(blob[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(blob) != 8+width*length {
+		return nil, errBlobCorrupt
+	}
+
+	elements := make([]string, 0, length)
+	for p := 8; p < len(blob); {
+		var value string
+		var err error
+		value, p, err = blobInt(blob, p, width)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, value)
+	}
+	return elements, nil
+}
+
+func blobString(blob []byte, p, length int) (string, int, error) {
+	if length < 0 || p+length > len(blob) {
+		return "", 0, errBlobCorrupt
+	}
+	return string(blob[p : p+length]), p + length, nil
+}
+
+// blobInt reads a little endian signed integer of width bytes.
+func blobInt(blob []byte, p, width int) (string, int, error) {
+	if p+width > len(blob) {
+		return "", 0, errBlobCorrupt
+	}
+	var u uint64
+	for i := width - 1; i >= 0; i-- {
+		u = u<<8 | uint64(blob[p+i])
+	}
+	shift := 64 - 8*width
+	n := int64(u<<shift) >> shift
+	return strconv.FormatInt(n, 10), p + width, nil
+}
+
+func pairsError(elements []string) error {
+	if len(elements)%2 != 0 {
+		return fmt.Errorf("%w: odd number of elements", errBlobCorrupt)
+	}
+	return nil
+}
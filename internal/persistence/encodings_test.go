@@ -0,0 +1,179 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeZiplist(t *testing.T) {
+	// The example from ziplist.c: [2, 5].
+	got, err := decodeZiplist(mustHex(t, "0f000000 0c000000 0200 00f3 02f6 ff"))
+	if err != nil || !reflect.DeepEqual(got, []string{"2", "5"}) {
+		t.Errorf("got %v, %v; want [2 5]", got, err)
+	}
+
+	// "hello", int16 -300, int64 2^40, int24 100000.
+	blob := mustHex(t, "25000000 1f000000 0400 0005 68656c6c6f 07 c0d4fe 04 e00000000000010000 0a f0a08601 ff")
+	got, err = decodeZiplist(blob)
+	want := []string{"hello", "-300", "1099511627776", "100000"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, %v; want %v", got, err, want)
+	}
+
+	blob[0]++
+	if _, err := decodeZiplist(blob); err == nil {
+		t.Error("expected an error for a wrong total length")
+	}
+	if _, err := decodeZiplist(mustHex(t, "0e000000 0c000000 0200 00f3 02f6")); err == nil {
+		t.Error("expected an error for a missing end marker")
+	}
+}
+
+func TestDecodeListpack(t *testing.T) {
+	// "a", 7 bit 5, 13 bit -5, int16 1000, 12 bit string of 64 bytes.
+	var b bytes.Buffer
+	b.Write(mustHex(t, "00000000 0500"))
+	b.Write(mustHex(t, "8161 02"))
+	b.Write(mustHex(t, "05 01"))
+	b.Write(mustHex(t, "dffb 02"))
+	b.Write(mustHex(t, "f1e803 03"))
+	b.Write([]byte{0xE0, 64})
+	b.WriteString(strings.Repeat("z", 64))
+	b.WriteByte(66)
+	b.WriteByte(0xFF)
+	blob := b.Bytes()
+	blob[0] = byte(len(blob))
+
+	got, err := decodeListpack(blob)
+	want := []string{"a", "5", "-5", "1000", strings.Repeat("z", 64)}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, %v; want %v", got, err, want)
+	}
+
+	if _, err := decodeListpack(blob[:len(blob)-1]); err == nil {
+		t.Error("expected an error for a truncated listpack")
+	}
+}
+
+func TestListpackBacklenSize(t *testing.T) {
+	tests := map[int]int{1: 1, 127: 1, 128: 2, 16382: 2, 16383: 3, 2097151: 4}
+	for entryLen, want := range tests {
+		if got := listpackBacklenSize(entryLen); got != want {
+			t.Errorf("listpackBacklenSize(%d) = %d, want %d", entryLen, got, want)
+		}
+	}
+}
+
+func TestDecodeIntset(t *testing.T) {
+	got, err := decodeIntset(mustHex(t, "02000000 03000000 0100 0200 0300"))
+	if err != nil || !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Errorf("got %v, %v; want [1 2 3]", got, err)
+	}
+
+	got, err = decodeIntset(mustHex(t, "08000000 01000000 0000000000000080"))
+	if err != nil || !reflect.DeepEqual(got, []string{"-9223372036854775808"}) {
+		t.Errorf("got %v, %v; want [-9223372036854775808]", got, err)
+	}
+
+	if _, err := decodeIntset(mustHex(t, "03000000 01000000 000000")); err == nil {
+		t.Error("expected an error for an invalid encoding")
+	}
+	if _, err := decodeIntset(mustHex(t, "02000000 02000000 0100")); err == nil {
+		t.Error("expected an error for a short intset")
+	}
+}
+
+func TestLZFDecompress(t *testing.T) {
+	// A literal "a" followed by a back reference copying it 9 times.
+	got, err := lzfDecompress(mustHex(t, "0061 e00000"), 10)
+	if err != nil || string(got) != "aaaaaaaaaa" {
+		t.Errorf("got %q, %v; want 10 a's", got, err)
+	}
+
+	if _, err := lzfDecompress(mustHex(t, "0061 e00000"), 11); err == nil {
+		t.Error("expected an error for a wrong length")
+	}
+	if _, err := lzfDecompress(mustHex(t, "0061 e00001"), 10); err == nil {
+		t.Error("expected an error for a reference before the start")
+	}
+}
+
+func TestReadLength(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint64
+	}{
+		{"3f", 63},
+		{"4100", 256},
+		{"80 00010000", 65536},
+		{"81 0000000100000000", 1 << 32},
+	}
+	for _, tt := range tests {
+		r := NewRDBReader(bytes.NewReader(mustHex(t, tt.input)))
+		got, special, err := r.readLength()
+		if err != nil || special || got != tt.want {
+			t.Errorf("readLength(%s) = %d, %v, %v; want %d", tt.input, got, special, err, tt.want)
+		}
+	}
+
+	r := NewRDBReader(bytes.NewReader(mustHex(t, "82 00000000")))
+	if _, _, err := r.readLength(); err == nil {
+		t.Error("expected an error for an unknown length encoding")
+	}
+
+	var buf bytes.Buffer
+	if err := NewRDBWriter(&buf, DefaultRDBOptions).writeLength(math.MaxUint32 + 1); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := NewRDBReader(&buf).readLength()
+	if err != nil || got != math.MaxUint32+1 {
+		t.Errorf("got %d, %v; want %d", got, err, uint64(math.MaxUint32+1))
+	}
+}
+
+func TestCRC64Jones(t *testing.T) {
+	if got := crc64Jones(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("got %016x, want e9c6d914c4b8d9ca", got)
+	}
+	// Checksums can be computed incrementally.
+	if got := crc64Jones(crc64Jones(0, []byte("1234")), []byte("56789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("incremental: got %016x, want e9c6d914c4b8d9ca", got)
+	}
+}
+
+func TestLZFCompress(t *testing.T) {
+	inputs := []string{
+		strings.Repeat("a", 1000),
+		strings.Repeat("abcdefgh", 50) + "tail",
+		strings.Repeat("0123456789", 3000),
+		"the quick brown fox jumps over the lazy dog, the quick brown fox jumps again",
+	}
+	for _, in := range inputs {
+		compressed := lzfCompress([]byte(in), len(in)-4)
+		if compressed == nil {
+			t.Errorf("%.20q... did not compress", in)
+			continue
+		}
+		out, err := lzfDecompress(compressed, len(in))
+		if err != nil || string(out) != in {
+			t.Errorf("%.20q... round trip: got %.20q, %v", in, out, err)
+		}
+	}
+
+	if compressed := lzfCompress([]byte("abcdefghijklmnopqrstuvwxyz"), 22); compressed != nil {
+		t.Errorf("expected no compression, got %d bytes", len(compressed))
+	}
+}
@@ -0,0 +1,134 @@
+package persistence
+
+import "errors"
+
+var errLZFCorrupt = errors.New("corrupt LZF data")
+
+// lzfDecompress expands LZF data, the compression Redis uses for long
+// strings in RDB files, into exactly outLen bytes.
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < 1<<5 {
+			// Literal run of ctrl+1 bytes.
+			length := ctrl + 1
+			if ip+length > len(in) || len(out)+length > outLen {
+				return nil, errLZFCorrupt
+			}
+			out = append(out, in[ip:ip+length]...)
+			ip += length
+			continue
+		}
+
+		// Back reference: copy length+2 bytes starting offset+1 bytes back.
+		length := ctrl >> 5
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, errLZFCorrupt
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errLZFCorrupt
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[ip]) - 1
+		ip++
+
+		length += 2
+		if ref < 0 || len(out)+length > outLen {
+			return nil, errLZFCorrupt
+		}
+		// The ranges may overlap, so copy byte by byte.
+		for i := 0; i < length; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, errLZFCorrupt
+	}
+	return out, nil
+}
+
+const (
+	lzfMaxLiteral = 1 << 5
+	lzfMaxOffset  = 1 << 13
+	lzfMaxRef     = 1<<8 + 1<<3
+	lzfHashBits   = 13
+)
+
+// lzfCompress compresses in with LZF, returning nil if the result would be
+// longer than maxLen bytes.
+func lzfCompress(in []byte, maxLen int) []byte {
+	if len(in) < 3 {
+		return nil
+	}
+
+	var table [1 << lzfHashBits]int32
+	hash := func(p int) uint32 {
+		v := uint32(in[p])<<16 | uint32(in[p+1])<<8 | uint32(in[p+2])
+		return v * 2654435761 >> (32 - lzfHashBits)
+	}
+
+	out := make([]byte, 0, maxLen)
+	// Index of the control byte of the literal run being built, if any.
+	literal := -1
+
+	for ip := 0; ip < len(in); {
+		if ip+2 < len(in) {
+			h := hash(ip)
+			// Positions are stored plus one so zero means empty.
+			ref := int(table[h]) - 1
+			table[h] = int32(ip + 1)
+
+			if ref >= 0 && ip-ref <= lzfMaxOffset &&
+				in[ref] == in[ip] && in[ref+1] == in[ip+1] && in[ref+2] == in[ip+2] {
+				length := 3
+				for limit := min(len(in)-ip, lzfMaxRef); length < limit && in[ref+length] == in[ip+length]; length++ {
+				}
+
+				offset := ip - ref - 1
+				l := length - 2
+				if l < 7 {
+					if len(out)+2 > maxLen {
+						return nil
+					}
+					out = append(out, byte(l<<5|offset>>8), byte(offset))
+				} else {
+					if len(out)+3 > maxLen {
+						return nil
+					}
+					out = append(out, byte(7<<5|offset>>8), byte(l-7), byte(offset))
+				}
+				literal = -1
+
+				for p := ip + 1; p < ip+length && p+2 < len(in); p++ {
+					table[hash(p)] = int32(p + 1)
+				}
+				ip += length
+				continue
+			}
+		}
+
+		if literal < 0 || out[literal] == lzfMaxLiteral-1 {
+			if len(out)+2 > maxLen {
+				return nil
+			}
+			out = append(out, 0, in[ip])
+			literal = len(out) - 2
+		} else {
+			if len(out)+1 > maxLen {
+				return nil
+			}
+			out[literal]++
+			out = append(out, in[ip])
+		}
+		ip++
+	}
+	return out
+}
@@ -0,0 +1,230 @@
+package persistence
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+type AOFFileType byte
+
+const (
+	AOFBase    AOFFileType = 'b'
+	AOFHistory AOFFileType = 'h'
+	AOFIncr    AOFFileType = 'i'
+)
+
+type AOFInfo struct {
+	Name string
+	Seq  int64
+	Type AOFFileType
+}
+
+// AOFManifest lists the files of a multi-part AOF: the base file holding a
+// snapshot of the dataset, the incremental files with the commands logged
+// after it, replayed in order, and history files left over from a rewrite
+// that are waiting to be deleted.
+type AOFManifest struct {
+	Base    *AOFInfo
+	Incrs   []AOFInfo
+	History []AOFInfo
+
+	baseSeq int64
+	incrSeq int64
+}
+
+func ManifestName(name string) string {
+	return name + ".manifest"
+}
+
+// LoadManifest reads the manifest of the AOF called name in dir. A missing
+// manifest is reported with an error satisfying os.IsNotExist.
+func LoadManifest(dir, name string) (*AOFManifest, error) {
+	file, err := os.Open(filepath.Join(dir, ManifestName(name)))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := &AOFManifest{}
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		info, err := parseManifestLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AOF manifest line %d: %w", lineNum, err)
+		}
+
+		switch info.Type {
+		case AOFBase:
+			if m.Base != nil {
+				return nil, fmt.Errorf("invalid AOF manifest line %d: found duplicate base file", lineNum)
+			}
+			m.Base = &info
+			m.baseSeq = max(m.baseSeq, info.Seq)
+		case AOFHistory:
+			m.History = append(m.History, info)
+		case AOFIncr:
+			if info.Seq <= m.incrSeq {
+				return nil, fmt.Errorf("invalid AOF manifest line %d: incr files out of order", lineNum)
+			}
+			m.Incrs = append(m.Incrs, info)
+			m.incrSeq = info.Seq
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read AOF manifest: %w", err)
+	}
+
+	if m.Base == nil && len(m.Incrs) == 0 {
+		return nil, fmt.Errorf("invalid AOF manifest: no base or incr files")
+	}
+	return m, nil
+}
+
+func parseManifestLine(line string) (AOFInfo, error) {
+	args, err := resp.SplitArgs(line)
+	if err != nil {
+		return AOFInfo{}, err
+	}
+	if len(args)%2 != 0 {
+		return AOFInfo{}, fmt.Errorf("expected key value pairs")
+	}
+
+	var info AOFInfo
+	for i := 0; i < len(args); i += 2 {
+		switch args[i] {
+		case "file":
+			if filepath.Base(args[i+1]) != args[i+1] {
+				return AOFInfo{}, fmt.Errorf("file name can't be a path")
+			}
+			info.Name = args[i+1]
+		case "seq":
+			seq, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || seq < 1 {
+				return AOFInfo{}, fmt.Errorf("invalid seq '%s'", args[i+1])
+			}
+			info.Seq = seq
+		case "type":
+			if len(args[i+1]) != 1 {
+				return AOFInfo{}, fmt.Errorf("invalid type '%s'", args[i+1])
+			}
+			info.Type = AOFFileType(args[i+1][0])
+		}
+	}
+
+	if info.Name == "" || info.Seq == 0 {
+		return AOFInfo{}, fmt.Errorf("missing file or seq")
+	}
+	if info.Type != AOFBase && info.Type != AOFHistory && info.Type != AOFIncr {
+		return AOFInfo{}, fmt.Errorf("unknown file type '%c'", info.Type)
+	}
+	return info, nil
+}
+
+func (m *AOFManifest) String() string {
+	var b strings.Builder
+	write := func(info AOFInfo) {
+		fmt.Fprintf(&b, "file %s seq %d type %c\n", quoteFileName(info.Name), info.Seq, info.Type)
+	}
+
+	if m.Base != nil {
+		write(*m.Base)
+	}
+	for _, info := range m.History {
+		write(info)
+	}
+	for _, info := range m.Incrs {
+		write(info)
+	}
+	return b.String()
+}
+
+// Files returns the base and incr files in the order they are replayed.
+func (m *AOFManifest) Files() []AOFInfo {
+	var files []AOFInfo
+	if m.Base != nil {
+		files = append(files, *m.Base)
+	}
+	return append(files, m.Incrs...)
+}
+
+func (m *AOFManifest) clone() *AOFManifest {
+	c := *m
+	if m.Base != nil {
+		base := *m.Base
+		c.Base = &base
+	}
+	c.Incrs = append([]AOFInfo(nil), m.Incrs...)
+	c.History = append([]AOFInfo(nil), m.History...)
+	return &c
+}
+
+// nextBase names the next base file; an RDB preamble base ends in .rdb.
+func (m *AOFManifest) nextBase(name string, rdb bool) AOFInfo {
+	m.baseSeq++
+	ext := "aof"
+	if rdb {
+		ext = "rdb"
+	}
+	return AOFInfo{Name: fmt.Sprintf("%s.%d.base.%s", name, m.baseSeq, ext), Seq: m.baseSeq, Type: AOFBase}
+}
+
+func (m *AOFManifest) nextIncr(name string) AOFInfo {
+	m.incrSeq++
+	return AOFInfo{Name: fmt.Sprintf("%s.%d.incr.aof", name, m.incrSeq), Seq: m.incrSeq, Type: AOFIncr}
+}
+
+func quoteFileName(name string) string {
+	if strings.ContainsAny(name, " \t\r\n\"'\\") {
+		return strconv.Quote(name)
+	}
+	return name
+}
+
+// writeManifest atomically replaces the manifest of the AOF called name.
+func writeManifest(dir, name string, m *AOFManifest) error {
+	target := filepath.Join(dir, ManifestName(name))
+	tmpFile := filepath.Join(dir, "temp-"+ManifestName(name))
+
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return fmt.Errorf("failed to create AOF manifest: %w", err)
+	}
+	if _, err := file.WriteString(m.String()); err != nil {
+		file.Close()
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to write AOF manifest: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to sync AOF manifest: %w", err)
+	}
+	file.Close()
+
+	if err := os.Rename(tmpFile, target); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to rename AOF manifest: %w", err)
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	d.Sync()
+	return nil
+}
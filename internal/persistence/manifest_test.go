@@ -0,0 +1,70 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	m := &AOFManifest{}
+	base := m.nextBase("my file.aof", false)
+	m.Base = &base
+	m.Incrs = append(m.Incrs, m.nextIncr("my file.aof"), m.nextIncr("my file.aof"))
+	m.History = []AOFInfo{{Name: "old.aof", Seq: 7, Type: AOFHistory}}
+
+	if err := writeManifest(dir, "my file.aof", m); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	loaded, err := LoadManifest(dir, "my file.aof")
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if loaded.String() != m.String() {
+		t.Errorf("Manifest did not round-trip:\n%s\nvs\n%s", loaded, m)
+	}
+
+	next := loaded.nextIncr("my file.aof")
+	if next.Seq != 3 {
+		t.Errorf("Expected sequence numbers to continue from the manifest, got %d", next.Seq)
+	}
+}
+
+func TestManifestFormat(t *testing.T) {
+	m := &AOFManifest{}
+	base := m.nextBase("appendonly.aof", false)
+	m.Base = &base
+	m.Incrs = append(m.Incrs, m.nextIncr("appendonly.aof"))
+
+	expected := "file appendonly.aof.1.base.aof seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n"
+	if m.String() != expected {
+		t.Errorf("Unexpected manifest:\n%s", m)
+	}
+}
+
+func TestInvalidManifests(t *testing.T) {
+	tests := map[string]string{
+		"odd arguments":  "file a.aof seq\n",
+		"bad seq":        "file a.aof seq x type i\n",
+		"unknown type":   "file a.aof seq 1 type z\n",
+		"path":           "file ../a.aof seq 1 type i\n",
+		"two bases":      "file a seq 1 type b\nfile b seq 2 type b\n",
+		"incr order":     "file a seq 2 type i\nfile b seq 1 type i\n",
+		"no files":       "# empty\n",
+		"missing fields": "seq 1 type i\n",
+	}
+
+	dir := t.TempDir()
+	for name, contents := range tests {
+		os.WriteFile(filepath.Join(dir, "x.manifest"), []byte(contents), 0644)
+		if _, err := LoadManifest(dir, "x"); err == nil || !strings.Contains(err.Error(), "manifest") {
+			t.Errorf("%s: expected manifest error, got %v", name, err)
+		}
+	}
+
+	if _, err := LoadManifest(dir, "missing"); !os.IsNotExist(err) {
+		t.Errorf("Expected a not-exist error for a missing manifest, got %v", err)
+	}
+}
@@ -0,0 +1,400 @@
+package persistence
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+// AOF is a multi-part append only file kept in its own directory: commands
+// are appended to the newest incr file, and a rewrite only has to write a
+// new base file, since writes made meanwhile already go to a fresh incr
+// file. The manifest says which files to replay.
+type AOF struct {
+	mu         sync.Mutex
+	dir        string
+	name       string
+	manifest   *AOFManifest
+	writer     *AOFWriter
+	closed     bool
+	rewriting  bool
+	rewriteRDB bool
+	// Number of incr files, oldest first, covered by the running rewrite.
+	covered    int
+	timestamps bool
+	// pending is set until the first rewrite of an AOF opened by StartAOF
+	// finishes; commands go to a temporary incr file meanwhile.
+	pending bool
+}
+
+// OpenAOF opens the multi-part AOF called name in dir for appending,
+// creating the directory if needed. A single-file AOF of the same name in
+// the parent directory, as written by older versions, becomes the base file.
+func OpenAOF(dir, name string, policy AOFSyncPolicy) (*AOF, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create AOF directory: %w", err)
+	}
+
+	m, err := LoadManifest(dir, name)
+	if os.IsNotExist(err) {
+		m, err = upgradeLegacyAOF(dir, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aof := &AOF{dir: dir, name: name, manifest: m}
+
+	if len(m.Incrs) > 0 {
+		last := m.Incrs[len(m.Incrs)-1]
+		aof.writer, err = NewAOFWriter(filepath.Join(dir, last.Name), policy)
+		if err != nil {
+			return nil, err
+		}
+		return aof, nil
+	}
+
+	if err := aof.openIncr(policy); err != nil {
+		return nil, err
+	}
+	return aof, nil
+}
+
+// StartAOF opens the AOF called name in dir for a server turning AOF on,
+// whose files don't hold its dataset. Until a rewrite finishes, commands go
+// to a temporary incr file the manifest doesn't list, so the files on disk
+// stay consistent; the rewrite then replaces all of them. StartRewrite must
+// be called next.
+func StartAOF(dir, name string, policy AOFSyncPolicy) (*AOF, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create AOF directory: %w", err)
+	}
+
+	m, err := LoadManifest(dir, name)
+	if os.IsNotExist(err) {
+		m, err = &AOFManifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aof := &AOF{dir: dir, name: name, manifest: m, pending: true}
+	if err := aof.openPending(policy); err != nil {
+		return nil, err
+	}
+	return aof, nil
+}
+
+func upgradeLegacyAOF(dir, name string) (*AOFManifest, error) {
+	m := &AOFManifest{}
+	legacy := filepath.Join(filepath.Dir(dir), name)
+	if _, err := os.Stat(legacy); err != nil {
+		return m, nil
+	}
+
+	base := m.nextBase(name, false)
+	if err := os.Rename(legacy, filepath.Join(dir, base.Name)); err != nil {
+		return nil, fmt.Errorf("failed to move %s into the AOF directory: %w", legacy, err)
+	}
+	m.Base = &base
+	return m, writeManifest(dir, name, m)
+}
+
+// openIncr starts a new incr file, records it in the manifest and makes it
+// the file commands are appended to. Callers must hold the lock or own a.
+func (a *AOF) openIncr(policy AOFSyncPolicy) error {
+	next := a.manifest.clone()
+	incr := next.nextIncr(a.name)
+	next.Incrs = append(next.Incrs, incr)
+
+	path := filepath.Join(a.dir, incr.Name)
+	writer, err := NewAOFWriter(path, policy)
+	if err != nil {
+		return err
+	}
+	if err := writeManifest(a.dir, a.name, next); err != nil {
+		writer.Close()
+		os.Remove(path)
+		return err
+	}
+	writer.SetTimestamps(a.timestamps)
+
+	if a.writer != nil {
+		a.writer.Close()
+		writer.resume(a.writer)
+	}
+	a.writer = writer
+	a.manifest = next
+	return nil
+}
+
+func (a *AOF) pendingPath() string {
+	return filepath.Join(a.dir, "temp-incr-"+a.name)
+}
+
+// openPending starts the temporary incr file of a pending AOF afresh, as the
+// commands it held are in the snapshot of a new rewrite.
+func (a *AOF) openPending(policy AOFSyncPolicy) error {
+	path := a.pendingPath()
+	os.Remove(path)
+	writer, err := NewAOFWriter(path, policy)
+	if err != nil {
+		return err
+	}
+	writer.SetTimestamps(a.timestamps)
+
+	if a.writer != nil {
+		a.writer.Close()
+		writer.resume(a.writer)
+	}
+	a.writer = writer
+	return nil
+}
+
+func (a *AOF) Append(command []resp.Value) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.writer.Append(command)
+}
+
+func (a *AOF) SetSyncPolicy(policy AOFSyncPolicy) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.writer.SetSyncPolicy(policy)
+}
+
+func (a *AOF) SetTimestamps(enabled bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.timestamps = enabled
+	a.writer.SetTimestamps(enabled)
+}
+
+// Offsets returns the bytes appended and fsynced over all incr files. No
+// bytes count as fsynced while the AOF is pending.
+func (a *AOF) Offsets() (written, synced int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	written, synced = a.writer.Offsets()
+	if a.pending {
+		synced = 0
+	}
+	return written, synced
+}
+
+// Pending reports whether the AOF waits for its first rewrite.
+func (a *AOF) Pending() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.pending
+}
+
+func (a *AOF) SyncPolicy() AOFSyncPolicy {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.writer.SyncPolicy()
+}
+
+// Manifest returns a copy of the current manifest.
+func (a *AOF) Manifest() *AOFManifest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.manifest.clone()
+}
+
+func (a *AOF) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	err := a.writer.Close()
+	if a.pending {
+		os.Remove(a.pendingPath())
+	}
+	return err
+}
+
+// StartRewrite switches appends to a new incr file and returns the file the
+// new base should be written to, as an RDB file if rdbPreamble is set or as
+// commands otherwise. It must be called at the same point the dataset
+// snapshot for the rewrite is taken, so every command lands either in the
+// snapshot or in the new incr file.
+func (a *AOF) StartRewrite(rdbPreamble bool) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rewriting {
+		return "", fmt.Errorf("AOF rewrite already in progress")
+	}
+	if a.pending {
+		if err := a.openPending(a.writer.SyncPolicy()); err != nil {
+			return "", err
+		}
+		a.covered = len(a.manifest.Incrs)
+	} else {
+		if err := a.openIncr(a.writer.SyncPolicy()); err != nil {
+			return "", err
+		}
+		a.covered = len(a.manifest.Incrs) - 1
+	}
+
+	a.rewriting = true
+	a.rewriteRDB = rdbPreamble
+	return filepath.Join(a.dir, "temp-rewriteaof-"+a.name), nil
+}
+
+// AbortRewrite discards a failed rewrite. The incr file it started is kept.
+func (a *AOF) AbortRewrite(rewritten string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rewriting = false
+	os.Remove(rewritten)
+}
+
+// FinishRewrite installs the rewritten file as the new base, replacing the
+// old base and the incr files it covers, which are then deleted.
+// The temporary incr file of a pending AOF is added as its incr file.
+func (a *AOF) FinishRewrite(rewritten string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.rewriting {
+		return fmt.Errorf("no AOF rewrite in progress")
+	}
+	a.rewriting = false
+
+	if a.closed {
+		os.Remove(rewritten)
+		return fmt.Errorf("AOF was closed during the rewrite")
+	}
+
+	current := a.manifest
+	var incrPath string
+	if a.pending {
+		// The temporary incr file joins the manifest with the new base.
+		current = current.clone()
+		incr := current.nextIncr(a.name)
+		incrPath = filepath.Join(a.dir, incr.Name)
+		if err := os.Rename(a.pendingPath(), incrPath); err != nil {
+			os.Remove(rewritten)
+			return fmt.Errorf("failed to rename temporary incr AOF: %w", err)
+		}
+		current.Incrs = append(current.Incrs, incr)
+	}
+
+	m, err := installBase(a.dir, a.name, current, rewritten, a.covered, a.rewriteRDB)
+	if err != nil {
+		if a.pending {
+			os.Rename(incrPath, a.pendingPath())
+		}
+		return err
+	}
+	a.manifest = m
+	a.pending = false
+	return nil
+}
+
+// installBase renames rewritten to a new base file and points the manifest
+// at it in place of the old base and the first covered incr files. The
+// replaced files are deleted once the manifest no longer lists them.
+func installBase(dir, name string, current *AOFManifest, rewritten string, covered int, rdb bool) (*AOFManifest, error) {
+	next := current.clone()
+	base := next.nextBase(name, rdb)
+	if err := os.Rename(rewritten, filepath.Join(dir, base.Name)); err != nil {
+		os.Remove(rewritten)
+		return nil, fmt.Errorf("failed to rename rewritten AOF: %w", err)
+	}
+
+	if next.Base != nil {
+		old := *next.Base
+		old.Type = AOFHistory
+		next.History = append(next.History, old)
+	}
+	for _, incr := range next.Incrs[:covered] {
+		incr.Type = AOFHistory
+		next.History = append(next.History, incr)
+	}
+	next.Base = &base
+	next.Incrs = next.Incrs[covered:]
+
+	if err := writeManifest(dir, name, next); err != nil {
+		os.Remove(filepath.Join(dir, base.Name))
+		return nil, err
+	}
+
+	history := next.History
+	next.History = nil
+	if err := writeManifest(dir, name, next); err != nil {
+		next.History = history
+		return next, nil
+	}
+	for _, info := range history {
+		os.Remove(filepath.Join(dir, info.Name))
+	}
+	return next, nil
+}
+
+// RewriteAOFDir writes the dataset as a new base file of the AOF called
+// name in dir, replacing all of its current files. It is used when AOF is
+// off; a running AOF is rewritten with StartRewrite and FinishRewrite.
+func RewriteAOFDir(dir, name string, st *store.Store, functions []string, rdbPreamble bool, opts RDBOptions) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create AOF directory: %w", err)
+	}
+
+	m, err := LoadManifest(dir, name)
+	if os.IsNotExist(err) {
+		m, err = &AOFManifest{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tmpFile := filepath.Join(dir, "temp-rewriteaof-"+name)
+	if err := WriteAOFBase(tmpFile, st, functions, rdbPreamble, opts); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	_, err = installBase(dir, name, m, tmpFile, len(m.Incrs), rdbPreamble)
+	return err
+}
+
+// WriteAOFBase writes an AOF base file holding the dataset, either as an RDB
+// preamble or as the commands that rebuild it.
+func WriteAOFBase(file string, st *store.Store, functions []string, rdbPreamble bool, opts RDBOptions) error {
+	if rdbPreamble {
+		return WriteRDB(file, st, functions, opts)
+	}
+	return WriteAOFSnapshot(file, st, functions)
+}
+
+// LoadAOFDir replays the files of the AOF called name in dir in manifest
+// order. Without a manifest it falls back to a single-file AOF of the same
+// name in the parent directory.
+func LoadAOFDir(dir, name string, st *store.Store, executeCommand func([]resp.Value) resp.Value, opts AOFLoadOptions) (*AOFLoadStats, error) {
+	stats := &AOFLoadStats{}
+
+	m, err := LoadManifest(dir, name)
+	if os.IsNotExist(err) {
+		return stats, loadAOFFile(filepath.Join(filepath.Dir(dir), name), st, executeCommand, opts, true, stats)
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	files := m.Files()
+	for i, info := range files {
+		path := filepath.Join(dir, info.Name)
+		if _, err := os.Stat(path); err != nil {
+			return stats, fmt.Errorf("AOF file %s listed in the manifest is missing: %w", info.Name, err)
+		}
+		if err := loadAOFFile(path, st, executeCommand, opts, i == len(files)-1, stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,348 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+func setCommand(key, value string) []resp.Value {
+	return []resp.Value{
+		{Type: resp.BulkString, Str: "SET"},
+		{Type: resp.BulkString, Str: key},
+		{Type: resp.BulkString, Str: value},
+	}
+}
+
+func loadSetCommands(t *testing.T, dir, name string) *store.Store {
+	t.Helper()
+	st := store.NewStore()
+	_, err := LoadAOFDir(dir, name, st, func(values []resp.Value) resp.Value {
+		st.Set(values[1].Str, values[2].Str)
+		return resp.Value{Type: resp.SimpleString, Str: "OK"}
+	}, AOFLoadOptions{})
+	if err != nil {
+		t.Fatalf("Failed to load AOF: %v", err)
+	}
+	return st
+}
+
+func TestOpenAOFCreatesIncrFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "appendonlydir")
+
+	aof, err := OpenAOF(dir, "appendonly.aof", AOFSyncAlways)
+	if err != nil {
+		t.Fatalf("Failed to open AOF: %v", err)
+	}
+	aof.Append(setCommand("key", "value"))
+	aof.Close()
+
+	m, err := LoadManifest(dir, "appendonly.aof")
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if m.Base != nil || len(m.Incrs) != 1 || m.Incrs[0].Name != "appendonly.aof.1.incr.aof" {
+		t.Errorf("Unexpected manifest:\n%s", m)
+	}
+
+	reopened, err := OpenAOF(dir, "appendonly.aof", AOFSyncAlways)
+	if err != nil {
+		t.Fatalf("Failed to reopen AOF: %v", err)
+	}
+	reopened.Append(setCommand("other", "value"))
+	reopened.Close()
+
+	if len(reopened.Manifest().Incrs) != 1 {
+		t.Error("Expected reopening to keep appending to the last incr file")
+	}
+	loaded := loadSetCommands(t, dir, "appendonly.aof")
+	if !loaded.Exists("key") || !loaded.Exists("other") {
+		t.Error("Expected both commands to be replayed")
+	}
+}
+
+func TestAOFRewriteKeepsConcurrentWrites(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "appendonlydir")
+
+	aof, err := OpenAOF(dir, "appendonly.aof", AOFSyncEverySec)
+	if err != nil {
+		t.Fatalf("Failed to open AOF: %v", err)
+	}
+
+	live := store.NewStore()
+	live.Set("before", "1")
+	aof.Append(setCommand("before", "1"))
+
+	tmpFile, err := aof.StartRewrite(false)
+	if err != nil {
+		t.Fatalf("Failed to start rewrite: %v", err)
+	}
+	snapshot := live.BeginSnapshot()
+
+	live.Set("during", "2")
+	aof.Append(setCommand("during", "2"))
+
+	if err := WriteAOFSnapshot(tmpFile, snapshot.Store(), nil); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	snapshot.Release()
+	if err := aof.FinishRewrite(tmpFile); err != nil {
+		t.Fatalf("Failed to finish rewrite: %v", err)
+	}
+
+	aof.Append(setCommand("after", "3"))
+	if err := aof.Close(); err != nil {
+		t.Fatalf("Failed to close AOF: %v", err)
+	}
+
+	m := aof.Manifest()
+	if m.Base == nil || m.Base.Name != "appendonly.aof.1.base.aof" || len(m.Incrs) != 1 || m.Incrs[0].Seq != 2 || len(m.History) != 0 {
+		t.Errorf("Unexpected manifest after rewrite:\n%s", m)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("Expected only the manifest, base and incr files to be left, got %d files", len(entries))
+	}
+
+	loaded := loadSetCommands(t, dir, "appendonly.aof")
+	for key, expected := range map[string]string{"before": "1", "during": "2", "after": "3"} {
+		if value, ok := loaded.Get(key); !ok || value != expected {
+			t.Errorf("%s: expected %q, got %q", key, expected, value)
+		}
+	}
+}
+
+func TestAOFFinishRewriteErrors(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "appendonlydir")
+	aof, err := OpenAOF(dir, "appendonly.aof", AOFSyncNo)
+	if err != nil {
+		t.Fatalf("Failed to open AOF: %v", err)
+	}
+
+	if err := aof.FinishRewrite(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected error when no rewrite was started")
+	}
+
+	tmpFile, err := aof.StartRewrite(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := aof.StartRewrite(false); err == nil {
+		t.Error("Expected error when a rewrite is already running")
+	}
+	aof.Append(setCommand("key", "value"))
+	aof.Close()
+
+	WriteAOFSnapshot(tmpFile, store.NewStore(), nil)
+	if err := aof.FinishRewrite(tmpFile); err == nil {
+		t.Error("Expected error when the AOF was closed during the rewrite")
+	}
+
+	loaded := loadSetCommands(t, dir, "appendonly.aof")
+	if value, _ := loaded.Get("key"); value != "value" {
+		t.Errorf("Expected the existing files to be kept, got %q", value)
+	}
+}
+
+func TestStartAOF(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "appendonlydir")
+	old, err := OpenAOF(dir, "appendonly.aof", AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Append(setCommand("old", "1"))
+	old.Close()
+
+	aof, err := StartAOF(dir, "appendonly.aof", AOFSyncAlways)
+	if err != nil {
+		t.Fatalf("Failed to start AOF: %v", err)
+	}
+	aof.Append(setCommand("dropped", "1"))
+
+	// A rewrite that failed leaves the old files as they were.
+	tmpFile, err := aof.StartRewrite(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.AbortRewrite(tmpFile)
+	if loaded := loadSetCommands(t, dir, "appendonly.aof"); !loaded.Exists("old") || loaded.Exists("dropped") {
+		t.Error("Expected the old files to be kept while the AOF is pending")
+	}
+	if _, synced := aof.Offsets(); synced != 0 {
+		t.Errorf("Expected nothing to count as fsynced while pending, got %d", synced)
+	}
+
+	live := store.NewStore()
+	live.Set("new", "2")
+	tmpFile, err = aof.StartRewrite(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.Append(setCommand("during", "3"))
+	if err := WriteAOFSnapshot(tmpFile, live, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := aof.FinishRewrite(tmpFile); err != nil {
+		t.Fatalf("Failed to finish rewrite: %v", err)
+	}
+	aof.Append(setCommand("after", "4"))
+	aof.Close()
+
+	if aof.Pending() {
+		t.Error("Expected the AOF to be live after its rewrite")
+	}
+	m := aof.Manifest()
+	if m.Base == nil || len(m.Incrs) != 1 || m.Incrs[0].Seq != 2 {
+		t.Errorf("Unexpected manifest:\n%s", m)
+	}
+	loaded := loadSetCommands(t, dir, "appendonly.aof")
+	for _, key := range []string{"new", "during", "after"} {
+		if !loaded.Exists(key) {
+			t.Errorf("Expected %s to be replayed", key)
+		}
+	}
+	if loaded.Exists("old") || loaded.Exists("dropped") {
+		t.Error("Expected the rewrite to replace the old files")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "temp-incr-appendonly.aof")); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary incr file to be gone, got %v", err)
+	}
+}
+
+func TestRewriteAOFDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "appendonlydir")
+	aof, err := OpenAOF(dir, "appendonly.aof", AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.Append(setCommand("old", "1"))
+	aof.Close()
+
+	st := store.NewStore()
+	st.Set("new", "2")
+	if err := RewriteAOFDir(dir, "appendonly.aof", st, nil, false, DefaultRDBOptions); err != nil {
+		t.Fatalf("Failed to rewrite: %v", err)
+	}
+
+	m, _ := LoadManifest(dir, "appendonly.aof")
+	if m.Base == nil || len(m.Incrs) != 0 {
+		t.Errorf("Expected only a base file, got:\n%s", m)
+	}
+	loaded := loadSetCommands(t, dir, "appendonly.aof")
+	if loaded.Exists("old") || !loaded.Exists("new") {
+		t.Error("Expected the rewrite to replace the previous files")
+	}
+}
+
+func TestLegacyAOFUpgrade(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "appendonlydir")
+
+	legacy, err := NewAOFWriter(filepath.Join(parent, "appendonly.aof"), AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.Append(setCommand("legacy", "1"))
+	legacy.Close()
+
+	if !loadSetCommands(t, dir, "appendonly.aof").Exists("legacy") {
+		t.Error("Expected a single-file AOF to load without a manifest")
+	}
+
+	aof, err := OpenAOF(dir, "appendonly.aof", AOFSyncAlways)
+	if err != nil {
+		t.Fatalf("Failed to open AOF: %v", err)
+	}
+	aof.Append(setCommand("new", "2"))
+	aof.Close()
+
+	if _, err := os.Stat(filepath.Join(parent, "appendonly.aof")); !os.IsNotExist(err) {
+		t.Error("Expected the legacy file to be moved into the AOF directory")
+	}
+	loaded := loadSetCommands(t, dir, "appendonly.aof")
+	if !loaded.Exists("legacy") || !loaded.Exists("new") {
+		t.Error("Expected the legacy file to become the base file")
+	}
+}
+
+func TestLoadAOFDirMissingFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "appendonlydir")
+	aof, err := OpenAOF(dir, "appendonly.aof", AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.Close()
+
+	os.Remove(filepath.Join(dir, "appendonly.aof.1.incr.aof"))
+	_, err = LoadAOFDir(dir, "appendonly.aof", store.NewStore(), func([]resp.Value) resp.Value { return resp.Value{} }, AOFLoadOptions{})
+	if err == nil {
+		t.Error("Expected an error for a file listed in the manifest but missing")
+	}
+}
+
+func TestAOFRewriteWithRDBPreamble(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "appendonlydir")
+	aof, err := OpenAOF(dir, "appendonly.aof", AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := store.NewStore()
+	st.Set("base", "1")
+	tmpFile, err := aof.StartRewrite(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.Append(setCommand("incr", "2"))
+	if err := WriteAOFBase(tmpFile, st, nil, true, DefaultRDBOptions); err != nil {
+		t.Fatal(err)
+	}
+	if err := aof.FinishRewrite(tmpFile); err != nil {
+		t.Fatalf("Failed to finish rewrite: %v", err)
+	}
+	aof.Close()
+
+	m := aof.Manifest()
+	if m.Base == nil || m.Base.Name != "appendonly.aof.1.base.rdb" {
+		t.Errorf("Expected an RDB base file, got:\n%s", m)
+	}
+	loaded := loadSetCommands(t, dir, "appendonly.aof")
+	if !loaded.Exists("base") || !loaded.Exists("incr") {
+		t.Error("Expected the RDB base and the incr file to be loaded")
+	}
+}
+
+func TestLoadAOFDirCorruptNotLast(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "appendonlydir")
+	st := store.NewStore()
+	st.Set("base", "1")
+	if err := RewriteAOFDir(dir, "appendonly.aof", st, nil, false, DefaultRDBOptions); err != nil {
+		t.Fatal(err)
+	}
+	aof, err := OpenAOF(dir, "appendonly.aof", AOFSyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aof.Append(setCommand("incr", "2"))
+	aof.Close()
+
+	m, _ := LoadManifest(dir, "appendonly.aof")
+	base := filepath.Join(dir, m.Base.Name)
+	file, _ := os.OpenFile(base, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("garbage\r\n")
+	file.Close()
+	before, _ := os.Stat(base)
+
+	// The incr file would be replayed on top of whatever the garbage hid.
+	_, err = LoadAOFDir(dir, "appendonly.aof", store.NewStore(), func([]resp.Value) resp.Value { return resp.OKValue() }, AOFLoadOptions{Corrupt: true})
+	if err == nil {
+		t.Error("Expected corruption in a file other than the last to fail the load")
+	}
+	if after, _ := os.Stat(base); after.Size() != before.Size() {
+		t.Errorf("Expected the file to be left as it was, got %d bytes instead of %d", after.Size(), before.Size())
+	}
+}
@@ -1,9 +1,11 @@
 package persistence
 
 import (
+	"bufio"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"strconv"
 	"time"
@@ -15,32 +17,86 @@ import (
 const (
 	RDBVersion = 9
 
-	opEOF        = 0xFF
-	opSelectDB   = 0xFE
-	opExpireTime = 0xFD
-	opExpireMS   = 0xFC
-	opResizeDB   = 0xFB
-	opAux        = 0xFA
-
-	typeString  = 0
-	typeList    = 1
-	typeSet     = 2
-	typeZSet    = 3
-	typeHash    = 4
-	typeZSet2   = 5
-	typeHashZip = 9
-	typeListZip = 10
-	typeSetInt  = 11
-	typeZSetZip = 12
-	typeQuick   = 14
+	// Versions written by Redis 3.2 up to 7.2 can be loaded.
+	rdbMinVersion = 6
+	rdbMaxVersion = 11
+
+	opFunction2     = 0xF5
+	opFunctionPreGA = 0xF6
+	opModuleAux     = 0xF7
+	opIdle          = 0xF8
+	opFreq          = 0xF9
+	opEOF           = 0xFF
+	opSelectDB      = 0xFE
+	opExpireTime    = 0xFD
+	opExpireMS      = 0xFC
+	opResizeDB      = 0xFB
+	opAux           = 0xFA
+
+	typeString         = 0
+	typeList           = 1
+	typeSet            = 2
+	typeZSet           = 3
+	typeHash           = 4
+	typeZSet2          = 5
+	typeModule         = 6
+	typeModule2        = 7
+	typeHashZipmap     = 9
+	typeListZiplist    = 10
+	typeSetIntset      = 11
+	typeZSetZiplist    = 12
+	typeHashZiplist    = 13
+	typeListQuicklist  = 14
+	typeStream         = 15
+	typeHashListpack   = 16
+	typeZSetListpack   = 17
+	typeListQuicklist2 = 18
+	typeStream2        = 19
+	typeSetListpack    = 20
+	typeStream3        = 21
+
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+
+	// Opcodes of the self-describing values modules store.
+	moduleOpEOF    = 0
+	moduleOpSInt   = 1
+	moduleOpUInt   = 2
+	moduleOpFloat  = 3
+	moduleOpDouble = 4
+	moduleOpString = 5
 )
 
+// RDBOptions hold the rdbcompression and rdbchecksum settings.
+type RDBOptions struct {
+	// Compression LZF compresses strings longer than 20 bytes.
+	Compression bool
+	// Checksum writes a CRC64 trailer and verifies it on load. Files written
+	// without one have a zero trailer, which is never verified.
+	Checksum bool
+}
+
+var DefaultRDBOptions = RDBOptions{Compression: true, Checksum: true}
+
 type RDBWriter struct {
-	writer io.Writer
+	writer *crcWriter
+	opts   RDBOptions
+}
+
+func NewRDBWriter(w io.Writer, opts RDBOptions) *RDBWriter {
+	return &RDBWriter{writer: &crcWriter{w: w}, opts: opts}
 }
 
-func NewRDBWriter(w io.Writer) *RDBWriter {
-	return &RDBWriter{writer: w}
+// crcWriter passes writes through, keeping a checksum of everything written.
+type crcWriter struct {
+	w   io.Writer
+	crc uint64
+}
+
+func (c *crcWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.crc = crc64Jones(c.crc, p[:n])
+	return n, err
 }
 
 func (w *RDBWriter) WriteHeader() error {
@@ -60,6 +116,13 @@ func (w *RDBWriter) WriteAuxField(key, value string) error {
 	return w.writeString(value)
 }
 
+func (w *RDBWriter) WriteFunction(code string) error {
+	if err := w.writeByte(opFunction2); err != nil {
+		return err
+	}
+	return w.writeString(code)
+}
+
 func (w *RDBWriter) WriteSelectDB(dbNum int) error {
 	if err := w.writeByte(opSelectDB); err != nil {
 		return err
@@ -104,9 +167,11 @@ func (w *RDBWriter) WriteEOF() error {
 		return err
 	}
 
-	checksum := make([]byte, 8)
-	_, err := w.writer.Write(checksum)
-	return err
+	var checksum uint64
+	if w.opts.Checksum {
+		checksum = w.writer.crc
+	}
+	return binary.Write(w.writer, binary.LittleEndian, checksum)
 }
 
 func (w *RDBWriter) writeByte(b byte) error {
@@ -120,7 +185,7 @@ func (w *RDBWriter) writeType(objType store.ObjectType) error {
 	case store.ObjString:
 		typeCode = typeString
 	case store.ObjList:
-		typeCode = typeQuick
+		typeCode = typeList
 	case store.ObjHash:
 		typeCode = typeHash
 	case store.ObjSet:
@@ -145,16 +210,28 @@ func (w *RDBWriter) writeLength(length uint64) error {
 			return err
 		}
 		return w.writeByte(b2)
-	} else {
+	} else if length <= math.MaxUint32 {
 
 		if err := w.writeByte(0x80); err != nil {
 			return err
 		}
 		return binary.Write(w.writer, binary.BigEndian, uint32(length))
+	} else {
+		if err := w.writeByte(0x81); err != nil {
+			return err
+		}
+		return binary.Write(w.writer, binary.BigEndian, length)
 	}
 }
 
 func (w *RDBWriter) writeString(s string) error {
+	if w.opts.Compression && len(s) > 20 {
+		// Only worth it if at least 4 bytes are saved.
+		if compressed := lzfCompress([]byte(s), len(s)-4); compressed != nil {
+			return w.writeLZFString(compressed, len(s))
+		}
+	}
+
 	if err := w.writeLength(uint64(len(s))); err != nil {
 		return err
 	}
@@ -162,6 +239,20 @@ func (w *RDBWriter) writeString(s string) error {
 	return err
 }
 
+func (w *RDBWriter) writeLZFString(compressed []byte, length int) error {
+	if err := w.writeByte(0xC3); err != nil {
+		return err
+	}
+	if err := w.writeLength(uint64(len(compressed))); err != nil {
+		return err
+	}
+	if err := w.writeLength(uint64(length)); err != nil {
+		return err
+	}
+	_, err := w.writer.Write(compressed)
+	return err
+}
+
 func (w *RDBWriter) writeValue(obj *store.RedisObject) error {
 	switch obj.Type {
 	case store.ObjString:
@@ -291,17 +382,61 @@ func (w *RDBWriter) writeZSetValue(obj *store.RedisObject) error {
 	return nil
 }
 
+type FunctionLoader func(code string) error
+
 func SaveRDB(filepath string, s *store.Store) error {
+	return SaveRDBWithFunctions(filepath, s, nil, DefaultRDBOptions)
+}
 
+func SaveRDBWithFunctions(filepath string, s *store.Store, functions []string, opts RDBOptions) error {
 	tmpFile := filepath + ".tmp"
-	file, err := os.Create(tmpFile)
+	if err := WriteRDB(tmpFile, s, functions, opts); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	if err := os.Rename(tmpFile, filepath); err != nil {
+		return fmt.Errorf("failed to rename RDB file: %w", err)
+	}
+
+	return nil
+}
+
+// WriteRDB writes the dataset and function libraries to file in RDB format,
+// replacing its contents.
+func WriteRDB(filepath string, s *store.Store, functions []string, opts RDBOptions) error {
+	file, err := os.Create(filepath)
 	if err != nil {
 		return fmt.Errorf("failed to create RDB file: %w", err)
 	}
 	defer file.Close()
 
-	writer := NewRDBWriter(file)
+	buffered := bufio.NewWriter(file)
+	if err := writeRDB(NewRDBWriter(buffered, opts), s, functions); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to flush RDB file: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
 
+	return nil
+}
+
+// WriteRDBStream writes the dataset and function libraries in RDB format to
+// w, as sent to replicas.
+func WriteRDBStream(w io.Writer, s *store.Store, functions []string, opts RDBOptions) error {
+	buffered := bufio.NewWriter(w)
+	if err := writeRDB(NewRDBWriter(buffered, opts), s, functions); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+func writeRDB(writer *RDBWriter, s *store.Store, functions []string) error {
 	if err := writer.WriteHeader(); err != nil {
 		return fmt.Errorf("failed to write header: %w", err)
 	}
@@ -316,6 +451,12 @@ func SaveRDB(filepath string, s *store.Store) error {
 		return err
 	}
 
+	for _, code := range functions {
+		if err := writer.WriteFunction(code); err != nil {
+			return fmt.Errorf("failed to write function library: %w", err)
+		}
+	}
+
 	if err := writer.WriteSelectDB(0); err != nil {
 		return err
 	}
@@ -340,25 +481,33 @@ func SaveRDB(filepath string, s *store.Store) error {
 		return fmt.Errorf("failed to write EOF: %w", err)
 	}
 
-	if err := file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync file: %w", err)
-	}
-
-	file.Close()
-
-	if err := os.Rename(tmpFile, filepath); err != nil {
-		return fmt.Errorf("failed to rename RDB file: %w", err)
-	}
-
 	return nil
 }
 
 type RDBReader struct {
-	reader io.Reader
+	reader *crcReader
+	// Key being read, to report where an error happened.
+	key string
+	// Collected while checking a file, nil otherwise.
+	stats *RDBStats
 }
 
 func NewRDBReader(r io.Reader) *RDBReader {
-	return &RDBReader{reader: r}
+	return &RDBReader{reader: &crcReader{r: r}}
+}
+
+// crcReader keeps a checksum and count of everything read through it.
+type crcReader struct {
+	r      io.Reader
+	crc    uint64
+	offset int64
+}
+
+func (c *crcReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.crc = crc64Jones(c.crc, p[:n])
+	c.offset += int64(n)
+	return n, err
 }
 
 func (r *RDBReader) readByte() (byte, error) {
@@ -373,7 +522,7 @@ func (r *RDBReader) readBytes(n int) ([]byte, error) {
 	return buf, err
 }
 
-func (r *RDBReader) readLength() (uint32, bool, error) {
+func (r *RDBReader) readLength() (uint64, bool, error) {
 	b, err := r.readByte()
 	if err != nil {
 		return 0, false, err
@@ -383,26 +532,47 @@ func (r *RDBReader) readLength() (uint32, bool, error) {
 
 	switch lenType {
 	case 0:
-		return uint32(b & 0x3F), false, nil
+		return uint64(b & 0x3F), false, nil
 	case 1:
 		nextByte, err := r.readByte()
 		if err != nil {
 			return 0, false, err
 		}
-		return uint32(b&0x3F)<<8 | uint32(nextByte), false, nil
+		return uint64(b&0x3F)<<8 | uint64(nextByte), false, nil
 	case 2:
-		buf, err := r.readBytes(4)
-		if err != nil {
-			return 0, false, err
+		switch b {
+		case 0x80:
+			buf, err := r.readBytes(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := r.readBytes(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
 		}
-		return binary.BigEndian.Uint32(buf), false, nil
 	case 3:
-		return uint32(b & 0x3F), true, nil
+		return uint64(b & 0x3F), true, nil
 	}
 
 	return 0, false, fmt.Errorf("invalid length encoding")
 }
 
+// readSize reads a length that is about to be allocated.
+func (r *RDBReader) readSize() (int, error) {
+	length, _, err := r.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if length > math.MaxInt32 {
+		return 0, fmt.Errorf("length %d too large", length)
+	}
+	return int(length), nil
+}
+
 func (r *RDBReader) readString() (string, error) {
 	length, special, err := r.readLength()
 	if err != nil {
@@ -427,11 +597,16 @@ func (r *RDBReader) readString() (string, error) {
 				return "", err
 			}
 			return fmt.Sprintf("%d", int32(binary.LittleEndian.Uint32(buf))), nil
+		case 3:
+			return r.readLZFString()
 		default:
 			return "", fmt.Errorf("unknown special encoding: %d", length)
 		}
 	}
 
+	if length > math.MaxInt32 {
+		return "", fmt.Errorf("string length %d too large", length)
+	}
 	buf, err := r.readBytes(int(length))
 	if err != nil {
 		return "", err
@@ -439,7 +614,31 @@ func (r *RDBReader) readString() (string, error) {
 	return string(buf), nil
 }
 
+func (r *RDBReader) readLZFString() (string, error) {
+	compressedLen, err := r.readSize()
+	if err != nil {
+		return "", err
+	}
+	length, err := r.readSize()
+	if err != nil {
+		return "", err
+	}
+	compressed, err := r.readBytes(compressedLen)
+	if err != nil {
+		return "", err
+	}
+	buf, err := lzfDecompress(compressed, length)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
 func LoadRDB(filepath string, st *store.Store) error {
+	return LoadRDBWithFunctions(filepath, st, nil, DefaultRDBOptions)
+}
+
+func LoadRDBWithFunctions(filepath string, st *store.Store, loadFunction FunctionLoader, opts RDBOptions) error {
 	file, err := os.Open(filepath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -449,18 +648,41 @@ func LoadRDB(filepath string, st *store.Store) error {
 	}
 	defer file.Close()
 
-	reader := NewRDBReader(file)
+	return readRDB(NewRDBReader(bufio.NewReader(file)), st, loadFunction, opts.Checksum)
+}
+
+// ReadRDBStream loads an RDB stream such as the one a master sends to its
+// replicas, reading nothing past its checksum.
+func ReadRDBStream(r io.Reader, st *store.Store, loadFunction FunctionLoader) error {
+	return readRDB(NewRDBReader(r), st, loadFunction, true)
+}
+
+// readRDB loads an RDB stream up to and including its EOF marker and
+// checksum, leaving the reader positioned right after it. With a nil store
+// the stream is only checked. Errors are returned as *RDBError.
+func readRDB(reader *RDBReader, st *store.Store, loadFunction FunctionLoader, verifyChecksum bool) (err error) {
+	defer func() {
+		if err != nil {
+			err = &RDBError{Offset: reader.reader.offset, Key: reader.key, Err: err}
+		}
+	}()
 
 	header, err := reader.readBytes(9)
 	if err != nil {
 		return fmt.Errorf("failed to read header: %w", err)
 	}
-	expectedHeader := fmt.Sprintf("REDIS%04d", RDBVersion)
-	if string(header) != expectedHeader {
+	if string(header[:5]) != "REDIS" {
 		return fmt.Errorf("invalid RDB header: %s", string(header))
 	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < rdbMinVersion || version > rdbMaxVersion {
+		return fmt.Errorf("unsupported RDB version: %s", string(header[5:]))
+	}
+	if reader.stats != nil {
+		reader.stats.Version = version
+	}
 
-	var currentDB uint32 = 0
+	var currentDB uint64 = 0
 	var expireTime *time.Time
 
 	for {
@@ -471,11 +693,24 @@ func LoadRDB(filepath string, st *store.Store) error {
 
 		switch opcode {
 		case opEOF:
-
-			_, err := reader.readBytes(8)
-			if err != nil && err != io.EOF {
+			computed := reader.reader.crc
+			// Every version read here ends with the checksum, so a file
+			// without it was cut short, which only an unchecked load lets
+			// through.
+			buf, err := reader.readBytes(8)
+			if err == io.EOF && !verifyChecksum {
+				return nil
+			}
+			if err != nil {
 				return fmt.Errorf("failed to read CRC: %w", err)
 			}
+			checksum := binary.LittleEndian.Uint64(buf)
+			if verifyChecksum && checksum != 0 && checksum != computed {
+				return fmt.Errorf("wrong RDB checksum: file has %016x, computed %016x", checksum, computed)
+			}
+			if reader.stats != nil {
+				reader.stats.Checksum = checksum
+			}
 			return nil
 
 		case opSelectDB:
@@ -519,16 +754,54 @@ func LoadRDB(filepath string, st *store.Store) error {
 				return fmt.Errorf("failed to read expire size: %w", err)
 			}
 
+		case opIdle:
+			if _, _, err := reader.readLength(); err != nil {
+				return fmt.Errorf("failed to read idle time: %w", err)
+			}
+
+		case opFreq:
+			if _, err := reader.readByte(); err != nil {
+				return fmt.Errorf("failed to read access frequency: %w", err)
+			}
+
+		case opModuleAux:
+			if err := reader.skipModuleAux(); err != nil {
+				return fmt.Errorf("failed to skip module aux data: %w", err)
+			}
+			if reader.stats != nil {
+				reader.stats.ModuleAux++
+			}
+
+		case opFunctionPreGA:
+			return fmt.Errorf("pre-GA function format is not supported")
+
+		case opFunction2:
+			code, err := reader.readString()
+			if err != nil {
+				return fmt.Errorf("failed to read function library: %w", err)
+			}
+			if loadFunction != nil {
+				if err := loadFunction(code); err != nil {
+					return fmt.Errorf("failed to load function library: %w", err)
+				}
+			}
+			if reader.stats != nil {
+				reader.stats.Functions++
+			}
+
 		case opAux:
 
-			_, err := reader.readString()
+			key, err := reader.readString()
 			if err != nil {
 				return fmt.Errorf("failed to read aux key: %w", err)
 			}
-			_, err = reader.readString()
+			value, err := reader.readString()
 			if err != nil {
 				return fmt.Errorf("failed to read aux value: %w", err)
 			}
+			if reader.stats != nil {
+				reader.stats.Aux[key] = value
+			}
 
 		default:
 
@@ -546,36 +819,94 @@ func (r *RDBReader) readKeyValue(valueType byte, st *store.Store, expireTime *ti
 	if err != nil {
 		return fmt.Errorf("failed to read key: %w", err)
 	}
+	r.key = key
 
-	var obj *store.RedisObject
-	switch valueType {
-	case typeString:
-		obj, err = r.readStringObject()
-	case typeList, typeQuick:
-		obj, err = r.readListObject()
-	case typeSet:
-		obj, err = r.readSetObject()
-	case typeHash:
-		obj, err = r.readHashObject()
-	case typeZSet, typeZSet2:
-		obj, err = r.readZSetObject()
-	default:
-		return fmt.Errorf("unsupported value type: %d", valueType)
+	if valueType == typeModule2 {
+		// Module values can't be represented here, so the key is dropped.
+		if _, _, err := r.readLength(); err != nil {
+			return fmt.Errorf("failed to read module id: %w", err)
+		}
+		if err := r.skipModuleValue(); err != nil {
+			return fmt.Errorf("failed to skip module value: %w", err)
+		}
+		r.key = ""
+		if r.stats != nil {
+			r.stats.Keys["module"]++
+		}
+		return nil
 	}
 
+	obj, err := r.readObject(valueType)
 	if err != nil {
 		return fmt.Errorf("failed to read value: %w", err)
 	}
+	r.key = ""
+
+	expired := expireTime != nil && !expireTime.After(time.Now())
+	if r.stats != nil {
+		r.stats.Keys[typeName(obj.Type)]++
+		if expireTime != nil {
+			r.stats.Expires++
+		}
+		if expired {
+			r.stats.AlreadyExpired++
+		}
+	}
+
+	// Keys that expired while the file was on disk are not loaded.
+	if expired || st == nil {
+		return nil
+	}
 
 	st.SetObject(key, obj)
 
-	if expireTime != nil && expireTime.After(time.Now()) {
+	if expireTime != nil {
 		st.SetObjectExpire(key, *expireTime)
 	}
 
 	return nil
 }
 
+// readObject reads a value of the given RDB type.
+func (r *RDBReader) readObject(valueType byte) (*store.RedisObject, error) {
+	switch valueType {
+	case typeString:
+		return r.readStringObject()
+	case typeList:
+		return r.readListObject()
+	case typeSet:
+		return r.readSetObject()
+	case typeHash:
+		return r.readHashObject()
+	case typeZSet, typeZSet2:
+		return r.readZSetObject(valueType == typeZSet2)
+	case typeListZiplist:
+		return r.readEncodedObject(decodeZiplist, listObject)
+	case typeListQuicklist:
+		return r.readQuicklistObject()
+	case typeListQuicklist2:
+		return r.readQuicklist2Object()
+	case typeSetIntset:
+		return r.readEncodedObject(decodeIntset, setObject)
+	case typeSetListpack:
+		return r.readEncodedObject(decodeListpack, setObject)
+	case typeHashZiplist:
+		return r.readEncodedObject(decodeZiplist, hashObject)
+	case typeHashListpack:
+		return r.readEncodedObject(decodeListpack, hashObject)
+	case typeZSetZiplist:
+		return r.readEncodedObject(decodeZiplist, zsetObject)
+	case typeZSetListpack:
+		return r.readEncodedObject(decodeListpack, zsetObject)
+	case typeModule:
+		return nil, fmt.Errorf("module keys in the pre-GA format are not supported")
+	case typeStream, typeStream2, typeStream3:
+		return nil, fmt.Errorf("stream keys are not supported")
+	default:
+		return nil, fmt.Errorf("unsupported value type: %d", valueType)
+	}
+}
+
 func (r *RDBReader) readStringObject() (*store.RedisObject, error) {
 	str, err := r.readString()
 	if err != nil {
@@ -603,67 +934,258 @@ func (r *RDBReader) readStringObject() (*store.RedisObject, error) {
 	return obj, nil
 }
 
+func (r *RDBReader) readStrings(count int) ([]string, error) {
+	elements := make([]string, 0, min(count, 1024))
+	for i := 0; i < count; i++ {
+		str, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, str)
+	}
+	return elements, nil
+}
+
 func (r *RDBReader) readListObject() (*store.RedisObject, error) {
-	length, _, err := r.readLength()
+	length, err := r.readSize()
 	if err != nil {
 		return nil, err
 	}
+	elements, err := r.readStrings(length)
+	if err != nil {
+		return nil, err
+	}
+	return listObject(elements)
+}
 
-	list := store.NewQuicklist()
-	for i := uint32(0); i < length; i++ {
-		str, err := r.readString()
+// readQuicklistObject reads a list stored as ziplist nodes.
+func (r *RDBReader) readQuicklistObject() (*store.RedisObject, error) {
+	length, err := r.readSize()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := r.readStrings(length)
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []string
+	for _, node := range nodes {
+		decoded, err := decodeZiplist([]byte(node))
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid quicklist node: %w", err)
 		}
-		list.PushTail(str)
+		elements = append(elements, decoded...)
 	}
+	return listObject(elements)
+}
 
-	return &store.RedisObject{
-		Type:     store.ObjList,
-		Encoding: store.EncodingQuicklist,
-		Ptr:      list,
-	}, nil
+// readQuicklist2Object reads a list stored as nodes that are either a
+// listpack or a single large element.
+func (r *RDBReader) readQuicklist2Object() (*store.RedisObject, error) {
+	length, err := r.readSize()
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []string
+	for i := 0; i < length; i++ {
+		container, _, err := r.readLength()
+		if err != nil {
+			return nil, err
+		}
+		node, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		switch container {
+		case quicklistNodePlain:
+			elements = append(elements, node)
+		case quicklistNodePacked:
+			decoded, err := decodeListpack([]byte(node))
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, decoded...)
+		default:
+			return nil, fmt.Errorf("unknown quicklist node container: %d", container)
+		}
+	}
+	return listObject(elements)
 }
 
 func (r *RDBReader) readSetObject() (*store.RedisObject, error) {
-	length, _, err := r.readLength()
+	length, err := r.readSize()
 	if err != nil {
 		return nil, err
 	}
+	members, err := r.readStrings(length)
+	if err != nil {
+		return nil, err
+	}
+	return setObject(members)
+}
 
-	set := store.NewSet()
-	for i := uint32(0); i < length; i++ {
+func (r *RDBReader) readHashObject() (*store.RedisObject, error) {
+	length, err := r.readSize()
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := r.readStrings(2 * length)
+	if err != nil {
+		return nil, err
+	}
+	return hashObject(pairs)
+}
+
+// readZSetObject reads a sorted set whose scores are binary doubles, or
+// strings in the older format.
+func (r *RDBReader) readZSetObject(binaryScores bool) (*store.RedisObject, error) {
+	length, err := r.readSize()
+	if err != nil {
+		return nil, err
+	}
+
+	zset := store.NewZSet()
+	for i := 0; i < length; i++ {
 		member, err := r.readString()
 		if err != nil {
 			return nil, err
 		}
-		set.Add(member)
+
+		var score float64
+		if binaryScores {
+			buf, err := r.readBytes(8)
+			if err != nil {
+				return nil, err
+			}
+			score = float64frombits(binary.LittleEndian.Uint64(buf))
+		} else {
+			score, err = r.readStringScore()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		zset.Add(score, member)
 	}
 
 	return &store.RedisObject{
-		Type:     store.ObjSet,
-		Encoding: store.EncodingHT,
-		Ptr:      set,
+		Type:     store.ObjZSet,
+		Encoding: store.EncodingSkiplist,
+		Ptr:      zset,
 	}, nil
 }
 
-func (r *RDBReader) readHashObject() (*store.RedisObject, error) {
-	length, _, err := r.readLength()
+// readStringScore reads a score as a length byte and its decimal digits,
+// with the lengths 253, 254 and 255 standing for NaN, +inf and -inf.
+func (r *RDBReader) readStringScore() (float64, error) {
+	length, err := r.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	buf, err := r.readBytes(int(length))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+// readEncodedObject reads a collection stored as a single encoded blob.
+func (r *RDBReader) readEncodedObject(decode func([]byte) ([]string, error), build func([]string) (*store.RedisObject, error)) (*store.RedisObject, error) {
+	blob, err := r.readString()
+	if err != nil {
+		return nil, err
+	}
+	elements, err := decode([]byte(blob))
 	if err != nil {
 		return nil, err
 	}
+	return build(elements)
+}
 
-	hash := store.NewHashTable()
-	for i := uint32(0); i < length; i++ {
-		field, err := r.readString()
+func (r *RDBReader) skipModuleAux() error {
+	// Module id, then the when opcode and its value.
+	for i := 0; i < 3; i++ {
+		if _, _, err := r.readLength(); err != nil {
+			return err
+		}
+	}
+	return r.skipModuleValue()
+}
+
+// skipModuleValue skips a module value, which is a sequence of typed fields
+// ending with an EOF opcode.
+func (r *RDBReader) skipModuleValue() error {
+	for {
+		opcode, _, err := r.readLength()
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		switch opcode {
+		case moduleOpEOF:
+			return nil
+		case moduleOpSInt, moduleOpUInt:
+			_, _, err = r.readLength()
+		case moduleOpFloat:
+			_, err = r.readBytes(4)
+		case moduleOpDouble:
+			_, err = r.readBytes(8)
+		case moduleOpString:
+			_, err = r.readString()
+		default:
+			return fmt.Errorf("unknown module value opcode: %d", opcode)
 		}
-		value, err := r.readString()
 		if err != nil {
-			return nil, err
+			return err
 		}
-		hash.Set(field, value)
+	}
+}
+
+func listObject(elements []string) (*store.RedisObject, error) {
+	list := store.NewQuicklist()
+	for _, elem := range elements {
+		list.PushTail(elem)
+	}
+
+	return &store.RedisObject{
+		Type:     store.ObjList,
+		Encoding: store.EncodingQuicklist,
+		Ptr:      list,
+	}, nil
+}
+
+func setObject(members []string) (*store.RedisObject, error) {
+	set := store.NewSet()
+	set.Add(members...)
+
+	return &store.RedisObject{
+		Type:     store.ObjSet,
+		Encoding: store.EncodingHT,
+		Ptr:      set,
+	}, nil
+}
+
+func hashObject(pairs []string) (*store.RedisObject, error) {
+	if err := pairsError(pairs); err != nil {
+		return nil, err
+	}
+
+	hash := store.NewHashTable()
+	for i := 0; i < len(pairs); i += 2 {
+		hash.Set(pairs[i], pairs[i+1])
 	}
 
 	return &store.RedisObject{
@@ -673,26 +1195,18 @@ func (r *RDBReader) readHashObject() (*store.RedisObject, error) {
 	}, nil
 }
 
-func (r *RDBReader) readZSetObject() (*store.RedisObject, error) {
-	length, _, err := r.readLength()
-	if err != nil {
+func zsetObject(pairs []string) (*store.RedisObject, error) {
+	if err := pairsError(pairs); err != nil {
 		return nil, err
 	}
 
 	zset := store.NewZSet()
-	for i := uint32(0); i < length; i++ {
-		member, err := r.readString()
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(pairs[i+1], 64)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%w: invalid score %q", errBlobCorrupt, pairs[i+1])
 		}
-
-		buf, err := r.readBytes(8)
-		if err != nil {
-			return nil, err
-		}
-		score := float64frombits(binary.LittleEndian.Uint64(buf))
-
-		zset.Add(score, member)
+		zset.Add(score, pairs[i])
 	}
 
 	return &store.RedisObject{
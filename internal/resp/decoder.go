@@ -0,0 +1,184 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	MaxMultibulkLen = 1024 * 1024
+	MaxBulkLen      = 512 * 1024 * 1024
+)
+
+var (
+	ErrInvalidMultibulkLength = errors.New("invalid multibulk length")
+	ErrInvalidBulkLength      = errors.New("invalid bulk length")
+	ErrMultibulkCountTooBig   = errors.New("too big mbulk count string")
+	ErrBulkCountTooBig        = errors.New("too big bulk count string")
+	ErrMissingBulkCRLF        = errors.New("expected CRLF after bulk string")
+)
+
+type span struct {
+	start, end int
+}
+
+// Decoder incrementally parses client requests (multibulk arrays of bulk
+// strings, or inline commands) straight out of a connection's read buffer.
+// When a frame is incomplete Decode returns zero consumed bytes and remembers
+// how far it got, so the next call with the same frame start and more data
+// appended resumes scanning instead of starting over.
+type Decoder struct {
+	argc     int
+	inBulk   bool
+	bulkLen  int
+	pos      int
+	argBytes int
+	spans    []span
+}
+
+func (d *Decoder) Pending() bool {
+	return d.argc > 0
+}
+
+func (d *Decoder) Reset() {
+	d.argc = 0
+	d.inBulk = false
+	d.bulkLen = 0
+	d.pos = 0
+	d.argBytes = 0
+	d.spans = d.spans[:0]
+}
+
+func (d *Decoder) Decode(buf []byte) (Value, int, error) {
+	if d.argc == 0 {
+		if len(buf) == 0 {
+			return Value{}, 0, nil
+		}
+		if buf[0] != byte(Array) {
+			return ReadInline(buf)
+		}
+
+		line, next, ok := readLineAt(buf, 1)
+		if !ok {
+			if len(buf) > MaxInlineSize {
+				return Value{}, 0, ErrMultibulkCountTooBig
+			}
+			return Value{}, 0, nil
+		}
+
+		count, ok := parseLength(line)
+		if !ok || count > MaxMultibulkLen {
+			return Value{}, 0, ErrInvalidMultibulkLength
+		}
+		if count <= 0 {
+			return Value{Type: Array, Array: []Value{}}, next, nil
+		}
+
+		d.argc = count
+		d.pos = next
+	}
+
+	for len(d.spans) < d.argc {
+		if !d.inBulk {
+			if d.pos >= len(buf) {
+				return Value{}, 0, nil
+			}
+			if buf[d.pos] != byte(BulkString) {
+				err := fmt.Errorf("expected '$', got '%c'", buf[d.pos])
+				d.Reset()
+				return Value{}, 0, err
+			}
+
+			line, next, ok := readLineAt(buf, d.pos+1)
+			if !ok {
+				if len(buf)-d.pos > MaxInlineSize {
+					d.Reset()
+					return Value{}, 0, ErrBulkCountTooBig
+				}
+				return Value{}, 0, nil
+			}
+
+			length, ok := parseLength(line)
+			if !ok || length < 0 || length > MaxBulkLen {
+				d.Reset()
+				return Value{}, 0, ErrInvalidBulkLength
+			}
+
+			d.inBulk = true
+			d.bulkLen = length
+			d.pos = next
+		}
+
+		end := d.pos + d.bulkLen
+		if len(buf) < end+2 {
+			return Value{}, 0, nil
+		}
+		if buf[end] != '\r' || buf[end+1] != '\n' {
+			d.Reset()
+			return Value{}, 0, ErrMissingBulkCRLF
+		}
+
+		d.spans = append(d.spans, span{d.pos, end})
+		d.argBytes += d.bulkLen
+		d.pos = end + 2
+		d.inBulk = false
+	}
+
+	// Copy every argument into one backing string so a command costs a single
+	// allocation no matter how many arguments it has.
+	var sb strings.Builder
+	sb.Grow(d.argBytes)
+	for _, sp := range d.spans {
+		sb.Write(buf[sp.start:sp.end])
+	}
+	data := sb.String()
+
+	args := make([]Value, len(d.spans))
+	offset := 0
+	for i, sp := range d.spans {
+		length := sp.end - sp.start
+		args[i] = Value{Type: BulkString, Str: data[offset : offset+length]}
+		offset += length
+	}
+
+	consumed := d.pos
+	d.Reset()
+	return Value{Type: Array, Array: args}, consumed, nil
+}
+
+func readLineAt(buf []byte, start int) ([]byte, int, bool) {
+	idx := bytes.Index(buf[start:], []byte("\r\n"))
+	if idx == -1 {
+		return nil, 0, false
+	}
+	return buf[start : start+idx], start + idx + 2, true
+}
+
+func parseLength(b []byte) (int, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+
+	negative := b[0] == '-'
+	if negative {
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 18 || (b[0] == '0' && len(b) > 1) {
+		return 0, false
+	}
+
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+
+	if negative {
+		return -n, true
+	}
+	return n, true
+}
@@ -0,0 +1,195 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecoderCompleteFrame(t *testing.T) {
+	var d Decoder
+	input := []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n")
+
+	value, consumed, err := d.Decode(input)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if consumed != len(input) {
+		t.Errorf("Expected %d consumed bytes, got %d", len(input), consumed)
+	}
+	if value.Type != Array || len(value.Array) != 3 || value.Array[2].Str != "value" {
+		t.Errorf("Decode() = %+v", value)
+	}
+	if d.Pending() {
+		t.Error("Decoder should not be pending after a complete frame")
+	}
+}
+
+func TestDecoderPipelined(t *testing.T) {
+	var d Decoder
+	input := []byte("*1\r\n$4\r\nPING\r\n*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\nPING\r\n")
+
+	var names []string
+	offset := 0
+	for offset < len(input) {
+		value, consumed, err := d.Decode(input[offset:])
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if consumed == 0 {
+			break
+		}
+		offset += consumed
+		names = append(names, value.Array[0].Str)
+	}
+
+	if offset != len(input) || strings.Join(names, ",") != "PING,ECHO,PING" {
+		t.Errorf("Decoded %v, consumed %d of %d", names, offset, len(input))
+	}
+}
+
+func TestDecoderResumesPartialFrames(t *testing.T) {
+	input := []byte("*2\r\n$4\r\nECHO\r\n$11\r\nhello world\r\n")
+
+	for split := 1; split < len(input); split++ {
+		var d Decoder
+
+		_, consumed, err := d.Decode(input[:split])
+		if err != nil || consumed != 0 {
+			t.Fatalf("split %d: expected incomplete frame, got %d, %v", split, consumed, err)
+		}
+
+		value, consumed, err := d.Decode(input)
+		if err != nil || consumed != len(input) {
+			t.Fatalf("split %d: expected full frame, got %d, %v", split, consumed, err)
+		}
+		if value.Array[1].Str != "hello world" {
+			t.Errorf("split %d: unexpected value %+v", split, value)
+		}
+	}
+}
+
+func TestDecoderPendingState(t *testing.T) {
+	var d Decoder
+
+	d.Decode([]byte("*2\r\n$3\r\nGET\r\n"))
+	if !d.Pending() {
+		t.Error("Decoder should be pending mid-frame")
+	}
+
+	d.Reset()
+	if d.Pending() {
+		t.Error("Reset should clear pending state")
+	}
+}
+
+func TestDecoderEmptyMultibulk(t *testing.T) {
+	var d Decoder
+
+	for _, input := range []string{"*0\r\n", "*-1\r\n"} {
+		value, consumed, err := d.Decode([]byte(input))
+		if err != nil || consumed != len(input) || len(value.Array) != 0 {
+			t.Errorf("Decode(%q) = %+v, %d, %v", input, value, consumed, err)
+		}
+	}
+}
+
+func TestDecoderInline(t *testing.T) {
+	var d Decoder
+
+	value, consumed, err := d.Decode([]byte("SET k \"v v\"\r\n"))
+	if err != nil || consumed != 13 || len(value.Array) != 3 || value.Array[2].Str != "v v" {
+		t.Errorf("Decode() inline = %+v, %d, %v", value, consumed, err)
+	}
+}
+
+func TestDecoderBinarySafe(t *testing.T) {
+	var d Decoder
+
+	value, _, err := d.Decode([]byte("*1\r\n$4\r\na\r\nb\r\n"))
+	if err != nil || value.Array[0].Str != "a\r\nb" {
+		t.Errorf("Expected binary-safe bulk, got %+v, %v", value, err)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   error
+		msg   string
+	}{
+		{"bad multibulk length", "*x\r\n", ErrInvalidMultibulkLength, ""},
+		{"leading zero multibulk", "*01\r\n", ErrInvalidMultibulkLength, ""},
+		{"plus sign multibulk", "*+1\r\n", ErrInvalidMultibulkLength, ""},
+		{"huge multibulk", "*1048577\r\n", ErrInvalidMultibulkLength, ""},
+		{"bad bulk length", "*1\r\n$x\r\n", ErrInvalidBulkLength, ""},
+		{"negative bulk length", "*1\r\n$-1\r\n", ErrInvalidBulkLength, ""},
+		{"not a bulk", "*1\r\n:1\r\n", nil, "expected '$', got ':'"},
+		{"missing CRLF", "*1\r\n$4\r\nPINGxx", ErrMissingBulkCRLF, ""},
+		{"huge multibulk header", "*" + strings.Repeat("1", MaxInlineSize+1), ErrMultibulkCountTooBig, ""},
+		{"huge bulk header", "*1\r\n$" + strings.Repeat("1", MaxInlineSize+1), ErrBulkCountTooBig, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d Decoder
+			_, _, err := d.Decode([]byte(tt.input))
+			if err == nil {
+				t.Fatal("Expected error")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.err)
+			}
+			if tt.msg != "" && err.Error() != tt.msg {
+				t.Errorf("Decode() error = %q, want %q", err.Error(), tt.msg)
+			}
+			if d.Pending() {
+				t.Error("Decoder should reset after an error")
+			}
+		})
+	}
+}
+
+func pipelinedCommands(n int) []byte {
+	var buf bytes.Buffer
+	s := NewSerializer(&buf)
+	for i := 0; i < n; i++ {
+		s.Serialize(ArrayValue(BulkStringValue("SET"), BulkStringValue("key:000000"), BulkStringValue(strings.Repeat("x", 64))))
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkDecoderPipelined(b *testing.B) {
+	input := pipelinedCommands(1000)
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+
+	var d Decoder
+	for i := 0; i < b.N; i++ {
+		offset := 0
+		for offset < len(input) {
+			_, consumed, err := d.Decode(input[offset:])
+			if err != nil || consumed == 0 {
+				b.Fatalf("Decode() = %d, %v", consumed, err)
+			}
+			offset += consumed
+		}
+	}
+}
+
+func BenchmarkParserPipelined(b *testing.B) {
+	input := pipelinedCommands(1000)
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		p := NewParser(bytes.NewReader(input))
+		for {
+			if _, err := p.Parse(); err != nil {
+				break
+			}
+		}
+	}
+}
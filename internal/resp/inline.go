@@ -0,0 +1,151 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+const MaxInlineSize = 64 * 1024
+
+var (
+	ErrUnbalancedQuotes = errors.New("unbalanced quotes in request")
+	ErrInlineTooBig     = errors.New("too big inline request")
+)
+
+func ReadInline(buf []byte) (Value, int, error) {
+	idx := bytes.IndexByte(buf, '\n')
+	if idx == -1 {
+		if len(buf) > MaxInlineSize {
+			return Value{}, 0, ErrInlineTooBig
+		}
+		return Value{}, 0, nil
+	}
+
+	value, err := ParseInline(string(buf[:idx]))
+	if err != nil {
+		return Value{}, 0, err
+	}
+	return value, idx + 1, nil
+}
+
+func ParseInline(line string) (Value, error) {
+	args, err := SplitArgs(strings.TrimSuffix(line, "\r"))
+	if err != nil {
+		return Value{}, err
+	}
+
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		values[i] = BulkStringValue(arg)
+	}
+	return ArrayValue(values...), nil
+}
+
+func SplitArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var current strings.Builder
+		inDoubleQuotes := false
+		inSingleQuotes := false
+		done := false
+
+		for !done {
+			if i >= len(line) {
+				if inDoubleQuotes || inSingleQuotes {
+					return nil, ErrUnbalancedQuotes
+				}
+				break
+			}
+
+			c := line[i]
+			switch {
+			case inDoubleQuotes:
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					current.WriteByte(hexValue(line[i+2])<<4 | hexValue(line[i+3]))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						current.WriteByte('\n')
+					case 'r':
+						current.WriteByte('\r')
+					case 't':
+						current.WriteByte('\t')
+					case 'b':
+						current.WriteByte('\b')
+					case 'a':
+						current.WriteByte('\a')
+					default:
+						current.WriteByte(line[i])
+					}
+				} else if c == '"' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					current.WriteByte(c)
+				}
+			case inSingleQuotes:
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					current.WriteByte('\'')
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					current.WriteByte(c)
+				}
+			default:
+				switch c {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDoubleQuotes = true
+				case '\'':
+					inSingleQuotes = true
+				default:
+					current.WriteByte(c)
+				}
+			}
+
+			if i < len(line) {
+				i++
+			}
+		}
+
+		args = append(args, current.String())
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
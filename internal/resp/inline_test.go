@@ -0,0 +1,89 @@
+package resp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"simple", "SET key value", []string{"SET", "key", "value"}},
+		{"extra spaces", "  GET   key  ", []string{"GET", "key"}},
+		{"tabs", "GET\tkey", []string{"GET", "key"}},
+		{"empty", "", []string{}},
+		{"double quotes", `SET key "hello world"`, []string{"SET", "key", "hello world"}},
+		{"single quotes", `SET key 'hello world'`, []string{"SET", "key", "hello world"}},
+		{"escapes", `SET key "a\nb\t\"c\""`, []string{"SET", "key", "a\nb\t\"c\""}},
+		{"hex escape", `SET key "\x41\x62"`, []string{"SET", "key", "Ab"}},
+		{"escaped single quote", `SET key 'it\'s'`, []string{"SET", "key", "it's"}},
+		{"empty quoted", `SET key ""`, []string{"SET", "key", ""}},
+		{"quote inside token", `SET ke"y" v`, []string{"SET", "key", "v"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitArgs(tt.input)
+			if err != nil {
+				t.Fatalf("SplitArgs() error = %v", err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.expected, "|") || len(got) != len(tt.expected) {
+				t.Errorf("SplitArgs(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSplitArgsUnbalancedQuotes(t *testing.T) {
+	for _, input := range []string{`SET key "value`, `SET key 'value`, `SET key "a"b`, `SET key 'a'b`} {
+		if _, err := SplitArgs(input); !errors.Is(err, ErrUnbalancedQuotes) {
+			t.Errorf("SplitArgs(%q) error = %v, want ErrUnbalancedQuotes", input, err)
+		}
+	}
+}
+
+func TestReadInline(t *testing.T) {
+	value, consumed, err := ReadInline([]byte("PING\r\nECHO hi\r\n"))
+	if err != nil {
+		t.Fatalf("ReadInline() error = %v", err)
+	}
+	if consumed != 6 || len(value.Array) != 1 || value.Array[0].Str != "PING" {
+		t.Errorf("ReadInline() = %+v, %d", value, consumed)
+	}
+
+	value, consumed, err = ReadInline([]byte("ECHO hi\n"))
+	if err != nil || consumed != 8 || len(value.Array) != 2 || value.Array[1].Str != "hi" {
+		t.Errorf("ReadInline() with bare LF = %+v, %d, %v", value, consumed, err)
+	}
+
+	_, consumed, err = ReadInline([]byte("PING"))
+	if err != nil || consumed != 0 {
+		t.Errorf("Expected incomplete inline command, got %d, %v", consumed, err)
+	}
+
+	_, _, err = ReadInline([]byte(strings.Repeat("a", MaxInlineSize+1)))
+	if !errors.Is(err, ErrInlineTooBig) {
+		t.Errorf("Expected ErrInlineTooBig, got %v", err)
+	}
+}
+
+func TestParseInlineCommand(t *testing.T) {
+	parser := NewParser(strings.NewReader("SET key \"some value\"\r\n*1\r\n$4\r\nPING\r\n"))
+
+	got, err := parser.Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.Type != Array || len(got.Array) != 3 || got.Array[2].Str != "some value" {
+		t.Errorf("Parse() inline = %+v", got)
+	}
+
+	got, err = parser.Parse()
+	if err != nil || got.Type != Array || got.Array[0].Str != "PING" {
+		t.Errorf("Parse() after inline = %+v, %v", got, err)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
+	"strings"
 )
 
 type Type byte
@@ -16,6 +18,17 @@ const (
 	Integer      Type = ':'
 	BulkString   Type = '$'
 	Array        Type = '*'
+
+	Null           Type = '_'
+	Boolean        Type = '#'
+	Double         Type = ','
+	BigNumber      Type = '('
+	BlobError      Type = '!'
+	VerbatimString Type = '='
+	Map            Type = '%'
+	Set            Type = '~'
+	Attribute      Type = '|'
+	Push           Type = '>'
 )
 
 var (
@@ -24,11 +37,15 @@ var (
 )
 
 type Value struct {
-	Type  Type
-	Str   string
-	Int   int64
-	Array []Value
-	Null  bool
+	Type   Type
+	Str    string
+	Int    int64
+	Double float64
+	Bool   bool
+	Format string
+	Array  []Value
+	Attrs  []Value
+	Null   bool
 }
 
 type Parser struct {
@@ -58,11 +75,38 @@ func (p *Parser) Parse() (Value, error) {
 		return p.parseBulkString()
 	case Array:
 		return p.parseArray()
+	case Null:
+		return p.parseNull()
+	case Boolean:
+		return p.parseBoolean()
+	case Double:
+		return p.parseDouble()
+	case BigNumber:
+		return p.parseBigNumber()
+	case BlobError:
+		return p.parseBlobError()
+	case VerbatimString:
+		return p.parseVerbatimString()
+	case Map, Set, Push:
+		return p.parseAggregate(Type(typeByte))
+	case Attribute:
+		return p.parseAttribute()
 	default:
-		return Value{}, fmt.Errorf("%w: %c", ErrInvalidType, typeByte)
+		if err := p.reader.UnreadByte(); err != nil {
+			return Value{}, err
+		}
+		return p.parseInline()
 	}
 }
 
+func (p *Parser) parseInline() (Value, error) {
+	line, err := p.reader.ReadString('\n')
+	if err != nil {
+		return Value{}, err
+	}
+	return ParseInline(strings.TrimSuffix(line, "\n"))
+}
+
 func (p *Parser) parseSimpleString() (Value, error) {
 	line, err := p.readLine()
 	if err != nil {
@@ -166,6 +210,135 @@ func (p *Parser) parseArray() (Value, error) {
 	return Value{Type: Array, Array: array}, nil
 }
 
+func (p *Parser) parseNull() (Value, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+	if line != "" {
+		return Value{}, fmt.Errorf("%w: invalid null", ErrInvalidFormat)
+	}
+	return Value{Type: Null, Null: true}, nil
+}
+
+func (p *Parser) parseBoolean() (Value, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch line {
+	case "t":
+		return Value{Type: Boolean, Bool: true}, nil
+	case "f":
+		return Value{Type: Boolean, Bool: false}, nil
+	default:
+		return Value{}, fmt.Errorf("%w: invalid boolean", ErrInvalidFormat)
+	}
+}
+
+func (p *Parser) parseDouble() (Value, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	num, err := parseDoubleString(line)
+	if err != nil {
+		return Value{}, fmt.Errorf("%w: invalid double", ErrInvalidFormat)
+	}
+	return Value{Type: Double, Double: num}, nil
+}
+
+func parseDoubleString(str string) (float64, error) {
+	switch strings.ToLower(str) {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(str, 64)
+}
+
+func (p *Parser) parseBigNumber() (Value, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	digits := strings.TrimPrefix(strings.TrimPrefix(line, "-"), "+")
+	if digits == "" {
+		return Value{}, fmt.Errorf("%w: invalid big number", ErrInvalidFormat)
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return Value{}, fmt.Errorf("%w: invalid big number", ErrInvalidFormat)
+		}
+	}
+	return Value{Type: BigNumber, Str: line}, nil
+}
+
+func (p *Parser) parseBlobError() (Value, error) {
+	value, err := p.parseBulkString()
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Type: BlobError, Str: value.Str}, nil
+}
+
+func (p *Parser) parseVerbatimString() (Value, error) {
+	value, err := p.parseBulkString()
+	if err != nil {
+		return Value{}, err
+	}
+	if len(value.Str) < 4 || value.Str[3] != ':' {
+		return Value{}, fmt.Errorf("%w: invalid verbatim string", ErrInvalidFormat)
+	}
+	return Value{Type: VerbatimString, Format: value.Str[:3], Str: value.Str[4:]}, nil
+}
+
+func (p *Parser) parseAggregate(t Type) (Value, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	count, err := strconv.Atoi(line)
+	if err != nil || count < 0 {
+		return Value{}, fmt.Errorf("%w: invalid aggregate length", ErrInvalidFormat)
+	}
+	if t == Map {
+		count *= 2
+	}
+
+	elements := make([]Value, count)
+	for i := range count {
+		val, err := p.Parse()
+		if err != nil {
+			return Value{}, err
+		}
+		elements[i] = val
+	}
+
+	return Value{Type: t, Array: elements}, nil
+}
+
+func (p *Parser) parseAttribute() (Value, error) {
+	attrs, err := p.parseAggregate(Map)
+	if err != nil {
+		return Value{}, err
+	}
+
+	value, err := p.Parse()
+	if err != nil {
+		return Value{}, err
+	}
+	value.Attrs = attrs.Array
+	return value, nil
+}
+
 func (p *Parser) readLine() (string, error) {
 	line, err := p.reader.ReadString('\n')
 	if err != nil {
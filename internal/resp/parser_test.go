@@ -342,3 +342,88 @@ func valuesEqual(a, b Value) bool {
 	}
 	return false
 }
+
+func TestParseRESP3Scalars(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected Value
+	}{
+		{"null", "_\r\n", Value{Type: Null, Null: true}},
+		{"true", "#t\r\n", Value{Type: Boolean, Bool: true}},
+		{"false", "#f\r\n", Value{Type: Boolean, Bool: false}},
+		{"double", ",1.5\r\n", Value{Type: Double, Double: 1.5}},
+		{"double exponent", ",1.5e3\r\n", Value{Type: Double, Double: 1500}},
+		{"big number", "(3492890328409238509324850943850943825024385\r\n", Value{Type: BigNumber, Str: "3492890328409238509324850943850943825024385"}},
+		{"blob error", "!21\r\nSYNTAX invalid syntax\r\n", Value{Type: BlobError, Str: "SYNTAX invalid syntax"}},
+		{"verbatim", "=15\r\ntxt:Some string\r\n", Value{Type: VerbatimString, Format: "txt", Str: "Some string"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewParser(strings.NewReader(tt.input)).Parse()
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			if got.Type != tt.expected.Type || got.Str != tt.expected.Str || got.Bool != tt.expected.Bool ||
+				got.Double != tt.expected.Double || got.Format != tt.expected.Format || got.Null != tt.expected.Null {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseRESP3SpecialDoubles(t *testing.T) {
+	for _, input := range []string{",inf\r\n", ",-inf\r\n", ",nan\r\n"} {
+		got, err := NewParser(strings.NewReader(input)).Parse()
+		if err != nil || got.Type != Double {
+			t.Errorf("Parse(%q) = %+v, %v", input, got, err)
+		}
+	}
+
+	if _, err := NewParser(strings.NewReader(",abc\r\n")).Parse(); err == nil {
+		t.Error("Expected error for invalid double")
+	}
+	if _, err := NewParser(strings.NewReader("#x\r\n")).Parse(); err == nil {
+		t.Error("Expected error for invalid boolean")
+	}
+}
+
+func TestParseRESP3Aggregates(t *testing.T) {
+	got, err := NewParser(strings.NewReader("%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n")).Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.Type != Map || len(got.Array) != 4 {
+		t.Fatalf("Expected map with 4 flattened entries, got %+v", got)
+	}
+	if got.Array[2].Str != "second" || got.Array[3].Int != 2 {
+		t.Errorf("Unexpected map contents: %+v", got.Array)
+	}
+
+	got, err = NewParser(strings.NewReader("~3\r\n:1\r\n:2\r\n:3\r\n")).Parse()
+	if err != nil || got.Type != Set || len(got.Array) != 3 {
+		t.Errorf("Expected 3-element set, got %+v, %v", got, err)
+	}
+
+	got, err = NewParser(strings.NewReader(">2\r\n+message\r\n$5\r\nhello\r\n")).Parse()
+	if err != nil || got.Type != Push || len(got.Array) != 2 || got.Array[1].Str != "hello" {
+		t.Errorf("Expected push frame, got %+v, %v", got, err)
+	}
+}
+
+func TestParseRESP3Attribute(t *testing.T) {
+	input := "|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.1923\r\n*1\r\n:2039123\r\n"
+	got, err := NewParser(strings.NewReader(input)).Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if got.Type != Array || len(got.Array) != 1 || got.Array[0].Int != 2039123 {
+		t.Fatalf("Expected attribute to attach to the following array, got %+v", got)
+	}
+	if len(got.Attrs) != 2 || got.Attrs[0].Str != "key-popularity" || got.Attrs[1].Type != Map {
+		t.Errorf("Unexpected attributes: %+v", got.Attrs)
+	}
+}
@@ -3,18 +3,42 @@ package resp
 import (
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 )
 
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
 type Serializer struct {
-	writer io.Writer
+	writer   io.Writer
+	protocol int
 }
 
 func NewSerializer(w io.Writer) *Serializer {
-	return &Serializer{writer: w}
+	return &Serializer{writer: w, protocol: RESP2}
+}
+
+func (s *Serializer) SetProtocol(protocol int) {
+	s.protocol = protocol
 }
 
 func (s *Serializer) Serialize(v Value) error {
+	if s.protocol < RESP3 {
+		v = ToRESP2(v)
+	}
+	return s.serialize(v)
+}
+
+func (s *Serializer) serialize(v Value) error {
+	if len(v.Attrs) > 0 {
+		if err := s.writeAggregate(Attribute, len(v.Attrs)/2, v.Attrs); err != nil {
+			return err
+		}
+	}
+
 	switch v.Type {
 	case SimpleString:
 		return s.writeSimpleString(v.Str)
@@ -26,11 +50,63 @@ func (s *Serializer) Serialize(v Value) error {
 		return s.writeBulkString(v.Str, v.Null)
 	case Array:
 		return s.writeArray(v.Array, v.Null)
+	case Null:
+		return s.writeNull()
+	case Boolean:
+		return s.writeBoolean(v.Bool)
+	case Double:
+		_, err := fmt.Fprintf(s.writer, ",%s\r\n", FormatDouble(v.Double))
+		return err
+	case BigNumber:
+		_, err := fmt.Fprintf(s.writer, "(%s\r\n", v.Str)
+		return err
+	case BlobError:
+		_, err := fmt.Fprintf(s.writer, "!%d\r\n%s\r\n", len(v.Str), v.Str)
+		return err
+	case VerbatimString:
+		format := v.Format
+		if format == "" {
+			format = "txt"
+		}
+		_, err := fmt.Fprintf(s.writer, "=%d\r\n%s:%s\r\n", len(v.Str)+4, format, v.Str)
+		return err
+	case Map:
+		return s.writeAggregate(Map, len(v.Array)/2, v.Array)
+	case Set, Push:
+		return s.writeAggregate(v.Type, len(v.Array), v.Array)
 	default:
 		return fmt.Errorf("%w: %c", ErrInvalidType, v.Type)
 	}
 }
 
+func (s *Serializer) writeNull() error {
+	_, err := s.writer.Write([]byte("_\r\n"))
+	return err
+}
+
+func (s *Serializer) writeBoolean(b bool) error {
+	if b {
+		_, err := s.writer.Write([]byte("#t\r\n"))
+		return err
+	}
+	_, err := s.writer.Write([]byte("#f\r\n"))
+	return err
+}
+
+func (s *Serializer) writeAggregate(t Type, count int, elements []Value) error {
+	if _, err := fmt.Fprintf(s.writer, "%c%d\r\n", t, count); err != nil {
+		return err
+	}
+
+	for _, elem := range elements {
+		if err := s.serialize(elem); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (s *Serializer) writeSimpleString(str string) error {
 	_, err := fmt.Fprintf(s.writer, "+%s\r\n", str)
 	return err
@@ -47,6 +123,9 @@ func (s *Serializer) writeInteger(num int64) error {
 }
 
 func (s *Serializer) writeBulkString(str string, null bool) error {
+	if null && s.protocol >= RESP3 {
+		return s.writeNull()
+	}
 	if null {
 		_, err := s.writer.Write([]byte("$-1\r\n"))
 		return err
@@ -58,6 +137,9 @@ func (s *Serializer) writeBulkString(str string, null bool) error {
 }
 
 func (s *Serializer) writeArray(array []Value, null bool) error {
+	if null && s.protocol >= RESP3 {
+		return s.writeNull()
+	}
 	if null {
 		_, err := s.writer.Write([]byte("*-1\r\n"))
 		return err
@@ -69,7 +151,7 @@ func (s *Serializer) writeArray(array []Value, null bool) error {
 	}
 
 	for _, elem := range array {
-		if err := s.Serialize(elem); err != nil {
+		if err := s.serialize(elem); err != nil {
 			return err
 		}
 	}
@@ -105,6 +187,81 @@ func NullArrayValue() Value {
 	return Value{Type: Array, Null: true}
 }
 
+func NullValue() Value {
+	return Value{Type: Null, Null: true}
+}
+
+func BooleanValue(b bool) Value {
+	return Value{Type: Boolean, Bool: b}
+}
+
+func DoubleValue(num float64) Value {
+	return Value{Type: Double, Double: num}
+}
+
+func BigNumberValue(str string) Value {
+	return Value{Type: BigNumber, Str: str}
+}
+
+func VerbatimStringValue(format, str string) Value {
+	return Value{Type: VerbatimString, Format: format, Str: str}
+}
+
+func MapValue(pairs ...Value) Value {
+	return Value{Type: Map, Array: pairs}
+}
+
+func SetValue(values ...Value) Value {
+	return Value{Type: Set, Array: values}
+}
+
+func PushValue(values ...Value) Value {
+	return Value{Type: Push, Array: values}
+}
+
+func FormatDouble(num float64) string {
+	switch {
+	case math.IsInf(num, 1):
+		return "inf"
+	case math.IsInf(num, -1):
+		return "-inf"
+	case math.IsNaN(num):
+		return "nan"
+	default:
+		return strconv.FormatFloat(num, 'f', -1, 64)
+	}
+}
+
+func ToRESP2(v Value) Value {
+	switch v.Type {
+	case Null:
+		return NullBulkStringValue()
+	case Boolean:
+		if v.Bool {
+			return IntegerValue(1)
+		}
+		return IntegerValue(0)
+	case Double:
+		return BulkStringValue(FormatDouble(v.Double))
+	case BigNumber, VerbatimString:
+		return BulkStringValue(v.Str)
+	case BlobError:
+		return ErrorValue(v.Str)
+	case Array, Map, Set, Push:
+		if v.Null {
+			return NullArrayValue()
+		}
+		elements := make([]Value, len(v.Array))
+		for i, elem := range v.Array {
+			elements[i] = ToRESP2(elem)
+		}
+		return Value{Type: Array, Array: elements}
+	default:
+		v.Attrs = nil
+		return v
+	}
+}
+
 func OKValue() Value {
 	return SimpleStringValue("OK")
 }
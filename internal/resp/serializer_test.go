@@ -2,6 +2,7 @@ package resp
 
 import (
 	"bytes"
+	"math"
 	"testing"
 )
 
@@ -310,3 +311,92 @@ func TestHelperFunctions(t *testing.T) {
 		t.Errorf("PongValue() = %+v, want SimpleString 'PONG'", pong)
 	}
 }
+
+func TestSerializeRESP3(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    Value
+		expected string
+	}{
+		{"null", NullValue(), "_\r\n"},
+		{"null bulk string", NullBulkStringValue(), "_\r\n"},
+		{"null array", NullArrayValue(), "_\r\n"},
+		{"true", BooleanValue(true), "#t\r\n"},
+		{"false", BooleanValue(false), "#f\r\n"},
+		{"double", DoubleValue(1.5), ",1.5\r\n"},
+		{"infinity", DoubleValue(math.Inf(1)), ",inf\r\n"},
+		{"big number", BigNumberValue("12345678901234567890"), "(12345678901234567890\r\n"},
+		{"verbatim", VerbatimStringValue("txt", "hi"), "=6\r\ntxt:hi\r\n"},
+		{"map", MapValue(BulkStringValue("a"), IntegerValue(1)), "%1\r\n$1\r\na\r\n:1\r\n"},
+		{"set", SetValue(IntegerValue(1), IntegerValue(2)), "~2\r\n:1\r\n:2\r\n"},
+		{"push", PushValue(BulkStringValue("message")), ">1\r\n$7\r\nmessage\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			s := NewSerializer(buf)
+			s.SetProtocol(RESP3)
+
+			if err := s.Serialize(tt.value); err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("Serialize() = %q, want %q", buf.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestSerializeRESP3DowngradedForRESP2(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    Value
+		expected string
+	}{
+		{"null", NullValue(), "$-1\r\n"},
+		{"boolean", BooleanValue(true), ":1\r\n"},
+		{"double", DoubleValue(2.5), "$3\r\n2.5\r\n"},
+		{"big number", BigNumberValue("123"), "$3\r\n123\r\n"},
+		{"verbatim", VerbatimStringValue("txt", "hi"), "$2\r\nhi\r\n"},
+		{"map", MapValue(BulkStringValue("a"), DoubleValue(1)), "*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{"set", SetValue(IntegerValue(1)), "*1\r\n:1\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := NewSerializer(buf).Serialize(tt.value); err != nil {
+				t.Fatalf("Serialize() error = %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("Serialize() = %q, want %q", buf.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestRESP3RoundTrip(t *testing.T) {
+	value := MapValue(
+		BulkStringValue("score"), DoubleValue(3.25),
+		BulkStringValue("members"), SetValue(BulkStringValue("x"), BooleanValue(true)),
+	)
+
+	buf := new(bytes.Buffer)
+	s := NewSerializer(buf)
+	s.SetProtocol(RESP3)
+	if err := s.Serialize(value); err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+
+	got, err := NewParser(buf).Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.Type != Map || len(got.Array) != 4 || got.Array[1].Double != 3.25 {
+		t.Fatalf("Round trip mismatch: %+v", got)
+	}
+	if got.Array[3].Type != Set || !got.Array[3].Array[1].Bool {
+		t.Errorf("Round trip nested set mismatch: %+v", got.Array[3])
+	}
+}
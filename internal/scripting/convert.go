@@ -0,0 +1,88 @@
+package scripting
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+func respToLua(L *lua.LState, v resp.Value) lua.LValue {
+	switch v.Type {
+	case resp.Integer:
+		return lua.LNumber(v.Int)
+	case resp.SimpleString:
+		tbl := L.NewTable()
+		tbl.RawSetString("ok", lua.LString(v.Str))
+		return tbl
+	case resp.Error:
+		tbl := L.NewTable()
+		tbl.RawSetString("err", lua.LString(v.Str))
+		return tbl
+	case resp.BulkString:
+		if v.Null {
+			return lua.LFalse
+		}
+		return lua.LString(v.Str)
+	case resp.Array:
+		if v.Null {
+			return lua.LFalse
+		}
+		tbl := L.CreateTable(len(v.Array), 0)
+		for i, elem := range v.Array {
+			tbl.RawSetInt(i+1, respToLua(L, elem))
+		}
+		return tbl
+	default:
+		return lua.LNil
+	}
+}
+
+func luaToResp(lv lua.LValue) resp.Value {
+	switch v := lv.(type) {
+	case lua.LNumber:
+		return resp.IntegerValue(int64(math.Trunc(float64(v))))
+	case lua.LString:
+		return resp.BulkStringValue(string(v))
+	case lua.LBool:
+		if v {
+			return resp.IntegerValue(1)
+		}
+		return resp.NullBulkStringValue()
+	case *lua.LTable:
+		if errVal, ok := v.RawGetString("err").(lua.LString); ok {
+			return resp.ErrorValue(string(errVal))
+		}
+		if okVal, ok := v.RawGetString("ok").(lua.LString); ok {
+			return resp.SimpleStringValue(string(okVal))
+		}
+
+		values := make([]resp.Value, 0, v.Len())
+		for i := 1; ; i++ {
+			elem := v.RawGetInt(i)
+			if elem == lua.LNil {
+				break
+			}
+			values = append(values, luaToResp(elem))
+		}
+		return resp.ArrayValue(values...)
+	default:
+		return resp.NullBulkStringValue()
+	}
+}
+
+func luaArgToString(lv lua.LValue) (string, bool) {
+	switch v := lv.(type) {
+	case lua.LString:
+		return string(v), true
+	case lua.LNumber:
+		f := float64(v)
+		if f == math.Trunc(f) && math.Abs(f) < 1e17 {
+			return strconv.FormatInt(int64(f), 10), true
+		}
+		return strconv.FormatFloat(f, 'g', 17, 64), true
+	default:
+		return "", false
+	}
+}
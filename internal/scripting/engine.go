@@ -0,0 +1,376 @@
+package scripting
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+var (
+	ErrNoScript   = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	ErrNotBusy    = errors.New("NOTBUSY No scripts in execution right now.")
+	ErrUnkillable = errors.New("UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+)
+
+const (
+	killedByUser     = "ERR Script killed by user with SCRIPT KILL..."
+	killedByFunction = "ERR Script killed by user with FUNCTION KILL..."
+)
+
+type CallFunc func(args []resp.Value) resp.Value
+
+type WriteCheckFunc func(name string) bool
+
+type OOMCheckFunc func() bool
+
+type Engine struct {
+	L        *lua.LState
+	call     CallFunc
+	isWrite  WriteCheckFunc
+	isOOM    OOMCheckFunc
+	scripts  map[string]*lua.LFunction
+	readOnly bool
+
+	fnL       *lua.LState
+	libMu     sync.RWMutex
+	libraries map[string]*Library
+	functions map[string]*Function
+	loading   *Library
+
+	// unlock releases the caller's locks while Lua code runs, so that
+	// SCRIPT KILL can be served, and returns the function retaking them.
+	unlock func() func()
+	relock func()
+
+	mu         sync.Mutex
+	running    bool
+	function   bool
+	wrote      bool
+	cancel     context.CancelFunc
+	killReason string
+}
+
+func NewEngine(call CallFunc, isWrite WriteCheckFunc) *Engine {
+	e := &Engine{
+		call:      call,
+		isWrite:   isWrite,
+		scripts:   make(map[string]*lua.LFunction),
+		libraries: make(map[string]*Library),
+		functions: make(map[string]*Function),
+		unlock:    func() func() { return func() {} },
+	}
+	e.L = e.newState(false)
+	e.fnL = e.newState(true)
+	return e
+}
+
+func (e *Engine) SetOOMCheck(isOOM OOMCheckFunc) {
+	e.isOOM = isOOM
+}
+
+func (e *Engine) SetUnlock(unlock func() func()) {
+	e.unlock = unlock
+}
+
+func (e *Engine) newState(withFunctionAPI bool) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	if withFunctionAPI {
+		// Libraries get their own globals and redis table from libraryEnv,
+		// and must not reach the shared globals behind them.
+		for _, name := range []string{"getfenv", "setfenv", "load", "loadstring"} {
+			L.SetGlobal(name, lua.LNil)
+		}
+	} else {
+		L.SetGlobal("redis", e.redisLib(L))
+	}
+	return L
+}
+
+func (e *Engine) functionLib(L *lua.LState) *lua.LTable {
+	lib := e.redisLib(L)
+	lib.RawSetString("register_function", L.NewFunction(e.registerFunction))
+	return lib
+}
+
+func (e *Engine) redisLib(L *lua.LState) *lua.LTable {
+	lib := L.NewTable()
+	L.SetFuncs(lib, map[string]lua.LGFunction{
+		"call":         e.redisCall(true),
+		"pcall":        e.redisCall(false),
+		"error_reply":  redisErrorReply,
+		"status_reply": redisStatusReply,
+		"sha1hex":      redisSha1Hex,
+		"log":          redisLog,
+	})
+	lib.RawSetString("LOG_DEBUG", lua.LNumber(0))
+	lib.RawSetString("LOG_VERBOSE", lua.LNumber(1))
+	lib.RawSetString("LOG_NOTICE", lua.LNumber(2))
+	lib.RawSetString("LOG_WARNING", lua.LNumber(3))
+	return lib
+}
+
+func (e *Engine) redisCall(raise bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		n := L.GetTop()
+		if n == 0 {
+			L.RaiseError("Please specify at least one argument for this redis lib call")
+		}
+
+		if e.loading != nil {
+			L.RaiseError("redis.call can only be called inside a script invocation")
+		}
+
+		args := make([]resp.Value, n)
+		for i := 1; i <= n; i++ {
+			str, ok := luaArgToString(L.Get(i))
+			if !ok {
+				L.RaiseError("Lua redis lib command arguments must be strings or integers")
+			}
+			args[i-1] = resp.BulkStringValue(str)
+		}
+
+		if e.isWrite != nil && e.isWrite(args[0].Str) {
+			if e.readOnly {
+				L.Error(respToLua(L, resp.ErrorValue("ERR Write commands are not allowed from read-only scripts.")), 1)
+			}
+			e.mu.Lock()
+			e.wrote = true
+			e.mu.Unlock()
+		}
+
+		e.relock()
+		result := e.call(args)
+		e.relock = e.unlock()
+		if raise && result.Type == resp.Error {
+			L.Error(respToLua(L, result), 1)
+		}
+
+		L.Push(respToLua(L, resp.ToRESP2(result)))
+		return 1
+	}
+}
+
+func redisErrorReply(L *lua.LState) int {
+	tbl := L.NewTable()
+	tbl.RawSetString("err", lua.LString(L.CheckString(1)))
+	L.Push(tbl)
+	return 1
+}
+
+func redisStatusReply(L *lua.LState) int {
+	tbl := L.NewTable()
+	tbl.RawSetString("ok", lua.LString(L.CheckString(1)))
+	L.Push(tbl)
+	return 1
+}
+
+func redisSha1Hex(L *lua.LState) int {
+	L.Push(lua.LString(SHA1Hex(L.CheckString(1))))
+	return 1
+}
+
+func redisLog(L *lua.LState) int {
+	if L.GetTop() < 2 {
+		L.RaiseError("redis.log() requires two arguments or more.")
+	}
+
+	parts := make([]string, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		parts = append(parts, L.Get(i).String())
+	}
+	log.Printf("Script log: %s", strings.Join(parts, " "))
+	return 0
+}
+
+func SHA1Hex(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+func (e *Engine) Load(script string) (string, error) {
+	sha := SHA1Hex(script)
+	if _, exists := e.scripts[sha]; exists {
+		return sha, nil
+	}
+
+	fn, err := e.L.Load(strings.NewReader(script), "user_script")
+	if err != nil {
+		return "", fmt.Errorf("ERR Error compiling script (new function): %s", luaErrorMessage(err))
+	}
+
+	e.scripts[sha] = fn
+	return sha, nil
+}
+
+func (e *Engine) Exists(sha string) bool {
+	_, exists := e.scripts[strings.ToLower(sha)]
+	return exists
+}
+
+func (e *Engine) Flush() {
+	e.scripts = make(map[string]*lua.LFunction)
+	e.L.Close()
+	e.L = e.newState(false)
+}
+
+func (e *Engine) Eval(script string, keys, args []string) resp.Value {
+	sha, err := e.Load(script)
+	if err != nil {
+		return resp.ErrorValue(err.Error())
+	}
+	return e.runScript(e.scripts[sha], keys, args)
+}
+
+func (e *Engine) EvalSha(sha string, keys, args []string) resp.Value {
+	fn, exists := e.scripts[strings.ToLower(sha)]
+	if !exists {
+		return resp.ErrorValue(ErrNoScript.Error())
+	}
+	return e.runScript(fn, keys, args)
+}
+
+func (e *Engine) IsBusy() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.running
+}
+
+// Kill stops the running EVAL script.
+func (e *Engine) Kill() error {
+	return e.kill(false)
+}
+
+// KillFunction stops the running FCALL function.
+func (e *Engine) KillFunction() error {
+	return e.kill(true)
+}
+
+func (e *Engine) kill(function bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.running || e.function != function {
+		return ErrNotBusy
+	}
+	if e.wrote {
+		return ErrUnkillable
+	}
+
+	e.killReason = killedByUser
+	if function {
+		e.killReason = killedByFunction
+	}
+	e.cancel()
+	return nil
+}
+
+func (e *Engine) runScript(fn *lua.LFunction, keys, args []string) resp.Value {
+	e.L.SetGlobal("KEYS", stringsToTable(e.L, keys))
+	e.L.SetGlobal("ARGV", stringsToTable(e.L, args))
+	return e.run(e.L, fn, false)
+}
+
+func (e *Engine) run(L *lua.LState, fn *lua.LFunction, readOnly bool, params ...lua.LValue) resp.Value {
+	e.readOnly = readOnly
+	defer func() { e.readOnly = false }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.mu.Lock()
+	e.running = true
+	e.function = L == e.fnL
+	e.wrote = false
+	e.killReason = ""
+	e.cancel = cancel
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		e.running = false
+		e.cancel = nil
+		e.mu.Unlock()
+		cancel()
+	}()
+
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	e.relock = e.unlock()
+	defer func() { e.relock() }()
+
+	L.Push(fn)
+	for _, param := range params {
+		L.Push(param)
+	}
+	if err := L.PCall(len(params), 1, nil); err != nil {
+		return e.errorReply(err)
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+	return luaToResp(ret)
+}
+
+func (e *Engine) errorReply(err error) resp.Value {
+	e.mu.Lock()
+	reason := e.killReason
+	e.mu.Unlock()
+	if reason != "" {
+		return resp.ErrorValue(reason)
+	}
+
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) {
+		if tbl, ok := apiErr.Object.(*lua.LTable); ok {
+			if msg, ok := tbl.RawGetString("err").(lua.LString); ok {
+				return resp.ErrorValue(string(msg))
+			}
+		}
+	}
+
+	return resp.ErrorValue("ERR " + luaErrorMessage(err))
+}
+
+func luaErrorMessage(err error) string {
+	msg := err.Error()
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) && apiErr.Object != nil {
+		msg = apiErr.Object.String()
+	}
+	return strings.ReplaceAll(strings.TrimSpace(msg), "\n", " ")
+}
+
+func stringsToTable(L *lua.LState, values []string) *lua.LTable {
+	tbl := L.CreateTable(len(values), 0)
+	for i, v := range values {
+		tbl.RawSetInt(i+1, lua.LString(v))
+	}
+	return tbl
+}
@@ -0,0 +1,301 @@
+package scripting
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+type fakeRedis struct {
+	data  map[string]string
+	calls [][]string
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{data: make(map[string]string)}
+}
+
+func (f *fakeRedis) call(args []resp.Value) resp.Value {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.Str
+	}
+	f.calls = append(f.calls, strs)
+
+	switch strings.ToUpper(strs[0]) {
+	case "SET":
+		f.data[strs[1]] = strs[2]
+		return resp.OKValue()
+	case "GET":
+		value, exists := f.data[strs[1]]
+		if !exists {
+			return resp.NullBulkStringValue()
+		}
+		return resp.BulkStringValue(value)
+	case "LPUSH":
+		return resp.ErrorValue("WRONGTYPE Operation against a key holding the wrong kind of value")
+	default:
+		return resp.ErrorValue("ERR unknown command")
+	}
+}
+
+func (f *fakeRedis) isWrite(name string) bool {
+	return strings.ToUpper(name) == "SET"
+}
+
+func TestEvalReturnConversion(t *testing.T) {
+	f := newFakeRedis()
+	e := NewEngine(f.call, f.isWrite)
+
+	tests := []struct {
+		script   string
+		expected resp.Value
+	}{
+		{"return 42", resp.IntegerValue(42)},
+		{"return 3.99", resp.IntegerValue(3)},
+		{"return 'hello'", resp.BulkStringValue("hello")},
+		{"return true", resp.IntegerValue(1)},
+		{"return false", resp.NullBulkStringValue()},
+		{"return nil", resp.NullBulkStringValue()},
+		{"return {ok='FINE'}", resp.SimpleStringValue("FINE")},
+		{"return redis.error_reply('MYERR bad')", resp.ErrorValue("MYERR bad")},
+		{"return redis.status_reply('DONE')", resp.SimpleStringValue("DONE")},
+	}
+
+	for _, tt := range tests {
+		result := e.Eval(tt.script, nil, nil)
+		if result.Type != tt.expected.Type || result.Str != tt.expected.Str ||
+			result.Int != tt.expected.Int || result.Null != tt.expected.Null {
+			t.Errorf("Eval(%q) = %+v, want %+v", tt.script, result, tt.expected)
+		}
+	}
+}
+
+func TestEvalArrayStopsAtNil(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	result := e.Eval("return {1, 'two', nil, 4}", nil, nil)
+	if result.Type != resp.Array || len(result.Array) != 2 {
+		t.Fatalf("Expected 2-element array, got %+v", result)
+	}
+	if result.Array[0].Int != 1 || result.Array[1].Str != "two" {
+		t.Errorf("Unexpected array contents: %+v", result.Array)
+	}
+}
+
+func TestEvalKeysAndArgv(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	result := e.Eval("return {KEYS[1], KEYS[2], ARGV[1]}", []string{"k1", "k2"}, []string{"a1"})
+	if result.Type != resp.Array || len(result.Array) != 3 {
+		t.Fatalf("Expected 3-element array, got %+v", result)
+	}
+	if result.Array[0].Str != "k1" || result.Array[1].Str != "k2" || result.Array[2].Str != "a1" {
+		t.Errorf("Unexpected KEYS/ARGV: %+v", result.Array)
+	}
+}
+
+func TestRedisCallDispatch(t *testing.T) {
+	f := newFakeRedis()
+	e := NewEngine(f.call, f.isWrite)
+
+	result := e.Eval("redis.call('SET', KEYS[1], ARGV[1]); return redis.call('GET', KEYS[1])",
+		[]string{"counter"}, []string{"10"})
+	if result.Type != resp.BulkString || result.Str != "10" {
+		t.Errorf("Expected bulk '10', got %+v", result)
+	}
+
+	if len(f.calls) != 2 || f.calls[0][0] != "SET" || f.calls[1][0] != "GET" {
+		t.Errorf("Unexpected dispatched calls: %v", f.calls)
+	}
+
+	result = e.Eval("return redis.call('GET', 'missing')", nil, nil)
+	if result.Type != resp.BulkString || !result.Null {
+		t.Errorf("Expected null for missing key, got %+v", result)
+	}
+}
+
+func TestRedisCallNumberArguments(t *testing.T) {
+	f := newFakeRedis()
+	e := NewEngine(f.call, nil)
+
+	e.Eval("redis.call('SET', 'n', 7)", nil, nil)
+	if f.data["n"] != "7" {
+		t.Errorf("Expected integer argument to be sent as '7', got %q", f.data["n"])
+	}
+
+	result := e.Eval("redis.call('SET', 'n', true)", nil, nil)
+	if result.Type != resp.Error {
+		t.Errorf("Expected error for boolean argument, got %+v", result)
+	}
+}
+
+func TestRedisCallVsPcall(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	result := e.Eval("redis.call('LPUSH', 'k', 'v'); return 'unreachable'", nil, nil)
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "WRONGTYPE") {
+		t.Errorf("Expected WRONGTYPE error from redis.call, got %+v", result)
+	}
+
+	result = e.Eval("local r = redis.pcall('LPUSH', 'k', 'v'); return r['err']", nil, nil)
+	if result.Type != resp.BulkString || !strings.HasPrefix(result.Str, "WRONGTYPE") {
+		t.Errorf("Expected pcall to return error table, got %+v", result)
+	}
+}
+
+func TestEvalRuntimeAndCompileErrors(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	result := e.Eval("error('boom')", nil, nil)
+	if result.Type != resp.Error || !strings.Contains(result.Str, "boom") {
+		t.Errorf("Expected runtime error, got %+v", result)
+	}
+
+	result = e.Eval("return (", nil, nil)
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "ERR Error compiling script") {
+		t.Errorf("Expected compile error, got %+v", result)
+	}
+}
+
+func TestScriptCache(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	script := "return ARGV[1]"
+	sha, err := e.Load(script)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if sha != SHA1Hex(script) {
+		t.Errorf("Expected sha %s, got %s", SHA1Hex(script), sha)
+	}
+
+	if !e.Exists(strings.ToUpper(sha)) {
+		t.Error("Loaded script should exist (case-insensitive)")
+	}
+
+	result := e.EvalSha(sha, nil, []string{"x"})
+	if result.Type != resp.BulkString || result.Str != "x" {
+		t.Errorf("Expected 'x', got %+v", result)
+	}
+
+	e.Flush()
+	if e.Exists(sha) {
+		t.Error("Script should not exist after flush")
+	}
+
+	result = e.EvalSha(sha, nil, nil)
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "NOSCRIPT") {
+		t.Errorf("Expected NOSCRIPT error, got %+v", result)
+	}
+}
+
+func TestEvalCachesScript(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	e.Eval("return 1", nil, nil)
+	if !e.Exists(SHA1Hex("return 1")) {
+		t.Error("EVAL should add the script to the cache")
+	}
+}
+
+func TestKillNotBusy(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	if err := e.Kill(); err != ErrNotBusy {
+		t.Errorf("Expected ErrNotBusy, got %v", err)
+	}
+}
+
+func TestKillRunningScript(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	go func() {
+		for !e.IsBusy() {
+			time.Sleep(time.Millisecond)
+		}
+		if err := e.Kill(); err != nil {
+			t.Errorf("Kill failed: %v", err)
+		}
+	}()
+
+	result := e.Eval("while true do end", nil, nil)
+	if result.Type != resp.Error || result.Str != killedByUser {
+		t.Errorf("Expected killed error, got %+v", result)
+	}
+
+	if e.IsBusy() {
+		t.Error("Engine should not be busy after kill")
+	}
+}
+
+func TestKillChecksScriptKind(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	go func() {
+		for !e.IsBusy() {
+			time.Sleep(time.Millisecond)
+		}
+		if err := e.KillFunction(); err != ErrNotBusy {
+			t.Errorf("Expected FUNCTION KILL to ignore an EVAL script, got %v", err)
+		}
+		e.Kill()
+	}()
+
+	e.Eval("while true do end", nil, nil)
+}
+
+func TestUnlockAroundLua(t *testing.T) {
+	locked := true
+	e := NewEngine(func(args []resp.Value) resp.Value {
+		if !locked {
+			t.Error("Expected redis.call to run with the lock held")
+		}
+		return resp.OKValue()
+	}, nil)
+	e.SetUnlock(func() func() {
+		locked = false
+		return func() { locked = true }
+	})
+
+	e.Eval("return redis.call('PING')", nil, nil)
+	if !locked {
+		t.Error("Expected the lock to be retaken after the script")
+	}
+}
+
+func TestWritingScriptIsUnkillable(t *testing.T) {
+	f := newFakeRedis()
+	e := NewEngine(f.call, f.isWrite)
+
+	errs := make(chan error, 1)
+	go func() {
+		for !e.IsBusy() {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+		errs <- e.Kill()
+	}()
+
+	script := `
+redis.call('SET', 'k', 'v')
+local deadline = os_time_ms() + 100
+while os_time_ms() < deadline do end
+return 'done'`
+	e.L.SetGlobal("os_time_ms", e.L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LNumber(time.Now().UnixMilli()))
+		return 1
+	}))
+
+	result := e.Eval(script, nil, nil)
+	if result.Type != resp.BulkString || result.Str != "done" {
+		t.Errorf("Expected script to finish, got %+v", result)
+	}
+
+	if err := <-errs; err != ErrUnkillable {
+		t.Errorf("Expected ErrUnkillable, got %v", err)
+	}
+}
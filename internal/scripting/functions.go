@@ -0,0 +1,320 @@
+package scripting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const libraryLoadTimeLimit = 500 * time.Millisecond
+
+var validFunctionFlags = map[string]bool{
+	"no-writes":             true,
+	"allow-oom":             true,
+	"allow-stale":           true,
+	"no-cluster":            true,
+	"allow-cross-slot-keys": true,
+}
+
+type Library struct {
+	Name      string
+	Code      string
+	Functions map[string]*Function
+}
+
+type Function struct {
+	Name        string
+	Description string
+	Flags       []string
+	library     *Library
+	fn          *lua.LFunction
+}
+
+func (f *Function) HasFlag(flag string) bool {
+	for _, candidate := range f.Flags {
+		if candidate == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func parseLibraryMetadata(code string) (string, error) {
+	firstLine, _, _ := strings.Cut(code, "\n")
+	if !strings.HasPrefix(firstLine, "#!") {
+		return "", errors.New("ERR Missing library metadata")
+	}
+
+	parts := strings.Fields(firstLine[2:])
+	if len(parts) == 0 {
+		return "", errors.New("ERR Missing library metadata")
+	}
+	if parts[0] != "lua" {
+		return "", fmt.Errorf("ERR Engine '%s' not found", parts[0])
+	}
+
+	name := ""
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || key != "name" {
+			return "", fmt.Errorf("ERR Invalid metadata value given: %s", part)
+		}
+		name = value
+	}
+
+	if name == "" {
+		return "", errors.New("ERR Library name was not given")
+	}
+	if !isValidFunctionName(name) {
+		return "", errors.New("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+
+	return name, nil
+}
+
+func (e *Engine) registerFunction(L *lua.LState) int {
+	if e.loading == nil {
+		L.RaiseError("redis.register_function can only be called on FUNCTION LOAD command")
+	}
+
+	f := &Function{library: e.loading}
+
+	if tbl, ok := L.Get(1).(*lua.LTable); ok && L.GetTop() == 1 {
+		name, ok := tbl.RawGetString("function_name").(lua.LString)
+		if !ok {
+			L.RaiseError("function_name argument given to redis.register_function must be a string")
+		}
+		callback, ok := tbl.RawGetString("callback").(*lua.LFunction)
+		if !ok {
+			L.RaiseError("callback argument given to redis.register_function must be a function")
+		}
+		f.Name = string(name)
+		f.fn = callback
+
+		if desc, ok := tbl.RawGetString("description").(lua.LString); ok {
+			f.Description = string(desc)
+		}
+
+		if flags, ok := tbl.RawGetString("flags").(*lua.LTable); ok {
+			for i := 1; i <= flags.Len(); i++ {
+				flag := flags.RawGetInt(i).String()
+				if !validFunctionFlags[flag] {
+					L.RaiseError("unknown flag given")
+				}
+				f.Flags = append(f.Flags, flag)
+			}
+		}
+	} else {
+		f.Name = L.CheckString(1)
+		f.fn = L.CheckFunction(2)
+	}
+
+	if !isValidFunctionName(f.Name) {
+		L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	if _, exists := e.loading.Functions[f.Name]; exists {
+		L.RaiseError("Function already exists in the library")
+	}
+
+	e.loading.Functions[f.Name] = f
+	return 0
+}
+
+func (e *Engine) FunctionLoad(code string, replace bool) (string, error) {
+	name, err := parseLibraryMetadata(code)
+	if err != nil {
+		return "", err
+	}
+
+	e.libMu.Lock()
+	defer e.libMu.Unlock()
+
+	existing := e.libraries[name]
+	if existing != nil && !replace {
+		return "", fmt.Errorf("ERR Library '%s' already exists", name)
+	}
+
+	L := e.fnL
+	fn, err := L.Load(strings.NewReader("--"+code), "user_function")
+	if err != nil {
+		return "", fmt.Errorf("ERR Error compiling function: %s", luaErrorMessage(err))
+	}
+
+	lib := &Library{Name: name, Code: code, Functions: make(map[string]*Function)}
+	fn.Env = e.libraryEnv(L)
+
+	ctx, cancel := context.WithTimeout(context.Background(), libraryLoadTimeLimit)
+	defer cancel()
+	L.SetContext(ctx)
+	e.loading = lib
+	L.Push(fn)
+	err = L.PCall(0, 0, nil)
+	e.loading = nil
+	L.RemoveContext()
+
+	if err != nil {
+		return "", fmt.Errorf("ERR Error registering functions: %s", luaErrorMessage(err))
+	}
+	if len(lib.Functions) == 0 {
+		return "", errors.New("ERR No functions registered")
+	}
+
+	for fname := range lib.Functions {
+		if other, exists := e.functions[fname]; exists && other.library != existing {
+			return "", fmt.Errorf("ERR Function %s already exists", fname)
+		}
+	}
+
+	if existing != nil {
+		e.removeLibrary(existing)
+	}
+	e.libraries[name] = lib
+	for fname, f := range lib.Functions {
+		e.functions[fname] = f
+	}
+
+	return name, nil
+}
+
+// libraryEnv returns the globals of a new library. They fall back to the
+// shared ones, so what a library sets stays its own and goes away with it.
+func (e *Engine) libraryEnv(L *lua.LState) *lua.LTable {
+	env := L.NewTable()
+	env.RawSetString("_G", env)
+	env.RawSetString("redis", e.functionLib(L))
+	meta := L.NewTable()
+	meta.RawSetString("__index", L.G.Global)
+	L.SetMetatable(env, meta)
+	return env
+}
+
+func (e *Engine) removeLibrary(lib *Library) {
+	for fname := range lib.Functions {
+		delete(e.functions, fname)
+	}
+	delete(e.libraries, lib.Name)
+}
+
+func (e *Engine) FunctionDelete(name string) error {
+	e.libMu.Lock()
+	defer e.libMu.Unlock()
+
+	lib, exists := e.libraries[name]
+	if !exists {
+		return errors.New("ERR Library not found")
+	}
+
+	e.removeLibrary(lib)
+	return nil
+}
+
+func (e *Engine) FunctionFlush() {
+	e.libMu.Lock()
+	defer e.libMu.Unlock()
+
+	e.libraries = make(map[string]*Library)
+	e.functions = make(map[string]*Function)
+	e.fnL.Close()
+	e.fnL = e.newState(true)
+}
+
+func (e *Engine) Libraries(pattern string) []*Library {
+	e.libMu.RLock()
+	defer e.libMu.RUnlock()
+
+	libs := make([]*Library, 0, len(e.libraries))
+	for name, lib := range e.libraries {
+		if pattern != "" {
+			if matched, _ := path.Match(pattern, name); !matched {
+				continue
+			}
+		}
+		libs = append(libs, lib)
+	}
+
+	sort.Slice(libs, func(i, j int) bool { return libs[i].Name < libs[j].Name })
+	return libs
+}
+
+func (e *Engine) LibraryCodes() []string {
+	libs := e.Libraries("")
+	codes := make([]string, len(libs))
+	for i, lib := range libs {
+		codes[i] = lib.Code
+	}
+	return codes
+}
+
+func (e *Engine) RestoreLibrary(code string) error {
+	_, err := e.FunctionLoad(code, true)
+	return err
+}
+
+func (e *Engine) FunctionRestore(codes []string, policy string) error {
+	e.libMu.Lock()
+	libraries := make(map[string]*Library, len(e.libraries))
+	for name, lib := range e.libraries {
+		libraries[name] = lib
+	}
+	functions := make(map[string]*Function, len(e.functions))
+	for name, f := range e.functions {
+		functions[name] = f
+	}
+	if policy == "FLUSH" {
+		e.libraries = make(map[string]*Library)
+		e.functions = make(map[string]*Function)
+	}
+	e.libMu.Unlock()
+
+	for _, code := range codes {
+		if _, err := e.FunctionLoad(code, policy == "REPLACE"); err != nil {
+			e.libMu.Lock()
+			e.libraries = libraries
+			e.functions = functions
+			e.libMu.Unlock()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) FCall(name string, keys, args []string, readOnly bool) resp.Value {
+	e.libMu.RLock()
+	f, exists := e.functions[name]
+	e.libMu.RUnlock()
+
+	if !exists {
+		return resp.ErrorValue("ERR Function not found")
+	}
+
+	noWrites := f.HasFlag("no-writes")
+	if readOnly && !noWrites {
+		return resp.ErrorValue("ERR Can not execute a script with write flag using *_ro command.")
+	}
+	if !noWrites && !f.HasFlag("allow-oom") && e.isOOM != nil && e.isOOM() {
+		return resp.ErrorValue("OOM command not allowed when used memory > 'maxmemory'.")
+	}
+
+	L := e.fnL
+	return e.run(L, f.fn, noWrites, stringsToTable(L, keys), stringsToTable(L, args))
+}
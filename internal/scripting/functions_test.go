@@ -0,0 +1,265 @@
+package scripting
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+const testLibrary = `#!lua name=mylib
+local function set_and_get(keys, args)
+  redis.call('SET', keys[1], args[1])
+  return redis.call('GET', keys[1])
+end
+
+redis.register_function('set_and_get', set_and_get)
+redis.register_function{
+  function_name = 'peek',
+  callback = function(keys, args) return redis.call('GET', keys[1]) end,
+  flags = {'no-writes'},
+  description = 'read a key',
+}
+redis.register_function{
+  function_name = 'sneaky',
+  callback = function(keys, args) return redis.call('SET', keys[1], 'x') end,
+  flags = {'no-writes'},
+}
+`
+
+func TestFunctionLoadAndCall(t *testing.T) {
+	f := newFakeRedis()
+	e := NewEngine(f.call, f.isWrite)
+
+	name, err := e.FunctionLoad(testLibrary, false)
+	if err != nil {
+		t.Fatalf("FunctionLoad failed: %v", err)
+	}
+	if name != "mylib" {
+		t.Errorf("Expected library name mylib, got %s", name)
+	}
+
+	result := e.FCall("set_and_get", []string{"k"}, []string{"v"}, false)
+	if result.Type != resp.BulkString || result.Str != "v" {
+		t.Errorf("Expected 'v', got %+v", result)
+	}
+
+	result = e.FCall("peek", []string{"k"}, nil, true)
+	if result.Type != resp.BulkString || result.Str != "v" {
+		t.Errorf("Expected FCALL_RO of no-writes function to succeed, got %+v", result)
+	}
+
+	result = e.FCall("missing", nil, nil, false)
+	if result.Type != resp.Error || result.Str != "ERR Function not found" {
+		t.Errorf("Expected function not found, got %+v", result)
+	}
+}
+
+func TestFunctionReadOnlyEnforcement(t *testing.T) {
+	f := newFakeRedis()
+	e := NewEngine(f.call, f.isWrite)
+	if _, err := e.FunctionLoad(testLibrary, false); err != nil {
+		t.Fatalf("FunctionLoad failed: %v", err)
+	}
+
+	result := e.FCall("set_and_get", []string{"k"}, []string{"v"}, true)
+	if result.Type != resp.Error || !strings.Contains(result.Str, "*_ro command") {
+		t.Errorf("Expected FCALL_RO to reject write function, got %+v", result)
+	}
+
+	result = e.FCall("sneaky", []string{"k"}, nil, false)
+	if result.Type != resp.Error || !strings.Contains(result.Str, "read-only scripts") {
+		t.Errorf("Expected no-writes function to be denied writes, got %+v", result)
+	}
+	if _, exists := f.data["k"]; exists {
+		t.Error("no-writes function should not have modified data")
+	}
+}
+
+func TestFunctionOOM(t *testing.T) {
+	f := newFakeRedis()
+	e := NewEngine(f.call, f.isWrite)
+	e.SetOOMCheck(func() bool { return true })
+
+	code := `#!lua name=oomlib
+redis.register_function('w', function(keys, args) return 1 end)
+redis.register_function{function_name='ok', callback=function() return 2 end, flags={'allow-oom'}}
+redis.register_function{function_name='ro', callback=function() return 3 end, flags={'no-writes'}}
+`
+	if _, err := e.FunctionLoad(code, false); err != nil {
+		t.Fatalf("FunctionLoad failed: %v", err)
+	}
+
+	if result := e.FCall("w", nil, nil, false); result.Type != resp.Error || !strings.HasPrefix(result.Str, "OOM") {
+		t.Errorf("Expected OOM error, got %+v", result)
+	}
+	if result := e.FCall("ok", nil, nil, false); result.Int != 2 {
+		t.Errorf("Expected allow-oom function to run, got %+v", result)
+	}
+	if result := e.FCall("ro", nil, nil, false); result.Int != 3 {
+		t.Errorf("Expected no-writes function to run, got %+v", result)
+	}
+}
+
+func TestFunctionLoadErrors(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"return 1", "ERR Missing library metadata"},
+		{"#!python name=x\n", "ERR Engine 'python' not found"},
+		{"#!lua foo=bar\n", "ERR Invalid metadata value given: foo=bar"},
+		{"#!lua\n", "ERR Library name was not given"},
+		{"#!lua name=bad-name\n", "ERR Library names can only contain"},
+		{"#!lua name=empty\nlocal x = 1\n", "ERR No functions registered"},
+		{"#!lua name=broken\nredis.register_function('f', function() end\n", "ERR Error compiling function"},
+		{"#!lua name=flags\nredis.register_function{function_name='f', callback=function() end, flags={'bogus'}}\n", "ERR Error registering functions"},
+		{"#!lua name=calls\nredis.call('GET', 'x')\nredis.register_function('f', function() end)\n", "ERR Error registering functions"},
+	}
+
+	for _, tt := range tests {
+		_, err := e.FunctionLoad(tt.code, false)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("FunctionLoad(%q) error = %v, want prefix %q", tt.code, err, tt.expected)
+		}
+		if err != nil && strings.Contains(err.Error(), "\n") {
+			t.Errorf("FunctionLoad(%q) error must fit on one line, got %q", tt.code, err.Error())
+		}
+	}
+}
+
+func TestFunctionReplaceAndConflicts(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	v1 := "#!lua name=lib\nredis.register_function('f', function() return 1 end)\n"
+	v2 := "#!lua name=lib\nredis.register_function('f', function() return 2 end)\n"
+	other := "#!lua name=other\nredis.register_function('f', function() return 3 end)\n"
+
+	if _, err := e.FunctionLoad(v1, false); err != nil {
+		t.Fatalf("FunctionLoad failed: %v", err)
+	}
+
+	if _, err := e.FunctionLoad(v2, false); err == nil || err.Error() != "ERR Library 'lib' already exists" {
+		t.Errorf("Expected library exists error, got %v", err)
+	}
+
+	if _, err := e.FunctionLoad(other, false); err == nil || err.Error() != "ERR Function f already exists" {
+		t.Errorf("Expected function exists error, got %v", err)
+	}
+
+	if _, err := e.FunctionLoad(v2, true); err != nil {
+		t.Fatalf("FunctionLoad REPLACE failed: %v", err)
+	}
+	if result := e.FCall("f", nil, nil, false); result.Int != 2 {
+		t.Errorf("Expected replaced function to return 2, got %+v", result)
+	}
+}
+
+func TestFunctionDeleteFlushAndList(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	e.FunctionLoad("#!lua name=alpha\nredis.register_function('a', function() return 1 end)\n", false)
+	e.FunctionLoad("#!lua name=beta\nredis.register_function('b', function() return 1 end)\n", false)
+
+	libs := e.Libraries("")
+	if len(libs) != 2 || libs[0].Name != "alpha" || libs[1].Name != "beta" {
+		t.Fatalf("Expected sorted [alpha beta], got %v", libs)
+	}
+
+	if libs := e.Libraries("b*"); len(libs) != 1 || libs[0].Name != "beta" {
+		t.Errorf("Expected pattern to match beta only, got %v", libs)
+	}
+
+	if err := e.FunctionDelete("alpha"); err != nil {
+		t.Fatalf("FunctionDelete failed: %v", err)
+	}
+	if result := e.FCall("a", nil, nil, false); result.Type != resp.Error {
+		t.Error("Deleted function should not be callable")
+	}
+	if err := e.FunctionDelete("alpha"); err == nil {
+		t.Error("Deleting a missing library should fail")
+	}
+
+	e.FunctionFlush()
+	if len(e.Libraries("")) != 0 {
+		t.Error("Expected no libraries after flush")
+	}
+}
+
+func TestFunctionRestorePolicies(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	v1 := "#!lua name=lib\nredis.register_function('f', function() return 1 end)\n"
+	v2 := "#!lua name=lib\nredis.register_function('f', function() return 2 end)\n"
+	extra := "#!lua name=extra\nredis.register_function('g', function() return 1 end)\n"
+
+	e.FunctionLoad(v1, false)
+	e.FunctionLoad(extra, false)
+
+	if err := e.FunctionRestore([]string{v2}, "APPEND"); err == nil {
+		t.Error("APPEND restore should fail on conflicting library")
+	}
+	if result := e.FCall("f", nil, nil, false); result.Int != 1 {
+		t.Errorf("Failed restore should keep the old library, got %+v", result)
+	}
+
+	if err := e.FunctionRestore([]string{v2}, "REPLACE"); err != nil {
+		t.Fatalf("REPLACE restore failed: %v", err)
+	}
+	if result := e.FCall("f", nil, nil, false); result.Int != 2 {
+		t.Errorf("Expected replaced function, got %+v", result)
+	}
+
+	if err := e.FunctionRestore([]string{v1}, "FLUSH"); err != nil {
+		t.Fatalf("FLUSH restore failed: %v", err)
+	}
+	if codes := e.LibraryCodes(); len(codes) != 1 || codes[0] != v1 {
+		t.Errorf("Expected only v1 after FLUSH restore, got %v", codes)
+	}
+}
+
+func TestFunctionLibraryGlobals(t *testing.T) {
+	e := NewEngine(newFakeRedis().call, nil)
+
+	setter := "#!lua name=setter\ncounter = 0\nredis.helper = 1\n" +
+		"redis.register_function('bump', function() counter = counter + 1 return counter end)\n"
+	reader := "#!lua name=reader\n" +
+		"redis.register_function('peek', function() return {type(counter), type(redis.helper), type(_G.counter)} end)\n"
+	if _, err := e.FunctionLoad(setter, false); err != nil {
+		t.Fatalf("FunctionLoad failed: %v", err)
+	}
+	if _, err := e.FunctionLoad(reader, false); err != nil {
+		t.Fatalf("FunctionLoad failed: %v", err)
+	}
+
+	peek := func() string {
+		var types []string
+		for _, v := range e.FCall("peek", nil, nil, false).Array {
+			types = append(types, v.Str)
+		}
+		return strings.Join(types, " ")
+	}
+
+	e.FCall("bump", nil, nil, false)
+	if result := e.FCall("bump", nil, nil, false); result.Int != 2 {
+		t.Errorf("Expected a library to keep its own globals, got %+v", result)
+	}
+	if types := peek(); types != "nil nil nil" {
+		t.Errorf("Expected other libraries not to see them, got %q", types)
+	}
+
+	// A library loaded by a failed restore leaves nothing behind.
+	leaky := "#!lua name=leaky\ncounter = 1\nredis.register_function('x', function() return 1 end)\n"
+	if err := e.FunctionRestore([]string{leaky, setter}, "APPEND"); err == nil {
+		t.Fatal("Expected the restore to fail")
+	}
+	if types := peek(); types != "nil nil nil" {
+		t.Errorf("Expected a rolled back library to leave no globals, got %q", types)
+	}
+
+	if _, err := e.FunctionLoad("#!lua name=escape\ngetfenv(0).counter = 1\nredis.register_function('y', function() return 1 end)\n", false); err == nil {
+		t.Error("Expected getfenv to be unavailable to libraries")
+	}
+}
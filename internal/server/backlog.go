@@ -0,0 +1,63 @@
+package server
+
+// backlog is a ring buffer holding the tail of the replication stream, so a
+// replica that lost its link for a short while can continue from its offset
+// instead of loading the whole dataset again. Offsets number the bytes of
+// the stream from 1, and offset is the number of bytes fed so far.
+type backlog struct {
+	buf     []byte
+	next    int
+	histlen int
+	offset  int64
+}
+
+func newBacklog(size int, offset int64) *backlog {
+	return &backlog{buf: make([]byte, size), offset: offset}
+}
+
+func (b *backlog) write(p []byte) {
+	b.offset += int64(len(p))
+	if len(p) > len(b.buf) {
+		p = p[len(p)-len(b.buf):]
+	}
+	for len(p) > 0 {
+		n := copy(b.buf[b.next:], p)
+		p = p[n:]
+		b.next = (b.next + n) % len(b.buf)
+		b.histlen = min(b.histlen+n, len(b.buf))
+	}
+}
+
+// firstOffset returns the offset of the oldest byte held.
+func (b *backlog) firstOffset() int64 {
+	return b.offset - int64(b.histlen) + 1
+}
+
+// since returns the stream from offset on, or false if that part of the
+// stream is no longer, or not yet, held.
+func (b *backlog) since(offset int64) ([]byte, bool) {
+	if offset < b.firstOffset() || offset > b.offset+1 {
+		return nil, false
+	}
+
+	n := int(b.offset + 1 - offset)
+	out := make([]byte, n)
+	start := (b.next - n + len(b.buf)) % len(b.buf)
+	copied := copy(out, b.buf[start:])
+	if copied < n {
+		copy(out[copied:], b.buf[:n-copied])
+	}
+	return out, true
+}
+
+// resize changes the capacity, keeping as much of the newest history as
+// fits.
+func (b *backlog) resize(size int) {
+	if size == len(b.buf) {
+		return
+	}
+	history, _ := b.since(b.firstOffset())
+	resized := newBacklog(size, b.offset-int64(len(history)))
+	resized.write(history)
+	*b = *resized
+}
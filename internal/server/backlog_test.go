@@ -0,0 +1,66 @@
+package server
+
+import "testing"
+
+func TestBacklogSince(t *testing.T) {
+	b := newBacklog(8, 100)
+	b.write([]byte("abcdef"))
+
+	tests := []struct {
+		offset   int64
+		expected string
+		ok       bool
+	}{
+		{101, "abcdef", true},
+		{104, "def", true},
+		{107, "", true},
+		{100, "", false},
+		{108, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := b.since(tt.offset)
+		if ok != tt.ok || string(got) != tt.expected {
+			t.Errorf("since(%d): expected %q, %v, got %q, %v", tt.offset, tt.expected, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestBacklogWrapsAround(t *testing.T) {
+	b := newBacklog(8, 0)
+	b.write([]byte("abcdef"))
+	b.write([]byte("ghij"))
+
+	if b.firstOffset() != 3 || b.histlen != 8 {
+		t.Errorf("Expected history from offset 3 of length 8, got %d and %d", b.firstOffset(), b.histlen)
+	}
+	if got, ok := b.since(3); !ok || string(got) != "cdefghij" {
+		t.Errorf("Expected cdefghij, got %q, %v", got, ok)
+	}
+	if _, ok := b.since(2); ok {
+		t.Error("Expected offset 2 to be gone")
+	}
+
+	b.write([]byte("0123456789"))
+	if got, _ := b.since(b.firstOffset()); string(got) != "23456789" {
+		t.Errorf("Expected a write larger than the backlog to keep its tail, got %q", got)
+	}
+}
+
+func TestBacklogResize(t *testing.T) {
+	b := newBacklog(8, 0)
+	b.write([]byte("abcdefgh"))
+
+	b.resize(4)
+	if got, ok := b.since(5); !ok || string(got) != "efgh" {
+		t.Errorf("Expected efgh after shrinking, got %q, %v", got, ok)
+	}
+
+	b.resize(16)
+	b.write([]byte("ijkl"))
+	if got, ok := b.since(5); !ok || string(got) != "efghijkl" {
+		t.Errorf("Expected efghijkl after growing, got %q, %v", got, ok)
+	}
+	if b.offset != 12 {
+		t.Errorf("Expected offset 12, got %d", b.offset)
+	}
+}
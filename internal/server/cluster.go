@@ -0,0 +1,749 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+const (
+	DefaultClusterNodeTimeout = 15 * time.Second
+
+	clusterBusPortOffset = 10000
+	clusterPingPeriod    = time.Second
+)
+
+// Keyspace is what cluster mode needs to know about the data set.
+type Keyspace interface {
+	Exists(key string) bool
+	CountKeysInSlot(slot int) int
+	GetKeysInSlot(slot, count int) []string
+}
+
+// clusterNode is a node of the cluster as this node sees it. Every node is
+// a master serving the slots it owns.
+type clusterNode struct {
+	id          string
+	host        string
+	port        int
+	busPort     int
+	configEpoch uint64
+	myself      bool
+	// Set while the node is only known by address, until it answers the
+	// MEET sent to it with its ID.
+	handshake bool
+	created   time.Time
+	// Suspected down by this node, and agreed down by a majority of masters.
+	pfail bool
+	fail  bool
+	// When other masters last reported the node as suspected down.
+	failReports map[string]time.Time
+	pingSent    time.Time
+	lastPing    time.Time
+	lastSeen    time.Time
+	link        *busLink
+	connecting  bool
+	// The number of slots the node owns, kept by setSlot.
+	numSlots int
+}
+
+func (n *clusterNode) addr() string {
+	return n.host + ":" + strconv.Itoa(n.port)
+}
+
+// cluster is the cluster state of a server: the known nodes, which of them
+// owns each hash slot, and the slots being migrated from or to this node.
+type cluster struct {
+	enabled      bool
+	myself       *clusterNode
+	nodes        map[string]*clusterNode
+	slots        [store.ClusterSlots]*clusterNode
+	migrating    [store.ClusterSlots]*clusterNode
+	importing    [store.ClusterSlots]*clusterNode
+	currentEpoch uint64
+	ok           bool
+	nodeTimeout  time.Duration
+	busPort      int
+	configFile   string
+	keyspace     Keyspace
+	bus          net.Listener
+	inbound      map[*busLink]struct{}
+	// Set when the state changed and the config file needs saving.
+	dirty            bool
+	messagesSent     int64
+	messagesReceived int64
+}
+
+// EnableCluster turns on cluster mode. The node's view of the cluster is
+// loaded from configFile, and saved there whenever it changes; a node with
+// no config file starts as a cluster of its own.
+func (s *Server) EnableCluster(keyspace Keyspace, configFile string) error {
+	c := &s.cluster
+	c.enabled = true
+	c.keyspace = keyspace
+	c.configFile = configFile
+	c.nodes = make(map[string]*clusterNode)
+	c.inbound = make(map[*busLink]struct{})
+	if c.nodeTimeout == 0 {
+		c.nodeTimeout = DefaultClusterNodeTimeout
+	}
+
+	if err := s.loadClusterConfig(); err != nil {
+		return err
+	}
+	if c.myself == nil {
+		c.myself = &clusterNode{id: newReplID(), myself: true, created: time.Now()}
+		c.nodes[c.myself.id] = c.myself
+		c.dirty = true
+	}
+	s.updateClusterState()
+	return nil
+}
+
+func (s *Server) SetClusterNodeTimeout(timeout time.Duration) {
+	s.cluster.nodeTimeout = timeout
+}
+
+// SetClusterPort sets the port of the cluster bus; 0 uses the client port
+// plus 10000.
+func (s *Server) SetClusterPort(port int) {
+	s.cluster.busPort = port
+}
+
+func (s *Server) ClusterInfo() string {
+	return fmt.Sprintf("# Cluster\r\ncluster_enabled:%d\r\n", flag(s.cluster.enabled))
+}
+
+// keysOf returns the keys a command accesses, per the command table.
+func (s *Server) keysOf(args []resp.Value) []string {
+	cmd, exists := s.commands[strings.ToUpper(args[0].Str)]
+	if !exists {
+		return nil
+	}
+	keys, _ := cmd.Spec.Resolve(args).Keys(args)
+	return keys
+}
+
+// clusterRedirect returns the error sending a command to the node that
+// serves its keys, when this node can't run it: -MOVED when the slot
+// belongs to another node, -ASK when it is being migrated and the keys are
+// already gone. EXEC is checked against the keys of the queued commands.
+func (s *Server) clusterRedirect(client *Client, spec *CommandSpec, args []resp.Value) (string, bool) {
+	asking := client.asking || spec.HasFlag(FlagAsking)
+	client.asking = false
+
+	keys := s.keysOf(args)
+	if client.inTransaction && strings.EqualFold(args[0].Str, "EXEC") {
+		for _, queued := range client.txQueue {
+			keys = append(keys, s.keysOf(queued.Array)...)
+		}
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+
+	slot := store.KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if store.KeySlot(key) != slot {
+			return "CROSSSLOT Keys in request don't hash to the same slot", true
+		}
+	}
+
+	c := &s.cluster
+	if !c.ok {
+		return "CLUSTERDOWN The cluster is down", true
+	}
+	owner := c.slots[slot]
+	missing := 0
+	if (owner == c.myself && c.migrating[slot] != nil) || (owner != c.myself && c.importing[slot] != nil) {
+		for _, key := range keys {
+			if !c.keyspace.Exists(key) {
+				missing++
+			}
+		}
+	}
+
+	switch {
+	case owner == c.myself && c.migrating[slot] != nil:
+		if missing == 0 {
+			return "", false
+		}
+		if missing < len(keys) {
+			return "TRYAGAIN Multiple keys request during rehashing of slot", true
+		}
+		return fmt.Sprintf("ASK %d %s", slot, c.migrating[slot].addr()), true
+	case owner == c.myself:
+		return "", false
+	case c.importing[slot] != nil && asking:
+		if len(keys) > 1 && missing > 0 {
+			return "TRYAGAIN Multiple keys request during rehashing of slot", true
+		}
+		return "", false
+	default:
+		return fmt.Sprintf("MOVED %d %s", slot, owner.addr()), true
+	}
+}
+
+func (s *Server) clusterCommand(args []resp.Value) resp.Value {
+	c := &s.cluster
+	if !c.enabled {
+		return resp.ErrorValue("ERR This instance has cluster support disabled")
+	}
+
+	switch sub := strings.ToUpper(args[0].Str); sub {
+	case "MYID":
+		return resp.BulkStringValue(c.myself.id)
+
+	case "INFO":
+		return resp.BulkStringValue(s.clusterInfoFields())
+
+	case "NODES":
+		var sb strings.Builder
+		for _, n := range c.sortedNodes() {
+			sb.WriteString(s.clusterNodeLine(n))
+			sb.WriteString("\n")
+		}
+		return resp.BulkStringValue(sb.String())
+
+	case "SLOTS":
+		var entries []resp.Value
+		for _, n := range c.sortedNodes() {
+			for _, r := range c.slotRanges(n) {
+				entries = append(entries, resp.ArrayValue(
+					resp.IntegerValue(int64(r[0])), resp.IntegerValue(int64(r[1])),
+					resp.ArrayValue(resp.BulkStringValue(n.host), resp.IntegerValue(int64(n.port)), resp.BulkStringValue(n.id)),
+				))
+			}
+		}
+		return resp.ArrayValue(entries...)
+
+	case "SHARDS":
+		var shards []resp.Value
+		for _, n := range c.sortedNodes() {
+			if n.handshake {
+				continue
+			}
+			var slots []resp.Value
+			for _, r := range c.slotRanges(n) {
+				slots = append(slots, resp.IntegerValue(int64(r[0])), resp.IntegerValue(int64(r[1])))
+			}
+			health := "online"
+			if n.fail || n.pfail {
+				health = "fail"
+			}
+			node := resp.MapValue(
+				resp.BulkStringValue("id"), resp.BulkStringValue(n.id),
+				resp.BulkStringValue("port"), resp.IntegerValue(int64(n.port)),
+				resp.BulkStringValue("ip"), resp.BulkStringValue(n.host),
+				resp.BulkStringValue("endpoint"), resp.BulkStringValue(n.host),
+				resp.BulkStringValue("role"), resp.BulkStringValue("master"),
+				resp.BulkStringValue("replication-offset"), resp.IntegerValue(s.repl.offset),
+				resp.BulkStringValue("health"), resp.BulkStringValue(health),
+			)
+			shards = append(shards, resp.MapValue(
+				resp.BulkStringValue("slots"), resp.ArrayValue(slots...),
+				resp.BulkStringValue("nodes"), resp.ArrayValue(node),
+			))
+		}
+		return resp.ArrayValue(shards...)
+
+	case "KEYSLOT":
+		return resp.IntegerValue(int64(store.KeySlot(args[1].Str)))
+
+	case "COUNTKEYSINSLOT":
+		slot, err := strconv.Atoi(args[1].Str)
+		if err != nil || slot < 0 || slot >= store.ClusterSlots {
+			return resp.ErrorValue("ERR Invalid slot")
+		}
+		return resp.IntegerValue(int64(c.keyspace.CountKeysInSlot(slot)))
+
+	case "GETKEYSINSLOT":
+		slot, err := strconv.Atoi(args[1].Str)
+		count, countErr := strconv.Atoi(args[2].Str)
+		if err != nil || countErr != nil || slot < 0 || slot >= store.ClusterSlots || count < 0 {
+			return resp.ErrorValue("ERR Invalid slot or number of keys")
+		}
+		keys := c.keyspace.GetKeysInSlot(slot, count)
+		values := make([]resp.Value, len(keys))
+		for i, key := range keys {
+			values[i] = resp.BulkStringValue(key)
+		}
+		return resp.ArrayValue(values...)
+
+	case "MEET":
+		port, err := strconv.Atoi(args[2].Str)
+		if err != nil || port <= 0 || port > 65535 {
+			return resp.ErrorValue("ERR Invalid base port specified: " + args[2].Str)
+		}
+		busPort := port + clusterBusPortOffset
+		if len(args) > 3 {
+			if busPort, err = strconv.Atoi(args[3].Str); err != nil || busPort <= 0 || busPort > 65535 {
+				return resp.ErrorValue("ERR Invalid bus port specified: " + args[3].Str)
+			}
+		}
+		if net.ParseIP(args[1].Str) == nil {
+			return resp.ErrorValue("ERR Invalid node address specified: " + args[1].Str + ":" + args[2].Str)
+		}
+		s.startHandshake(args[1].Str, port, busPort)
+		return resp.OKValue()
+
+	case "ADDSLOTS", "DELSLOTS", "ADDSLOTSRANGE", "DELSLOTSRANGE":
+		return s.clusterChangeSlots(sub, args[1:])
+
+	case "SETSLOT":
+		return s.clusterSetSlot(args[1:])
+
+	case "FORGET":
+		n, exists := c.nodes[args[1].Str]
+		if !exists {
+			return resp.ErrorValue("ERR Unknown node " + args[1].Str)
+		}
+		if n.myself {
+			return resp.ErrorValue("ERR I tried hard but I can't forget myself...")
+		}
+		s.removeClusterNode(n)
+		return resp.OKValue()
+
+	case "SAVECONFIG":
+		if err := s.saveClusterConfig(); err != nil {
+			return resp.ErrorValue("ERR error saving the cluster node config: " + err.Error())
+		}
+		return resp.OKValue()
+	}
+
+	return resp.ErrorValue(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLUSTER HELP.", args[0].Str))
+}
+
+func parseSlot(arg string) (int, bool) {
+	slot, err := strconv.Atoi(arg)
+	return slot, err == nil && slot >= 0 && slot < store.ClusterSlots
+}
+
+// clusterChangeSlots assigns slots to this node, or unassigns them, for
+// the ADDSLOTS family of subcommands.
+func (s *Server) clusterChangeSlots(sub string, args []resp.Value) resp.Value {
+	c := &s.cluster
+	ranged := strings.HasSuffix(sub, "RANGE")
+	if ranged && len(args)%2 != 0 {
+		return resp.ErrorValue(fmt.Sprintf("ERR wrong number of arguments for 'cluster|%s' command", strings.ToLower(sub)))
+	}
+
+	var slots []int
+	for i := 0; i < len(args); i++ {
+		start, ok := parseSlot(args[i].Str)
+		if !ok {
+			return resp.ErrorValue("ERR Invalid or out of range slot")
+		}
+		end := start
+		if ranged {
+			i++
+			if end, ok = parseSlot(args[i].Str); !ok {
+				return resp.ErrorValue("ERR Invalid or out of range slot")
+			}
+			if start > end {
+				return resp.ErrorValue(fmt.Sprintf("ERR start slot number %d is greater than end slot number %d", start, end))
+			}
+		}
+		for slot := start; slot <= end; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+
+	add := strings.HasPrefix(sub, "ADD")
+	seen := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		if seen[slot] {
+			return resp.ErrorValue(fmt.Sprintf("ERR Slot %d specified multiple times", slot))
+		}
+		seen[slot] = true
+		if add && c.slots[slot] != nil {
+			return resp.ErrorValue(fmt.Sprintf("ERR Slot %d is already busy", slot))
+		}
+		if !add && c.slots[slot] == nil {
+			return resp.ErrorValue(fmt.Sprintf("ERR Slot %d is already unassigned", slot))
+		}
+	}
+
+	for _, slot := range slots {
+		if add {
+			c.setSlot(slot, c.myself)
+			c.importing[slot] = nil
+		} else {
+			c.setSlot(slot, nil)
+		}
+	}
+	s.clusterChanged()
+	return resp.OKValue()
+}
+
+func (s *Server) clusterSetSlot(args []resp.Value) resp.Value {
+	c := &s.cluster
+	slot, ok := parseSlot(args[0].Str)
+	if !ok {
+		return resp.ErrorValue("ERR Invalid or out of range slot")
+	}
+
+	action := strings.ToUpper(args[1].Str)
+	if action == "STABLE" {
+		c.migrating[slot], c.importing[slot] = nil, nil
+		s.clusterChanged()
+		return resp.OKValue()
+	}
+	if len(args) != 3 || (action != "MIGRATING" && action != "IMPORTING" && action != "NODE") {
+		return resp.ErrorValue("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+	n, exists := c.nodes[args[2].Str]
+	if !exists || n.handshake {
+		return resp.ErrorValue("ERR I don't know about node " + args[2].Str)
+	}
+
+	switch action {
+	case "MIGRATING":
+		if c.slots[slot] != c.myself {
+			return resp.ErrorValue(fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot))
+		}
+		if n.myself {
+			return resp.ErrorValue("ERR I'm the owner of hash slot " + strconv.Itoa(slot))
+		}
+		c.migrating[slot] = n
+	case "IMPORTING":
+		if c.slots[slot] == c.myself {
+			return resp.ErrorValue(fmt.Sprintf("ERR I'm already the owner of hash slot %d", slot))
+		}
+		if n.myself {
+			return resp.ErrorValue("ERR Target node is myself")
+		}
+		c.importing[slot] = n
+	case "NODE":
+		if c.slots[slot] == c.myself && !n.myself && c.keyspace.CountKeysInSlot(slot) > 0 {
+			return resp.ErrorValue(fmt.Sprintf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot))
+		}
+		c.migrating[slot] = nil
+		// The node that finished importing a slot takes a new config epoch,
+		// so that its claim wins over the old owner's everywhere.
+		if n.myself && c.importing[slot] != nil {
+			c.importing[slot] = nil
+			c.currentEpoch++
+			c.myself.configEpoch = c.currentEpoch
+		}
+		c.setSlot(slot, n)
+	}
+	s.clusterChanged()
+	return resp.OKValue()
+}
+
+// clusterChanged saves and announces a change made to this node's view.
+func (s *Server) clusterChanged() {
+	s.cluster.dirty = true
+	s.updateClusterState()
+	s.broadcastBus(busPong)
+}
+
+// updateClusterState marks the cluster as down unless every slot is owned
+// by a node not agreed to be down.
+func (s *Server) updateClusterState() {
+	c := &s.cluster
+	c.ok = true
+	for _, n := range c.slots {
+		if n == nil || n.fail {
+			c.ok = false
+			return
+		}
+	}
+}
+
+func (s *Server) clusterInfoFields() string {
+	c := &s.cluster
+	assigned, pfail, failed := 0, 0, 0
+	for _, n := range c.slots {
+		switch {
+		case n == nil:
+			continue
+		case n.fail:
+			failed++
+		case n.pfail:
+			pfail++
+		}
+		assigned++
+	}
+	size, known := 0, 0
+	for _, n := range c.nodes {
+		if n.handshake {
+			continue
+		}
+		known++
+		if n.numSlots > 0 {
+			size++
+		}
+	}
+
+	state := "ok"
+	if !c.ok {
+		state = "fail"
+	}
+	return fmt.Sprintf("cluster_state:%s\r\ncluster_slots_assigned:%d\r\ncluster_slots_ok:%d\r\ncluster_slots_pfail:%d\r\n"+
+		"cluster_slots_fail:%d\r\ncluster_known_nodes:%d\r\ncluster_size:%d\r\ncluster_current_epoch:%d\r\ncluster_my_epoch:%d\r\n"+
+		"cluster_stats_messages_sent:%d\r\ncluster_stats_messages_received:%d\r\n",
+		state, assigned, assigned-pfail-failed, pfail, failed, known, size, c.currentEpoch, c.myself.configEpoch,
+		c.messagesSent, c.messagesReceived)
+}
+
+func (c *cluster) sortedNodes() []*clusterNode {
+	nodes := make([]*clusterNode, 0, len(c.nodes))
+	for _, n := range c.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+	return nodes
+}
+
+// setSlot assigns slot to n, or leaves it unassigned when n is nil.
+func (c *cluster) setSlot(slot int, n *clusterNode) {
+	if owner := c.slots[slot]; owner != nil {
+		owner.numSlots--
+	}
+	if n != nil {
+		n.numSlots++
+	}
+	c.slots[slot] = n
+}
+
+// slotRanges returns the ranges of slots n owns, as inclusive bounds.
+func (c *cluster) slotRanges(n *clusterNode) [][2]int {
+	var ranges [][2]int
+	for slot := 0; slot < store.ClusterSlots; slot++ {
+		if c.slots[slot] != n {
+			continue
+		}
+		if last := len(ranges) - 1; last >= 0 && ranges[last][1] == slot-1 {
+			ranges[last][1] = slot
+		} else {
+			ranges = append(ranges, [2]int{slot, slot})
+		}
+	}
+	return ranges
+}
+
+// clusterNodeLine describes n the way CLUSTER NODES and the config file do.
+func (s *Server) clusterNodeLine(n *clusterNode) string {
+	c := &s.cluster
+	var flags []string
+	if n.myself {
+		flags = append(flags, "myself")
+	}
+	flags = append(flags, "master")
+	if n.fail {
+		flags = append(flags, "fail")
+	} else if n.pfail {
+		flags = append(flags, "fail?")
+	}
+	if n.handshake {
+		flags = append(flags, "handshake")
+	}
+
+	link := "disconnected"
+	if n.myself || n.link != nil {
+		link = "connected"
+	}
+	var lastSeen int64
+	if !n.lastSeen.IsZero() && !n.myself {
+		lastSeen = n.lastSeen.UnixMilli()
+	}
+	var pingSent int64
+	if !n.pingSent.IsZero() {
+		pingSent = n.pingSent.UnixMilli()
+	}
+
+	line := fmt.Sprintf("%s %s:%d@%d %s - %d %d %d %s", n.id, n.host, n.port, n.busPort,
+		strings.Join(flags, ","), pingSent, lastSeen, n.configEpoch, link)
+	for _, r := range c.slotRanges(n) {
+		if r[0] == r[1] {
+			line += fmt.Sprintf(" %d", r[0])
+		} else {
+			line += fmt.Sprintf(" %d-%d", r[0], r[1])
+		}
+	}
+	if n.myself {
+		for slot := 0; slot < store.ClusterSlots; slot++ {
+			if target := c.migrating[slot]; target != nil {
+				line += fmt.Sprintf(" [%d->-%s]", slot, target.id)
+			}
+			if source := c.importing[slot]; source != nil {
+				line += fmt.Sprintf(" [%d-<-%s]", slot, source.id)
+			}
+		}
+	}
+	return line
+}
+
+func (s *Server) removeClusterNode(n *clusterNode) {
+	c := &s.cluster
+	for slot := range c.slots {
+		if c.slots[slot] == n {
+			c.setSlot(slot, nil)
+		}
+		if c.migrating[slot] == n {
+			c.migrating[slot] = nil
+		}
+		if c.importing[slot] == n {
+			c.importing[slot] = nil
+		}
+	}
+	for _, other := range c.nodes {
+		delete(other.failReports, n.id)
+	}
+	if n.link != nil {
+		n.link.close()
+	}
+	delete(c.nodes, n.id)
+	c.dirty = true
+	s.updateClusterState()
+}
+
+func (s *Server) saveClusterConfig() error {
+	c := &s.cluster
+	var sb strings.Builder
+	for _, n := range c.sortedNodes() {
+		if n.handshake {
+			continue
+		}
+		sb.WriteString(s.clusterNodeLine(n))
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, "vars currentEpoch %d lastVoteEpoch 0\n", c.currentEpoch)
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.configFile), "temp-nodes-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(sb.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.configFile); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+func (s *Server) loadClusterConfig() error {
+	c := &s.cluster
+	data, err := os.ReadFile(c.configFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		slot      int
+		node      string
+		importing bool
+	}
+	var transfers []pending
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		bad := fmt.Errorf("unrecoverable error: corrupted cluster config file %q, line %d", c.configFile, i+1)
+		if fields[0] == "vars" {
+			for j := 1; j+1 < len(fields); j += 2 {
+				if fields[j] == "currentEpoch" {
+					if c.currentEpoch, err = strconv.ParseUint(fields[j+1], 10, 64); err != nil {
+						return bad
+					}
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return bad
+		}
+
+		n := &clusterNode{id: fields[0], created: time.Now(), lastSeen: time.Now()}
+		addr, _, _ := strings.Cut(fields[1], ",")
+		hostPort, bus, _ := strings.Cut(addr, "@")
+		host, port, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			return bad
+		}
+		n.host = host
+		if n.port, err = strconv.Atoi(port); err != nil {
+			return bad
+		}
+		if n.busPort, err = strconv.Atoi(bus); err != nil {
+			return bad
+		}
+		n.myself = strings.Contains(fields[2], "myself")
+		if n.configEpoch, err = strconv.ParseUint(fields[6], 10, 64); err != nil {
+			return bad
+		}
+		c.nodes[n.id] = n
+		if n.myself {
+			c.myself = n
+		}
+
+		for _, field := range fields[8:] {
+			if strings.HasPrefix(field, "[") {
+				spec := strings.Trim(field, "[]")
+				slot, node, migrating := strings.Cut(spec, "->-")
+				if !migrating {
+					slot, node, _ = strings.Cut(spec, "-<-")
+				}
+				number, ok := parseSlot(slot)
+				if !ok {
+					return bad
+				}
+				transfers = append(transfers, pending{number, node, !migrating})
+				continue
+			}
+			startText, endText, isRange := strings.Cut(field, "-")
+			start, ok := parseSlot(startText)
+			end := start
+			if isRange {
+				end, ok = parseSlot(endText)
+			}
+			if !ok || start > end {
+				return bad
+			}
+			for slot := start; slot <= end; slot++ {
+				c.setSlot(slot, n)
+			}
+		}
+	}
+
+	if c.myself == nil {
+		return fmt.Errorf("unrecoverable error: cluster config file %q has no myself node", c.configFile)
+	}
+	for _, t := range transfers {
+		n, exists := c.nodes[t.node]
+		if !exists {
+			continue
+		}
+		if t.importing {
+			c.importing[t.slot] = n
+		} else {
+			c.migrating[t.slot] = n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,494 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+const (
+	busPing = "ping"
+	busPong = "pong"
+	busMeet = "meet"
+	busFail = "fail"
+
+	busWriteTimeout = 5 * time.Second
+)
+
+// busMessage is what nodes exchange on the cluster bus, one JSON object per
+// line. Every message carries the sender's view of itself and gossip about
+// the other nodes it knows.
+type busMessage struct {
+	Type         string        `json:"type"`
+	Sender       string        `json:"sender"`
+	Port         int           `json:"port"`
+	BusPort      int           `json:"bus_port"`
+	CurrentEpoch uint64        `json:"current_epoch"`
+	ConfigEpoch  uint64        `json:"config_epoch"`
+	Slots        [][2]int      `json:"slots,omitempty"`
+	Gossip       []gossipEntry `json:"gossip,omitempty"`
+	// The node a fail message declares down.
+	Failing string `json:"failing,omitempty"`
+}
+
+type gossipEntry struct {
+	ID      string `json:"id"`
+	Host    string `json:"host"`
+	Port    int    `json:"port"`
+	BusPort int    `json:"bus_port"`
+	PFail   bool   `json:"pfail,omitempty"`
+	Fail    bool   `json:"fail,omitempty"`
+}
+
+// busLink is a connection of the cluster bus: either one this node opened to
+// ping node, or one another node opened to it. Messages are written by a
+// goroutine of their own so that sending never blocks the server.
+type busLink struct {
+	conn   net.Conn
+	node   *clusterNode
+	out    chan busMessage
+	closed bool
+}
+
+func newBusLink(conn net.Conn, node *clusterNode) *busLink {
+	link := &busLink{conn: conn, node: node, out: make(chan busMessage, 64)}
+	go link.writeLoop()
+	return link
+}
+
+func (l *busLink) writeLoop() {
+	encoder := json.NewEncoder(l.conn)
+	for msg := range l.out {
+		l.conn.SetWriteDeadline(time.Now().Add(busWriteTimeout))
+		if err := encoder.Encode(msg); err != nil {
+			l.conn.Close()
+			return
+		}
+	}
+}
+
+// send queues msg, dropping it when the link is backed up. Callers hold the
+// server lock, as for close.
+func (l *busLink) send(msg busMessage) {
+	if l.closed {
+		return
+	}
+	select {
+	case l.out <- msg:
+	default:
+	}
+}
+
+func (l *busLink) close() {
+	if l.closed {
+		return
+	}
+	l.closed = true
+	close(l.out)
+	l.conn.Close()
+}
+
+func (s *Server) startClusterBus() error {
+	c := &s.cluster
+	port, _ := strconv.Atoi(s.port)
+	busPort := c.busPort
+	if busPort == 0 {
+		busPort = port + clusterBusPortOffset
+	}
+
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(busPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on the cluster bus port %d: %w", busPort, err)
+	}
+
+	s.lockIdle()
+	c.myself.port, c.myself.busPort = port, busPort
+	c.bus = listener
+	s.mu.Unlock()
+	go s.acceptBus(listener)
+	return nil
+}
+
+func (s *Server) stopClusterBus() {
+	c := &s.cluster
+	if c.bus == nil {
+		return
+	}
+	c.bus.Close()
+	c.bus = nil
+	for _, n := range c.nodes {
+		if n.link != nil {
+			n.link.close()
+			n.link = nil
+		}
+	}
+	for link := range c.inbound {
+		link.close()
+	}
+}
+
+func (s *Server) acceptBus(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		s.lockIdle()
+		link := newBusLink(conn, nil)
+		s.cluster.inbound[link] = struct{}{}
+		s.mu.Unlock()
+		go s.readBus(link)
+	}
+}
+
+// readBus processes the messages received on link until it breaks.
+func (s *Server) readBus(link *busLink) {
+	decoder := json.NewDecoder(link.conn)
+	for {
+		var msg busMessage
+		err := decoder.Decode(&msg)
+
+		s.lockIdle()
+		if err != nil {
+			link.close()
+			delete(s.cluster.inbound, link)
+			if link.node != nil && link.node.link == link {
+				link.node.link = nil
+			}
+			s.mu.Unlock()
+			return
+		}
+		s.processBusMessage(link, &msg)
+		s.mu.Unlock()
+	}
+}
+
+// connectNode opens the link used to ping n, sending it a MEET when it is
+// only known by address.
+func (s *Server) connectNode(n *clusterNode, timeout time.Duration) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(n.host, strconv.Itoa(n.busPort)), timeout)
+
+	s.lockIdle()
+	defer s.mu.Unlock()
+	n.connecting = false
+	if err != nil {
+		return
+	}
+	if s.cluster.bus == nil || s.cluster.nodes[n.id] != n {
+		conn.Close()
+		return
+	}
+
+	n.link = newBusLink(conn, n)
+	if n.handshake {
+		s.sendBus(n, busMeet)
+	} else {
+		s.sendBus(n, busPing)
+	}
+	go s.readBus(n.link)
+}
+
+func (s *Server) newBusMessage(typ string, to *clusterNode) busMessage {
+	c := &s.cluster
+	msg := busMessage{
+		Type:         typ,
+		Sender:       c.myself.id,
+		Port:         c.myself.port,
+		BusPort:      c.myself.busPort,
+		CurrentEpoch: c.currentEpoch,
+		ConfigEpoch:  c.myself.configEpoch,
+		Slots:        c.slotRanges(c.myself),
+	}
+	for _, n := range c.nodes {
+		if n.myself || n.handshake || n == to {
+			continue
+		}
+		msg.Gossip = append(msg.Gossip, gossipEntry{
+			ID: n.id, Host: n.host, Port: n.port, BusPort: n.busPort, PFail: n.pfail, Fail: n.fail,
+		})
+	}
+	return msg
+}
+
+func (s *Server) sendBus(n *clusterNode, typ string) {
+	s.sendBusMessage(n, s.newBusMessage(typ, n))
+}
+
+func (s *Server) sendBusMessage(n *clusterNode, msg busMessage) {
+	if n.link == nil {
+		return
+	}
+	n.link.send(msg)
+	s.cluster.messagesSent++
+	if msg.Type == busPing || msg.Type == busMeet {
+		now := time.Now()
+		n.lastPing = now
+		if n.pingSent.IsZero() {
+			n.pingSent = now
+		}
+	}
+}
+
+func (s *Server) broadcastBus(typ string) {
+	for _, n := range s.cluster.nodes {
+		if !n.myself && !n.handshake {
+			s.sendBus(n, typ)
+		}
+	}
+}
+
+func (s *Server) processBusMessage(link *busLink, msg *busMessage) {
+	c := &s.cluster
+	c.messagesReceived++
+	now := time.Now()
+	inbound := link.node == nil
+
+	if msg.CurrentEpoch > c.currentEpoch {
+		c.currentEpoch = msg.CurrentEpoch
+		c.dirty = true
+	}
+	// A node learns its own address from the connections others open to it.
+	if inbound && (msg.Type == busMeet || msg.Type == busPing) && c.myself.host == "" {
+		if host, _, err := net.SplitHostPort(link.conn.LocalAddr().String()); err == nil {
+			c.myself.host = host
+			c.dirty = true
+		}
+	}
+
+	sender := c.nodes[msg.Sender]
+	switch {
+	case !inbound && link.node.handshake && msg.Type == busPong:
+		sender = s.finishHandshake(link.node, msg)
+	case sender == nil && inbound && msg.Type == busMeet && msg.Sender != c.myself.id:
+		host, _, _ := net.SplitHostPort(link.conn.RemoteAddr().String())
+		sender = &clusterNode{id: msg.Sender, host: host, port: msg.Port, busPort: msg.BusPort, created: now}
+		c.nodes[sender.id] = sender
+		c.dirty = true
+	}
+
+	if sender != nil && !sender.myself && !sender.handshake {
+		sender.lastSeen = now
+		if msg.Type == busPong && !inbound {
+			sender.pingSent = time.Time{}
+		}
+		if sender.pfail || sender.fail {
+			sender.pfail, sender.fail = false, false
+			sender.failReports = nil
+			s.updateClusterState()
+		}
+		s.updateSlotsFrom(sender, msg)
+		s.processGossip(sender, msg.Gossip)
+
+		if msg.Type == busFail {
+			if n := c.nodes[msg.Failing]; n != nil && !n.myself && !n.fail {
+				n.pfail, n.fail = true, true
+				c.dirty = true
+				s.updateClusterState()
+			}
+		}
+	}
+
+	if inbound && (msg.Type == busPing || msg.Type == busMeet) {
+		link.send(s.newBusMessage(busPong, sender))
+		c.messagesSent++
+	}
+}
+
+// finishHandshake gives a node met by address the ID it answered with,
+// unless that node turns out to be known already.
+func (s *Server) finishHandshake(n *clusterNode, msg *busMessage) *clusterNode {
+	c := &s.cluster
+	delete(c.nodes, n.id)
+	if existing, known := c.nodes[msg.Sender]; known {
+		n.link.close()
+		if existing.myself {
+			return nil
+		}
+		return existing
+	}
+
+	n.id = msg.Sender
+	n.handshake = false
+	n.port, n.busPort = msg.Port, msg.BusPort
+	c.nodes[n.id] = n
+	c.dirty = true
+	return n
+}
+
+// updateSlotsFrom applies the slots sender claims. A claim wins when the slot
+// is unassigned or its owner has an older config epoch.
+func (s *Server) updateSlotsFrom(sender *clusterNode, msg *busMessage) {
+	c := &s.cluster
+	if sender.configEpoch != msg.ConfigEpoch {
+		sender.configEpoch = msg.ConfigEpoch
+		c.dirty = true
+	}
+
+	changed := false
+	for _, r := range msg.Slots {
+		for slot := max(r[0], 0); slot <= r[1] && slot < store.ClusterSlots; slot++ {
+			owner := c.slots[slot]
+			if owner == sender || c.importing[slot] != nil {
+				continue
+			}
+			if owner == nil || owner.configEpoch < sender.configEpoch {
+				if owner == c.myself {
+					c.migrating[slot] = nil
+				}
+				c.setSlot(slot, sender)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		c.dirty = true
+		s.updateClusterState()
+	}
+
+	// Two masters with the same config epoch could both claim a slot; the
+	// one with the lower ID moves to a new epoch.
+	if sender.configEpoch == c.myself.configEpoch && sender.id > c.myself.id {
+		c.currentEpoch++
+		c.myself.configEpoch = c.currentEpoch
+		c.dirty = true
+	}
+}
+
+func (s *Server) processGossip(sender *clusterNode, entries []gossipEntry) {
+	c := &s.cluster
+	for _, g := range entries {
+		if g.ID == c.myself.id || g.Host == "" {
+			continue
+		}
+		n, known := c.nodes[g.ID]
+		if !known {
+			s.startHandshake(g.Host, g.Port, g.BusPort)
+			continue
+		}
+		if n.handshake {
+			continue
+		}
+		if g.PFail || g.Fail {
+			if n.failReports == nil {
+				n.failReports = make(map[string]time.Time)
+			}
+			n.failReports[sender.id] = time.Now()
+			s.checkNodeFail(n)
+		} else {
+			delete(n.failReports, sender.id)
+		}
+	}
+}
+
+// startHandshake adds a node known only by address, to be sent a MEET.
+func (s *Server) startHandshake(host string, port, busPort int) {
+	c := &s.cluster
+	for _, n := range c.nodes {
+		if n.handshake && n.host == host && n.busPort == busPort {
+			return
+		}
+	}
+	n := &clusterNode{id: newReplID(), host: host, port: port, busPort: busPort, handshake: true, created: time.Now()}
+	c.nodes[n.id] = n
+}
+
+// checkNodeFail marks a node suspected down as down once a majority of the
+// masters serving slots agree, and tells every node.
+func (s *Server) checkNodeFail(n *clusterNode) {
+	c := &s.cluster
+	if !n.pfail || n.fail {
+		return
+	}
+
+	masters, reports := 0, 0
+	for _, m := range c.nodes {
+		if m.handshake || m.numSlots == 0 {
+			continue
+		}
+		masters++
+		if m.myself {
+			reports++
+		} else if at, reported := n.failReports[m.id]; reported && time.Since(at) <= 2*c.nodeTimeout {
+			reports++
+		}
+	}
+	if reports < masters/2+1 {
+		return
+	}
+
+	log.Printf("Marking node %s as failing (quorum reached)", n.id)
+	n.fail = true
+	c.dirty = true
+	s.updateClusterState()
+	for _, other := range c.nodes {
+		if !other.myself && !other.handshake {
+			msg := s.newBusMessage(busFail, other)
+			msg.Failing = n.id
+			s.sendBusMessage(other, msg)
+		}
+	}
+}
+
+// clusterCron keeps links to every node up, pings them, detects failures and
+// saves the cluster config when it changed.
+func (s *Server) clusterCron() {
+	c := &s.cluster
+	if c.bus == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, n := range c.nodes {
+		if n.myself {
+			continue
+		}
+		if n.handshake && now.Sub(n.created) > c.nodeTimeout {
+			if n.link != nil {
+				n.link.close()
+			}
+			delete(c.nodes, n.id)
+			continue
+		}
+
+		switch {
+		case n.link == nil:
+			if !n.connecting {
+				n.connecting = true
+				go s.connectNode(n, c.nodeTimeout)
+			}
+		case !n.pingSent.IsZero() && now.Sub(n.pingSent) > c.nodeTimeout/2:
+			// No pong for a while: the link may be stuck, so open a new one.
+			n.link.close()
+			n.link = nil
+			n.pingSent = time.Time{}
+		case n.pingSent.IsZero() && now.Sub(n.lastPing) >= min(clusterPingPeriod, c.nodeTimeout/2):
+			s.sendBus(n, busPing)
+		}
+
+		if n.handshake {
+			continue
+		}
+		for id, at := range n.failReports {
+			if now.Sub(at) > 2*c.nodeTimeout {
+				delete(n.failReports, id)
+			}
+		}
+		if !n.pfail && now.Sub(n.lastSeen) > c.nodeTimeout {
+			log.Printf("Node %s is not reachable, marking it as possibly failing", n.id)
+			n.pfail = true
+		}
+		s.checkNodeFail(n)
+	}
+
+	s.updateClusterState()
+	if c.dirty {
+		if err := s.saveClusterConfig(); err != nil {
+			log.Printf("Failed to save the cluster config: %v", err)
+		}
+	}
+}
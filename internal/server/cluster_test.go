@@ -0,0 +1,137 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/command"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+func startClusterNode(t *testing.T, port string) *Server {
+	t.Helper()
+	server := NewServer()
+	st := store.NewStore()
+	st.SetKeyModifiedHandler(server.MarkKeyModified)
+	st.TrackSlots()
+
+	server.RegisterCommand("SET", command.SetCommand(st))
+	server.RegisterCommand("GET", command.GetCommand(st))
+	server.RegisterCommand("DEL", command.DelCommand(st))
+	server.RegisterCommand("RESTORE", command.RestoreCommand(st))
+	server.RegisterCommand("RESTORE-ASKING", command.RestoreCommand(st))
+	server.RegisterCommand("MIGRATE", command.MigrateCommand(st, server.Call, command.NewMigrateCache(), true))
+
+	server.SetClusterNodeTimeout(1500 * time.Millisecond)
+	if err := server.EnableCluster(st, filepath.Join(t.TempDir(), "nodes.conf")); err != nil {
+		t.Fatalf("Failed to enable cluster mode: %v", err)
+	}
+
+	go server.Start(port)
+	t.Cleanup(func() { server.Stop() })
+	time.Sleep(100 * time.Millisecond)
+	return server
+}
+
+func clusterState(c *replTestClient) string {
+	return infoField(c.do("CLUSTER", "INFO").Str, "cluster_state")
+}
+
+func TestCluster(t *testing.T) {
+	ports := []string{"16394", "16395", "16396"}
+	ranges := [][2]string{{"0", "5460"}, {"5461", "10922"}, {"10923", "16383"}}
+	var servers []*Server
+	var clients []*replTestClient
+	var ids []string
+	for i, port := range ports {
+		servers = append(servers, startClusterNode(t, port))
+		c := dialReplTest(t, port)
+		if reply := c.do("CLUSTER", "ADDSLOTSRANGE", ranges[i][0], ranges[i][1]); reply.Str != "OK" {
+			t.Fatalf("ADDSLOTSRANGE on %s: %v", port, reply)
+		}
+		clients = append(clients, c)
+		ids = append(ids, c.do("CLUSTER", "MYID").Str)
+	}
+	clients[0].do("CLUSTER", "MEET", "127.0.0.1", ports[1])
+	clients[0].do("CLUSTER", "MEET", "127.0.0.1", ports[2])
+
+	for i, c := range clients {
+		waitFor(t, "node "+ports[i]+" to see the whole cluster", func() bool {
+			info := c.do("CLUSTER", "INFO").Str
+			return infoField(info, "cluster_state") == "ok" && infoField(info, "cluster_known_nodes") == "3"
+		})
+	}
+
+	// "foo" hashes to slot 12182, served by the third node.
+	if reply := clients[0].do("CLUSTER", "KEYSLOT", "foo"); reply.Int != 12182 {
+		t.Errorf("KEYSLOT foo = %d, want 12182", reply.Int)
+	}
+	if reply := clients[0].do("SET", "foo", "bar"); reply.Str != "MOVED 12182 127.0.0.1:16396" {
+		t.Errorf("Expected a MOVED redirect, got %v", reply)
+	}
+	if reply := clients[2].do("SET", "foo", "bar"); reply.Str != "OK" {
+		t.Errorf("Expected the owner to serve foo, got %v", reply)
+	}
+	if reply := clients[2].do("DEL", "foo", "bar"); !strings.HasPrefix(reply.Str, "CROSSSLOT") {
+		t.Errorf("Expected CROSSSLOT, got %v", reply)
+	}
+	clients[2].do("SET", "{foo}.a", "1")
+	if reply := clients[2].do("DEL", "foo", "{foo}.a"); reply.Int != 2 {
+		t.Errorf("Expected keys sharing a hash tag to be deleted together, got %v", reply)
+	}
+
+	// Move slot 12182 from the third node to the first.
+	clients[2].do("SET", "foo", "bar")
+	clients[2].do("SET", "{foo}.b", "baz")
+	if reply := clients[0].do("CLUSTER", "SETSLOT", "12182", "IMPORTING", ids[2]); reply.Str != "OK" {
+		t.Fatalf("SETSLOT IMPORTING: %v", reply)
+	}
+	if reply := clients[2].do("CLUSTER", "SETSLOT", "12182", "MIGRATING", ids[0]); reply.Str != "OK" {
+		t.Fatalf("SETSLOT MIGRATING: %v", reply)
+	}
+	if reply := clients[2].do("MIGRATE", "127.0.0.1", ports[0], "", "0", "1000", "KEYS", "foo"); reply.Str != "OK" {
+		t.Fatalf("MIGRATE: %v", reply)
+	}
+	if reply := clients[2].do("GET", "{foo}.b"); reply.Str != "baz" {
+		t.Errorf("Expected keys not migrated yet to be served, got %v", reply)
+	}
+	if reply := clients[2].do("GET", "foo"); reply.Str != "ASK 12182 127.0.0.1:16394" {
+		t.Errorf("Expected an ASK redirect, got %v", reply)
+	}
+	if reply := clients[0].do("GET", "foo"); reply.Str != "MOVED 12182 127.0.0.1:16396" {
+		t.Errorf("Expected MOVED without ASKING, got %v", reply)
+	}
+	clients[0].do("ASKING")
+	if reply := clients[0].do("GET", "foo"); reply.Str != "bar" {
+		t.Errorf("Expected the importing node to serve foo after ASKING, got %v", reply)
+	}
+
+	clients[2].do("MIGRATE", "127.0.0.1", ports[0], "{foo}.b", "0", "1000")
+	if reply := clients[2].do("CLUSTER", "COUNTKEYSINSLOT", "12182"); reply.Int != 0 {
+		t.Errorf("Expected no keys left in the slot, got %v", reply)
+	}
+	clients[0].do("CLUSTER", "SETSLOT", "12182", "NODE", ids[0])
+	clients[2].do("CLUSTER", "SETSLOT", "12182", "NODE", ids[0])
+	for _, c := range clients[1:] {
+		waitFor(t, "the new owner of slot 12182 to spread", func() bool {
+			return c.do("GET", "foo").Str == "MOVED 12182 127.0.0.1:16394"
+		})
+	}
+	if reply := clients[0].do("GET", "{foo}.b"); reply.Str != "baz" {
+		t.Errorf("Expected the new owner to serve {foo}.b, got %v", reply)
+	}
+
+	// A node that stops answering is agreed down, and so is the cluster.
+	servers[1].Stop()
+	waitFor(t, "the cluster to detect the failed node", func() bool {
+		return clusterState(clients[0]) == "fail" && clusterState(clients[2]) == "fail"
+	})
+	if nodes := clients[0].do("CLUSTER", "NODES").Str; !strings.Contains(nodes, "master,fail") {
+		t.Errorf("Expected the stopped node to be flagged as failed:\n%s", nodes)
+	}
+	if reply := clients[0].do("GET", "foo"); !strings.HasPrefix(reply.Str, "CLUSTERDOWN") {
+		t.Errorf("Expected CLUSTERDOWN, got %v", reply)
+	}
+}
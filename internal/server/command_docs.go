@@ -0,0 +1,396 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+type commandArg struct {
+	Name     string
+	Type     string
+	Token    string
+	Optional bool
+	Multiple bool
+	Args     []*commandArg
+}
+
+// parseArgumentSyntax turns the compact usage strings in the command table,
+// e.g. "key [NX|XX] (field value)...", into the argument trees COMMAND DOCS
+// reports. Upper-case words are pure tokens, "[...]" is optional, "(...)"
+// groups a block, "|" separates alternatives and "..." repeats the previous
+// argument. A "name:type" suffix overrides the default string type.
+func parseArgumentSyntax(syntax string) []*commandArg {
+	tokens := tokenizeSyntax(syntax)
+	pos := 0
+	alternatives := parseAlternatives(tokens, &pos)
+	if len(alternatives) == 0 {
+		return nil
+	}
+	return alternatives[0]
+}
+
+func tokenizeSyntax(syntax string) []string {
+	var tokens []string
+	var current strings.Builder
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for i := 0; i < len(syntax); i++ {
+		c := syntax[i]
+		switch {
+		case c == ' ':
+			flush()
+		case c == '[' || c == ']' || c == '(' || c == ')' || c == '|':
+			flush()
+			tokens = append(tokens, string(c))
+		case strings.HasPrefix(syntax[i:], "..."):
+			flush()
+			tokens = append(tokens, "...")
+			i += 2
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func parseAlternatives(tokens []string, pos *int) [][]*commandArg {
+	var alternatives [][]*commandArg
+	var sequence []*commandArg
+
+	for *pos < len(tokens) {
+		token := tokens[*pos]
+		switch token {
+		case "]", ")":
+			return append(alternatives, sequence)
+		case "|":
+			alternatives = append(alternatives, sequence)
+			sequence = nil
+			*pos++
+			continue
+		case "...":
+			if len(sequence) > 0 {
+				sequence[len(sequence)-1].Multiple = true
+			}
+			*pos++
+			continue
+		}
+
+		var arg *commandArg
+		if token == "[" || token == "(" {
+			*pos++
+			arg = groupToArg(parseAlternatives(tokens, pos))
+			arg.Optional = arg.Optional || token == "["
+		} else {
+			arg = wordToArg(token)
+		}
+		*pos++
+		sequence = append(sequence, arg)
+	}
+
+	return append(alternatives, sequence)
+}
+
+func groupToArg(alternatives [][]*commandArg) *commandArg {
+	if len(alternatives) == 1 {
+		return sequenceToArg(alternatives[0])
+	}
+
+	arg := &commandArg{Type: "oneof"}
+	names := make([]string, len(alternatives))
+	for i, alternative := range alternatives {
+		child := sequenceToArg(alternative)
+		arg.Args = append(arg.Args, child)
+		names[i] = child.Name
+	}
+	arg.Name = strings.Join(names, "-")
+	return arg
+}
+
+func sequenceToArg(sequence []*commandArg) *commandArg {
+	if len(sequence) == 1 {
+		return sequence[0]
+	}
+
+	if len(sequence) == 2 && sequence[0].Type == "pure-token" && sequence[1].Type != "pure-token" {
+		arg := sequence[1]
+		arg.Token = sequence[0].Token
+		return arg
+	}
+
+	arg := &commandArg{Type: "block", Args: sequence}
+	if sequence[0].Type == "pure-token" {
+		arg.Name = sequence[0].Name
+		arg.Token = sequence[0].Token
+		arg.Args = sequence[1:]
+		return arg
+	}
+
+	names := make([]string, len(sequence))
+	for i, child := range sequence {
+		names[i] = child.Name
+	}
+	arg.Name = strings.Join(names, "-")
+	return arg
+}
+
+func wordToArg(word string) *commandArg {
+	if word == strings.ToUpper(word) && strings.ToLower(word) != word {
+		return &commandArg{Name: strings.ToLower(word), Type: "pure-token", Token: word}
+	}
+
+	name, argType, found := strings.Cut(word, ":")
+	if !found {
+		argType = "string"
+		if name == "key" {
+			argType = "key"
+		}
+	}
+	return &commandArg{Name: name, Type: argType}
+}
+
+func (a *commandArg) toValue() resp.Value {
+	fields := []resp.Value{
+		resp.BulkStringValue("name"), resp.BulkStringValue(a.Name),
+		resp.BulkStringValue("type"), resp.BulkStringValue(a.Type),
+	}
+	if a.Type == "key" {
+		fields = append(fields, resp.BulkStringValue("key_spec_index"), resp.IntegerValue(0))
+	}
+	if a.Token != "" {
+		fields = append(fields, resp.BulkStringValue("token"), resp.BulkStringValue(a.Token))
+	}
+
+	var flags []resp.Value
+	if a.Optional {
+		flags = append(flags, resp.SimpleStringValue("optional"))
+	}
+	if a.Multiple {
+		flags = append(flags, resp.SimpleStringValue("multiple"))
+	}
+	if len(flags) > 0 {
+		fields = append(fields, resp.BulkStringValue("flags"), resp.SetValue(flags...))
+	}
+
+	if len(a.Args) > 0 {
+		args := make([]resp.Value, len(a.Args))
+		for i, child := range a.Args {
+			args[i] = child.toValue()
+		}
+		fields = append(fields, resp.BulkStringValue("arguments"), resp.ArrayValue(args...))
+	}
+
+	return resp.MapValue(fields...)
+}
+
+func commandInfo(spec *CommandSpec) resp.Value {
+	flags := make([]resp.Value, len(spec.Flags))
+	for i, flag := range spec.Flags {
+		flags[i] = resp.SimpleStringValue(flag)
+	}
+
+	categories := spec.ACLCategories()
+	categoryValues := make([]resp.Value, len(categories))
+	for i, category := range categories {
+		categoryValues[i] = resp.SimpleStringValue(category)
+	}
+
+	subcommands := make([]resp.Value, len(spec.Subcommands))
+	for i, sub := range spec.Subcommands {
+		subcommands[i] = commandInfo(sub)
+	}
+
+	return resp.ArrayValue(
+		resp.BulkStringValue(spec.FullName()),
+		resp.IntegerValue(int64(spec.Arity)),
+		resp.SetValue(flags...),
+		resp.IntegerValue(int64(spec.FirstKey)),
+		resp.IntegerValue(int64(spec.LastKey)),
+		resp.IntegerValue(int64(spec.Step)),
+		resp.SetValue(categoryValues...),
+		resp.SetValue(),
+		keySpecs(spec),
+		resp.ArrayValue(subcommands...),
+	)
+}
+
+func keySpecs(spec *CommandSpec) resp.Value {
+	access := []resp.Value{resp.SimpleStringValue("RO"), resp.SimpleStringValue("ACCESS")}
+	if spec.HasFlag(FlagWrite) {
+		access = []resp.Value{resp.SimpleStringValue("RW"), resp.SimpleStringValue("UPDATE")}
+	}
+
+	var beginSearch, findKeys resp.Value
+	switch {
+	case spec.KeyNum > 0:
+		beginSearch = resp.MapValue(
+			resp.BulkStringValue("type"), resp.BulkStringValue("index"),
+			resp.BulkStringValue("spec"), resp.MapValue(
+				resp.BulkStringValue("index"), resp.IntegerValue(int64(spec.KeyNum)),
+			),
+		)
+		findKeys = resp.MapValue(
+			resp.BulkStringValue("type"), resp.BulkStringValue("keynum"),
+			resp.BulkStringValue("spec"), resp.MapValue(
+				resp.BulkStringValue("keynumidx"), resp.IntegerValue(0),
+				resp.BulkStringValue("firstkey"), resp.IntegerValue(1),
+				resp.BulkStringValue("keystep"), resp.IntegerValue(1),
+			),
+		)
+	case spec.FirstKey > 0:
+		lastKey := spec.LastKey
+		if lastKey >= 0 {
+			lastKey -= spec.FirstKey
+		}
+		beginSearch = resp.MapValue(
+			resp.BulkStringValue("type"), resp.BulkStringValue("index"),
+			resp.BulkStringValue("spec"), resp.MapValue(
+				resp.BulkStringValue("index"), resp.IntegerValue(int64(spec.FirstKey)),
+			),
+		)
+		findKeys = resp.MapValue(
+			resp.BulkStringValue("type"), resp.BulkStringValue("range"),
+			resp.BulkStringValue("spec"), resp.MapValue(
+				resp.BulkStringValue("lastkey"), resp.IntegerValue(int64(lastKey)),
+				resp.BulkStringValue("keystep"), resp.IntegerValue(int64(spec.Step)),
+				resp.BulkStringValue("limit"), resp.IntegerValue(0),
+			),
+		)
+	default:
+		return resp.ArrayValue()
+	}
+
+	return resp.ArrayValue(resp.MapValue(
+		resp.BulkStringValue("flags"), resp.SetValue(access...),
+		resp.BulkStringValue("begin_search"), beginSearch,
+		resp.BulkStringValue("find_keys"), findKeys,
+	))
+}
+
+func commandDocs(spec *CommandSpec) resp.Value {
+	fields := []resp.Value{
+		resp.BulkStringValue("summary"), resp.BulkStringValue(spec.Summary),
+		resp.BulkStringValue("since"), resp.BulkStringValue(spec.Since),
+		resp.BulkStringValue("group"), resp.BulkStringValue(spec.Group),
+		resp.BulkStringValue("complexity"), resp.BulkStringValue(spec.Complexity),
+	}
+
+	if args := parseArgumentSyntax(spec.Arguments); len(args) > 0 {
+		values := make([]resp.Value, len(args))
+		for i, arg := range args {
+			values[i] = arg.toValue()
+		}
+		fields = append(fields, resp.BulkStringValue("arguments"), resp.ArrayValue(values...))
+	}
+
+	if len(spec.Subcommands) > 0 {
+		subcommands := make([]resp.Value, 0, len(spec.Subcommands)*2)
+		for _, sub := range spec.Subcommands {
+			subcommands = append(subcommands, resp.BulkStringValue(sub.FullName()), commandDocs(sub))
+		}
+		fields = append(fields, resp.BulkStringValue("subcommands"), resp.MapValue(subcommands...))
+	}
+
+	return resp.MapValue(fields...)
+}
+
+func (s *Server) findSpec(name string) *CommandSpec {
+	parent, sub, hasSub := strings.Cut(name, "|")
+	cmd, exists := s.commands[strings.ToUpper(parent)]
+	if !exists {
+		return nil
+	}
+	if hasSub {
+		return cmd.Spec.Subcommand(sub)
+	}
+	return cmd.Spec
+}
+
+func (s *Server) commandCommand(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		commands := s.sortedCommands()
+		infos := make([]resp.Value, len(commands))
+		for i, cmd := range commands {
+			infos[i] = commandInfo(cmd.Spec)
+		}
+		return resp.ArrayValue(infos...)
+	}
+
+	subcommand := strings.ToUpper(args[0].Str)
+
+	switch subcommand {
+	case "COUNT":
+		return resp.IntegerValue(int64(len(s.commands)))
+
+	case "INFO":
+		if len(args) == 1 {
+			return s.commandCommand(nil)
+		}
+
+		infos := make([]resp.Value, len(args)-1)
+		for i, arg := range args[1:] {
+			if spec := s.findSpec(arg.Str); spec != nil {
+				infos[i] = commandInfo(spec)
+			} else {
+				infos[i] = resp.NullArrayValue()
+			}
+		}
+		return resp.ArrayValue(infos...)
+
+	case "DOCS":
+		var specs []*CommandSpec
+		if len(args) == 1 {
+			for _, cmd := range s.sortedCommands() {
+				specs = append(specs, cmd.Spec)
+			}
+		} else {
+			for _, arg := range args[1:] {
+				if spec := s.findSpec(arg.Str); spec != nil {
+					specs = append(specs, spec)
+				}
+			}
+		}
+
+		docs := make([]resp.Value, 0, len(specs)*2)
+		for _, spec := range specs {
+			docs = append(docs, resp.BulkStringValue(spec.FullName()), commandDocs(spec))
+		}
+		return resp.MapValue(docs...)
+
+	case "GETKEYS":
+		cmdArgs := args[1:]
+		cmd, exists := s.commands[strings.ToUpper(cmdArgs[0].Str)]
+		if !exists {
+			return resp.ErrorValue(ErrInvalidCommand.Error())
+		}
+
+		spec := cmd.Spec.Resolve(cmdArgs)
+		if !cmd.Spec.CheckArity(len(cmdArgs)) || !spec.CheckArity(len(cmdArgs)) {
+			return resp.ErrorValue(ErrInvalidCommandArgs.Error())
+		}
+
+		keys, err := spec.Keys(cmdArgs)
+		if err != nil {
+			return resp.ErrorValue(err.Error())
+		}
+		if len(keys) == 0 {
+			return resp.ErrorValue(ErrNoKeyArguments.Error())
+		}
+
+		values := make([]resp.Value, len(keys))
+		for i, key := range keys {
+			values[i] = resp.BulkStringValue(key)
+		}
+		return resp.ArrayValue(values...)
+
+	default:
+		return resp.ErrorValue("ERR unknown subcommand '" + args[0].Str + "'. Try COMMAND HELP.")
+	}
+}
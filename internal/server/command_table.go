@@ -0,0 +1,639 @@
+package server
+
+var defaultCommandSpecs = []*CommandSpec{
+	{
+		Name: "ping", Arity: -1, Flags: []string{FlagFast, FlagStale},
+		Group: "connection", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Returns the server's liveliness response.",
+		Arguments: "[message]",
+	},
+	{
+		Name: "echo", Arity: 2, Flags: []string{FlagFast, FlagStale},
+		Group: "connection", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Returns the given string.",
+		Arguments: "message",
+	},
+	{
+		Name: "hello", Arity: -1, Flags: []string{FlagNoScript, FlagLoading, FlagStale, FlagFast, FlagNoAuth, FlagAllowBusy},
+		Group: "connection", Since: "6.0.0", Complexity: "O(1)",
+		Summary:   "Handshakes with the Redis server.",
+		Arguments: "[protover:integer [AUTH username password] [SETNAME clientname]]",
+	},
+	{
+		Name: "auth", Arity: -2, Flags: []string{FlagNoScript, FlagLoading, FlagStale, FlagFast, FlagNoAuth, FlagAllowBusy},
+		Group: "connection", Since: "1.0.0", Complexity: "O(N) where N is the number of passwords defined for the user",
+		Summary:   "Authenticates the connection.",
+		Arguments: "[username] password",
+	},
+	{
+		Name: "command", Arity: -1, Flags: []string{FlagLoading, FlagStale},
+		Group: "server", Since: "2.8.13", Complexity: "O(N) where N is the total number of Redis commands",
+		Summary: "Returns detailed information about all commands.",
+		Subcommands: []*CommandSpec{
+			{
+				Name: "count", Arity: 2, Flags: []string{FlagLoading, FlagStale},
+				Since: "2.8.13", Complexity: "O(1)",
+				Summary: "Returns a count of commands.",
+			},
+			{
+				Name: "docs", Arity: -2, Flags: []string{FlagLoading, FlagStale},
+				Since: "7.0.0", Complexity: "O(N) where N is the number of commands to look up",
+				Summary:   "Returns documentary information about one, multiple or all commands.",
+				Arguments: "[command-name ...]",
+			},
+			{
+				Name: "getkeys", Arity: -3, Flags: []string{FlagLoading, FlagStale},
+				Since: "2.8.13", Complexity: "O(N) where N is the number of arguments to the command",
+				Summary:   "Extracts the key names from an arbitrary command.",
+				Arguments: "command arg ...",
+			},
+			{
+				Name: "info", Arity: -2, Flags: []string{FlagLoading, FlagStale},
+				Since: "2.8.13", Complexity: "O(N) where N is the number of commands to look up",
+				Summary:   "Returns information about one, multiple or all commands.",
+				Arguments: "[command-name ...]",
+			},
+		},
+	},
+	{
+		Name: "info", Arity: -1, Flags: []string{FlagLoading, FlagStale},
+		Categories: []string{"@dangerous"},
+		Group:      "server", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Returns information and statistics about the server.",
+		Arguments: "[section ...]",
+	},
+	{
+		Name: "config", Arity: -2,
+		Group: "server", Since: "2.0.0", Complexity: "Depends on subcommand.",
+		Summary: "A container for server configuration commands.",
+		Subcommands: []*CommandSpec{
+			{
+				Name: "get", Arity: -3, Flags: []string{FlagAdmin, FlagNoScript, FlagLoading, FlagStale},
+				Since: "2.0.0", Complexity: "O(N) when N is the number of configuration parameters provided",
+				Summary:   "Returns the effective values of configuration parameters.",
+				Arguments: "parameter ...",
+			},
+			{
+				Name: "set", Arity: -4, Flags: []string{FlagAdmin, FlagNoScript, FlagLoading, FlagStale},
+				Since: "2.0.0", Complexity: "O(N) when N is the number of configuration parameters provided",
+				Summary:   "Sets configuration parameters in-flight.",
+				Arguments: "(parameter value)...",
+			},
+			{
+				Name: "rewrite", Arity: 2, Flags: []string{FlagAdmin, FlagNoScript, FlagLoading, FlagStale},
+				Since: "2.8.0", Complexity: "O(1)",
+				Summary: "Persists the effective configuration to file.",
+			},
+			{
+				Name: "resetstat", Arity: 2, Flags: []string{FlagAdmin, FlagNoScript, FlagLoading, FlagStale},
+				Since: "2.0.0", Complexity: "O(1)",
+				Summary: "Resets the server's statistics.",
+			},
+		},
+	},
+	{
+		Name: "shutdown", Arity: -1, Flags: []string{FlagAdmin, FlagNoScript, FlagLoading, FlagStale, FlagNoMulti, FlagAllowBusy},
+		Group: "server", Since: "1.0.0", Complexity: "O(N) when saving, where N is the total number of keys in all databases when saving data, otherwise O(1)",
+		Summary:   "Synchronously saves the database(s) to disk and shuts down the Redis server.",
+		Arguments: "[NOSAVE|SAVE]",
+	},
+	{
+		Name: "dbsize", Arity: 1, Flags: []string{FlagReadOnly, FlagFast},
+		Categories: []string{"@keyspace"},
+		Group:      "server", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Returns the number of keys in the database.",
+	},
+	{
+		Name: "flushdb", Arity: -1, Flags: []string{FlagWrite},
+		Categories: []string{"@keyspace", "@dangerous"},
+		Group:      "server", Since: "1.0.0", Complexity: "O(N) where N is the number of keys in the selected database",
+		Summary:   "Removes all keys from the current database.",
+		Arguments: "[ASYNC|SYNC]",
+	},
+	{
+		Name: "flushall", Arity: -1, Flags: []string{FlagWrite},
+		Categories: []string{"@keyspace", "@dangerous"},
+		Group:      "server", Since: "1.0.0", Complexity: "O(N) where N is the total number of keys in all databases",
+		Summary:   "Removes all keys from all databases.",
+		Arguments: "[ASYNC|SYNC]",
+	},
+	{
+		Name: "replicaof", Arity: 3, Flags: []string{FlagAdmin, FlagNoScript, FlagStale, FlagNoAsyncLoading},
+		Group: "server", Since: "5.0.0", Complexity: "O(1)",
+		Summary:   "Configures a server as replica of another, or promotes it to a master.",
+		Arguments: "host port",
+	},
+	{
+		Name: "slaveof", Arity: 3, Flags: []string{FlagAdmin, FlagNoScript, FlagStale, FlagNoAsyncLoading},
+		Group: "server", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Sets a Redis server as a replica of another, or promotes it to being a master.",
+		Arguments: "host port",
+	},
+	{
+		Name: "psync", Arity: -3, Flags: []string{FlagAdmin, FlagNoScript, FlagNoAsyncLoading, FlagNoMulti},
+		Group: "server", Since: "2.8.0", Complexity: "O(1)",
+		Summary:   "An internal command used in replication.",
+		Arguments: "replicationid offset:integer",
+	},
+	{
+		Name: "sync", Arity: 1, Flags: []string{FlagAdmin, FlagNoScript, FlagNoAsyncLoading, FlagNoMulti},
+		Group: "server", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "An internal command used in replication.",
+	},
+	{
+		Name: "replconf", Arity: -1, Flags: []string{FlagAdmin, FlagNoScript, FlagLoading, FlagStale, FlagAllowBusy},
+		Group: "server", Since: "3.0.0", Complexity: "O(1)",
+		Summary: "An internal command for configuring the replication stream.",
+	},
+	{
+		Name: "waitaof", Arity: 4, Flags: []string{FlagNoScript, FlagNoMulti},
+		Group: "generic", Since: "7.2.0", Complexity: "O(1)",
+		Summary:   "Blocks until all of the preceding write commands sent by the connection are written to the append-only file of the master and/or replicas.",
+		Arguments: "numlocal:integer numreplicas:integer timeout:integer",
+	},
+	{
+		Name: "asking", Arity: 1, Flags: []string{FlagFast},
+		Group: "cluster", Since: "3.0.0", Complexity: "O(1)",
+		Summary: "Signals that a cluster client is following an -ASK redirect.",
+	},
+	{
+		Name: "cluster", Arity: -2,
+		Group: "cluster", Since: "3.0.0", Complexity: "Depends on subcommand.",
+		Summary: "A container for Redis Cluster commands.",
+		Subcommands: []*CommandSpec{
+			{
+				Name: "addslots", Arity: -3, Flags: []string{FlagAdmin, FlagNoScript, FlagStale},
+				Since: "3.0.0", Complexity: "O(N) where N is the total number of hash slot arguments",
+				Summary:   "Assigns new hash slots to a node.",
+				Arguments: "slot:integer ...",
+			},
+			{
+				Name: "addslotsrange", Arity: -4, Flags: []string{FlagAdmin, FlagNoScript, FlagStale},
+				Since: "7.0.0", Complexity: "O(N) where N is the total number of the slots between the start slot and end slot arguments.",
+				Summary:   "Assigns new hash slot ranges to a node.",
+				Arguments: "(start-slot:integer end-slot:integer) ...",
+			},
+			{
+				Name: "countkeysinslot", Arity: 3, Flags: []string{FlagStale},
+				Since: "3.0.0", Complexity: "O(1)",
+				Summary:   "Returns the number of keys in a hash slot.",
+				Arguments: "slot:integer",
+			},
+			{
+				Name: "delslots", Arity: -3, Flags: []string{FlagAdmin, FlagNoScript, FlagStale},
+				Since: "3.0.0", Complexity: "O(N) where N is the total number of hash slot arguments",
+				Summary:   "Sets hash slots as unbound for a node.",
+				Arguments: "slot:integer ...",
+			},
+			{
+				Name: "delslotsrange", Arity: -4, Flags: []string{FlagAdmin, FlagNoScript, FlagStale},
+				Since: "7.0.0", Complexity: "O(N) where N is the total number of the slots between the start slot and end slot arguments.",
+				Summary:   "Sets hash slot ranges as unbound for a node.",
+				Arguments: "(start-slot:integer end-slot:integer) ...",
+			},
+			{
+				Name: "forget", Arity: 3, Flags: []string{FlagAdmin, FlagNoScript, FlagStale},
+				Since: "3.0.0", Complexity: "O(1)",
+				Summary:   "Removes a node from the nodes table.",
+				Arguments: "node-id",
+			},
+			{
+				Name: "getkeysinslot", Arity: 4, Flags: []string{FlagStale},
+				Since: "3.0.0", Complexity: "O(N) where N is the number of requested keys",
+				Summary:   "Returns the key names in a hash slot.",
+				Arguments: "slot:integer count:integer",
+			},
+			{
+				Name: "info", Arity: 2, Flags: []string{FlagStale},
+				Since: "3.0.0", Complexity: "O(1)",
+				Summary: "Returns information about the state of a node.",
+			},
+			{
+				Name: "keyslot", Arity: 3, Flags: []string{FlagStale},
+				Since: "3.0.0", Complexity: "O(N) where N is the number of bytes in the key",
+				Summary:   "Returns the hash slot for a key.",
+				Arguments: "key",
+			},
+			{
+				Name: "meet", Arity: -4, Flags: []string{FlagAdmin, FlagNoScript, FlagStale},
+				Since: "3.0.0", Complexity: "O(1)",
+				Summary:   "Forces a node to handshake with another node.",
+				Arguments: "ip port:integer [cluster-bus-port:integer]",
+			},
+			{
+				Name: "myid", Arity: 2, Flags: []string{FlagStale},
+				Since: "3.0.0", Complexity: "O(1)",
+				Summary: "Returns the ID of a node.",
+			},
+			{
+				Name: "nodes", Arity: 2, Flags: []string{FlagStale},
+				Since: "3.0.0", Complexity: "O(N) where N is the total number of Cluster nodes",
+				Summary: "Returns the cluster configuration for a node.",
+			},
+			{
+				Name: "saveconfig", Arity: 2, Flags: []string{FlagAdmin, FlagNoScript, FlagStale},
+				Since: "3.0.0", Complexity: "O(1)",
+				Summary: "Forces a node to save the cluster configuration to disk.",
+			},
+			{
+				Name: "setslot", Arity: -4, Flags: []string{FlagAdmin, FlagNoScript, FlagStale},
+				Since: "3.0.0", Complexity: "O(1)",
+				Summary:   "Binds a hash slot to a node.",
+				Arguments: "slot:integer <IMPORTING node-id|MIGRATING node-id|NODE node-id|STABLE>",
+			},
+			{
+				Name: "shards", Arity: 2, Flags: []string{FlagLoading, FlagStale},
+				Since: "7.0.0", Complexity: "O(N) where N is the total number of cluster nodes",
+				Summary: "Returns the mapping of cluster slots to shards.",
+			},
+			{
+				Name: "slots", Arity: 2, Flags: []string{FlagLoading, FlagStale},
+				Since: "3.0.0", Complexity: "O(N) where N is the total number of Cluster nodes",
+				Summary: "Returns the mapping of cluster slots to nodes.",
+			},
+		},
+	},
+	{
+		Name: "save", Arity: 1, Flags: []string{FlagAdmin, FlagNoScript, FlagNoAsyncLoading, FlagNoMulti},
+		Group: "server", Since: "1.0.0", Complexity: "O(N) where N is the total number of keys in all databases",
+		Summary: "Synchronously saves the database(s) to disk.",
+	},
+	{
+		Name: "bgsave", Arity: -1, Flags: []string{FlagAdmin, FlagNoScript, FlagNoAsyncLoading},
+		Group: "server", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Asynchronously saves the database(s) to disk.",
+		Arguments: "[SCHEDULE]",
+	},
+	{
+		Name: "lastsave", Arity: 1, Flags: []string{FlagLoading, FlagStale, FlagFast},
+		Categories: []string{"@dangerous"},
+		Group:      "server", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Returns the Unix timestamp of the last successful save to disk.",
+	},
+	{
+		Name: "bgrewriteaof", Arity: 1, Flags: []string{FlagAdmin, FlagNoScript, FlagNoAsyncLoading},
+		Group: "server", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Asynchronously rewrites the append-only file to disk.",
+	},
+	{
+		Name: "del", Arity: -2, Flags: []string{FlagWrite}, FirstKey: 1, LastKey: -1, Step: 1,
+		Group: "generic", Since: "1.0.0", Complexity: "O(N) where N is the number of keys that will be removed.",
+		Summary:   "Deletes one or more keys.",
+		Arguments: "key ...",
+	},
+	{
+		Name: "exists", Arity: -2, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: -1, Step: 1,
+		Group: "generic", Since: "1.0.0", Complexity: "O(N) where N is the number of keys to check.",
+		Summary:   "Determines whether one or more keys exist.",
+		Arguments: "key ...",
+	},
+	{
+		Name: "type", Arity: 2, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "generic", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Determines the type of value stored at a key.",
+		Arguments: "key",
+	},
+	{
+		Name: "expire", Arity: -3, Flags: []string{FlagWrite, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "generic", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Sets the expiration time of a key in seconds.",
+		Arguments: "key seconds:integer [NX|XX|GT|LT]",
+	},
+	{
+		Name: "pexpireat", Arity: -3, Flags: []string{FlagWrite, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "generic", Since: "2.6.0", Complexity: "O(1)",
+		Summary:   "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+		Arguments: "key unix-time-milliseconds:unix-time [NX|XX|GT|LT]",
+	},
+	{
+		Name: "dump", Arity: 2, Flags: []string{FlagReadOnly}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "generic", Since: "2.6.0", Complexity: "O(1) to access the key and additional O(N*M) to serialize it, where N is the number of Redis objects composing the value and M their average size.",
+		Summary:   "Returns a serialized representation of the value stored at a key.",
+		Arguments: "key",
+	},
+	{
+		Name: "restore", Arity: -4, Flags: []string{FlagWrite, FlagDenyOOM}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "generic", Since: "2.6.0", Complexity: "O(1) to create the new key and additional O(N*M) to reconstruct the serialized value, where N is the number of Redis objects composing the value and M their average size.",
+		Summary:   "Creates a key from the serialized representation of a value.",
+		Arguments: "key ttl:integer serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds:integer] [FREQ frequency:integer]",
+	},
+	{
+		Name: "restore-asking", Arity: -4, Flags: []string{FlagWrite, FlagDenyOOM, FlagAsking}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "server", Since: "3.0.0", Complexity: "O(1) to create the new key and additional O(N*M) to reconstruct the serialized value, where N is the number of Redis objects composing the value and M their average size.",
+		Summary:   "An internal command for migrating keys in a cluster.",
+		Arguments: "key ttl:integer serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds:integer] [FREQ frequency:integer]",
+	},
+	{
+		Name: "migrate", Arity: -6, Flags: []string{FlagWrite, FlagMovableKeys}, GetKeys: migrateKeys,
+		Group: "generic", Since: "2.6.0", Complexity: "This command actually executes a DUMP+DEL in the source instance, and a RESTORE in the target instance.",
+		Summary:   "Atomically transfers a key from one Redis instance to another.",
+		Arguments: "host port key|\"\" destination-db:integer timeout:integer [COPY] [REPLACE] [AUTH password|AUTH2 username password] [KEYS key ...]",
+	},
+	{
+		Name: "set", Arity: -3, Flags: []string{FlagWrite, FlagDenyOOM}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "string", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+		Arguments: "key value [NX|XX] [GET] [EX seconds:integer|PX milliseconds:integer|EXAT unix-time-seconds:unix-time|PXAT unix-time-milliseconds:unix-time|KEEPTTL]",
+	},
+	{
+		Name: "get", Arity: 2, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "string", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Returns the string value of a key.",
+		Arguments: "key",
+	},
+	{
+		Name: "incr", Arity: 2, Flags: []string{FlagWrite, FlagDenyOOM, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "string", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+		Arguments: "key",
+	},
+	{
+		Name: "decr", Arity: 2, Flags: []string{FlagWrite, FlagDenyOOM, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "string", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Decrements the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+		Arguments: "key",
+	},
+	{
+		Name: "lpush", Arity: -3, Flags: []string{FlagWrite, FlagDenyOOM, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "list", Since: "1.0.0", Complexity: "O(1) for each element added, so O(N) to add N elements when the command is called with multiple arguments.",
+		Summary:   "Prepends one or more elements to a list. Creates the key if it doesn't exist.",
+		Arguments: "key element ...",
+	},
+	{
+		Name: "rpush", Arity: -3, Flags: []string{FlagWrite, FlagDenyOOM, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "list", Since: "1.0.0", Complexity: "O(1) for each element added, so O(N) to add N elements when the command is called with multiple arguments.",
+		Summary:   "Appends one or more elements to a list. Creates the key if it doesn't exist.",
+		Arguments: "key element ...",
+	},
+	{
+		Name: "lpop", Arity: -2, Flags: []string{FlagWrite, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "list", Since: "1.0.0", Complexity: "O(N) where N is the number of elements returned",
+		Summary:   "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.",
+		Arguments: "key [count:integer]",
+	},
+	{
+		Name: "rpop", Arity: -2, Flags: []string{FlagWrite, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "list", Since: "1.0.0", Complexity: "O(N) where N is the number of elements returned",
+		Summary:   "Returns and removes the last elements of a list. Deletes the list if the last element was popped.",
+		Arguments: "key [count:integer]",
+	},
+	{
+		Name: "llen", Arity: 2, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "list", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Returns the length of a list.",
+		Arguments: "key",
+	},
+	{
+		Name: "lrange", Arity: 4, Flags: []string{FlagReadOnly}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "list", Since: "1.0.0", Complexity: "O(S+N) where S is the distance of start offset from HEAD for small lists, from nearest end (HEAD or TAIL) for large lists; and N is the number of elements in the specified range.",
+		Summary:   "Returns a range of elements from a list.",
+		Arguments: "key start:integer stop:integer",
+	},
+	{
+		Name: "hset", Arity: -4, Flags: []string{FlagWrite, FlagDenyOOM, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "hash", Since: "2.0.0", Complexity: "O(1) for each field/value pair added, so O(N) to add N field/value pairs when the command is called with multiple field/value pairs.",
+		Summary:   "Creates or modifies the value of a field in a hash.",
+		Arguments: "key (field value)...",
+	},
+	{
+		Name: "hget", Arity: 3, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "hash", Since: "2.0.0", Complexity: "O(1)",
+		Summary:   "Returns the value of a field in a hash.",
+		Arguments: "key field",
+	},
+	{
+		Name: "hdel", Arity: -3, Flags: []string{FlagWrite, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "hash", Since: "2.0.0", Complexity: "O(N) where N is the number of fields to be removed.",
+		Summary:   "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.",
+		Arguments: "key field ...",
+	},
+	{
+		Name: "hexists", Arity: 3, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "hash", Since: "2.0.0", Complexity: "O(1)",
+		Summary:   "Determines whether a field exists in a hash.",
+		Arguments: "key field",
+	},
+	{
+		Name: "hlen", Arity: 2, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "hash", Since: "2.0.0", Complexity: "O(1)",
+		Summary:   "Returns the number of fields in a hash.",
+		Arguments: "key",
+	},
+	{
+		Name: "hgetall", Arity: 2, Flags: []string{FlagReadOnly}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "hash", Since: "2.0.0", Complexity: "O(N) where N is the size of the hash.",
+		Summary:   "Returns all fields and values in a hash.",
+		Arguments: "key",
+	},
+	{
+		Name: "hkeys", Arity: 2, Flags: []string{FlagReadOnly}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "hash", Since: "2.0.0", Complexity: "O(N) where N is the size of the hash.",
+		Summary:   "Returns all fields in a hash.",
+		Arguments: "key",
+	},
+	{
+		Name: "hvals", Arity: 2, Flags: []string{FlagReadOnly}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "hash", Since: "2.0.0", Complexity: "O(N) where N is the size of the hash.",
+		Summary:   "Returns all values in a hash.",
+		Arguments: "key",
+	},
+	{
+		Name: "sadd", Arity: -3, Flags: []string{FlagWrite, FlagDenyOOM, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "set", Since: "1.0.0", Complexity: "O(1) for each element added, so O(N) to add N elements when the command is called with multiple arguments.",
+		Summary:   "Adds one or more members to a set. Creates the key if it doesn't exist.",
+		Arguments: "key member ...",
+	},
+	{
+		Name: "srem", Arity: -3, Flags: []string{FlagWrite, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "set", Since: "1.0.0", Complexity: "O(N) where N is the number of members to be removed.",
+		Summary:   "Removes one or more members from a set. Deletes the set if the last member was removed.",
+		Arguments: "key member ...",
+	},
+	{
+		Name: "sismember", Arity: 3, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "set", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Determines whether a member belongs to a set.",
+		Arguments: "key member",
+	},
+	{
+		Name: "smembers", Arity: 2, Flags: []string{FlagReadOnly}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "set", Since: "1.0.0", Complexity: "O(N) where N is the set cardinality.",
+		Summary:   "Returns all members of a set.",
+		Arguments: "key",
+	},
+	{
+		Name: "scard", Arity: 2, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "set", Since: "1.0.0", Complexity: "O(1)",
+		Summary:   "Returns the number of members in a set.",
+		Arguments: "key",
+	},
+	{
+		Name: "spop", Arity: -2, Flags: []string{FlagWrite, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "set", Since: "1.0.0", Complexity: "Without the count argument O(1), otherwise O(N) where N is the value of the passed count.",
+		Summary:   "Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.",
+		Arguments: "key [count:integer]",
+	},
+	{
+		Name: "zadd", Arity: -4, Flags: []string{FlagWrite, FlagDenyOOM, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "sorted-set", Since: "1.2.0", Complexity: "O(log(N)) for each item added, where N is the number of elements in the sorted set.",
+		Summary:   "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.",
+		Arguments: "key [NX|XX] [GT|LT] [CH] [INCR] (score:double member)...",
+	},
+	{
+		Name: "zrem", Arity: -3, Flags: []string{FlagWrite, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "sorted-set", Since: "1.2.0", Complexity: "O(M*log(N)) with N being the number of elements in the sorted set and M the number of elements to be removed.",
+		Summary:   "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.",
+		Arguments: "key member ...",
+	},
+	{
+		Name: "zscore", Arity: 3, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "sorted-set", Since: "1.2.0", Complexity: "O(1)",
+		Summary:   "Returns the score of a member in a sorted set.",
+		Arguments: "key member",
+	},
+	{
+		Name: "zcard", Arity: 2, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "sorted-set", Since: "1.2.0", Complexity: "O(1)",
+		Summary:   "Returns the number of members in a sorted set.",
+		Arguments: "key",
+	},
+	{
+		Name: "zrank", Arity: -3, Flags: []string{FlagReadOnly, FlagFast}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "sorted-set", Since: "2.0.0", Complexity: "O(log(N))",
+		Summary:   "Returns the index of a member in a sorted set ordered by ascending scores.",
+		Arguments: "key member [WITHSCORE]",
+	},
+	{
+		Name: "zrange", Arity: -4, Flags: []string{FlagReadOnly}, FirstKey: 1, LastKey: 1, Step: 1,
+		Group: "sorted-set", Since: "1.2.0", Complexity: "O(log(N)+M) with N being the number of elements in the sorted set and M the number of elements returned.",
+		Summary:   "Returns members in a sorted set within a range of indexes.",
+		Arguments: "key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset:integer count:integer] [WITHSCORES]",
+	},
+	{
+		Name: "multi", Arity: 1, Flags: []string{FlagNoScript, FlagLoading, FlagStale, FlagFast, FlagAllowBusy},
+		Group: "transactions", Since: "1.2.0", Complexity: "O(1)",
+		Summary: "Starts a transaction.",
+	},
+	{
+		Name: "exec", Arity: 1, Flags: []string{FlagNoScript, FlagLoading, FlagStale, FlagSkipSlowlog},
+		Group: "transactions", Since: "1.2.0", Complexity: "Depends on commands in the transaction",
+		Summary: "Executes all commands in a transaction.",
+	},
+	{
+		Name: "discard", Arity: 1, Flags: []string{FlagNoScript, FlagLoading, FlagStale, FlagFast, FlagAllowBusy},
+		Group: "transactions", Since: "2.0.0", Complexity: "O(N), when N is the number of queued commands",
+		Summary: "Discards a transaction.",
+	},
+	{
+		Name: "watch", Arity: -2, Flags: []string{FlagNoScript, FlagLoading, FlagStale, FlagFast, FlagAllowBusy}, FirstKey: 1, LastKey: -1, Step: 1,
+		Group: "transactions", Since: "2.2.0", Complexity: "O(1) for every key.",
+		Summary:   "Monitors changes to keys to determine the execution of a transaction.",
+		Arguments: "key ...",
+	},
+	{
+		Name: "unwatch", Arity: 1, Flags: []string{FlagNoScript, FlagLoading, FlagStale, FlagFast, FlagAllowBusy},
+		Group: "transactions", Since: "2.2.0", Complexity: "O(1)",
+		Summary: "Forgets about watched keys of a transaction.",
+	},
+	{
+		Name: "eval", Arity: -3, Flags: []string{FlagNoScript, FlagStale, FlagSkipMonitor, FlagMayReplicate, FlagNoMandatoryKey, FlagMovableKeys}, KeyNum: 2,
+		Group: "scripting", Since: "2.6.0", Complexity: "Depends on the script that is executed.",
+		Summary:   "Executes a server-side Lua script.",
+		Arguments: "script numkeys:integer [key ...] [arg ...]",
+	},
+	{
+		Name: "evalsha", Arity: -3, Flags: []string{FlagNoScript, FlagStale, FlagSkipMonitor, FlagMayReplicate, FlagNoMandatoryKey, FlagMovableKeys}, KeyNum: 2,
+		Group: "scripting", Since: "2.6.0", Complexity: "Depends on the script that is executed.",
+		Summary:   "Executes a server-side Lua script by SHA1 digest.",
+		Arguments: "sha1 numkeys:integer [key ...] [arg ...]",
+	},
+	{
+		Name: "script", Arity: -2,
+		Group: "scripting", Since: "2.6.0", Complexity: "Depends on subcommand.",
+		Summary: "A container for Lua scripts management commands.",
+		Subcommands: []*CommandSpec{
+			{
+				Name: "exists", Arity: -3, Flags: []string{FlagNoScript},
+				Since: "2.6.0", Complexity: "O(N) with N being the number of scripts to check (so checking a single script is an O(1) operation).",
+				Summary:   "Determines whether server-side Lua scripts exist in the script cache.",
+				Arguments: "sha1 ...",
+			},
+			{
+				Name: "flush", Arity: -2, Flags: []string{FlagNoScript},
+				Since: "2.6.0", Complexity: "O(N) with N being the number of scripts in cache",
+				Summary:   "Removes all server-side Lua scripts from the script cache.",
+				Arguments: "[ASYNC|SYNC]",
+			},
+			{
+				Name: "kill", Arity: 2, Flags: []string{FlagNoScript, FlagAllowBusy},
+				Since: "2.6.0", Complexity: "O(1)",
+				Summary: "Terminates a server-side Lua script during execution.",
+			},
+			{
+				Name: "load", Arity: 3, Flags: []string{FlagNoScript, FlagStale},
+				Since: "2.6.0", Complexity: "O(N) with N being the length in bytes of the script body.",
+				Summary:   "Loads a server-side Lua script to the script cache.",
+				Arguments: "script",
+			},
+		},
+	},
+	{
+		Name: "function", Arity: -2,
+		Group: "scripting", Since: "7.0.0", Complexity: "Depends on subcommand.",
+		Summary: "A container for function commands.",
+		Subcommands: []*CommandSpec{
+			{
+				Name: "delete", Arity: 3, Flags: []string{FlagWrite, FlagNoScript},
+				Since: "7.0.0", Complexity: "O(1)",
+				Summary:   "Deletes a library and its functions.",
+				Arguments: "library-name",
+			},
+			{
+				Name: "dump", Arity: 2, Flags: []string{FlagNoScript},
+				Since: "7.0.0", Complexity: "O(N) where N is the number of functions",
+				Summary: "Dumps all libraries into a serialized binary payload.",
+			},
+			{
+				Name: "flush", Arity: -2, Flags: []string{FlagWrite, FlagNoScript},
+				Since: "7.0.0", Complexity: "O(N) where N is the number of functions deleted",
+				Summary:   "Deletes all libraries and functions.",
+				Arguments: "[ASYNC|SYNC]",
+			},
+			{
+				Name: "kill", Arity: 2, Flags: []string{FlagNoScript, FlagAllowBusy},
+				Since: "7.0.0", Complexity: "O(1)",
+				Summary: "Terminates a function during execution.",
+			},
+			{
+				Name: "list", Arity: -2, Flags: []string{FlagNoScript},
+				Since: "7.0.0", Complexity: "O(N) where N is the number of functions",
+				Summary:   "Returns information about all libraries.",
+				Arguments: "[LIBRARYNAME library-name-pattern] [WITHCODE]",
+			},
+			{
+				Name: "load", Arity: -3, Flags: []string{FlagWrite, FlagDenyOOM, FlagNoScript},
+				Since: "7.0.0", Complexity: "O(1) (considering compilation time is redundant)",
+				Summary:   "Creates a library.",
+				Arguments: "[REPLACE] function-code",
+			},
+			{
+				Name: "restore", Arity: -3, Flags: []string{FlagWrite, FlagDenyOOM, FlagNoScript},
+				Since: "7.0.0", Complexity: "O(N) where N is the number of functions on the payload",
+				Summary:   "Restores all libraries from a payload.",
+				Arguments: "serialized-value [FLUSH|APPEND|REPLACE]",
+			},
+		},
+	},
+	{
+		Name: "fcall", Arity: -3, Flags: []string{FlagNoScript, FlagStale, FlagSkipMonitor, FlagMayReplicate, FlagNoMandatoryKey, FlagMovableKeys}, KeyNum: 2,
+		Group: "scripting", Since: "7.0.0", Complexity: "Depends on the function that is executed.",
+		Summary:   "Invokes a function.",
+		Arguments: "function numkeys:integer [key ...] [arg ...]",
+	},
+	{
+		Name: "fcall_ro", Arity: -3, Flags: []string{FlagReadOnly, FlagNoScript, FlagStale, FlagSkipMonitor, FlagNoMandatoryKey, FlagMovableKeys}, KeyNum: 2,
+		Group: "scripting", Since: "7.0.0", Complexity: "Depends on the function that is executed.",
+		Summary:   "Invokes a read-only function.",
+		Arguments: "function numkeys:integer [key ...] [arg ...]",
+	},
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+const (
+	FlagWrite          = "write"
+	FlagReadOnly       = "readonly"
+	FlagDenyOOM        = "denyoom"
+	FlagAdmin          = "admin"
+	FlagFast           = "fast"
+	FlagNoScript       = "noscript"
+	FlagLoading        = "loading"
+	FlagStale          = "stale"
+	FlagNoAuth         = "no_auth"
+	FlagNoMulti        = "no_multi"
+	FlagAllowBusy      = "allow_busy"
+	FlagSkipMonitor    = "skip_monitor"
+	FlagSkipSlowlog    = "skip_slowlog"
+	FlagMayReplicate   = "may_replicate"
+	FlagNoAsyncLoading = "no_async_loading"
+	FlagNoMandatoryKey = "no_mandatory_keys"
+	FlagMovableKeys    = "movablekeys"
+	FlagAsking         = "asking"
+)
+
+var (
+	ErrInvalidCommand      = errors.New("ERR Invalid command specified")
+	ErrInvalidCommandArgs  = errors.New("ERR Invalid number of arguments specified for command")
+	ErrInvalidKeyArguments = errors.New("ERR Invalid arguments specified for command")
+	ErrNoKeyArguments      = errors.New("ERR The command has no key arguments")
+)
+
+type CommandSpec struct {
+	Name        string
+	Arity       int
+	Flags       []string
+	FirstKey    int
+	LastKey     int
+	Step        int
+	KeyNum      int
+	Categories  []string
+	Group       string
+	Summary     string
+	Since       string
+	Complexity  string
+	Arguments   string
+	Subcommands []*CommandSpec
+	// GetKeys finds the keys of commands whose key positions depend on
+	// their options.
+	GetKeys func(args []resp.Value) ([]string, error)
+
+	parent *CommandSpec
+}
+
+type Command struct {
+	Spec    *CommandSpec
+	Handler CommandHandler
+}
+
+func (c *CommandSpec) HasFlag(flag string) bool {
+	for _, candidate := range c.Flags {
+		if candidate == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CommandSpec) FullName() string {
+	if c.parent != nil {
+		return c.parent.Name + "|" + c.Name
+	}
+	return c.Name
+}
+
+func (c *CommandSpec) Subcommand(name string) *CommandSpec {
+	for _, sub := range c.Subcommands {
+		if strings.EqualFold(sub.Name, name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+func (c *CommandSpec) Resolve(args []resp.Value) *CommandSpec {
+	if len(c.Subcommands) > 0 && len(args) > 1 {
+		if sub := c.Subcommand(args[1].Str); sub != nil {
+			return sub
+		}
+	}
+	return c
+}
+
+func (c *CommandSpec) CheckArity(argc int) bool {
+	if c.Arity >= 0 {
+		return argc == c.Arity
+	}
+	return argc >= -c.Arity
+}
+
+func (c *CommandSpec) ACLCategories() []string {
+	var categories []string
+	if c.HasFlag(FlagWrite) {
+		categories = append(categories, "@write")
+	}
+	if c.HasFlag(FlagReadOnly) {
+		categories = append(categories, "@read")
+	}
+	if c.HasFlag(FlagAdmin) {
+		categories = append(categories, "@admin", "@dangerous")
+	}
+	if c.HasFlag(FlagFast) {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	if group, exists := groupCategories[c.Group]; exists {
+		categories = append(categories, group)
+	}
+	for _, category := range c.Categories {
+		if !containsString(categories, category) {
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
+
+func (c *CommandSpec) Keys(args []resp.Value) ([]string, error) {
+	if c.GetKeys != nil {
+		return c.GetKeys(args)
+	}
+	if c.KeyNum > 0 {
+		if len(args) <= c.KeyNum {
+			return nil, ErrInvalidKeyArguments
+		}
+		numKeys, err := strconv.Atoi(args[c.KeyNum].Str)
+		if err != nil || numKeys < 0 || c.KeyNum+numKeys >= len(args) {
+			return nil, ErrInvalidKeyArguments
+		}
+
+		keys := make([]string, numKeys)
+		for i := range keys {
+			keys[i] = args[c.KeyNum+1+i].Str
+		}
+		return keys, nil
+	}
+
+	if c.FirstKey == 0 {
+		return nil, nil
+	}
+
+	last := c.LastKey
+	if last < 0 {
+		last = len(args) + last
+	}
+
+	var keys []string
+	for i := c.FirstKey; i <= last && i < len(args); i += c.Step {
+		keys = append(keys, args[i].Str)
+	}
+	return keys, nil
+}
+
+// migrateKeys returns the key of MIGRATE, or the keys following KEYS when
+// the key argument is empty.
+func migrateKeys(args []resp.Value) ([]string, error) {
+	if len(args) < 6 {
+		return nil, ErrInvalidKeyArguments
+	}
+	if args[3].Str != "" {
+		return []string{args[3].Str}, nil
+	}
+	for i := 6; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Str) {
+		case "KEYS":
+			var keys []string
+			for _, arg := range args[i+1:] {
+				keys = append(keys, arg.Str)
+			}
+			return keys, nil
+		case "AUTH":
+			i++
+		case "AUTH2":
+			i += 2
+		}
+	}
+	return nil, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+var groupCategories = map[string]string{
+	"connection":   "@connection",
+	"generic":      "@keyspace",
+	"string":       "@string",
+	"list":         "@list",
+	"hash":         "@hash",
+	"set":          "@set",
+	"sorted-set":   "@sortedset",
+	"transactions": "@transaction",
+	"scripting":    "@scripting",
+}
+
+var commandTable = buildCommandTable(defaultCommandSpecs)
+
+func buildCommandTable(specs []*CommandSpec) map[string]*CommandSpec {
+	table := make(map[string]*CommandSpec, len(specs))
+	for _, spec := range specs {
+		for _, sub := range spec.Subcommands {
+			sub.parent = spec
+			if sub.Group == "" {
+				sub.Group = spec.Group
+			}
+		}
+		table[strings.ToUpper(spec.Name)] = spec
+	}
+	return table
+}
+
+func lookupSpec(name string) *CommandSpec {
+	return commandTable[strings.ToUpper(name)]
+}
+
+func (s *Server) commandSpec(name string) *CommandSpec {
+	if cmd, exists := s.commands[strings.ToUpper(name)]; exists {
+		return cmd.Spec
+	}
+	return lookupSpec(name)
+}
+
+func (s *Server) validateCommand(args []resp.Value) (*CommandSpec, string, bool) {
+	cmd, exists := s.commands[strings.ToUpper(args[0].Str)]
+	if !exists {
+		return nil, unknownCommandError(args), false
+	}
+
+	spec := cmd.Spec.Resolve(args)
+	if spec != cmd.Spec && !spec.CheckArity(len(args)) {
+		return nil, fmt.Sprintf("ERR wrong number of arguments for '%s' command", spec.FullName()), false
+	}
+	if !cmd.Spec.CheckArity(len(args)) {
+		return nil, fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd.Spec.Name), false
+	}
+	return spec, "", true
+}
+
+func unknownCommandError(args []resp.Value) string {
+	var sb strings.Builder
+	for _, arg := range args[1:] {
+		fmt.Fprintf(&sb, "'%s' ", arg.Str)
+	}
+	return fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", args[0].Str, sb.String())
+}
+
+func (s *Server) sortedCommands() []*Command {
+	commands := make([]*Command, 0, len(s.commands))
+	for _, cmd := range s.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Spec.Name < commands[j].Spec.Name })
+	return commands
+}
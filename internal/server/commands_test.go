@@ -0,0 +1,338 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+func TestCommandTableConsistency(t *testing.T) {
+	for name, spec := range commandTable {
+		if strings.ToUpper(spec.Name) != name {
+			t.Errorf("%s: table key does not match spec name %q", name, spec.Name)
+		}
+		if spec.Arity == 0 {
+			t.Errorf("%s: arity must not be zero", name)
+		}
+		if spec.FirstKey > 0 && spec.Step <= 0 {
+			t.Errorf("%s: key step must be positive", name)
+		}
+		if spec.Summary == "" || spec.Group == "" || spec.Since == "" {
+			t.Errorf("%s: missing documentation", name)
+		}
+		if spec.HasFlag(FlagWrite) && spec.HasFlag(FlagReadOnly) {
+			t.Errorf("%s: cannot be both write and readonly", name)
+		}
+		for _, sub := range spec.Subcommands {
+			if sub.parent != spec {
+				t.Errorf("%s|%s: parent not linked", name, sub.Name)
+			}
+		}
+	}
+}
+
+func newCommandTestServer() (*Server, *Client) {
+	server := NewServer()
+	noop := func(args []resp.Value) resp.Value { return resp.OKValue() }
+	for _, name := range []string{"GET", "SET", "DEL", "PING", "EVAL", "CONFIG", "FUNCTION", "MIGRATE"} {
+		server.RegisterCommand(name, noop)
+	}
+	return server, &Client{watchedKeys: make(map[string]bool)}
+}
+
+func TestCommandCount(t *testing.T) {
+	server, client := newCommandTestServer()
+
+	result := server.processCommand(client, commandValue("COMMAND", "COUNT"))
+	if result.Type != resp.Integer || result.Int != int64(len(server.commands)) {
+		t.Errorf("Expected COMMAND COUNT %d, got %v", len(server.commands), result)
+	}
+
+	result = server.processCommand(client, commandValue("COMMAND"))
+	if result.Type != resp.Array || len(result.Array) != len(server.commands) {
+		t.Errorf("Expected %d command entries, got %d", len(server.commands), len(result.Array))
+	}
+}
+
+func TestCommandInfo(t *testing.T) {
+	server, client := newCommandTestServer()
+
+	result := server.processCommand(client, commandValue("COMMAND", "INFO", "set", "nosuchcommand", "config|get"))
+	if result.Type != resp.Array || len(result.Array) != 3 {
+		t.Fatalf("Expected 3 entries, got %v", result)
+	}
+
+	set := result.Array[0]
+	if len(set.Array) != 10 || set.Array[0].Str != "set" || set.Array[1].Int != -3 {
+		t.Fatalf("Unexpected SET info: %v", set)
+	}
+	if set.Array[2].Type != resp.Set || set.Array[2].Array[0].Str != FlagWrite {
+		t.Errorf("Expected write flag first, got %v", set.Array[2])
+	}
+	if set.Array[3].Int != 1 || set.Array[4].Int != 1 || set.Array[5].Int != 1 {
+		t.Errorf("Unexpected key positions: %v", set.Array[3:6])
+	}
+
+	var categories []string
+	for _, category := range set.Array[6].Array {
+		categories = append(categories, category.Str)
+	}
+	if strings.Join(categories, " ") != "@write @slow @string" {
+		t.Errorf("Unexpected ACL categories: %v", categories)
+	}
+	if len(set.Array[8].Array) != 1 {
+		t.Errorf("Expected one key spec, got %v", set.Array[8])
+	}
+
+	if !result.Array[1].Null {
+		t.Errorf("Expected null for unknown command, got %v", result.Array[1])
+	}
+	if result.Array[2].Array[0].Str != "config|get" {
+		t.Errorf("Expected config|get info, got %v", result.Array[2])
+	}
+}
+
+func TestCommandInfoSubcommands(t *testing.T) {
+	server, client := newCommandTestServer()
+
+	result := server.processCommand(client, commandValue("COMMAND", "INFO", "function"))
+	subcommands := result.Array[0].Array[9].Array
+	if len(subcommands) != 7 {
+		t.Fatalf("Expected 7 FUNCTION subcommands, got %d", len(subcommands))
+	}
+	if subcommands[0].Array[0].Str != "function|delete" {
+		t.Errorf("Expected function|delete, got %v", subcommands[0].Array[0])
+	}
+}
+
+func TestCommandDocs(t *testing.T) {
+	server, client := newCommandTestServer()
+
+	result := server.processCommand(client, commandValue("COMMAND", "DOCS", "set"))
+	if result.Type != resp.Map || len(result.Array) != 2 || result.Array[0].Str != "set" {
+		t.Fatalf("Unexpected COMMAND DOCS reply: %v", result)
+	}
+
+	docs := valueMap(result.Array[1])
+	if docs["group"].Str != "string" || docs["since"].Str != "1.0.0" || docs["summary"].Str == "" {
+		t.Errorf("Unexpected docs: %v", docs)
+	}
+
+	args := docs["arguments"].Array
+	if len(args) != 5 {
+		t.Fatalf("Expected 5 SET arguments, got %d", len(args))
+	}
+	key := valueMap(args[0])
+	if key["name"].Str != "key" || key["type"].Str != "key" {
+		t.Errorf("Unexpected key argument: %v", key)
+	}
+
+	expiration := valueMap(args[4])
+	if expiration["type"].Str != "oneof" || len(expiration["arguments"].Array) != 5 {
+		t.Fatalf("Unexpected expiration argument: %v", expiration)
+	}
+	ex := valueMap(expiration["arguments"].Array[0])
+	if ex["name"].Str != "seconds" || ex["type"].Str != "integer" || ex["token"].Str != "EX" {
+		t.Errorf("Unexpected EX argument: %v", ex)
+	}
+}
+
+func valueMap(v resp.Value) map[string]resp.Value {
+	fields := make(map[string]resp.Value)
+	for i := 0; i+1 < len(v.Array); i += 2 {
+		fields[v.Array[i].Str] = v.Array[i+1]
+	}
+	return fields
+}
+
+func TestCommandGetKeys(t *testing.T) {
+	server, client := newCommandTestServer()
+
+	tests := []struct {
+		args     []string
+		expected string
+		err      string
+	}{
+		{[]string{"SET", "k", "v"}, "k", ""},
+		{[]string{"DEL", "a", "b", "c"}, "a b c", ""},
+		{[]string{"EVAL", "return 1", "2", "k1", "k2", "arg"}, "k1 k2", ""},
+		{[]string{"EVAL", "return 1", "5", "k1"}, "", ErrInvalidKeyArguments.Error()},
+		{[]string{"MIGRATE", "host", "6379", "k", "0", "1000", "COPY"}, "k", ""},
+		{[]string{"MIGRATE", "host", "6379", "", "0", "1000", "AUTH2", "user", "KEYS", "KEYS", "k1", "k2"}, "k1 k2", ""},
+		{[]string{"PING"}, "", ErrNoKeyArguments.Error()},
+		{[]string{"GET"}, "", ErrInvalidCommandArgs.Error()},
+		{[]string{"NOSUCH", "x"}, "", ErrInvalidCommand.Error()},
+	}
+
+	for _, tt := range tests {
+		result := server.processCommand(client, commandValue(append([]string{"COMMAND", "GETKEYS"}, tt.args...)...))
+		if tt.err != "" {
+			if result.Type != resp.Error || result.Str != tt.err {
+				t.Errorf("%v: expected %q, got %v", tt.args, tt.err, result)
+			}
+			continue
+		}
+
+		var keys []string
+		for _, key := range result.Array {
+			keys = append(keys, key.Str)
+		}
+		if strings.Join(keys, " ") != tt.expected {
+			t.Errorf("%v: expected keys %q, got %v", tt.args, tt.expected, keys)
+		}
+	}
+}
+
+func TestSubcommandArity(t *testing.T) {
+	server, client := newCommandTestServer()
+
+	result := server.processCommand(client, commandValue("CONFIG", "GET"))
+	if result.Type != resp.Error || result.Str != "ERR wrong number of arguments for 'config|get' command" {
+		t.Errorf("Expected subcommand arity error, got %v", result)
+	}
+}
+
+func TestDenyOOMCommands(t *testing.T) {
+	server, client := newCommandTestServer()
+	server.SetOOMCheck(func() error { return errors.New("OOM") })
+
+	result := server.processCommand(client, commandValue("SET", "k", "v"))
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "OOM command not allowed") {
+		t.Errorf("Expected OOM error for SET, got %v", result)
+	}
+
+	result = server.processCommand(client, commandValue("GET", "k"))
+	if result.Type == resp.Error {
+		t.Errorf("GET should be allowed when out of memory, got %v", result)
+	}
+
+	result = server.processCommand(client, commandValue("DEL", "k"))
+	if result.Type == resp.Error {
+		t.Errorf("DEL should be allowed when out of memory, got %v", result)
+	}
+
+	server.processCommand(client, commandValue("MULTI"))
+	server.processCommand(client, commandValue("SET", "k", "v"))
+	result = server.processCommand(client, commandValue("EXEC"))
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "EXECABORT") {
+		t.Errorf("Expected EXECABORT after OOM rejection, got %v", result)
+	}
+}
+
+func TestRegisterCustomSpec(t *testing.T) {
+	server := NewServer()
+	server.Register(&CommandSpec{Name: "mywrite", Arity: 2, Flags: []string{FlagWrite}, FirstKey: 1, LastKey: 1, Step: 1},
+		func(args []resp.Value) resp.Value { return resp.OKValue() })
+
+	if !server.IsWriteCommand("MYWRITE") {
+		t.Error("Custom spec flags should drive IsWriteCommand")
+	}
+
+	client := &Client{watchedKeys: make(map[string]bool)}
+	result := server.processCommand(client, commandValue("MYWRITE"))
+	if result.Type != resp.Error || result.Str != "ERR wrong number of arguments for 'mywrite' command" {
+		t.Errorf("Expected arity error from custom spec, got %v", result)
+	}
+}
+
+func TestParseArgumentSyntax(t *testing.T) {
+	args := parseArgumentSyntax("key [NX|XX] (score:double member)... [LIMIT offset count]")
+	if len(args) != 4 {
+		t.Fatalf("Expected 4 arguments, got %d", len(args))
+	}
+
+	if args[1].Type != "oneof" || !args[1].Optional || len(args[1].Args) != 2 || args[1].Args[0].Token != "NX" {
+		t.Errorf("Unexpected oneof argument: %+v", args[1])
+	}
+
+	data := args[2]
+	if data.Type != "block" || !data.Multiple || data.Optional || len(data.Args) != 2 || data.Args[0].Type != "double" {
+		t.Errorf("Unexpected block argument: %+v", data)
+	}
+
+	limit := args[3]
+	if limit.Type != "block" || limit.Token != "LIMIT" || !limit.Optional || len(limit.Args) != 2 {
+		t.Errorf("Unexpected LIMIT block: %+v", limit)
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	server, client := newCommandTestServer()
+
+	server.processCommand(client, commandValue("GET", "k"))
+	server.processCommand(client, commandValue("SET", "k", "v"))
+	server.processCommand(client, commandValue("NOSUCH"))
+	server.processCommand(client, commandValue("GET"))
+
+	stats := server.Stats()
+	if stats.CommandsProcessed != 2 {
+		t.Errorf("Expected 2 processed commands, got %d", stats.CommandsProcessed)
+	}
+	if stats.RejectedCalls != 2 {
+		t.Errorf("Expected 2 rejected calls, got %d", stats.RejectedCalls)
+	}
+
+	server.ResetStats()
+	if server.Stats() != (Stats{}) {
+		t.Errorf("Expected stats to be reset, got %+v", server.Stats())
+	}
+}
+
+func TestWriteCheck(t *testing.T) {
+	server, client := newCommandTestServer()
+	server.SetWriteCheck(func() error { return errors.New("MISCONF cannot persist") })
+
+	result := server.processCommand(client, commandValue("SET", "k", "v"))
+	if result.Type != resp.Error || result.Str != "MISCONF cannot persist" {
+		t.Errorf("Expected write to be refused, got %v", result)
+	}
+
+	result = server.processCommand(client, commandValue("GET", "k"))
+	if result.Type == resp.Error {
+		t.Errorf("Reads should not be refused, got %v", result)
+	}
+
+	result = server.Call([]resp.Value{resp.BulkStringValue("SET"), resp.BulkStringValue("k"), resp.BulkStringValue("v")})
+	if result.Type != resp.Error {
+		t.Errorf("Expected scripted write to be refused, got %v", result)
+	}
+
+	// A save failing after the write was queued fails the EXEC.
+	writes := 0
+	server.RegisterCommand("SET", func(args []resp.Value) resp.Value {
+		writes++
+		return resp.OKValue()
+	})
+	server.SetWriteCheck(nil)
+	server.processCommand(client, commandValue("MULTI"))
+	server.processCommand(client, commandValue("SET", "k", "v"))
+	server.SetWriteCheck(func() error { return errors.New("MISCONF cannot persist") })
+	result = server.processCommand(client, commandValue("EXEC"))
+	if result.Type != resp.Error || result.Str != "EXECABORT Transaction discarded because of: MISCONF cannot persist" {
+		t.Errorf("Expected EXEC to be refused, got %v", result)
+	}
+	if writes != 0 {
+		t.Errorf("Expected the queued write not to run, ran %d times", writes)
+	}
+
+	server.processCommand(client, commandValue("MULTI"))
+	server.processCommand(client, commandValue("GET", "k"))
+	if result := server.processCommand(client, commandValue("EXEC")); result.Type != resp.Array {
+		t.Errorf("Expected a read-only transaction to run, got %v", result)
+	}
+}
+
+func TestCronJobs(t *testing.T) {
+	server := NewServer()
+	runs := 0
+	server.AddCronJob(func() { runs++ })
+
+	delay, _ := server.OnTick()
+	server.OnTick()
+
+	if runs != 2 || delay != CronInterval {
+		t.Errorf("Expected 2 runs every %v, got %d runs with delay %v", CronInterval, runs, delay)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+func commandValue(args ...string) resp.Value {
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.BulkStringValue(arg)
+	}
+	return resp.ArrayValue(values...)
+}
+
+func TestHelloSwitchesProtocol(t *testing.T) {
+	server := NewServer()
+	conn := &mockConn{}
+	server.OnOpen(conn)
+	client := server.clients[conn]
+
+	result := server.processCommand(client, commandValue("HELLO", "3"))
+	if result.Type != resp.Map {
+		t.Fatalf("Expected map reply, got %+v", result)
+	}
+
+	fields := make(map[string]resp.Value)
+	for i := 0; i+1 < len(result.Array); i += 2 {
+		fields[result.Array[i].Str] = result.Array[i+1]
+	}
+	if fields["proto"].Int != 3 || fields["id"].Int != client.id || fields["server"].Str != "redis" {
+		t.Errorf("Unexpected HELLO fields: %+v", fields)
+	}
+	if client.protocol != resp.RESP3 {
+		t.Errorf("Expected client protocol 3, got %d", client.protocol)
+	}
+
+	server.writeResponse(conn, resp.DoubleValue(1.5))
+	if string(conn.writeBuf) != ",1.5\r\n" {
+		t.Errorf("Expected RESP3 double, got %q", string(conn.writeBuf))
+	}
+
+	server.processCommand(client, commandValue("HELLO", "2"))
+	conn.writeBuf = nil
+	server.writeResponse(conn, resp.DoubleValue(1.5))
+	if string(conn.writeBuf) != "$3\r\n1.5\r\n" {
+		t.Errorf("Expected RESP2 bulk string, got %q", string(conn.writeBuf))
+	}
+}
+
+func TestHelloWithoutVersion(t *testing.T) {
+	server := NewServer()
+	conn := &mockConn{}
+	server.OnOpen(conn)
+
+	result := server.processCommand(server.clients[conn], commandValue("HELLO"))
+	if result.Type != resp.Map {
+		t.Fatalf("Expected map reply, got %+v", result)
+	}
+	if server.clients[conn].protocol != resp.RESP2 {
+		t.Error("HELLO without version should keep the current protocol")
+	}
+}
+
+func TestHelloErrors(t *testing.T) {
+	server := NewServer()
+	client := &Client{protocol: resp.RESP2, watchedKeys: make(map[string]bool)}
+
+	tests := []struct {
+		args   []string
+		prefix string
+	}{
+		{[]string{"HELLO", "abc"}, "ERR Protocol version is not an integer"},
+		{[]string{"HELLO", "4"}, "NOPROTO"},
+		{[]string{"HELLO", "1"}, "NOPROTO"},
+		{[]string{"HELLO", "3", "BOGUS"}, "ERR Syntax error in HELLO option 'BOGUS'"},
+		{[]string{"HELLO", "3", "SETNAME"}, "ERR Syntax error in HELLO option 'SETNAME'"},
+		{[]string{"HELLO", "3", "SETNAME", "bad name"}, "ERR Client names cannot contain spaces"},
+		{[]string{"HELLO", "3", "AUTH", "someone", "pass"}, "WRONGPASS"},
+	}
+
+	for _, tt := range tests {
+		result := server.processCommand(client, commandValue(tt.args...))
+		if result.Type != resp.Error || !strings.HasPrefix(result.Str, tt.prefix) {
+			t.Errorf("%v: expected error %q, got %+v", tt.args, tt.prefix, result)
+		}
+	}
+
+	if client.protocol != resp.RESP2 {
+		t.Error("Failed HELLO should not change the protocol")
+	}
+}
+
+func TestHelloSetName(t *testing.T) {
+	server := NewServer()
+	client := &Client{protocol: resp.RESP2, watchedKeys: make(map[string]bool)}
+
+	result := server.processCommand(client, commandValue("HELLO", "3", "SETNAME", "worker-1"))
+	if result.Type != resp.Map {
+		t.Fatalf("Expected map reply, got %+v", result)
+	}
+	if client.name != "worker-1" {
+		t.Errorf("Expected client name 'worker-1', got %q", client.name)
+	}
+}
+
+func TestAuthRequired(t *testing.T) {
+	server := NewServer()
+	server.SetRequirePass("secret")
+	server.RegisterCommand("PING", func(args []resp.Value) resp.Value {
+		return resp.SimpleStringValue("PONG")
+	})
+	client := &Client{protocol: resp.RESP2, watchedKeys: make(map[string]bool)}
+
+	result := server.processCommand(client, commandValue("PING"))
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "NOAUTH") {
+		t.Errorf("Expected NOAUTH error, got %+v", result)
+	}
+
+	result = server.processCommand(client, commandValue("HELLO", "3"))
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "NOAUTH") {
+		t.Errorf("Expected NOAUTH error from HELLO, got %+v", result)
+	}
+
+	result = server.processCommand(client, commandValue("AUTH", "wrong"))
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "WRONGPASS") {
+		t.Errorf("Expected WRONGPASS error, got %+v", result)
+	}
+
+	result = server.processCommand(client, commandValue("AUTH", "secret"))
+	if result.Type != resp.SimpleString || result.Str != "OK" {
+		t.Errorf("Expected OK, got %+v", result)
+	}
+
+	result = server.processCommand(client, commandValue("PING"))
+	if result.Str != "PONG" {
+		t.Errorf("Expected PONG after AUTH, got %+v", result)
+	}
+}
+
+func TestHelloAuth(t *testing.T) {
+	server := NewServer()
+	server.SetRequirePass("secret")
+	client := &Client{protocol: resp.RESP2, watchedKeys: make(map[string]bool)}
+
+	result := server.processCommand(client, commandValue("HELLO", "3", "AUTH", "default", "wrong"))
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "WRONGPASS") {
+		t.Errorf("Expected WRONGPASS error, got %+v", result)
+	}
+
+	result = server.processCommand(client, commandValue("HELLO", "3", "AUTH", "default", "secret"))
+	if result.Type != resp.Map {
+		t.Fatalf("Expected map reply, got %+v", result)
+	}
+	if !client.authenticated || client.protocol != resp.RESP3 {
+		t.Error("HELLO AUTH should authenticate and switch protocol")
+	}
+}
+
+func TestAuthWithoutPassword(t *testing.T) {
+	server := NewServer()
+	client := &Client{protocol: resp.RESP2, watchedKeys: make(map[string]bool)}
+
+	result := server.processCommand(client, commandValue("AUTH", "secret"))
+	if result.Type != resp.Error || !strings.Contains(result.Str, "without any password configured") {
+		t.Errorf("Expected no-password error, got %+v", result)
+	}
+}
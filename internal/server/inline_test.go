@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/command"
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+func TestInlineCommands(t *testing.T) {
+	server := NewServer()
+	s := store.NewStore()
+
+	server.RegisterCommand("PING", command.PingCommand)
+	server.RegisterCommand("SET", command.SetCommand(s))
+	server.RegisterCommand("GET", command.GetCommand(s))
+
+	go server.Start("16385")
+	defer server.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", "localhost:16385")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	parser := resp.NewParser(bufio.NewReader(conn))
+
+	conn.Write([]byte("PING\r\n\r\nSET greeting \"hello world\"\nGET greeting\r\n*2\r\n$3\r\nGET\r\n$8\r\ngreeting\r\n"))
+
+	response, _ := parser.Parse()
+	if response.Type != resp.SimpleString || response.Str != "PONG" {
+		t.Errorf("Expected PONG, got %v", response)
+	}
+
+	response, _ = parser.Parse()
+	if response.Type != resp.SimpleString || response.Str != "OK" {
+		t.Errorf("Expected OK for inline SET, got %v", response)
+	}
+
+	for i := 0; i < 2; i++ {
+		response, _ = parser.Parse()
+		if response.Type != resp.BulkString || response.Str != "hello world" {
+			t.Errorf("Expected 'hello world', got %v", response)
+		}
+	}
+
+	conn.Write([]byte("SET key \"unterminated\r\n"))
+	response, _ = parser.Parse()
+	if response.Type != resp.Error || response.Str != "ERR Protocol error: unbalanced quotes in request" {
+		t.Errorf("Expected unbalanced quotes error, got %v", response)
+	}
+}
@@ -0,0 +1,422 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+type linkState int
+
+const (
+	linkConnecting linkState = iota
+	linkTransfer
+	linkConnected
+)
+
+var errLinkClosed = errors.New("replication link closed")
+
+// masterLink is a replica's connection to its master, kept up by
+// runMasterLink in its own goroutine. Its fields are guarded by the server
+// lock, except writes to conn, which have their own.
+type masterLink struct {
+	host    string
+	port    string
+	conn    net.Conn
+	state   linkState
+	lastIO  time.Time
+	stopped bool
+	stop    chan struct{}
+	writeMu sync.Mutex
+	// Offset of the stream known to be fsynced to the AOF, and the points
+	// of the stream applied since, in AOF offsets.
+	fack  int64
+	marks []aofMark
+}
+
+// aofMark ties an offset of the stream to where its writes ended in the
+// AOF, so that an fsync of the AOF tells how much of the stream it covered.
+type aofMark struct {
+	aof  int64
+	repl int64
+}
+
+func (m *masterLink) status() string {
+	if m.state == linkConnected {
+		return "up"
+	}
+	return "down"
+}
+
+func (m *masterLink) close() {
+	if m.stopped {
+		return
+	}
+	m.stopped = true
+	close(m.stop)
+	if m.conn != nil {
+		m.conn.Close()
+	}
+}
+
+func (m *masterLink) write(conn net.Conn, args ...string) error {
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.BulkStringValue(arg)
+	}
+
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(replAckPeriod * 5))
+	_, err := conn.Write(resp.SerializeArray(values))
+	return err
+}
+
+// runMasterLink syncs with the master and applies its stream, connecting
+// again whenever the link breaks, until the link is closed.
+func (s *Server) runMasterLink(link *masterLink) {
+	for {
+		err := s.syncWithMaster(link)
+
+		s.lockIdle()
+		stopped := link.stopped
+		link.conn = nil
+		link.state = linkConnecting
+		s.mu.Unlock()
+		if stopped {
+			return
+		}
+		log.Printf("Connection with master %s:%s lost: %v", link.host, link.port, err)
+
+		select {
+		case <-link.stop:
+			return
+		case <-time.After(replRetryDelay):
+		}
+	}
+}
+
+func (s *Server) syncWithMaster(link *masterLink) error {
+	s.lockIdle()
+	timeout, auth := s.repl.timeout, s.repl.masterAuth
+	s.mu.Unlock()
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(link.host, link.port), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s.lockIdle()
+	if link.stopped {
+		s.mu.Unlock()
+		return errLinkClosed
+	}
+	link.conn = conn
+	id, offset := s.repl.id, s.repl.offset
+	s.mu.Unlock()
+
+	reader := bufio.NewReader(deadlineReader{conn, timeout})
+	parser := resp.NewParser(reader)
+	request := func(args ...string) (resp.Value, error) {
+		if err := link.write(conn, args...); err != nil {
+			return resp.Value{}, err
+		}
+		return parser.Parse()
+	}
+	expectOK := func(args ...string) error {
+		reply, err := request(args...)
+		if err != nil {
+			return err
+		}
+		if reply.Type == resp.Error {
+			return fmt.Errorf("master replied to %s: %s", args[0], reply.Str)
+		}
+		return nil
+	}
+
+	if reply, err := request("PING"); err != nil {
+		return err
+	} else if reply.Type == resp.Error && !strings.HasPrefix(reply.Str, "NOAUTH") {
+		return fmt.Errorf("master replied to PING: %s", reply.Str)
+	}
+	if auth != "" {
+		if err := expectOK("AUTH", auth); err != nil {
+			return err
+		}
+	}
+	if err := expectOK("REPLCONF", "listening-port", s.port); err != nil {
+		return err
+	}
+	if err := expectOK("REPLCONF", "capa", "eof", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	reply, err := request("PSYNC", id, strconv.FormatInt(offset+1, 10))
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(reply.Str)
+	switch {
+	case reply.Type == resp.SimpleString && len(fields) == 3 && fields[0] == "FULLRESYNC":
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad FULLRESYNC reply: %s", reply.Str)
+		}
+		if err := s.loadFromMaster(link, reader, fields[1], masterOffset); err != nil {
+			return err
+		}
+	case reply.Type == resp.SimpleString && len(fields) >= 1 && fields[0] == "CONTINUE":
+		s.continueWithMaster(link, fields[1:])
+	default:
+		return fmt.Errorf("master replied to PSYNC: %s", reply.Str)
+	}
+
+	return s.streamFromMaster(link, conn, reader)
+}
+
+// loadFromMaster reads the data set sent for a full resynchronization and
+// replaces the local one with it.
+func (s *Server) loadFromMaster(link *masterLink, reader *bufio.Reader, id string, offset int64) error {
+	s.lockIdle()
+	link.state = linkTransfer
+	dataset := s.repl.dataset
+	s.mu.Unlock()
+	log.Printf("Full resync from master %s:%s, loading the data set", link.host, link.port)
+
+	// Masters may send newlines to keep the link alive before the payload.
+	var line string
+	for line == "" || line == "\n" {
+		var err error
+		if line, err = reader.ReadString('\n'); err != nil {
+			return err
+		}
+	}
+	header := strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(header, "$") {
+		return fmt.Errorf("bad protocol from master: %q", header)
+	}
+
+	var payload io.Reader = reader
+	mark, diskless := strings.CutPrefix(header, "$EOF:")
+	if !diskless {
+		size, err := strconv.ParseInt(header[1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad RDB payload length: %q", header)
+		}
+		payload = io.LimitReader(reader, size)
+	}
+
+	install, err := dataset.Load(payload)
+	if err != nil {
+		return fmt.Errorf("failed to load the RDB sent by master: %w", err)
+	}
+	if diskless {
+		end := make([]byte, len(mark))
+		if _, err := io.ReadFull(reader, end); err != nil {
+			return err
+		}
+		if string(end) != mark {
+			return fmt.Errorf("RDB payload not followed by its delimiter")
+		}
+	} else if _, err := io.Copy(io.Discard, payload); err != nil {
+		return err
+	}
+
+	s.lockIdle()
+	defer s.mu.Unlock()
+	if link.stopped {
+		return errLinkClosed
+	}
+	install()
+	s.repl.id = id
+	s.repl.id2 = strings.Repeat("0", 40)
+	s.repl.offset = offset
+	s.repl.secondOffset = -1
+	s.repl.backlog = newBacklog(s.repl.backlogSize, offset)
+	s.dropReplicas()
+	link.state = linkConnected
+	link.lastIO = time.Now()
+	log.Printf("MASTER <-> REPLICA sync: Finished with success")
+	return nil
+}
+
+// continueWithMaster resumes the stream after the master accepted a partial
+// resynchronization, adopting its new replication ID if it changed.
+func (s *Server) continueWithMaster(link *masterLink, fields []string) {
+	s.lockIdle()
+	defer s.mu.Unlock()
+
+	if len(fields) > 0 && fields[0] != s.repl.id {
+		s.repl.id2 = s.repl.id
+		s.repl.secondOffset = s.repl.offset + 1
+		s.repl.id = fields[0]
+		s.dropReplicas()
+	}
+	link.state = linkConnected
+	link.lastIO = time.Now()
+	log.Printf("Successful partial resynchronization with master %s:%s", link.host, link.port)
+}
+
+// streamFromMaster applies the commands the master streams, acknowledging
+// the processed offset every second, until the link breaks.
+func (s *Server) streamFromMaster(link *masterLink, conn net.Conn, reader *bufio.Reader) error {
+	done := make(chan struct{})
+	defer close(done)
+	go s.sendAcks(link, conn, done)
+
+	var stream masterStream
+	var pending []byte
+	buf := make([]byte, 16*1024)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			pending = append(pending, buf[:n]...)
+			consumed, applyErr := s.applyMasterStream(link, conn, &stream, pending)
+			pending = append(pending[:0], pending[consumed:]...)
+			if applyErr != nil {
+				return applyErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// masterStream is the state of the stream being applied. The commands of a
+// MULTI block are held until its EXEC arrives, and only then run and
+// counted in the replication offset, so that a link broken in the middle
+// of a block resumes from its start.
+type masterStream struct {
+	decoder resp.Decoder
+	inMulti bool
+	multi   []resp.Value
+	raw     []byte
+}
+
+func (s *Server) applyMasterStream(link *masterLink, conn net.Conn, stream *masterStream, data []byte) (int, error) {
+	s.lockIdle()
+	defer s.mu.Unlock()
+	if link.stopped {
+		return 0, errLinkClosed
+	}
+	link.lastIO = time.Now()
+
+	offset := 0
+	for offset < len(data) {
+		value, consumed, err := stream.decoder.Decode(data[offset:])
+		if err != nil {
+			return offset, fmt.Errorf("protocol error from master: %w", err)
+		}
+		if consumed == 0 {
+			break
+		}
+		raw := append([]byte(nil), data[offset:offset+consumed]...)
+		offset += consumed
+
+		args := value.Array
+		getAck := len(args) > 1 && strings.EqualFold(args[0].Str, "REPLCONF") && strings.EqualFold(args[1].Str, "GETACK")
+		switch {
+		case len(args) == 1 && strings.EqualFold(args[0].Str, "MULTI"):
+			stream.inMulti = true
+			stream.raw = append(stream.raw, raw...)
+			continue
+		case len(args) == 1 && strings.EqualFold(args[0].Str, "EXEC") && stream.inMulti:
+			s.executeBatch(stream.multi)
+			raw = append(stream.raw, raw...)
+			stream.inMulti, stream.multi, stream.raw = false, nil, nil
+		case stream.inMulti:
+			stream.multi = append(stream.multi, value)
+			stream.raw = append(stream.raw, raw...)
+			continue
+		case len(args) > 0 && !getAck:
+			s.executeCommand(value)
+		}
+		s.feedReplicas(raw)
+		if getAck {
+			offset, fack := s.ackOffsets(link)
+			go link.write(conn, "REPLCONF", "ACK", offset, "FACK", fack)
+		}
+	}
+	s.markAOF(link)
+	return offset, nil
+}
+
+func (s *Server) sendAcks(link *masterLink, conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(replAckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.lockIdle()
+			offset, fack := s.ackOffsets(link)
+			s.mu.Unlock()
+			if err := link.write(conn, "REPLCONF", "ACK", offset, "FACK", fack); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// maxAOFMarks bounds the marks kept between two fsyncs; past it the last
+// one is moved forward, which only makes FACK more conservative.
+const maxAOFMarks = 1024
+
+// markAOF records where the stream applied so far ends in the AOF.
+func (s *Server) markAOF(link *masterLink) {
+	if s.aof == nil {
+		return
+	}
+	n := len(link.marks)
+	if n > 0 && link.marks[n-1].repl == s.repl.offset {
+		return
+	}
+	written, _ := s.aof.Offsets()
+	mark := aofMark{aof: written, repl: s.repl.offset}
+	if n >= maxAOFMarks {
+		link.marks[n-1] = mark
+	} else {
+		link.marks = append(link.marks, mark)
+	}
+}
+
+// ackOffsets returns the offsets a replica acknowledges: the end of the
+// stream it applied, and the end of the part the last fsync of its AOF
+// covered.
+func (s *Server) ackOffsets(link *masterLink) (string, string) {
+	if s.aof != nil {
+		s.markAOF(link)
+		_, synced := s.aof.Offsets()
+		covered := 0
+		for covered < len(link.marks) && link.marks[covered].aof <= synced {
+			link.fack = link.marks[covered].repl
+			covered++
+		}
+		link.marks = link.marks[covered:]
+	}
+	return strconv.FormatInt(s.repl.offset, 10), strconv.FormatInt(link.fack, 10)
+}
+
+// deadlineReader extends the read deadline before every read, so a master
+// that stays silent for longer than timeout breaks the link.
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r deadlineReader) Read(p []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	return r.conn.Read(p)
+}
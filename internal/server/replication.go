@@ -0,0 +1,552 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/panjf2000/gnet/v2"
+)
+
+const (
+	DefaultReplBacklogSize = 1024 * 1024
+	DefaultReplTimeout     = 60 * time.Second
+
+	replPingPeriod = 10 * time.Second
+	replAckPeriod  = time.Second
+	replRetryDelay = time.Second
+	replChunkSize  = 64 * 1024
+)
+
+var errReplicaGone = errors.New("replica disconnected")
+
+// Dataset gives replication access to the data set as an RDB stream.
+type Dataset struct {
+	// Snapshot is called with the server locked and returns a function that
+	// writes the snapshot it took, to be run in another goroutine.
+	Snapshot func() func(w io.Writer) error
+	// Load reads an RDB stream into a new data set and returns a function
+	// that installs it, called with the server locked.
+	Load func(r io.Reader) (func(), error)
+	// Dir is where disk-based syncs write the snapshot before sending it.
+	Dir func() string
+}
+
+type replicaState int
+
+const (
+	replicaSendBulk replicaState = iota
+	replicaOnline
+)
+
+func (st replicaState) String() string {
+	if st == replicaOnline {
+		return "online"
+	}
+	return "send_bulk"
+}
+
+// replica is a connection that synced with this server and now receives its
+// replication stream.
+type replica struct {
+	conn  gnet.Conn
+	port  int
+	state replicaState
+	// Stream fed while the RDB is sent, written once the RDB is done.
+	pending   [][]byte
+	ackOffset int64
+	// Offset up to which the replica's AOF is fsynced.
+	fackOffset int64
+	lastAck    time.Time
+	closed     atomic.Bool
+}
+
+// replication is the replication state of a server. A master and a replica
+// share the same stream history: a replica takes the replication ID and
+// offset of its master and feeds what it receives to its own backlog and
+// replicas. id2 and secondOffset keep the previous history valid up to
+// that offset after a replica is promoted, so its former peers can still
+// continue from it.
+type replication struct {
+	id           string
+	id2          string
+	offset       int64
+	secondOffset int64
+	backlog      *backlog
+	backlogSize  int
+	replicas     []*replica
+	lastPing     time.Time
+
+	master     *masterLink
+	readOnly   bool
+	diskless   bool
+	timeout    time.Duration
+	masterAuth string
+	dataset    Dataset
+}
+
+func newReplication() replication {
+	return replication{
+		id:           newReplID(),
+		id2:          strings.Repeat("0", 40),
+		secondOffset: -1,
+		backlogSize:  DefaultReplBacklogSize,
+		readOnly:     true,
+		diskless:     true,
+		timeout:      DefaultReplTimeout,
+	}
+}
+
+func newReplID() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// SetDataset installs the hooks full resynchronizations save and load the
+// data set with.
+func (s *Server) SetDataset(dataset Dataset) {
+	s.repl.dataset = dataset
+}
+
+func (s *Server) SetReplicaReadOnly(readOnly bool) {
+	s.repl.readOnly = readOnly
+}
+
+func (s *Server) SetReplDisklessSync(diskless bool) {
+	s.repl.diskless = diskless
+}
+
+func (s *Server) SetReplTimeout(timeout time.Duration) {
+	s.repl.timeout = timeout
+}
+
+func (s *Server) SetMasterAuth(password string) {
+	s.repl.masterAuth = password
+}
+
+func (s *Server) SetReplBacklogSize(size int) {
+	s.repl.backlogSize = size
+	if s.repl.backlog != nil {
+		s.repl.backlog.resize(size)
+	}
+}
+
+// ReplicaOf makes the server a replica of the master at host:port.
+func (s *Server) ReplicaOf(host, port string) {
+	s.lockIdle()
+	defer s.mu.Unlock()
+	s.setMaster(host, port)
+}
+
+func (s *Server) role() string {
+	if s.repl.master != nil {
+		return "replica"
+	}
+	return "master"
+}
+
+func (s *Server) readOnlyReplica() bool {
+	return s.repl.master != nil && s.repl.readOnly
+}
+
+func (s *Server) replicaOfCommand(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'replicaof' command")
+	}
+	host, port := args[0].Str, args[1].Str
+
+	if strings.EqualFold(host, "no") && strings.EqualFold(port, "one") {
+		if s.repl.master != nil {
+			s.promote()
+			log.Println("MASTER MODE enabled (user request)")
+		}
+		return resp.OKValue()
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return resp.ErrorValue("ERR Invalid master port")
+	}
+	if m := s.repl.master; m != nil && m.host == host && m.port == port {
+		return resp.Value{Type: resp.SimpleString, Str: "OK Already connected to specified master"}
+	}
+
+	s.setMaster(host, port)
+	log.Printf("REPLICAOF %s:%s enabled (user request)", host, port)
+	return resp.OKValue()
+}
+
+// setMaster drops any current master and replicas, and starts syncing with
+// the new master, trying to continue from this server's own history.
+func (s *Server) setMaster(host, port string) {
+	if s.repl.master != nil {
+		s.repl.master.close()
+	}
+	s.dropReplicas()
+
+	link := &masterLink{host: host, port: port, stop: make(chan struct{})}
+	s.repl.master = link
+	go s.runMasterLink(link)
+}
+
+// promote turns a replica into a master. The history so far stays valid
+// under the previous ID, so replicas of the same master can continue from
+// this server after a failover.
+func (s *Server) promote() {
+	s.repl.master.close()
+	s.repl.master = nil
+	s.repl.id2 = s.repl.id
+	s.repl.secondOffset = s.repl.offset + 1
+	s.repl.id = newReplID()
+	s.dropReplicas()
+}
+
+// dropReplicas disconnects all replicas, which then sync again.
+func (s *Server) dropReplicas() {
+	for _, r := range s.repl.replicas {
+		r.closed.Store(true)
+		r.conn.Close()
+	}
+	s.repl.replicas = nil
+}
+
+func (s *Server) removeReplica(r *replica) {
+	r.closed.Store(true)
+	for i, candidate := range s.repl.replicas {
+		if candidate == r {
+			s.repl.replicas = append(s.repl.replicas[:i], s.repl.replicas[i+1:]...)
+			break
+		}
+	}
+}
+
+// PropagateDel propagates the deletion of a key the store removed by itself,
+// as expired or evicted, so that the AOF and replicas don't depend on their
+// own clock or memory to drop it.
+func (s *Server) PropagateDel(key string) {
+	s.propagation = append(s.propagation, []resp.Value{resp.BulkStringValue("DEL"), resp.BulkStringValue(key)})
+	if s.callDepth == 0 {
+		s.flushPropagation()
+	}
+}
+
+// flushPropagation appends what the call that just returned wrote to the
+// AOF and to the replication stream, wrapped in MULTI/EXEC when it is more
+// than one command, so that it is replayed atomically.
+func (s *Server) flushPropagation() {
+	commands := s.propagation
+	s.propagation = nil
+	if len(commands) > 1 {
+		commands = append([][]resp.Value{{resp.BulkStringValue("MULTI")}}, commands...)
+		commands = append(commands, []resp.Value{resp.BulkStringValue("EXEC")})
+	}
+	for _, args := range commands {
+		if s.aofEnabled {
+			if err := s.aof.Append(args); err != nil {
+				log.Printf("Failed to append to AOF: %v", err)
+			}
+		}
+		s.propagate(args)
+	}
+}
+
+// propagate feeds a write command executed on a master to the replication
+// stream. Replicas instead forward the stream of their master as it is.
+func (s *Server) propagate(args []resp.Value) {
+	if s.repl.master != nil || s.repl.backlog == nil {
+		return
+	}
+	s.feedReplicas(resp.SerializeArray(args))
+}
+
+func (s *Server) feedReplicas(data []byte) {
+	if s.repl.backlog == nil {
+		s.repl.backlog = newBacklog(s.repl.backlogSize, s.repl.offset)
+	}
+	s.repl.backlog.write(data)
+	s.repl.offset += int64(len(data))
+
+	for _, r := range s.repl.replicas {
+		switch r.state {
+		case replicaOnline:
+			r.conn.AsyncWrite(data, nil)
+		case replicaSendBulk:
+			r.pending = append(r.pending, data)
+		}
+	}
+}
+
+func (s *Server) replconf(client *Client, args []resp.Value) resp.Value {
+	if len(args)%2 != 0 {
+		return resp.ErrorValue("ERR syntax error")
+	}
+
+	ack := false
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(args[i].Str) {
+		case "listening-port":
+			port, err := strconv.Atoi(args[i+1].Str)
+			if err != nil {
+				return resp.ErrorValue("ERR value is not an integer or out of range")
+			}
+			client.listeningPort = port
+		case "ip-address", "capa":
+		case "ack", "fack":
+			ack = true
+			r := client.replica
+			if r == nil {
+				continue
+			}
+			offset, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+			if err != nil {
+				continue
+			}
+			if strings.EqualFold(args[i].Str, "ack") {
+				r.ackOffset = max(r.ackOffset, offset)
+				r.lastAck = time.Now()
+			} else {
+				r.fackOffset = max(r.fackOffset, offset)
+			}
+		case "getack":
+			// Only sent by a master to its replicas.
+			return noReply
+		default:
+			return resp.ErrorValue("ERR Unrecognized REPLCONF option: " + args[i].Str)
+		}
+	}
+	// Acks are not replied to.
+	if ack {
+		return noReply
+	}
+	return resp.OKValue()
+}
+
+// psync starts streaming to a replica, from its offset if the backlog
+// still holds it, or after sending it the whole data set otherwise. The
+// replies are written here so that they precede the stream.
+func (s *Server) psync(client *Client, args []resp.Value) resp.Value {
+	if client.replica != nil {
+		return noReply
+	}
+	if m := s.repl.master; m != nil && m.state != linkConnected {
+		return resp.ErrorValue("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+	if s.repl.dataset.Snapshot == nil {
+		return resp.ErrorValue("ERR replication is not available on this server")
+	}
+
+	r := &replica{conn: client.conn, port: client.listeningPort, lastAck: time.Now()}
+	client.replica = r
+	s.repl.replicas = append(s.repl.replicas, r)
+	if s.repl.backlog == nil {
+		s.repl.backlog = newBacklog(s.repl.backlogSize, s.repl.offset)
+	}
+
+	if strings.EqualFold(args[0].Str, "psync") {
+		if len(args) != 3 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'psync' command")
+		}
+		if stream, ok := s.partialSync(args[1].Str, args[2].Str); ok {
+			s.stats.SyncPartialOK++
+			r.state = replicaOnline
+			r.conn.AsyncWrite([]byte("+CONTINUE "+s.repl.id+"\r\n"), nil)
+			r.conn.AsyncWrite(stream, nil)
+			log.Printf("Partial resynchronization request from %s accepted, sending %d bytes of backlog", client.conn.RemoteAddr(), len(stream))
+			return noReply
+		}
+		if args[1].Str != "?" {
+			s.stats.SyncPartialErr++
+		}
+		r.conn.AsyncWrite([]byte(fmt.Sprintf("+FULLRESYNC %s %d\r\n", s.repl.id, s.repl.offset)), nil)
+	}
+
+	log.Printf("Starting full resynchronization with replica %s", client.conn.RemoteAddr())
+	s.stats.SyncFull++
+	s.fullSync(r)
+	return noReply
+}
+
+func (s *Server) partialSync(id, offsetArg string) ([]byte, bool) {
+	offset, err := strconv.ParseInt(offsetArg, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	if id != s.repl.id && (id != s.repl.id2 || offset > s.repl.secondOffset) {
+		return nil, false
+	}
+	return s.repl.backlog.since(offset)
+}
+
+// fullSync sends a snapshot of the data set to the replica from another
+// goroutine. Until it's done, the stream for the replica is kept pending.
+func (s *Server) fullSync(r *replica) {
+	r.state = replicaSendBulk
+	write := s.repl.dataset.Snapshot()
+	diskless := s.repl.diskless
+	var dir string
+	if !diskless {
+		dir = s.repl.dataset.Dir()
+	}
+
+	done := func(c gnet.Conn, err error) error {
+		s.lockIdle()
+		defer s.mu.Unlock()
+		if err != nil || r.closed.Load() {
+			return nil
+		}
+		r.state = replicaOnline
+		for _, data := range r.pending {
+			c.AsyncWrite(data, nil)
+		}
+		r.pending = nil
+		log.Printf("Synchronization with replica %s succeeded", c.RemoteAddr())
+		return nil
+	}
+
+	go func() {
+		if err := sendRDB(r, write, diskless, dir, done); err != nil {
+			log.Printf("Full resynchronization with replica %s failed: %v", r.conn.RemoteAddr(), err)
+			r.conn.Close()
+		}
+	}()
+}
+
+// sendRDB writes the snapshot to the replica as a bulk payload, calling done
+// on the event loop after the last byte. A diskless payload is streamed as
+// it's produced and ends with a random delimiter announced up front; a disk
+// payload is saved to a file first so its length can be sent.
+func sendRDB(r *replica, write func(io.Writer) error, diskless bool, dir string, done gnet.AsyncCallback) error {
+	out := replicaWriter{r}
+
+	if diskless {
+		mark := newReplID()
+		if _, err := io.WriteString(out, "$EOF:"+mark+"\r\n"); err != nil {
+			return err
+		}
+		buffered := bufio.NewWriterSize(out, replChunkSize)
+		if err := write(buffered); err != nil {
+			return err
+		}
+		if err := buffered.Flush(); err != nil {
+			return err
+		}
+		return r.conn.AsyncWrite([]byte(mark), done)
+	}
+
+	file, err := os.CreateTemp(dir, "temp-repl-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := write(file); err != nil {
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(out, "$%d\r\n", size); err != nil {
+		return err
+	}
+	if _, err := io.CopyBuffer(out, file, make([]byte, replChunkSize)); err != nil {
+		return err
+	}
+	return r.conn.AsyncWrite(nil, done)
+}
+
+// replicaWriter queues writes to a replica's connection from any goroutine.
+type replicaWriter struct {
+	r *replica
+}
+
+func (w replicaWriter) Write(p []byte) (int, error) {
+	if w.r.closed.Load() {
+		return 0, errReplicaGone
+	}
+	if err := w.r.conn.AsyncWrite(append([]byte(nil), p...), nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// replicationCron pings replicas so they can tell the link is alive, and
+// drops replicas that stopped acknowledging the stream.
+func (s *Server) replicationCron() {
+	if len(s.repl.replicas) == 0 {
+		return
+	}
+
+	now := time.Now()
+	if s.repl.master == nil && now.Sub(s.repl.lastPing) >= replPingPeriod {
+		s.feedReplicas(resp.SerializeArray([]resp.Value{resp.BulkStringValue("PING")}))
+		s.repl.lastPing = now
+	}
+
+	for _, r := range s.repl.replicas {
+		if r.state == replicaOnline && now.Sub(r.lastAck) > s.repl.timeout {
+			log.Printf("Disconnecting timedout replica %s", r.conn.RemoteAddr())
+			r.conn.Close()
+		}
+	}
+}
+
+// ReplicationInfo renders the replication section of INFO.
+func (s *Server) ReplicationInfo() string {
+	var sb strings.Builder
+	sb.WriteString("# Replication\r\n")
+
+	if m := s.repl.master; m != nil {
+		lastIO := int64(-1)
+		if m.state == linkConnected {
+			lastIO = int64(time.Since(m.lastIO) / time.Second)
+		}
+		fmt.Fprintf(&sb, "role:slave\r\nmaster_host:%s\r\nmaster_port:%s\r\nmaster_link_status:%s\r\n"+
+			"master_last_io_seconds_ago:%d\r\nmaster_sync_in_progress:%d\r\nslave_read_repl_offset:%d\r\nslave_repl_offset:%d\r\n"+
+			"slave_read_only:%d\r\n",
+			m.host, m.port, m.status(), lastIO, flag(m.state == linkTransfer), s.repl.offset, s.repl.offset, flag(s.repl.readOnly))
+	} else {
+		sb.WriteString("role:master\r\n")
+	}
+
+	fmt.Fprintf(&sb, "connected_slaves:%d\r\n", len(s.repl.replicas))
+	for i, r := range s.repl.replicas {
+		host, _, _ := net.SplitHostPort(r.conn.RemoteAddr().String())
+		fmt.Fprintf(&sb, "slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\r\n",
+			i, host, r.port, r.state, r.ackOffset, int64(time.Since(r.lastAck)/time.Second))
+	}
+
+	backlogFirst, backlogLen := int64(0), 0
+	if b := s.repl.backlog; b != nil {
+		backlogFirst, backlogLen = b.firstOffset(), b.histlen
+	}
+	fmt.Fprintf(&sb, "master_replid:%s\r\nmaster_replid2:%s\r\nmaster_repl_offset:%d\r\nsecond_repl_offset:%d\r\n"+
+		"repl_backlog_active:%d\r\nrepl_backlog_size:%d\r\nrepl_backlog_first_byte_offset:%d\r\nrepl_backlog_histlen:%d\r\n",
+		s.repl.id, s.repl.id2, s.repl.offset, s.repl.secondOffset,
+		flag(s.repl.backlog != nil), s.repl.backlogSize, backlogFirst, backlogLen)
+	return sb.String()
+}
+
+func flag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
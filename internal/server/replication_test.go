@@ -0,0 +1,304 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/command"
+	"github.com/lojhan/redis-clone/internal/persistence"
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+func startReplicationNode(t *testing.T, port string) *Server {
+	t.Helper()
+	server, _ := startReplicationStore(t, port)
+	return server
+}
+
+func startReplicationStore(t *testing.T, port string) (*Server, *store.Store) {
+	t.Helper()
+	server := NewServer()
+	st := store.NewStore()
+	st.SetKeyModifiedHandler(server.MarkKeyModified)
+	st.SetKeyRemovedHandler(server.PropagateDel)
+	server.SetEffects(command.Effects(st))
+
+	server.RegisterCommand("PING", command.PingCommand)
+	server.RegisterCommand("SET", command.SetCommand(st))
+	server.RegisterCommand("GET", command.GetCommand(st))
+	server.RegisterCommand("DEL", command.DelCommand(st))
+	server.RegisterCommand("SADD", command.SAddCommand(st))
+	server.RegisterCommand("SREM", command.SRemCommand(st))
+	server.RegisterCommand("SPOP", command.SPopCommand(st))
+	server.RegisterCommand("SMEMBERS", command.SMembersCommand(st))
+	server.RegisterCommand("RESTORE", command.RestoreCommand(st))
+	server.RegisterCommand("MIGRATE", command.MigrateCommand(st, server.Call, command.NewMigrateCache(), false))
+	server.RegisterCommand("INFO", command.InfoCommandWithSections(command.InfoSection{
+		Name:   "replication",
+		Render: server.ReplicationInfo,
+	}))
+
+	dir := t.TempDir()
+	server.SetDataset(Dataset{
+		Snapshot: func() func(io.Writer) error {
+			snapshot := st.BeginSnapshot()
+			return func(w io.Writer) error {
+				defer snapshot.Release()
+				return persistence.WriteRDBStream(w, snapshot.Store(), nil, persistence.RDBOptions{})
+			}
+		},
+		Load: func(r io.Reader) (func(), error) {
+			loaded := store.NewStore()
+			if err := persistence.ReadRDBStream(r, loaded, nil); err != nil {
+				return nil, err
+			}
+			return func() { st.Replace(loaded) }, nil
+		},
+		Dir: func() string { return dir },
+	})
+
+	go server.Start(port)
+	t.Cleanup(func() { server.Stop() })
+	time.Sleep(100 * time.Millisecond)
+	return server, st
+}
+
+type replTestClient struct {
+	t      *testing.T
+	conn   net.Conn
+	parser *resp.Parser
+}
+
+func dialReplTest(t *testing.T, port string) *replTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", "localhost:"+port)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &replTestClient{t: t, conn: conn, parser: resp.NewParser(bufio.NewReader(conn))}
+}
+
+func (c *replTestClient) do(args ...string) resp.Value {
+	c.t.Helper()
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.BulkStringValue(arg)
+	}
+	if _, err := c.conn.Write(resp.SerializeArray(values)); err != nil {
+		c.t.Fatalf("Failed to send %v: %v", args, err)
+	}
+	reply, err := c.parser.Parse()
+	if err != nil {
+		c.t.Fatalf("Failed to read reply to %v: %v", args, err)
+	}
+	return reply
+}
+
+func (c *replTestClient) info() string {
+	c.t.Helper()
+	return c.do("INFO", "replication").Str
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func infoField(info, name string) string {
+	for _, line := range strings.Split(info, "\r\n") {
+		if value, ok := strings.CutPrefix(line, name+":"); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestReplication(t *testing.T) {
+	master := startReplicationNode(t, "16386")
+	replica := startReplicationNode(t, "16387")
+	m := dialReplTest(t, "16386")
+	r := dialReplTest(t, "16387")
+
+	m.do("SET", "before", "1")
+	if reply := r.do("REPLICAOF", "localhost", "16386"); reply.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", reply)
+	}
+	waitFor(t, "the initial sync", func() bool { return r.do("GET", "before").Str == "1" })
+	waitFor(t, "the link to be up", func() bool { return infoField(r.info(), "master_link_status") == "up" })
+
+	if reply := r.do("REPLICAOF", "localhost", "16386"); reply.Str != "OK Already connected to specified master" {
+		t.Errorf("Expected already connected reply, got %v", reply)
+	}
+
+	m.do("SET", "after", "2")
+	m.do("DEL", "before")
+	waitFor(t, "writes to propagate", func() bool {
+		return r.do("GET", "after").Str == "2" && r.do("GET", "before").Null
+	})
+
+	if reply := r.do("SET", "k", "v"); reply.Type != resp.Error || !strings.HasPrefix(reply.Str, "READONLY") {
+		t.Errorf("Expected READONLY error, got %v", reply)
+	}
+
+	info := m.info()
+	if infoField(info, "role") != "master" || infoField(info, "connected_slaves") != "1" ||
+		!strings.Contains(infoField(info, "slave0"), "port=16387,state=online") {
+		t.Errorf("Unexpected master INFO:\n%s", info)
+	}
+	waitFor(t, "the replica to catch up", func() bool {
+		return infoField(r.info(), "slave_repl_offset") == infoField(m.info(), "master_repl_offset")
+	})
+	if infoField(r.info(), "master_replid") != infoField(m.info(), "master_replid") {
+		t.Error("Expected the replica to share the replication ID of its master")
+	}
+
+	// A replica that loses its link continues from its offset.
+	replica.mu.Lock()
+	replica.repl.master.conn.Close()
+	replica.mu.Unlock()
+	m.do("SET", "during", "3")
+	waitFor(t, "the partial resync", func() bool { return r.do("GET", "during").Str == "3" })
+
+	master.mu.Lock()
+	stats := master.stats
+	master.mu.Unlock()
+	if stats.SyncFull != 1 || stats.SyncPartialOK != 1 {
+		t.Errorf("Expected one full and one partial sync, got %+v", stats)
+	}
+
+	oldID := infoField(m.info(), "master_replid")
+	if reply := r.do("REPLICAOF", "NO", "ONE"); reply.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", reply)
+	}
+	info = r.info()
+	if infoField(info, "role") != "master" || infoField(info, "master_replid2") != oldID || infoField(info, "master_replid") == oldID {
+		t.Errorf("Expected the promoted replica to keep the old ID as its second ID:\n%s", info)
+	}
+	if reply := r.do("SET", "k", "v"); reply.Str != "OK" {
+		t.Errorf("Expected writes after promotion, got %v", reply)
+	}
+}
+
+// TestReplicationEffects checks that commands whose result depends on when
+// or where they run leave the replica with the same data as the master.
+func TestReplicationEffects(t *testing.T) {
+	_, masterStore := startReplicationStore(t, "16397")
+	replica, replicaStore := startReplicationStore(t, "16398")
+	m := dialReplTest(t, "16397")
+	r := dialReplTest(t, "16398")
+	r.do("REPLICAOF", "localhost", "16397")
+	waitFor(t, "the link to be up", func() bool { return infoField(r.info(), "master_link_status") == "up" })
+
+	sameMembers := func(key string) bool {
+		master, _ := masterStore.SMembers(key)
+		copied, _ := replicaStore.SMembers(key)
+		sort.Strings(master)
+		sort.Strings(copied)
+		return strings.Join(master, " ") == strings.Join(copied, " ")
+	}
+
+	m.do("SADD", "set", "a", "b", "c", "d", "e", "f", "g", "h")
+	m.do("SPOP", "set")
+	m.do("SPOP", "set")
+	waitFor(t, "SPOP to propagate", func() bool { return sameMembers("set") })
+
+	// The replica applies the write late; the key must still expire when
+	// it does on the master.
+	replica.mu.Lock()
+	m.do("SET", "ttl", "v", "EX", "100")
+	time.Sleep(50 * time.Millisecond)
+	replica.mu.Unlock()
+	waitFor(t, "SET EX to propagate", func() bool { return r.do("GET", "ttl").Str == "v" })
+	_, masterExpiry, _ := masterStore.Object("ttl")
+	_, replicaExpiry, _ := replicaStore.Object("ttl")
+	if masterExpiry.UnixMilli() != replicaExpiry.UnixMilli() {
+		t.Errorf("Expected the same expiry on both, got %v on the master and %v on the replica", masterExpiry, replicaExpiry)
+	}
+
+	m.do("MULTI")
+	m.do("SADD", "tx", "a", "b", "c")
+	m.do("SPOP", "tx")
+	if reply := m.do("EXEC"); len(reply.Array) != 2 {
+		t.Fatalf("Expected two results from EXEC, got %v", reply)
+	}
+	waitFor(t, "the transaction to propagate", func() bool {
+		members, _ := replicaStore.SMembers("tx")
+		return len(members) == 2 && sameMembers("tx")
+	})
+}
+
+func TestMigrateOnReplica(t *testing.T) {
+	startReplicationNode(t, "16399")
+	startReplicationNode(t, "16400")
+	m := dialReplTest(t, "16399")
+	r := dialReplTest(t, "16400")
+	m.do("SET", "key", "value")
+	r.do("REPLICAOF", "localhost", "16399")
+	waitFor(t, "the initial sync", func() bool { return r.do("GET", "key").Str == "value" })
+
+	// MIGRATE is a write, so a read-only replica refuses it before
+	// contacting the target, even with COPY.
+	for _, option := range []string{"REPLACE", "COPY"} {
+		if reply := r.do("MIGRATE", "localhost", "16399", "key", "0", "1000", "REPLACE", option); !strings.HasPrefix(reply.Str, "READONLY") {
+			t.Errorf("Expected READONLY with %s, got %v", option, reply)
+		}
+	}
+	if reply := r.do("GET", "key"); reply.Str != "value" {
+		t.Errorf("Expected the replica to keep the key, got %v", reply)
+	}
+}
+
+func TestReplicationDiskSync(t *testing.T) {
+	master := startReplicationNode(t, "16388")
+	startReplicationNode(t, "16389")
+	master.mu.Lock()
+	master.SetReplDisklessSync(false)
+	master.mu.Unlock()
+
+	m := dialReplTest(t, "16388")
+	r := dialReplTest(t, "16389")
+	m.do("SET", "key", "value")
+	r.do("REPLICAOF", "localhost", "16388")
+	waitFor(t, "the initial sync", func() bool { return r.do("GET", "key").Str == "value" })
+
+	m.do("SET", "key", "new")
+	waitFor(t, "writes to propagate", func() bool { return r.do("GET", "key").Str == "new" })
+}
+
+func TestPsyncErrors(t *testing.T) {
+	server, client := newCommandTestServer()
+
+	if reply := server.processCommand(client, cmd("REPLCONF", "listening-port")); reply.Str != "ERR syntax error" {
+		t.Errorf("Expected syntax error, got %v", reply)
+	}
+	if reply := server.processCommand(client, cmd("REPLCONF", "bogus", "1")); !strings.HasPrefix(reply.Str, "ERR Unrecognized REPLCONF option") {
+		t.Errorf("Expected unrecognized option error, got %v", reply)
+	}
+	if reply := server.processCommand(client, cmd("REPLICAOF", "localhost", "port")); reply.Str != "ERR Invalid master port" {
+		t.Errorf("Expected invalid port error, got %v", reply)
+	}
+	if reply := server.processCommand(client, cmd("PSYNC", "?", "-1")); reply.Type != resp.Error {
+		t.Errorf("Expected PSYNC to fail without a dataset, got %v", reply)
+	}
+}
+
+func cmd(args ...string) resp.Value {
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.BulkStringValue(arg)
+	}
+	return resp.Value{Type: resp.Array, Array: values}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+const DefaultScriptTimeLimit = 5 * time.Second
+
+// scriptCommands maps the commands that run scripts to whether the script
+// is a function.
+var scriptCommands = map[string]bool{
+	"EVAL":     false,
+	"EVALSHA":  false,
+	"FCALL":    true,
+	"FCALL_RO": true,
+}
+
+// scriptRun is a script running on its own goroutine. Once it runs past the
+// time limit, the event loop goes back to serving clients, who get BUSY
+// until it ends, and the reply is sent from the script's goroutine.
+type scriptRun struct {
+	client   *Client
+	function bool
+	done     chan resp.Value
+	detached bool
+}
+
+func (s *Server) SetScriptTimeLimit(limit time.Duration) {
+	s.scriptTimeLimit = limit
+}
+
+// ScriptUnlock releases mu while a script runs Lua code, and returns the
+// function that takes it back.
+func (s *Server) ScriptUnlock() func() {
+	if s.script == nil {
+		return func() {}
+	}
+	s.mu.Unlock()
+	return s.mu.Lock
+}
+
+// runScript runs fn, a command that runs scripts, with mu held. A zero time
+// limit lets scripts block the event loop until they end.
+func (s *Server) runScript(client *Client, function bool, fn func() resp.Value) resp.Value {
+	limit := s.scriptTimeLimit
+	if limit <= 0 {
+		return fn()
+	}
+
+	run := &scriptRun{client: client, function: function, done: make(chan resp.Value, 1)}
+	s.script = run
+	go func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		result := fn()
+		s.script = nil
+		s.idle.Broadcast()
+		if !run.detached {
+			run.done <- result
+			return
+		}
+		client.script = nil
+		s.writeResponse(client.conn, result)
+		client.conn.Wake(nil)
+	}()
+
+	s.mu.Unlock()
+	timer := time.NewTimer(limit)
+	defer timer.Stop()
+	select {
+	case result := <-run.done:
+		s.mu.Lock()
+		return result
+	case <-timer.C:
+	}
+
+	s.mu.Lock()
+	select {
+	case result := <-run.done:
+		return result
+	default:
+	}
+	run.detached = true
+	client.script = run
+	log.Printf("Slow script detected: still in execution after %d milliseconds. You can try killing the script using the %s command.", limit.Milliseconds(), s.killCommand())
+	return noReply
+}
+
+// queuedScript reports whether the transaction runs a script, and whether
+// the first one is a function.
+func (s *Server) queuedScript(client *Client) (bool, bool) {
+	for _, cmd := range client.txQueue {
+		if function, ok := scriptCommands[strings.ToUpper(cmd.Array[0].Str)]; ok {
+			return function, true
+		}
+	}
+	return false, false
+}
+
+// allowedWhileBusy reports whether a command may run while a script is
+// past its time limit.
+func (s *Server) allowedWhileBusy(spec *CommandSpec, args []resp.Value) bool {
+	if strings.EqualFold(spec.Name, "shutdown") {
+		return len(args) > 1 && strings.EqualFold(args[1].Str, "NOSAVE")
+	}
+	return spec.Resolve(args).HasFlag(FlagAllowBusy)
+}
+
+func (s *Server) killCommand() string {
+	if s.script.function {
+		return "FUNCTION KILL"
+	}
+	return "SCRIPT KILL"
+}
+
+// lockIdle takes mu once no script is running. Goroutines use it so as not
+// to touch the data set while a script has released mu.
+func (s *Server) lockIdle() {
+	s.mu.Lock()
+	for s.script != nil {
+		s.idle.Wait()
+	}
+}
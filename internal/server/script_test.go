@@ -0,0 +1,136 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/command"
+	"github.com/lojhan/redis-clone/internal/resp"
+	"github.com/lojhan/redis-clone/internal/scripting"
+	"github.com/lojhan/redis-clone/internal/store"
+)
+
+func startScriptNode(t *testing.T, port string) *Server {
+	t.Helper()
+	server := NewServer()
+	st := store.NewStore()
+	server.SetScriptTimeLimit(50 * time.Millisecond)
+
+	engine := scripting.NewEngine(server.Call, server.IsWriteCommand)
+	engine.SetUnlock(server.ScriptUnlock)
+	server.RegisterCommand("PING", command.PingCommand)
+	server.RegisterCommand("SET", command.SetCommand(st))
+	server.RegisterCommand("GET", command.GetCommand(st))
+	server.RegisterCommand("EVAL", command.EvalCommand(engine))
+	server.RegisterCommand("SCRIPT", command.ScriptCommand(engine))
+	server.RegisterCommand("FUNCTION", command.FunctionCommand(engine))
+	server.RegisterCommand("FCALL", command.FCallCommand(engine))
+	server.RegisterCommand("SHUTDOWN", func(args []resp.Value) resp.Value {
+		return resp.OKValue()
+	})
+
+	go server.Start(port)
+	t.Cleanup(func() { server.Stop() })
+	time.Sleep(100 * time.Millisecond)
+	return server
+}
+
+func TestKillSlowScript(t *testing.T) {
+	startScriptNode(t, "16402")
+	c := dialReplTest(t, "16402")
+	other := dialReplTest(t, "16402")
+
+	if reply := c.do("EVAL", "local i = 0 while i < 100000 do i = i + 1 end return redis.call('PING')", "0"); reply.Str != "PONG" {
+		t.Errorf("Expected a short script to run, got %v", reply)
+	}
+
+	c.conn.Write(resp.SerializeArray([]resp.Value{
+		resp.BulkStringValue("EVAL"), resp.BulkStringValue("while true do end"), resp.BulkStringValue("0"),
+	}))
+	waitFor(t, "the server to report BUSY", func() bool {
+		return strings.HasPrefix(other.do("GET", "key").Str, "BUSY")
+	})
+
+	if reply := other.do("SHUTDOWN"); !strings.HasPrefix(reply.Str, "BUSY") {
+		t.Errorf("Expected SHUTDOWN without NOSAVE to be refused, got %v", reply)
+	}
+	if reply := other.do("FUNCTION", "KILL"); !strings.HasPrefix(reply.Str, "NOTBUSY") {
+		t.Errorf("Expected FUNCTION KILL to leave an EVAL script alone, got %v", reply)
+	}
+	if reply := other.do("SCRIPT", "KILL"); reply.Str != "OK" {
+		t.Fatalf("Expected SCRIPT KILL to succeed, got %v", reply)
+	}
+
+	reply, err := c.parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(reply.Str, "Script killed by user with SCRIPT KILL") {
+		t.Errorf("Expected the script to be killed, got %v", reply)
+	}
+	if reply := other.do("SET", "key", "value"); reply.Str != "OK" {
+		t.Errorf("Expected writes to run again after the kill, got %v", reply)
+	}
+	if reply := c.do("GET", "key"); reply.Str != "value" {
+		t.Errorf("Expected the killed script's client to be served again, got %v", reply)
+	}
+}
+
+func TestSlowWritingScriptRunsToTheEnd(t *testing.T) {
+	startScriptNode(t, "16403")
+	c := dialReplTest(t, "16403")
+	other := dialReplTest(t, "16403")
+
+	c.conn.Write(resp.SerializeArray([]resp.Value{
+		resp.BulkStringValue("EVAL"),
+		resp.BulkStringValue("redis.call('SET', 'key', 'script') local i = 0 while i < 3000000 do i = i + 1 end return 'done'"),
+		resp.BulkStringValue("0"),
+	}))
+	waitFor(t, "the server to report BUSY", func() bool {
+		return strings.HasPrefix(other.do("PING").Str, "BUSY")
+	})
+	if reply := other.do("SCRIPT", "KILL"); !strings.HasPrefix(reply.Str, "UNKILLABLE") {
+		t.Errorf("Expected a script that wrote to be unkillable, got %v", reply)
+	}
+
+	reply, err := c.parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Str != "done" {
+		t.Errorf("Expected the script to finish, got %v", reply)
+	}
+	if reply := other.do("GET", "key"); reply.Str != "script" {
+		t.Errorf("Expected the script's write, got %v", reply)
+	}
+}
+
+func TestKillSlowFunction(t *testing.T) {
+	startScriptNode(t, "16404")
+	c := dialReplTest(t, "16404")
+	other := dialReplTest(t, "16404")
+
+	c.do("FUNCTION", "LOAD", "#!lua name=lib\nredis.register_function('spin', function() while true do end end)")
+	c.conn.Write(resp.SerializeArray([]resp.Value{
+		resp.BulkStringValue("FCALL"), resp.BulkStringValue("spin"), resp.BulkStringValue("0"),
+	}))
+	waitFor(t, "the server to report BUSY", func() bool {
+		return strings.Contains(other.do("PING").Str, "FUNCTION KILL")
+	})
+
+	if reply := other.do("SCRIPT", "KILL"); !strings.HasPrefix(reply.Str, "NOTBUSY") {
+		t.Errorf("Expected SCRIPT KILL to leave a function alone, got %v", reply)
+	}
+	if reply := other.do("FUNCTION", "KILL"); reply.Str != "OK" {
+		t.Fatalf("Expected FUNCTION KILL to succeed, got %v", reply)
+	}
+
+	reply, err := c.parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(reply.Str, "Script killed by user with FUNCTION KILL") {
+		t.Errorf("Expected the function to be killed, got %v", reply)
+	}
+}
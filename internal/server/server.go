@@ -3,10 +3,14 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/lojhan/redis-clone/internal/persistence"
 	"github.com/lojhan/redis-clone/internal/resp"
@@ -14,81 +18,177 @@ import (
 )
 
 const (
-	DefaultPort = "6379"
-	MaxClients  = 10000
+	DefaultPort  = "6379"
+	MaxClients   = 10000
+	CronInterval = 100 * time.Millisecond
 )
 
 type CommandHandler func(args []resp.Value) resp.Value
 
-func isWriteCommand(cmdName string) bool {
-	writeCommands := map[string]bool{
-		"SET":       true,
-		"DEL":       true,
-		"INCR":      true,
-		"DECR":      true,
-		"LPUSH":     true,
-		"RPUSH":     true,
-		"LPOP":      true,
-		"RPOP":      true,
-		"HSET":      true,
-		"HDEL":      true,
-		"SADD":      true,
-		"SREM":      true,
-		"SPOP":      true,
-		"ZADD":      true,
-		"ZREM":      true,
-		"EXPIRE":    true,
-		"PEXPIREAT": true,
-		"FLUSHDB":   true,
-		"FLUSHALL":  true,
-	}
-	return writeCommands[cmdName]
-}
+// noReply is returned by commands that write their own reply, or none.
+var noReply = resp.Value{}
 
 func SetNonBlocking(fd int) error {
 	return syscall.SetNonblock(fd, true)
 }
 
 type Client struct {
+	id            int64
+	name          string
+	protocol      int
+	authenticated bool
 	readBuffer    []byte
+	decoder       resp.Decoder
 	inTransaction bool
 	txQueue       []resp.Value
 	watchedKeys   map[string]bool
 	isDirty       bool
+	execAborted   bool
+	conn          gnet.Conn
+	listeningPort int
+	replica       *replica
+	// Where the client's latest write ended, and the WAITAOF call it is
+	// blocked on, if any.
+	aofOffset  int64
+	aofFile    *persistence.AOF
+	replOffset int64
+	aofWait    *aofWait
+	// The script the client waits on, once it ran past the time limit.
+	script *scriptRun
+	// Set by ASKING for the next command.
+	asking bool
 }
 
+// Server serves clients on a single event loop. Cron jobs and replication
+// run in their own goroutines, so all of them hold mu while touching the
+// server or the data set.
 type Server struct {
 	gnet.BuiltinEventEngine
 
+	mu          sync.Mutex
 	eng         gnet.Engine
 	addr        string
-	handlers    map[string]CommandHandler
+	port        string
+	commands    map[string]*Command
+	oomCheck    func() error
+	writeCheck  func() error
+	cronJobs    []func()
 	clients     map[gnet.Conn]*Client
 	watchedKeys map[string][]*Client
-	aofWriter   *persistence.AOFWriter
+	aof         *persistence.AOF
 	aofEnabled  bool
+	requirePass string
+	nextID      int64
+	stats       Stats
+	repl        replication
+	aofWaiters  []*Client
+	cluster     cluster
+	effects     func(args []resp.Value, result resp.Value) [][]resp.Value
+	// Commands run by the call in progress, and what they propagate once it
+	// returns.
+	callDepth   int
+	propagation [][]resp.Value
+	// The script in progress, and the signal that none is.
+	script          *scriptRun
+	idle            *sync.Cond
+	scriptTimeLimit time.Duration
+}
+
+type Stats struct {
+	ConnectionsReceived int64
+	CommandsProcessed   int64
+	RejectedCalls       int64
+	SyncFull            int64
+	SyncPartialOK       int64
+	SyncPartialErr      int64
 }
 
 func NewServer() *Server {
-	return &Server{
-		handlers:    make(map[string]CommandHandler),
+	s := &Server{
+		commands:    make(map[string]*Command),
 		clients:     make(map[gnet.Conn]*Client),
 		watchedKeys: make(map[string][]*Client),
 		aofEnabled:  false,
+		repl:        newReplication(),
+
+		scriptTimeLimit: DefaultScriptTimeLimit,
+	}
+	s.idle = sync.NewCond(&s.mu)
+
+	for _, name := range []string{"HELLO", "AUTH", "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH", "REPLICAOF", "SLAVEOF", "PSYNC", "SYNC", "REPLCONF", "WAITAOF", "CLUSTER", "ASKING"} {
+		s.commands[name] = &Command{Spec: lookupSpec(name)}
 	}
+	s.RegisterCommand("COMMAND", s.commandCommand)
+
+	return s
 }
 
+func (s *Server) Register(spec *CommandSpec, handler CommandHandler) {
+	for _, sub := range spec.Subcommands {
+		sub.parent = spec
+	}
+	s.commands[strings.ToUpper(spec.Name)] = &Command{Spec: spec, Handler: handler}
+}
+
+// RegisterCommand registers handler under the spec of name in the command
+// table. It panics if the table has no such command, as its arity, flags
+// and keys would otherwise go unchecked.
 func (s *Server) RegisterCommand(name string, handler CommandHandler) {
-	s.handlers[strings.ToUpper(name)] = handler
+	spec := lookupSpec(name)
+	if spec == nil {
+		panic("no command table entry for " + strings.ToUpper(name))
+	}
+	s.commands[strings.ToUpper(name)] = &Command{Spec: spec, Handler: handler}
 }
 
 func (s *Server) GetHandler(name string) CommandHandler {
-	return s.handlers[strings.ToUpper(name)]
+	if cmd, exists := s.commands[strings.ToUpper(name)]; exists {
+		return cmd.Handler
+	}
+	return nil
+}
+
+func (s *Server) SetOOMCheck(check func() error) {
+	s.oomCheck = check
+}
+
+// SetWriteCheck installs a check run before every write command; when it
+// returns an error the command is rejected with that error.
+func (s *Server) SetWriteCheck(check func() error) {
+	s.writeCheck = check
+}
+
+// AddCronJob registers a function run on the event loop every CronInterval.
+func (s *Server) AddCronJob(job func()) {
+	s.cronJobs = append(s.cronJobs, job)
+}
+
+func (s *Server) SetRequirePass(password string) {
+	s.requirePass = password
+}
+
+// SetEffects installs how write commands are turned into the commands
+// propagated to the AOF and to replicas. Without it they are propagated as
+// they were called.
+func (s *Server) SetEffects(effects func(args []resp.Value, result resp.Value) [][]resp.Value) {
+	s.effects = effects
+}
+
+func (s *Server) SetAOF(aof *persistence.AOF) {
+	s.aof = aof
+	s.aofEnabled = aof != nil
+	// Marks taken against another AOF don't tell what this one synced.
+	if s.repl.master != nil {
+		s.repl.master.marks = nil
+	}
+}
+
+func (s *Server) Stats() Stats {
+	return s.stats
 }
 
-func (s *Server) SetAOFWriter(aof *persistence.AOFWriter) {
-	s.aofWriter = aof
-	s.aofEnabled = true
+func (s *Server) ResetStats() {
+	s.stats = Stats{}
 }
 
 func (s *Server) OnBoot(eng gnet.Engine) gnet.Action {
@@ -97,17 +197,41 @@ func (s *Server) OnBoot(eng gnet.Engine) gnet.Action {
 	return gnet.None
 }
 
+func (s *Server) OnTick() (time.Duration, gnet.Action) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.script != nil {
+		return CronInterval, gnet.None
+	}
+	for _, job := range s.cronJobs {
+		job()
+	}
+	s.replicationCron()
+	s.serveAOFWaiters()
+	s.clusterCron()
+	return CronInterval, gnet.None
+}
+
 func (s *Server) OnOpen(c gnet.Conn) ([]byte, gnet.Action) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	s.stats.ConnectionsReceived++
 	s.clients[c] = &Client{
+		id:            s.nextID,
+		protocol:      resp.RESP2,
 		readBuffer:    make([]byte, 0, 4096),
 		watchedKeys:   make(map[string]bool),
 		inTransaction: false,
+		conn:          c,
 	}
 	log.Printf("Client connected: %s", c.RemoteAddr())
 	return nil, gnet.None
 }
 
 func (s *Server) OnTraffic(c gnet.Conn) gnet.Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	client, exists := s.clients[c]
 	if !exists {
 		return gnet.Close
@@ -119,45 +243,55 @@ func (s *Server) OnTraffic(c gnet.Conn) gnet.Action {
 		return gnet.Close
 	}
 
-	client.readBuffer = append(client.readBuffer, buf...)
-
-	for len(client.readBuffer) > 0 {
-		parser := resp.NewParser(bytes.NewReader(client.readBuffer))
-		value, err := parser.Parse()
+	data := buf
+	if len(client.readBuffer) > 0 {
+		client.readBuffer = append(client.readBuffer, buf...)
+		data = client.readBuffer
+	}
+	return s.processInput(c, client, data)
+}
 
+// processInput runs the commands in data. What follows an incomplete
+// command, or a command that blocked the client, is kept for later.
+func (s *Server) processInput(c gnet.Conn, client *Client, data []byte) gnet.Action {
+	offset := 0
+	for offset < len(data) && client.aofWait == nil && client.script == nil {
+		value, consumed, err := client.decoder.Decode(data[offset:])
 		if err != nil {
-
-			if err.Error() == "EOF" || strings.Contains(err.Error(), "EOF") {
-
-				break
-			}
-
 			log.Printf("Error parsing command from %s: %v", c.RemoteAddr(), err)
-			response := resp.ErrorValue("ERR protocol error")
-			s.writeResponse(c, response)
-			return gnet.Close
+			return s.closeWithError(c, "ERR Protocol error: "+err.Error())
 		}
-
-		consumed := s.calculateConsumedBytes(client.readBuffer, value)
 		if consumed == 0 {
-
 			break
 		}
+		offset += consumed
 
-		response := s.processCommand(client, value)
-
-		s.writeResponse(c, response)
+		if len(value.Array) == 0 {
+			continue
+		}
 
-		client.readBuffer = client.readBuffer[consumed:]
+		if reply := s.processCommand(client, value); reply.Type != 0 {
+			s.writeResponse(c, reply)
+		}
 	}
 
+	client.readBuffer = append(client.readBuffer[:0], data[offset:]...)
+
 	return gnet.None
 }
 
 func (s *Server) OnClose(c gnet.Conn, err error) gnet.Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	client, exists := s.clients[c]
 	if exists {
 		s.unwatchAll(client)
+		if client.replica != nil {
+			s.removeReplica(client.replica)
+		}
+		if client.aofWait != nil {
+			s.removeAOFWaiter(client)
+		}
 		delete(s.clients, c)
 	}
 
@@ -186,7 +320,71 @@ func (s *Server) processCommand(client *Client, value resp.Value) resp.Value {
 
 	cmdName := strings.ToUpper(cmdValue.Str)
 
+	if s.requirePass != "" && !client.authenticated && cmdName != "AUTH" && cmdName != "HELLO" {
+		return s.rejectCommand(client, "NOAUTH Authentication required.")
+	}
+
+	spec, msg, ok := s.validateCommand(value.Array)
+	if !ok {
+		return s.rejectCommand(client, msg)
+	}
+
+	if s.script != nil && !s.allowedWhileBusy(spec, value.Array) {
+		return s.rejectCommand(client, "BUSY Redis is busy running a script. You can only call "+s.killCommand()+" or SHUTDOWN NOSAVE.")
+	}
+
+	if s.cluster.enabled {
+		if msg, redirected := s.clusterRedirect(client, spec, value.Array); redirected {
+			if cmdName == "EXEC" {
+				s.resetTransaction(client)
+				s.stats.RejectedCalls++
+				return resp.ErrorValue(msg)
+			}
+			return s.rejectCommand(client, msg)
+		}
+	}
+
+	if spec.HasFlag(FlagDenyOOM) && s.oomCheck != nil && s.oomCheck() != nil {
+		return s.rejectCommand(client, "OOM command not allowed when used memory > 'maxmemory'.")
+	}
+
+	if spec.HasFlag(FlagWrite) && s.writeCheck != nil {
+		if err := s.writeCheck(); err != nil {
+			return s.rejectCommand(client, err.Error())
+		}
+	}
+
+	if s.readOnlyReplica() && spec.Resolve(value.Array).HasFlag(FlagWrite) {
+		return s.rejectCommand(client, "READONLY You can't write against a read only replica.")
+	}
+
+	if client.inTransaction && spec.HasFlag(FlagNoMulti) {
+		return s.rejectCommand(client, "ERR Command not allowed inside a transaction")
+	}
+
 	switch cmdName {
+	case "HELLO":
+		return s.hello(client, value.Array[1:])
+
+	case "AUTH":
+		args := value.Array[1:]
+		if len(args) < 1 || len(args) > 2 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'auth' command")
+		}
+		if s.requirePass == "" {
+			return resp.ErrorValue("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		}
+
+		username := "default"
+		if len(args) == 2 {
+			username = args[0].Str
+		}
+		if err := s.authenticate(username, args[len(args)-1].Str); err != nil {
+			return resp.ErrorValue(err.Error())
+		}
+		client.authenticated = true
+		return resp.OKValue()
+
 	case "MULTI":
 		if client.inTransaction {
 			return resp.ErrorValue("ERR MULTI calls can not be nested")
@@ -200,32 +398,44 @@ func (s *Server) processCommand(client *Client, value resp.Value) resp.Value {
 			return resp.ErrorValue("ERR EXEC without MULTI")
 		}
 
+		if client.execAborted {
+			s.resetTransaction(client)
+			return resp.ErrorValue("EXECABORT Transaction discarded because of previous errors.")
+		}
+
 		if client.isDirty {
-			client.inTransaction = false
-			client.txQueue = nil
-			client.isDirty = false
-			s.unwatchAll(client)
+			s.resetTransaction(client)
 			return resp.Value{Type: resp.BulkString, Null: true}
 		}
 
-		results := make([]resp.Value, len(client.txQueue))
-		for i, cmd := range client.txQueue {
-			results[i] = s.executeCommand(cmd)
+		// A save may have failed since the writes were queued.
+		if s.writeCheck != nil && s.queuesWrite(client) {
+			if err := s.writeCheck(); err != nil {
+				s.resetTransaction(client)
+				s.stats.RejectedCalls++
+				return resp.ErrorValue("EXECABORT Transaction discarded because of: " + err.Error())
+			}
 		}
 
-		client.inTransaction = false
-		client.txQueue = nil
-		s.unwatchAll(client)
-		return resp.Value{Type: resp.Array, Array: results}
+		exec := func() resp.Value {
+			aofOffset, replOffset := s.writeOffsets()
+			results := s.executeBatch(client.txQueue)
+			s.trackWrites(client, aofOffset, replOffset)
+
+			s.resetTransaction(client)
+			return resp.Value{Type: resp.Array, Array: results}
+		}
+		if function, ok := s.queuedScript(client); ok {
+			return s.runScript(client, function, exec)
+		}
+		return exec()
 
 	case "DISCARD":
 		if !client.inTransaction {
 			return resp.ErrorValue("ERR DISCARD without MULTI")
 		}
 
-		client.inTransaction = false
-		client.txQueue = nil
-		s.unwatchAll(client)
+		s.resetTransaction(client)
 		return resp.Value{Type: resp.SimpleString, Str: "OK"}
 
 	case "WATCH":
@@ -249,6 +459,28 @@ func (s *Server) processCommand(client *Client, value resp.Value) resp.Value {
 	case "UNWATCH":
 		s.unwatchAll(client)
 		return resp.Value{Type: resp.SimpleString, Str: "OK"}
+
+	case "REPLICAOF", "SLAVEOF":
+		return s.replicaOfCommand(value.Array[1:])
+
+	case "PSYNC", "SYNC":
+		return s.psync(client, value.Array)
+
+	case "REPLCONF":
+		return s.replconf(client, value.Array[1:])
+
+	case "WAITAOF":
+		return s.waitAOF(client, value.Array[1:])
+
+	case "CLUSTER":
+		return s.clusterCommand(value.Array[1:])
+
+	case "ASKING":
+		if !s.cluster.enabled {
+			return resp.ErrorValue("ERR This instance has cluster support disabled")
+		}
+		client.asking = true
+		return resp.OKValue()
 	}
 
 	if client.inTransaction {
@@ -256,32 +488,210 @@ func (s *Server) processCommand(client *Client, value resp.Value) resp.Value {
 		return resp.Value{Type: resp.SimpleString, Str: "QUEUED"}
 	}
 
-	return s.executeCommand(value)
+	execute := func() resp.Value {
+		aofOffset, replOffset := s.writeOffsets()
+		result := s.executeCommand(value)
+		s.trackWrites(client, aofOffset, replOffset)
+		return result
+	}
+	if function, ok := scriptCommands[cmdName]; ok {
+		return s.runScript(client, function, execute)
+	}
+	return execute()
+}
+
+func (s *Server) rejectCommand(client *Client, msg string) resp.Value {
+	s.stats.RejectedCalls++
+	if client.inTransaction {
+		client.execAborted = true
+	}
+	return resp.ErrorValue(msg)
+}
+
+func (s *Server) queuesWrite(client *Client) bool {
+	for _, cmd := range client.txQueue {
+		if spec := s.commandSpec(cmd.Array[0].Str); spec != nil && spec.Resolve(cmd.Array).HasFlag(FlagWrite) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) resetTransaction(client *Client) {
+	client.inTransaction = false
+	client.txQueue = nil
+	client.isDirty = false
+	client.execAborted = false
+	s.unwatchAll(client)
+}
+
+func (s *Server) authenticate(username, password string) error {
+	if username != "default" || (s.requirePass != "" && password != s.requirePass) {
+		return errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	return nil
+}
+
+func (s *Server) hello(client *Client, args []resp.Value) resp.Value {
+	protocol := client.protocol
+	if protocol == 0 {
+		protocol = resp.RESP2
+	}
+	name := client.name
+	authenticated := client.authenticated
+
+	i := 0
+	if len(args) > 0 {
+		version, err := strconv.Atoi(args[0].Str)
+		if err != nil {
+			return resp.ErrorValue("ERR Protocol version is not an integer or out of range")
+		}
+		if version < resp.RESP2 || version > resp.RESP3 {
+			return resp.ErrorValue("NOPROTO unsupported protocol version")
+		}
+		protocol = version
+		i = 1
+	}
+
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].Str)
+		switch {
+		case option == "AUTH" && i+2 < len(args):
+			if err := s.authenticate(args[i+1].Str, args[i+2].Str); err != nil {
+				return resp.ErrorValue(err.Error())
+			}
+			authenticated = true
+			i += 2
+		case option == "SETNAME" && i+1 < len(args):
+			if !isValidClientName(args[i+1].Str) {
+				return resp.ErrorValue("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			name = args[i+1].Str
+			i++
+		default:
+			return resp.ErrorValue("ERR Syntax error in HELLO option '" + args[i].Str + "'")
+		}
+	}
+
+	if s.requirePass != "" && !authenticated {
+		return resp.ErrorValue("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+
+	client.protocol = protocol
+	client.name = name
+	client.authenticated = authenticated
+
+	mode := "standalone"
+	if s.cluster.enabled {
+		mode = "cluster"
+	}
+	return resp.MapValue(
+		resp.BulkStringValue("server"), resp.BulkStringValue("redis"),
+		resp.BulkStringValue("version"), resp.BulkStringValue("7.0.0-clone"),
+		resp.BulkStringValue("proto"), resp.IntegerValue(int64(protocol)),
+		resp.BulkStringValue("id"), resp.IntegerValue(client.id),
+		resp.BulkStringValue("mode"), resp.BulkStringValue(mode),
+		resp.BulkStringValue("role"), resp.BulkStringValue(s.role()),
+		resp.BulkStringValue("modules"), resp.ArrayValue(),
+	)
+}
+
+func isValidClientName(name string) bool {
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
 }
 
 func (s *Server) executeCommand(value resp.Value) resp.Value {
 	cmdName := strings.ToUpper(value.Array[0].Str)
 
-	handler, exists := s.handlers[cmdName]
-	if !exists {
+	cmd, exists := s.commands[cmdName]
+	if !exists || cmd.Handler == nil {
 		return resp.ErrorValue(fmt.Sprintf("ERR unknown command '%s'", cmdName))
 	}
 
 	args := value.Array[1:]
-	result := handler(args)
-
-	if s.aofEnabled && result.Type != resp.Error && isWriteCommand(cmdName) {
-		if err := s.aofWriter.Append(value.Array); err != nil {
-			log.Printf("Failed to append to AOF: %v", err)
+	s.callDepth++
+	result := cmd.Handler(args)
+	s.stats.CommandsProcessed++
+
+	if result.Type != resp.Error && cmd.Spec.Resolve(value.Array).HasFlag(FlagWrite) {
+		if s.effects != nil {
+			s.propagation = append(s.propagation, s.effects(value.Array, result)...)
+		} else {
+			s.propagation = append(s.propagation, value.Array)
 		}
 	}
+	s.callDepth--
+	if s.callDepth == 0 {
+		s.flushPropagation()
+	}
 
 	return result
 }
 
+// executeBatch runs commands as one call, so that what they write is
+// propagated together.
+func (s *Server) executeBatch(commands []resp.Value) []resp.Value {
+	s.callDepth++
+	results := make([]resp.Value, len(commands))
+	for i, cmd := range commands {
+		results[i] = s.executeCommand(cmd)
+	}
+	s.callDepth--
+	s.flushPropagation()
+	return results
+}
+
+func (s *Server) Call(args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		return resp.ErrorValue("ERR empty command")
+	}
+
+	if spec := s.commandSpec(args[0].Str); spec != nil {
+		if spec.HasFlag(FlagNoScript) || spec.Resolve(args).HasFlag(FlagNoScript) {
+			return resp.ErrorValue("ERR This Redis command is not allowed from script")
+		}
+		if !spec.CheckArity(len(args)) {
+			return resp.ErrorValue("ERR Wrong number of args calling Redis command from script")
+		}
+		if spec.Resolve(args).HasFlag(FlagWrite) && s.writeCheck != nil {
+			if err := s.writeCheck(); err != nil {
+				return resp.ErrorValue(err.Error())
+			}
+		}
+		if spec.Resolve(args).HasFlag(FlagWrite) && s.readOnlyReplica() {
+			return resp.ErrorValue("READONLY You can't write against a read only replica.")
+		}
+	}
+
+	return s.executeCommand(resp.Value{Type: resp.Array, Array: args})
+}
+
+func (s *Server) IsWriteCommand(name string) bool {
+	spec := s.commandSpec(name)
+	return spec != nil && spec.HasFlag(FlagWrite)
+}
+
+func (s *Server) closeWithError(c gnet.Conn, message string) gnet.Action {
+	var buf bytes.Buffer
+	if err := resp.NewSerializer(&buf).Serialize(resp.ErrorValue(message)); err == nil {
+		if _, err := c.Write(buf.Bytes()); err != nil {
+			log.Printf("Error writing response: %v", err)
+		}
+	}
+	return gnet.Close
+}
+
 func (s *Server) writeResponse(c gnet.Conn, value resp.Value) {
 	var buf bytes.Buffer
 	serializer := resp.NewSerializer(&buf)
+	if client, exists := s.clients[c]; exists {
+		serializer.SetProtocol(client.protocol)
+	}
 	if err := serializer.Serialize(value); err != nil {
 		log.Printf("Error serializing response: %v", err)
 		return
@@ -324,37 +734,36 @@ func (s *Server) MarkKeyModified(key string) {
 	}
 }
 
-func (s *Server) calculateConsumedBytes(buf []byte, value resp.Value) int {
-
-	var tempBuf bytes.Buffer
-	serializer := resp.NewSerializer(&tempBuf)
-	if err := serializer.Serialize(value); err != nil {
-		return 0
-	}
-
-	serializedLen := tempBuf.Len()
-	if serializedLen <= len(buf) {
-		return serializedLen
-	}
-
-	return 0
-}
-
 func (s *Server) Start(port string) error {
 	if port == "" {
 		port = DefaultPort
 	}
 
 	s.addr = "tcp://:" + port
+	s.port = port
+
+	if s.cluster.enabled {
+		if err := s.startClusterBus(); err != nil {
+			return err
+		}
+	}
 
 	return gnet.Run(s, s.addr,
 		gnet.WithMulticore(false),
 		gnet.WithReusePort(true),
 		gnet.WithTCPNoDelay(gnet.TCPNoDelay),
+		gnet.WithTicker(true),
 	)
 }
 
 func (s *Server) Stop() error {
+	s.mu.Lock()
+	if s.repl.master != nil {
+		s.repl.master.close()
+	}
+	s.stopClusterBus()
+	s.mu.Unlock()
+
 	ctx := context.Background()
 	return s.eng.Stop(ctx)
 }
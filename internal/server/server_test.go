@@ -1,8 +1,8 @@
 package server
 
 import (
-	"bytes"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/lojhan/redis-clone/internal/resp"
@@ -16,8 +16,8 @@ func TestNewServer(t *testing.T) {
 		t.Fatal("NewServer returned nil")
 	}
 
-	if server.handlers == nil {
-		t.Error("handlers map not initialized")
+	if server.commands == nil {
+		t.Error("commands map not initialized")
 	}
 
 	if server.clients == nil {
@@ -40,22 +40,29 @@ func TestRegisterCommand(t *testing.T) {
 		return resp.Value{Type: resp.SimpleString, Str: "OK"}
 	}
 
-	server.RegisterCommand("TEST", testHandler)
+	server.RegisterCommand("PING", testHandler)
 
-	handler := server.GetHandler("TEST")
+	handler := server.GetHandler("PING")
 	if handler == nil {
 		t.Fatal("Handler not registered")
 	}
 
-	handler = server.GetHandler("test")
+	handler = server.GetHandler("ping")
 	if handler == nil {
 		t.Error("Handler should be case-insensitive")
 	}
 
-	handler = server.GetHandler("TeSt")
+	handler = server.GetHandler("PiNg")
 	if handler == nil {
 		t.Error("Handler should be case-insensitive")
 	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a command missing from the table to be refused")
+		}
+	}()
+	server.RegisterCommand("TEST", testHandler)
 }
 
 func TestGetHandlerNonExistent(t *testing.T) {
@@ -68,6 +75,7 @@ func TestGetHandlerNonExistent(t *testing.T) {
 }
 
 func TestIsWriteCommand(t *testing.T) {
+	server := NewServer()
 	tests := []struct {
 		name     string
 		command  string
@@ -100,9 +108,9 @@ func TestIsWriteCommand(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := isWriteCommand(tt.command)
+			result := server.IsWriteCommand(tt.command)
 			if result != tt.expected {
-				t.Errorf("isWriteCommand(%s) = %v, want %v", tt.command, result, tt.expected)
+				t.Errorf("IsWriteCommand(%s) = %v, want %v", tt.command, result, tt.expected)
 			}
 		})
 	}
@@ -539,6 +547,94 @@ func TestProcessCommandQueueing(t *testing.T) {
 	}
 }
 
+func TestProcessCommandQueueingUnknownCommand(t *testing.T) {
+	server := NewServer()
+	server.RegisterCommand("SET", func(args []resp.Value) resp.Value {
+		return resp.OKValue()
+	})
+	client := &Client{watchedKeys: make(map[string]bool)}
+
+	server.processCommand(client, commandValue("MULTI"))
+	server.processCommand(client, commandValue("SET", "k", "v"))
+
+	result := server.processCommand(client, commandValue("NOSUCHCMD", "a", "b"))
+	if result.Type != resp.Error || result.Str != "ERR unknown command 'NOSUCHCMD', with args beginning with: 'a' 'b' " {
+		t.Errorf("Expected unknown command error, got %v", result)
+	}
+	if len(client.txQueue) != 1 {
+		t.Errorf("Unknown command should not be queued, queue has %d entries", len(client.txQueue))
+	}
+
+	result = server.processCommand(client, commandValue("EXEC"))
+	if result.Type != resp.Error || result.Str != "EXECABORT Transaction discarded because of previous errors." {
+		t.Errorf("Expected EXECABORT, got %v", result)
+	}
+	if client.inTransaction || client.execAborted || client.txQueue != nil {
+		t.Error("Transaction state should be reset after EXECABORT")
+	}
+}
+
+func TestProcessCommandQueueingWrongArity(t *testing.T) {
+	server := NewServer()
+	executed := false
+	server.RegisterCommand("GET", func(args []resp.Value) resp.Value {
+		executed = true
+		return resp.NullBulkStringValue()
+	})
+	client := &Client{watchedKeys: make(map[string]bool)}
+
+	server.processCommand(client, commandValue("MULTI"))
+	server.processCommand(client, commandValue("GET", "k"))
+
+	result := server.processCommand(client, commandValue("GET"))
+	if result.Type != resp.Error || result.Str != "ERR wrong number of arguments for 'get' command" {
+		t.Errorf("Expected arity error, got %v", result)
+	}
+
+	result = server.processCommand(client, commandValue("EXEC"))
+	if result.Type != resp.Error || !strings.HasPrefix(result.Str, "EXECABORT") {
+		t.Errorf("Expected EXECABORT, got %v", result)
+	}
+	if executed {
+		t.Error("No queued command should run after EXECABORT")
+	}
+}
+
+func TestProcessCommandDiscardClearsAbort(t *testing.T) {
+	server := NewServer()
+	server.RegisterCommand("PING", func(args []resp.Value) resp.Value {
+		return resp.SimpleStringValue("PONG")
+	})
+	client := &Client{watchedKeys: make(map[string]bool)}
+
+	server.processCommand(client, commandValue("MULTI"))
+	server.processCommand(client, commandValue("NOSUCHCMD"))
+	server.processCommand(client, commandValue("DISCARD"))
+
+	server.processCommand(client, commandValue("MULTI"))
+	server.processCommand(client, commandValue("PING"))
+	result := server.processCommand(client, commandValue("EXEC"))
+	if result.Type != resp.Array || len(result.Array) != 1 || result.Array[0].Str != "PONG" {
+		t.Errorf("Expected [PONG] after DISCARD reset, got %v", result)
+	}
+}
+
+func TestProcessCommandArityOutsideTransaction(t *testing.T) {
+	server := NewServer()
+	server.RegisterCommand("ECHO", func(args []resp.Value) resp.Value {
+		return resp.BulkStringValue(args[0].Str)
+	})
+	client := &Client{watchedKeys: make(map[string]bool)}
+
+	result := server.processCommand(client, commandValue("ECHO"))
+	if result.Type != resp.Error || result.Str != "ERR wrong number of arguments for 'echo' command" {
+		t.Errorf("Expected arity error, got %v", result)
+	}
+	if client.execAborted {
+		t.Error("Errors outside MULTI should not flag a transaction")
+	}
+}
+
 func TestExecuteCommandUnknown(t *testing.T) {
 	server := NewServer()
 
@@ -588,6 +684,36 @@ func TestExecuteCommandSuccess(t *testing.T) {
 	}
 }
 
+func TestCallDispatchesToHandlers(t *testing.T) {
+	server := NewServer()
+	server.RegisterCommand("ECHO", func(args []resp.Value) resp.Value {
+		return resp.Value{Type: resp.BulkString, Str: args[0].Str}
+	})
+
+	result := server.Call([]resp.Value{
+		{Type: resp.BulkString, Str: "echo"},
+		{Type: resp.BulkString, Str: "hi"},
+	})
+	if result.Type != resp.BulkString || result.Str != "hi" {
+		t.Errorf("Expected 'hi', got %v", result)
+	}
+}
+
+func TestCallRejectsNoScriptCommands(t *testing.T) {
+	server := NewServer()
+	server.RegisterCommand("EVAL", func(args []resp.Value) resp.Value {
+		t.Error("EVAL handler should not be reached from a script")
+		return resp.OKValue()
+	})
+
+	for _, name := range []string{"EVAL", "multi", "WATCH"} {
+		result := server.Call([]resp.Value{{Type: resp.BulkString, Str: name}})
+		if result.Type != resp.Error || result.Str != "ERR This Redis command is not allowed from script" {
+			t.Errorf("Expected %s to be rejected, got %v", name, result)
+		}
+	}
+}
+
 func TestMarkKeyModified(t *testing.T) {
 	server := NewServer()
 
@@ -695,44 +821,56 @@ func TestUnwatchAllMultipleClients(t *testing.T) {
 	}
 }
 
-func TestCalculateConsumedBytes(t *testing.T) {
+func TestOnTrafficPipelined(t *testing.T) {
 	server := NewServer()
+	server.RegisterCommand("ECHO", func(args []resp.Value) resp.Value {
+		return resp.BulkStringValue(args[0].Str)
+	})
+	conn := &mockConn{}
+	server.OnOpen(conn)
 
-	value := resp.Value{
-		Type: resp.Array,
-		Array: []resp.Value{
-			{Type: resp.BulkString, Str: "PING"},
-		},
+	conn.readData = []byte("*2\r\n$4\r\nECHO\r\n$1\r\na\r\n*2\r\n$4\r\nECHO\r\n$1\r\nb\r\n*2\r\n$4\r\nEC")
+	if action := server.OnTraffic(conn); action != gnet.None {
+		t.Fatalf("Expected gnet.None action, got %v", action)
 	}
 
-	var buf bytes.Buffer
-	serializer := resp.NewSerializer(&buf)
-	serializer.Serialize(value)
-	expectedBytes := buf.Bytes()
+	if string(conn.writeBuf) != "$1\r\na\r\n$1\r\nb\r\n" {
+		t.Errorf("Unexpected responses: %q", string(conn.writeBuf))
+	}
+	if string(server.clients[conn].readBuffer) != "*2\r\n$4\r\nEC" {
+		t.Errorf("Expected partial frame to be buffered, got %q", string(server.clients[conn].readBuffer))
+	}
 
-	consumed := server.calculateConsumedBytes(expectedBytes, value)
+	conn.writeBuf = nil
+	conn.readData = []byte("HO\r\n$1\r\nc\r\n")
+	server.OnTraffic(conn)
 
-	if consumed != len(expectedBytes) {
-		t.Errorf("Expected %d consumed bytes, got %d", len(expectedBytes), consumed)
+	if string(conn.writeBuf) != "$1\r\nc\r\n" {
+		t.Errorf("Unexpected response after resuming: %q", string(conn.writeBuf))
+	}
+	if len(server.clients[conn].readBuffer) != 0 {
+		t.Errorf("Expected empty read buffer, got %q", string(server.clients[conn].readBuffer))
 	}
 }
 
-func TestCalculateConsumedBytesInsufficientBuffer(t *testing.T) {
+func TestOnTrafficNonCanonicalLengths(t *testing.T) {
 	server := NewServer()
+	server.RegisterCommand("PING", func(args []resp.Value) resp.Value {
+		return resp.SimpleStringValue("PONG")
+	})
+	conn := &mockConn{}
+	server.OnOpen(conn)
 
-	value := resp.Value{
-		Type: resp.Array,
-		Array: []resp.Value{
-			{Type: resp.BulkString, Str: "PING"},
-		},
+	conn.readData = []byte("*1\r\n$4\r\nPING\r\n*01\r\n$4\r\nPING\r\n")
+	if action := server.OnTraffic(conn); action != gnet.Close {
+		t.Errorf("Expected connection to close on invalid multibulk length, got %v", action)
 	}
 
-	smallBuf := []byte("*1\r\n")
-
-	consumed := server.calculateConsumedBytes(smallBuf, value)
-
-	if consumed != 0 {
-		t.Errorf("Expected 0 consumed bytes for insufficient buffer, got %d", consumed)
+	if string(conn.writeBuf) != "+PONG\r\n" {
+		t.Errorf("Unexpected responses: %q", string(conn.writeBuf))
+	}
+	if string(conn.syncWriteBuf) != "-ERR Protocol error: invalid multibulk length\r\n" {
+		t.Errorf("Unexpected protocol error: %q", string(conn.syncWriteBuf))
 	}
 }
 
@@ -809,9 +947,22 @@ func TestClientInitialization(t *testing.T) {
 
 type mockConn struct {
 	gnet.Conn
-	remoteAddr string
-	writeBuf   []byte
-	writeErr   error
+	remoteAddr   string
+	readData     []byte
+	writeBuf     []byte
+	syncWriteBuf []byte
+	writeErr     error
+}
+
+func (m *mockConn) Next(n int) ([]byte, error) {
+	data := m.readData
+	m.readData = nil
+	return data, nil
+}
+
+func (m *mockConn) Write(data []byte) (int, error) {
+	m.syncWriteBuf = append(m.syncWriteBuf, data...)
+	return len(data), nil
 }
 
 func (m *mockConn) RemoteAddr() net.Addr {
@@ -897,3 +1048,25 @@ func TestWriteResponse(t *testing.T) {
 		t.Errorf("Expected %q, got %q", expected, string(conn.writeBuf))
 	}
 }
+
+func BenchmarkOnTrafficPipelined(b *testing.B) {
+	server := NewServer()
+	server.RegisterCommand("PING", func(args []resp.Value) resp.Value {
+		return resp.SimpleStringValue("PONG")
+	})
+	conn := &mockConn{}
+	server.OnOpen(conn)
+
+	var pipeline []byte
+	for i := 0; i < 1000; i++ {
+		pipeline = append(pipeline, "*1\r\n$4\r\nPING\r\n"...)
+	}
+	b.SetBytes(int64(len(pipeline)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		conn.readData = pipeline
+		conn.writeBuf = conn.writeBuf[:0]
+		server.OnTraffic(conn)
+	}
+}
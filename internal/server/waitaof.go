@@ -0,0 +1,138 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+// aofWait is a WAITAOF call a client is blocked on.
+type aofWait struct {
+	numLocal    int
+	numReplicas int
+	deadline    time.Time
+}
+
+// writeOffsets returns the ends of the AOF and of the replication stream,
+// which trackWrites compares to find out whether a command wrote to them.
+func (s *Server) writeOffsets() (int64, int64) {
+	var written int64
+	if s.aof != nil {
+		written, _ = s.aof.Offsets()
+	}
+	return written, s.repl.offset
+}
+
+// trackWrites records, for WAITAOF, where the client's latest write ended
+// in the AOF and in the replication stream.
+func (s *Server) trackWrites(client *Client, aofBefore, replBefore int64) {
+	aofOffset, replOffset := s.writeOffsets()
+	if aofOffset != aofBefore {
+		client.aofOffset = aofOffset
+		client.aofFile = s.aof
+	}
+	if replOffset != replBefore {
+		client.replOffset = replOffset
+	}
+}
+
+func (s *Server) waitAOF(client *Client, args []resp.Value) resp.Value {
+	numLocal, err := strconv.Atoi(args[0].Str)
+	if err != nil || numLocal < 0 {
+		return resp.ErrorValue("ERR value is not an integer or out of range")
+	}
+	numReplicas, err := strconv.Atoi(args[1].Str)
+	if err != nil || numReplicas < 0 {
+		return resp.ErrorValue("ERR value is not an integer or out of range")
+	}
+	timeout, err := strconv.ParseInt(args[2].Str, 10, 64)
+	if err != nil {
+		return resp.ErrorValue("ERR timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return resp.ErrorValue("ERR timeout is negative")
+	}
+
+	if s.repl.master != nil {
+		return resp.ErrorValue("ERR WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.")
+	}
+	if numLocal > 0 && !s.aofEnabled {
+		return resp.ErrorValue("ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+	}
+
+	wait := &aofWait{numLocal: numLocal, numReplicas: numReplicas}
+	if reply, done := s.checkAOFWait(client, wait, false); done {
+		return reply
+	}
+	if timeout > 0 {
+		wait.deadline = time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	}
+	client.aofWait = wait
+	s.aofWaiters = append(s.aofWaiters, client)
+	return noReply
+}
+
+// checkAOFWait returns the reply to a WAITAOF call once enough copies of
+// the client's writes are fsynced, or once it expired.
+func (s *Server) checkAOFWait(client *Client, wait *aofWait, expired bool) (resp.Value, bool) {
+	local := 0
+	if s.aofEnabled && s.aofSynced(client) {
+		local = 1
+	}
+	replicas := 0
+	for _, r := range s.repl.replicas {
+		if r.fackOffset >= client.replOffset {
+			replicas++
+		}
+	}
+
+	if !expired && (local < wait.numLocal || replicas < wait.numReplicas) {
+		return resp.Value{}, false
+	}
+	return resp.ArrayValue(resp.IntegerValue(int64(local)), resp.IntegerValue(int64(replicas))), true
+}
+
+// aofSynced reports whether the client's latest write to the AOF is on
+// disk. Writes to an AOF that has since been closed were fsynced on close.
+func (s *Server) aofSynced(client *Client) bool {
+	if client.aofFile != s.aof {
+		return true
+	}
+	_, synced := s.aof.Offsets()
+	return synced >= client.aofOffset
+}
+
+// serveAOFWaiters replies to the WAITAOF calls that are satisfied or timed
+// out, and resumes reading commands from those clients.
+func (s *Server) serveAOFWaiters() {
+	if len(s.aofWaiters) == 0 {
+		return
+	}
+
+	now := time.Now()
+	waiting := s.aofWaiters[:0]
+	for _, client := range s.aofWaiters {
+		wait := client.aofWait
+		expired := !wait.deadline.IsZero() && now.After(wait.deadline)
+		reply, done := s.checkAOFWait(client, wait, expired)
+		if !done {
+			waiting = append(waiting, client)
+			continue
+		}
+		client.aofWait = nil
+		s.writeResponse(client.conn, reply)
+		client.conn.Wake(nil)
+	}
+	clear(s.aofWaiters[len(waiting):])
+	s.aofWaiters = waiting
+}
+
+func (s *Server) removeAOFWaiter(client *Client) {
+	for i, candidate := range s.aofWaiters {
+		if candidate == client {
+			s.aofWaiters = append(s.aofWaiters[:i], s.aofWaiters[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lojhan/redis-clone/internal/persistence"
+	"github.com/lojhan/redis-clone/internal/resp"
+)
+
+func enableAOF(t *testing.T, server *Server, policy persistence.AOFSyncPolicy) {
+	t.Helper()
+	aof, err := persistence.OpenAOF(t.TempDir(), "appendonly.aof", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { aof.Close() })
+	server.mu.Lock()
+	server.SetAOF(aof)
+	server.mu.Unlock()
+}
+
+func expectWaitAOF(t *testing.T, reply resp.Value, local, replicas int64) {
+	t.Helper()
+	if reply.Type != resp.Array || len(reply.Array) != 2 || reply.Array[0].Int != local || reply.Array[1].Int != replicas {
+		t.Errorf("Expected [%d %d], got %v", local, replicas, reply)
+	}
+}
+
+func TestWaitAOF(t *testing.T) {
+	server := startReplicationNode(t, "16390")
+	c := dialReplTest(t, "16390")
+
+	if reply := c.do("WAITAOF", "1", "0", "0"); reply.Type != resp.Error {
+		t.Errorf("Expected an error with appendonly disabled, got %v", reply)
+	}
+	expectWaitAOF(t, c.do("WAITAOF", "0", "0", "0"), 0, 0)
+
+	enableAOF(t, server, persistence.AOFSyncEverySec)
+	expectWaitAOF(t, c.do("WAITAOF", "1", "0", "0"), 1, 0)
+
+	// The reply waits for the next fsync, and commands sent meanwhile run
+	// after it.
+	c.do("SET", "key", "value")
+	start := time.Now()
+	c.conn.Write(append(resp.SerializeArray([]resp.Value{
+		resp.BulkStringValue("WAITAOF"), resp.BulkStringValue("1"), resp.BulkStringValue("0"), resp.BulkStringValue("0"),
+	}), "*1\r\n$4\r\nPING\r\n"...))
+	reply, err := c.parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectWaitAOF(t, reply, 1, 0)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the write to be fsynced within a second, took %v", elapsed)
+	}
+	if reply, _ := c.parser.Parse(); reply.Str != "PONG" {
+		t.Errorf("Expected PONG after WAITAOF, got %v", reply)
+	}
+}
+
+func TestWaitAOFTimeout(t *testing.T) {
+	server := startReplicationNode(t, "16391")
+	enableAOF(t, server, persistence.AOFSyncNo)
+	c := dialReplTest(t, "16391")
+
+	c.do("SET", "key", "value")
+	start := time.Now()
+	expectWaitAOF(t, c.do("WAITAOF", "1", "0", "200"), 0, 0)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected WAITAOF to wait for its timeout, returned after %v", elapsed)
+	}
+
+	c.do("MULTI")
+	if reply := c.do("WAITAOF", "0", "0", "0"); reply.Str != "ERR Command not allowed inside a transaction" {
+		t.Errorf("Expected WAITAOF to be rejected in MULTI, got %v", reply)
+	}
+	if reply := c.do("EXEC"); reply.Type != resp.Error {
+		t.Errorf("Expected EXECABORT, got %v", reply)
+	}
+}
+
+func TestWaitAOFReplicas(t *testing.T) {
+	startReplicationNode(t, "16392")
+	replica := startReplicationNode(t, "16393")
+	enableAOF(t, replica, persistence.AOFSyncEverySec)
+	m := dialReplTest(t, "16392")
+	r := dialReplTest(t, "16393")
+
+	r.do("REPLICAOF", "localhost", "16392")
+	waitFor(t, "the link to be up", func() bool { return infoField(r.info(), "master_link_status") == "up" })
+
+	m.do("SET", "key", "value")
+	expectWaitAOF(t, m.do("WAITAOF", "0", "1", "5000"), 0, 1)
+	if reply := r.do("WAITAOF", "0", "0", "0"); reply.Type != resp.Error {
+		t.Errorf("Expected WAITAOF to fail on a replica, got %v", reply)
+	}
+
+	// Under steady writes the replica's AOF always has unsynced data, yet
+	// each fsync still acknowledges the part of the stream it covered.
+	writer := dialReplTest(t, "16392")
+	go io.Copy(io.Discard, writer.conn)
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		set := resp.SerializeArray([]resp.Value{resp.BulkStringValue("SET"), resp.BulkStringValue("busy"), resp.BulkStringValue("value")})
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				writer.conn.Write(set)
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		m.do("SET", "key", "value")
+		start := time.Now()
+		expectWaitAOF(t, m.do("WAITAOF", "0", "1", "5000"), 0, 1)
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("Expected the write to be acknowledged within a few seconds, took %v", elapsed)
+		}
+	}
+}
@@ -209,3 +209,47 @@ func sendCommand(t *testing.T, serializer *resp.Serializer, writer *bufio.Writer
 		t.Fatalf("Failed to flush: %v", err)
 	}
 }
+
+func TestWatchDirtyOnFlushAndExpiry(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(s *store.Store)
+		touch func(s *store.Store)
+	}{
+		{
+			name:  "FLUSHDB",
+			setup: func(s *store.Store) { s.Set("watched", "v") },
+			touch: func(s *store.Store) { s.FlushDB() },
+		},
+		{
+			name:  "expiry",
+			setup: func(s *store.Store) { s.SetWithExpiry("watched", "v", time.Now().Add(20*time.Millisecond)) },
+			touch: func(s *store.Store) {
+				time.Sleep(30 * time.Millisecond)
+				s.Get("watched")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer()
+			s := store.NewStore()
+			s.SetKeyModifiedHandler(server.MarkKeyModified)
+			server.RegisterCommand("SET", command.SetCommand(s))
+
+			tt.setup(s)
+			client := &Client{watchedKeys: make(map[string]bool)}
+			server.processCommand(client, commandValue("WATCH", "watched"))
+
+			tt.touch(s)
+
+			server.processCommand(client, commandValue("MULTI"))
+			server.processCommand(client, commandValue("SET", "other", "v"))
+			result := server.processCommand(client, commandValue("EXEC"))
+			if result.Type != resp.BulkString || !result.Null {
+				t.Errorf("Expected EXEC to abort with null, got %v", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"iter"
+	"maps"
+	"unsafe"
+)
+
+const (
+	// A dict is resized once it holds fewer than 1/dictShrinkRatio of the
+	// entries it was sized for.
+	dictShrinkRatio = 10
+	// Dicts that never held more entries are not worth resizing.
+	dictMinPeak = 1024
+)
+
+// dict is a map that can shrink. Go maps keep the memory of deleted entries
+// until they are dropped, so a dict that lost most of its entries is moved
+// to a right-sized map a batch at a time: while that runs, new entries go to
+// m, and lookups fall back to old for the entries not moved yet.
+type dict[V any] struct {
+	m   map[string]V
+	old map[string]V
+	// Pulls the next entry of old to move.
+	next func() (string, V, bool)
+	stop func()
+	// The most entries m held since it was allocated.
+	peak int
+	// While snapshots read the entries, they are frozen in base, and the
+	// dict holds the changes on top of them: entries in m, and the keys of
+	// base that were deleted or replaced in hidden.
+	base   *dict[V]
+	hidden map[string]struct{}
+}
+
+func newDict[V any](size int) dict[V] {
+	return dict[V]{m: make(map[string]V, size), peak: size}
+}
+
+func (d *dict[V]) get(key string) (V, bool) {
+	if value, ok := d.m[key]; ok || (d.old == nil && d.base == nil) {
+		return value, ok
+	}
+	if value, ok := d.old[key]; ok || d.base == nil {
+		return value, ok
+	}
+	return d.getBase(key)
+}
+
+// getBase looks key up in the frozen entries.
+func (d *dict[V]) getBase(key string) (V, bool) {
+	if _, hidden := d.hidden[key]; hidden || d.base == nil {
+		var zero V
+		return zero, false
+	}
+	return d.base.get(key)
+}
+
+// set stores value under key and reports whether the key is new.
+func (d *dict[V]) set(key string, value V) bool {
+	_, exists := d.m[key]
+	if !exists && d.old != nil {
+		if _, exists = d.old[key]; exists {
+			delete(d.old, key)
+		}
+	}
+	if !exists && d.base != nil {
+		if _, exists = d.getBase(key); exists {
+			d.hidden[key] = struct{}{}
+		}
+	}
+	d.m[key] = value
+	if len(d.m) > d.peak {
+		d.peak = len(d.m)
+	}
+	return !exists
+}
+
+// del removes key and reports whether it was there.
+func (d *dict[V]) del(key string) bool {
+	if _, ok := d.m[key]; ok {
+		delete(d.m, key)
+		return true
+	}
+	if _, ok := d.old[key]; ok {
+		delete(d.old, key)
+		return true
+	}
+	if _, ok := d.getBase(key); ok {
+		d.hidden[key] = struct{}{}
+		return true
+	}
+	return false
+}
+
+func (d *dict[V]) len() int {
+	n := len(d.m) + len(d.old)
+	if d.base != nil {
+		n += d.base.len() - len(d.hidden)
+	}
+	return n
+}
+
+func (d *dict[V]) all() iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		for key, value := range d.m {
+			if !yield(key, value) {
+				return
+			}
+		}
+		for key, value := range d.old {
+			if !yield(key, value) {
+				return
+			}
+		}
+		if d.base == nil {
+			return
+		}
+		for key, value := range d.base.all() {
+			if _, hidden := d.hidden[key]; !hidden && !yield(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// freeze returns the dict's entries for a snapshot to read, and keeps
+// changes out of them until thaw.
+func (d *dict[V]) freeze() dict[V] {
+	frozen := *d
+	*d = dict[V]{m: make(map[string]V), base: &frozen, hidden: make(map[string]struct{})}
+	return frozen
+}
+
+// thaw applies the changes made since freeze to the frozen entries, once
+// no snapshot reads them. It takes time in the number of changes, not of
+// entries.
+func (d *dict[V]) thaw() {
+	if d.base == nil {
+		return
+	}
+	base := d.base
+	base.thaw()
+	for key := range d.hidden {
+		base.del(key)
+	}
+	for key, value := range d.m {
+		base.set(key, value)
+	}
+	for key, value := range d.old {
+		base.set(key, value)
+	}
+	*d = *base
+}
+
+func (d *dict[V]) clone() dict[V] {
+	clone := newDict[V](d.len())
+	for key, value := range d.all() {
+		clone.m[key] = value
+	}
+	return clone
+}
+
+func (d *dict[V]) shrinkable() bool {
+	return d.old == nil && d.base == nil && d.peak >= dictMinPeak && len(d.m)*dictShrinkRatio < d.peak
+}
+
+// startResize starts moving the entries to a right-sized map, and returns
+// an estimate of the bytes that frees once done.
+func (d *dict[V]) startResize() int64 {
+	var key string
+	var value V
+	// Go maps keep a control byte per slot and fill up to 7/8 of them.
+	slot := int64(unsafe.Sizeof(key)+unsafe.Sizeof(value)+1) * 8 / 7
+	freed := int64(d.peak-len(d.m)) * slot
+
+	d.old, d.m = d.m, make(map[string]V, len(d.m))
+	d.peak = len(d.old)
+	d.next, d.stop = iter.Pull2(maps.All(d.old))
+	return freed
+}
+
+// rehash moves up to n entries to the new map, and reports whether the
+// resize is done.
+func (d *dict[V]) rehash(n int) bool {
+	for ; n > 0 && len(d.old) > 0; n-- {
+		key, value, ok := d.next()
+		if !ok {
+			break
+		}
+		d.m[key] = value
+		delete(d.old, key)
+	}
+	if len(d.old) > 0 {
+		return false
+	}
+	d.release()
+	return true
+}
+
+// release ends a resize in progress, keeping the entries not moved yet in
+// old. It leaves the dict readable but no longer resizable.
+func (d *dict[V]) release() {
+	if d.base != nil {
+		d.base.release()
+	}
+	if d.stop != nil {
+		d.stop()
+		d.next, d.stop = nil, nil
+	}
+	if len(d.old) == 0 {
+		d.old = nil
+	}
+}
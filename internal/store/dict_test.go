@@ -0,0 +1,124 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDictResize(t *testing.T) {
+	d := newDict[int](0)
+	for i := 0; i < 10000; i++ {
+		d.set(strconv.Itoa(i), i)
+	}
+	if d.shrinkable() {
+		t.Error("A full dict should not be shrinkable")
+	}
+	for i := 500; i < 10000; i++ {
+		d.del(strconv.Itoa(i))
+	}
+	if !d.shrinkable() {
+		t.Fatal("Expected a dict holding 5% of its peak to be shrinkable")
+	}
+
+	if freed := d.startResize(); freed <= 0 {
+		t.Errorf("Expected the resize to free memory, got %d", freed)
+	}
+	if d.rehash(100) {
+		t.Fatal("Expected the resize to take more than one batch")
+	}
+
+	// Lookups, updates and deletes see both maps while entries move.
+	for i := 0; i < 500; i++ {
+		if value, ok := d.get(strconv.Itoa(i)); !ok || value != i {
+			t.Fatalf("Expected %d during the resize, got %d, %v", i, value, ok)
+		}
+	}
+	if d.set("0", -1) || d.set("1", -1) {
+		t.Error("Overwriting an entry should not report it as new")
+	}
+	if !d.set("new", 1) {
+		t.Error("Expected a new entry to be reported as new")
+	}
+	if !d.del("2") || !d.del("499") || d.del("9999") {
+		t.Error("Unexpected results deleting during the resize")
+	}
+	if d.len() != 499 {
+		t.Errorf("Expected 499 entries, got %d", d.len())
+	}
+	seen := 0
+	for range d.all() {
+		seen++
+	}
+	if seen != 499 {
+		t.Errorf("Expected to iterate over 499 entries, got %d", seen)
+	}
+
+	for !d.rehash(100) {
+	}
+	if d.old != nil || len(d.m) != 499 {
+		t.Errorf("Expected all entries in the new map, got %d and %d", len(d.m), len(d.old))
+	}
+	if value, _ := d.get("0"); value != -1 {
+		t.Errorf("Expected updated value -1, got %d", value)
+	}
+	if d.shrinkable() {
+		t.Error("A freshly resized dict should not be shrinkable")
+	}
+}
+
+func TestDictSmallNotShrinkable(t *testing.T) {
+	d := newDict[int](0)
+	for i := 0; i < dictMinPeak-1; i++ {
+		d.set(strconv.Itoa(i), i)
+	}
+	for i := 0; i < dictMinPeak-1; i++ {
+		d.del(strconv.Itoa(i))
+	}
+	if d.shrinkable() {
+		t.Error("Small dicts should not be resized")
+	}
+}
+
+func TestDictFreeze(t *testing.T) {
+	d := newDict[int](0)
+	for i := 0; i < 10; i++ {
+		d.set(strconv.Itoa(i), i)
+	}
+
+	frozen := d.freeze()
+	if d.set("0", -1) || !d.set("new", 10) || !d.del("1") || d.del("1") {
+		t.Error("Unexpected results changing a frozen dict")
+	}
+	if d.set("1", 1) != true {
+		t.Error("A key deleted since the freeze should be new again")
+	}
+	d.del("2")
+
+	if frozen.len() != 10 {
+		t.Errorf("Expected the frozen entries to be unchanged, got %d", frozen.len())
+	}
+	if value, _ := frozen.get("0"); value != 0 {
+		t.Errorf("Expected the frozen value 0, got %d", value)
+	}
+	if d.len() != 10 {
+		t.Errorf("Expected 10 entries, got %d", d.len())
+	}
+	seen := 0
+	for range d.all() {
+		seen++
+	}
+	if seen != 10 {
+		t.Errorf("Expected to iterate over 10 entries, got %d", seen)
+	}
+
+	d.thaw()
+	if d.base != nil || d.len() != 10 {
+		t.Errorf("Expected the changes folded back, got %d entries", d.len())
+	}
+	if value, _ := d.get("0"); value != -1 {
+		t.Errorf("Expected updated value -1, got %d", value)
+	}
+	if _, ok := d.get("2"); ok {
+		t.Error("Expected the deleted key to stay deleted")
+	}
+}
@@ -119,13 +119,13 @@ func (s *Store) evictApproximateLRU(volatileOnly bool) string {
 
 	var candidates []string
 	if volatileOnly {
-		for key := range s.expires {
-			if _, exists := s.data[key]; exists {
+		for key := range s.expires.all() {
+			if _, exists := s.data.get(key); exists {
 				candidates = append(candidates, key)
 			}
 		}
 	} else {
-		for key := range s.data {
+		for key := range s.data.all() {
 			candidates = append(candidates, key)
 		}
 	}
@@ -154,7 +154,7 @@ func (s *Store) evictApproximateLRU(volatileOnly bool) string {
 		}
 
 		key := candidates[idx]
-		if obj, exists := s.data[key]; exists {
+		if obj, exists := s.data.get(key); exists {
 			if obj.LRU < oldestLRU {
 				oldestLRU = obj.LRU
 				oldestKey = key
@@ -174,13 +174,13 @@ func (s *Store) evictRandom(volatileOnly bool) string {
 	var candidates []string
 
 	if volatileOnly {
-		for key := range s.expires {
-			if _, exists := s.data[key]; exists {
+		for key := range s.expires.all() {
+			if _, exists := s.data.get(key); exists {
 				candidates = append(candidates, key)
 			}
 		}
 	} else {
-		for key := range s.data {
+		for key := range s.data.all() {
 			candidates = append(candidates, key)
 		}
 	}
@@ -199,8 +199,8 @@ func (s *Store) evictShortestTTL() string {
 	var shortestTTL time.Duration = time.Duration(1<<63 - 1)
 
 	now := time.Now()
-	for key, expireTime := range s.expires {
-		if _, exists := s.data[key]; exists {
+	for key, expireTime := range s.expires.all() {
+		if _, exists := s.data.get(key); exists {
 			ttl := expireTime.Sub(now)
 			if ttl < shortestTTL {
 				shortestTTL = ttl
@@ -219,15 +219,16 @@ func (s *Store) evictShortestTTL() string {
 
 func (s *Store) deleteInternal(key string) {
 	if s.evictionConfig != nil && s.evictionConfig.memoryTracking {
-		if obj, exists := s.data[key]; exists {
+		if obj, exists := s.data.get(key); exists {
 			s.evictionConfig.currentMemory -= EstimateObjectSize(obj)
 			s.evictionConfig.currentMemory -= EstimateKeySize(key)
 		}
 	}
 
-	delete(s.data, key)
-	delete(s.expires, key)
+	s.delKey(key)
+	s.expires.del(key)
 	s.notifyKeyModified(key)
+	s.notifyKeyRemoved(key)
 }
 
 func (s *Store) UpdateMemoryUsage(delta int64) {
@@ -250,6 +251,57 @@ func (s *Store) IsMemoryExceeded() bool {
 	return s.evictionConfig.currentMemory > s.evictionConfig.MaxMemory
 }
 
+// ConfigureEviction changes the memory limits of a running store. The first
+// call on a store that was not tracking memory sizes up the existing dataset
+// so the limit applies to keys written before it was set.
+func (s *Store) ConfigureEviction(maxMemory int64, policy EvictionPolicy, samples int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.evictionConfig == nil || !s.evictionConfig.memoryTracking {
+		config := NewEvictionConfig(maxMemory, policy, samples)
+		config.memoryTracking = true
+		for key, obj := range s.data.all() {
+			config.currentMemory += EstimateObjectSize(obj) + EstimateKeySize(key)
+		}
+		s.evictionConfig = config
+		return
+	}
+
+	s.evictionConfig.MaxMemory = maxMemory
+	s.evictionConfig.Policy = policy
+	if samples > 0 {
+		s.evictionConfig.Samples = samples
+	}
+}
+
+func (s *Store) EvictionSettings() (int64, EvictionPolicy, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.evictionConfig == nil {
+		return 0, EvictionNoEviction, 5
+	}
+	return s.evictionConfig.MaxMemory, s.evictionConfig.Policy, s.evictionConfig.Samples
+}
+
+func (s *Store) EvictedKeys() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.evictedKeys
+}
+
+func (s *Store) ResetStats() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictedKeys = 0
+}
+
+func (s *Store) EvictIfNeeded() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.TryEvictUntilUnderLimit()
+}
+
 func (s *Store) TryEvictUntilUnderLimit() error {
 	if !s.IsMemoryExceeded() {
 		return nil
@@ -266,6 +318,7 @@ func (s *Store) TryEvictUntilUnderLimit() error {
 
 			return fmt.Errorf("OOM: no keys available for eviction")
 		}
+		s.evictedKeys++
 	}
 
 	if s.IsMemoryExceeded() {
@@ -237,6 +237,42 @@ func TestMemoryTracking(t *testing.T) {
 	}
 }
 
+func TestConfigureEvictionAtRuntime(t *testing.T) {
+	s := NewStore()
+	s.Set("existing", "value")
+
+	s.ConfigureEviction(1, EvictionAllKeysRandom, 3)
+
+	if s.GetMemoryUsage() == 0 {
+		t.Error("Existing keys should count towards memory once eviction is configured")
+	}
+
+	maxMemory, policy, samples := s.EvictionSettings()
+	if maxMemory != 1 || policy != EvictionAllKeysRandom || samples != 3 {
+		t.Errorf("Unexpected settings: %d %s %d", maxMemory, policy, samples)
+	}
+
+	if err := s.EvictIfNeeded(); err != nil {
+		t.Errorf("Unexpected eviction error: %v", err)
+	}
+	if s.Exists("existing") {
+		t.Error("Expected the existing key to be evicted")
+	}
+	if s.EvictedKeys() != 1 {
+		t.Errorf("Expected 1 evicted key, got %d", s.EvictedKeys())
+	}
+
+	s.ResetStats()
+	if s.EvictedKeys() != 0 {
+		t.Error("ResetStats should clear the evicted keys counter")
+	}
+
+	s.ConfigureEviction(0, EvictionNoEviction, 0)
+	if err := s.EvictIfNeeded(); err != nil {
+		t.Errorf("Disabling maxmemory should stop evictions: %v", err)
+	}
+}
+
 func TestEstimateObjectSize(t *testing.T) {
 
 	strObj := &RedisObject{
@@ -256,3 +292,22 @@ func TestEstimateObjectSize(t *testing.T) {
 		t.Error("Nil object should have 0 size")
 	}
 }
+
+func TestKeyModifiedOnEviction(t *testing.T) {
+	s := NewStore()
+	s.SetEvictionConfig(NewEvictionConfig(1, EvictionAllKeysRandom, 5))
+	s.data.set("victim", createStringObject("value"))
+
+	var modified []string
+	s.SetKeyModifiedHandler(func(key string) {
+		modified = append(modified, key)
+	})
+
+	key, ok := s.PerformEviction()
+	if !ok || key != "victim" {
+		t.Fatalf("Expected 'victim' to be evicted, got %q", key)
+	}
+	if len(modified) != 1 || modified[0] != "victim" {
+		t.Errorf("Expected eviction to notify 'victim', got %v", modified)
+	}
+}
@@ -1,63 +1,60 @@
 package store
 
 type HashTable struct {
-	data map[string]string
+	data dict[string]
 }
 
 func NewHashTable() *HashTable {
 	return &HashTable{
-		data: make(map[string]string),
+		data: newDict[string](0),
 	}
 }
 
 func (h *HashTable) Set(field, value string) bool {
-	_, exists := h.data[field]
-	h.data[field] = value
-	return !exists
+	return h.data.set(field, value)
 }
 
 func (h *HashTable) Get(field string) (string, bool) {
-	value, exists := h.data[field]
-	return value, exists
+	return h.data.get(field)
 }
 
 func (h *HashTable) Delete(field string) bool {
-	_, exists := h.data[field]
-	if exists {
-		delete(h.data, field)
-	}
-	return exists
+	return h.data.del(field)
 }
 
 func (h *HashTable) Exists(field string) bool {
-	_, exists := h.data[field]
+	_, exists := h.data.get(field)
 	return exists
 }
 
 func (h *HashTable) Len() int {
-	return len(h.data)
+	return h.data.len()
 }
 
 func (h *HashTable) GetAll() map[string]string {
-	result := make(map[string]string, len(h.data))
-	for k, v := range h.data {
+	result := make(map[string]string, h.data.len())
+	for k, v := range h.data.all() {
 		result[k] = v
 	}
 	return result
 }
 
 func (h *HashTable) Fields() []string {
-	fields := make([]string, 0, len(h.data))
-	for field := range h.data {
+	fields := make([]string, 0, h.data.len())
+	for field := range h.data.all() {
 		fields = append(fields, field)
 	}
 	return fields
 }
 
 func (h *HashTable) Values() []string {
-	values := make([]string, 0, len(h.data))
-	for _, value := range h.data {
+	values := make([]string, 0, h.data.len())
+	for _, value := range h.data.all() {
 		values = append(values, value)
 	}
 	return values
 }
+
+func (h *HashTable) Clone() *HashTable {
+	return &HashTable{data: h.data.clone()}
+}
@@ -260,3 +260,23 @@ func (q *Quicklist) ToSlice() []string {
 
 	return result
 }
+
+func (q *Quicklist) Clone() *Quicklist {
+	clone := NewQuicklist()
+	for node := q.head; node != nil; node = node.next {
+		copied := &QuicklistNode{
+			prev:    clone.tail,
+			entries: append([]string(nil), node.entries...),
+			size:    node.size,
+		}
+		if clone.tail == nil {
+			clone.head = copied
+		} else {
+			clone.tail.next = copied
+		}
+		clone.tail = copied
+	}
+	clone.len = q.len
+	clone.count = q.count
+	return clone
+}
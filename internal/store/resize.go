@@ -0,0 +1,140 @@
+package store
+
+import "time"
+
+// Entries moved per batch of the resize pass; the store lock is released
+// between batches.
+const resizeBatch = 1000
+
+type resizer interface {
+	shrinkable() bool
+	startResize() int64
+	rehash(n int) bool
+}
+
+// resizeState tracks the background pass that moves sparse maps to
+// right-sized ones, one map at a time.
+type resizeState struct {
+	current resizer
+	// The object owning current, or nil for the keyspace maps.
+	owner *RedisObject
+	freed int64
+	// Collections that lost entries since they were last looked at.
+	pending   map[string]struct{}
+	resized   int64
+	reclaimed int64
+}
+
+// Resize runs the resize pass for about budget and reports whether it has
+// more to do.
+func (s *Store) Resize(budget time.Duration) bool {
+	deadline := time.Now().Add(budget)
+	for {
+		s.mu.Lock()
+		more := s.resizeStep()
+		s.mu.Unlock()
+		if !more || !time.Now().Before(deadline) {
+			return more
+		}
+	}
+}
+
+// ResizeStats returns whether a map is being resized, how many were, and
+// an estimate of the bytes that freed.
+func (s *Store) ResizeStats() (bool, int64, int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.resize.current != nil, s.resize.resized, s.resize.reclaimed
+}
+
+func (s *Store) resizeStep() bool {
+	r := &s.resize
+	if r.current == nil && !s.startNextResize() {
+		return false
+	}
+	// A map an open snapshot may be reading must not move under it.
+	if r.owner != nil && s.shared(r.owner) || r.owner == nil && s.openSnapshots > 0 {
+		return false
+	}
+	if !r.current.rehash(resizeBatch) {
+		return true
+	}
+	r.resized++
+	r.reclaimed += r.freed
+	r.current, r.owner = nil, nil
+	return true
+}
+
+func (s *Store) startNextResize() bool {
+	r := &s.resize
+	for _, d := range []resizer{&s.data, &s.expires} {
+		if d.shrinkable() {
+			r.current, r.owner, r.freed = d, nil, d.startResize()
+			return true
+		}
+	}
+
+	for key := range r.pending {
+		obj, exists := s.data.get(key)
+		if exists && s.shared(obj) {
+			continue
+		}
+		delete(r.pending, key)
+		if !exists {
+			continue
+		}
+		if d := collectionDict(obj); d != nil && d.shrinkable() {
+			r.current, r.owner, r.freed = d, obj, d.startResize()
+			return true
+		}
+	}
+	if len(r.pending) == 0 {
+		r.pending = nil
+	}
+	return false
+}
+
+// noteShrink queues the collection at key for the resize pass if it lost
+// enough entries to be worth it.
+func (s *Store) noteShrink(key string, obj *RedisObject) {
+	if d := collectionDict(obj); d != nil && d.shrinkable() {
+		if s.resize.pending == nil {
+			s.resize.pending = make(map[string]struct{})
+		}
+		s.resize.pending[key] = struct{}{}
+	}
+}
+
+// replaceKeyspace swaps in new keyspace maps, ending a resize of the old
+// ones.
+func (s *Store) replaceKeyspace(data dict[*RedisObject], expires dict[time.Time]) {
+	if s.resize.current != nil && s.resize.owner == nil {
+		s.resize.current = nil
+	}
+	s.resize.pending = nil
+	s.data.release()
+	s.expires.release()
+	s.data, s.expires = data, expires
+	if s.slots != nil {
+		s.slots = &slotIndex{}
+		for key := range s.data.all() {
+			s.slots.add(key)
+		}
+	}
+}
+
+func collectionDict(obj *RedisObject) resizer {
+	switch ptr := obj.Ptr.(type) {
+	case *HashTable:
+		return &ptr.data
+	case *Set:
+		return &ptr.members
+	case *ZSet:
+		return &ptr.dict
+	}
+	return nil
+}
+
+func dictOf[V any](m map[string]V) dict[V] {
+	return dict[V]{m: m, peak: len(m)}
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func finishResize(s *Store) {
+	for s.Resize(time.Second) {
+	}
+}
+
+func TestResizeCollections(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 5000; i++ {
+		field := strconv.Itoa(i)
+		s.HSet("hash", field, "v")
+		s.SAdd("set", field)
+		s.ZAdd("zset", float64(i), field)
+	}
+	for i := 100; i < 5000; i++ {
+		field := strconv.Itoa(i)
+		s.HDel("hash", field)
+		s.SRem("set", field)
+		s.ZRem("zset", field)
+	}
+
+	finishResize(s)
+	running, resized, reclaimed := s.ResizeStats()
+	if running || resized != 3 || reclaimed <= 0 {
+		t.Errorf("Expected three finished resizes, got %v, %d, %d", running, resized, reclaimed)
+	}
+	for _, key := range []string{"hash", "set", "zset"} {
+		obj, _ := s.data.get(key)
+		if collectionDict(obj).shrinkable() {
+			t.Errorf("Expected %s to be resized", key)
+		}
+	}
+	if n, _ := s.HLen("hash"); n != 100 {
+		t.Errorf("Expected 100 fields, got %d", n)
+	}
+	if !s.SIsMember("set", "99") {
+		t.Error("Expected set members to survive the resize")
+	}
+	if rank, _ := s.ZRank("zset", "99"); rank != 99 {
+		t.Errorf("Expected rank 99, got %d", rank)
+	}
+}
+
+func TestResizeKeyspace(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 5000; i++ {
+		s.SetWithExpiry(strconv.Itoa(i), "v", time.Now().Add(time.Hour))
+	}
+	for i := 50; i < 5000; i++ {
+		s.Delete(strconv.Itoa(i))
+	}
+
+	s.Resize(0)
+	s.Set("new", "v")
+	s.Delete("0")
+	finishResize(s)
+
+	if _, resized, _ := s.ResizeStats(); resized != 2 {
+		t.Errorf("Expected both keyspace maps resized, got %d", resized)
+	}
+	if s.Size() != 50 || !s.Exists("49") || !s.Exists("new") || s.Exists("0") {
+		t.Errorf("Unexpected keys after the resize: %v", s.Keys())
+	}
+
+	// Flushing the keyspace mid-resize abandons it.
+	for i := 0; i < 5000; i++ {
+		s.Set(strconv.Itoa(i), "v")
+	}
+	for i := 0; i < 5000; i++ {
+		s.Delete(strconv.Itoa(i))
+	}
+	s.Resize(0)
+	s.FlushDB()
+	finishResize(s)
+	if running, _, _ := s.ResizeStats(); running {
+		t.Error("Expected no resize after a flush")
+	}
+}
+
+func TestResizeWaitsForSnapshots(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 5000; i++ {
+		s.SAdd("set", strconv.Itoa(i))
+	}
+	snapshot := s.BeginSnapshot()
+	for i := 10; i < 5000; i++ {
+		s.SRem("set", strconv.Itoa(i))
+	}
+	// The removals cloned the set, so only the live copy is queued.
+	obj, _ := s.data.get("set")
+	snapshot.Release()
+
+	snapshot = s.BeginSnapshot()
+	if s.Resize(time.Second) {
+		t.Error("Expected no resize of a set an open snapshot may read")
+	}
+	if obj.Ptr.(*Set).members.old != nil {
+		t.Error("A shared set should not be resized")
+	}
+	snapshot.Release()
+
+	finishResize(s)
+	if collectionDict(obj).shrinkable() {
+		t.Error("Expected the set to be resized once the snapshot is released")
+	}
+}
+
+func TestResizeKeyspaceWaitsForSnapshots(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 5000; i++ {
+		s.Set(strconv.Itoa(i), "v")
+	}
+	for i := 50; i < 5000; i++ {
+		s.Delete(strconv.Itoa(i))
+	}
+
+	s.Resize(0)
+	snapshot := s.BeginSnapshot()
+	if s.Resize(time.Second) {
+		t.Error("Expected no resize of a keyspace an open snapshot reads")
+	}
+	s.Delete("0")
+	s.Set("new", "v")
+	if view := snapshot.Store(); view.Size() != 50 || !view.Exists("0") {
+		t.Errorf("Expected the snapshot to keep its 50 keys, got %d", view.Size())
+	}
+	snapshot.Release()
+
+	finishResize(s)
+	if _, resized, _ := s.ResizeStats(); resized != 1 {
+		t.Errorf("Expected the resize to finish once the snapshot is released, got %d", resized)
+	}
+	if s.Size() != 50 || s.Exists("0") || !s.Exists("new") || !s.Exists("49") {
+		t.Errorf("Unexpected keys after the resize: %d", s.Size())
+	}
+}
+
+// rss returns the resident set size of the process, read from procfs.
+func rss(t *testing.T) int64 {
+	runtime.GC()
+	debug.FreeOSMemory()
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		t.Skipf("Cannot read RSS: %v", err)
+	}
+	fields := strings.Fields(string(statm))
+	pages, _ := strconv.ParseInt(fields[1], 10, 64)
+	return pages * int64(os.Getpagesize())
+}
+
+func TestResizeRecoversRSS(t *testing.T) {
+	const fields = 500000
+	s := NewStore()
+	for i := 0; i < fields; i++ {
+		s.HSet("hash", strconv.Itoa(i), "v")
+	}
+	for i := 1000; i < fields; i++ {
+		s.HDel("hash", strconv.Itoa(i))
+	}
+
+	before := rss(t)
+	finishResize(s)
+	after := rss(t)
+
+	_, _, reclaimed := s.ResizeStats()
+	t.Logf("RSS went from %d to %d bytes, %d estimated reclaimed", before, after, reclaimed)
+	if before-after < reclaimed/2 {
+		t.Errorf("Expected RSS to drop by about %d bytes, went from %d to %d", reclaimed, before, after)
+	}
+	if n, _ := s.HLen("hash"); n != 1000 {
+		t.Errorf("Expected 1000 fields, got %d", n)
+	}
+}
@@ -1,20 +1,19 @@
 package store
 
 type Set struct {
-	members map[string]struct{}
+	members dict[struct{}]
 }
 
 func NewSet() *Set {
 	return &Set{
-		members: make(map[string]struct{}),
+		members: newDict[struct{}](0),
 	}
 }
 
 func (s *Set) Add(members ...string) int {
 	added := 0
 	for _, member := range members {
-		if _, exists := s.members[member]; !exists {
-			s.members[member] = struct{}{}
+		if s.members.set(member, struct{}{}) {
 			added++
 		}
 	}
@@ -24,8 +23,7 @@ func (s *Set) Add(members ...string) int {
 func (s *Set) Remove(members ...string) int {
 	removed := 0
 	for _, member := range members {
-		if _, exists := s.members[member]; exists {
-			delete(s.members, member)
+		if s.members.del(member) {
 			removed++
 		}
 	}
@@ -33,31 +31,35 @@ func (s *Set) Remove(members ...string) int {
 }
 
 func (s *Set) IsMember(member string) bool {
-	_, exists := s.members[member]
+	_, exists := s.members.get(member)
 	return exists
 }
 
 func (s *Set) Members() []string {
-	result := make([]string, 0, len(s.members))
-	for member := range s.members {
+	result := make([]string, 0, s.members.len())
+	for member := range s.members.all() {
 		result = append(result, member)
 	}
 	return result
 }
 
 func (s *Set) Card() int {
-	return len(s.members)
+	return s.members.len()
 }
 
 func (s *Set) Pop() (string, bool) {
-	if len(s.members) == 0 {
+	if s.members.len() == 0 {
 		return "", false
 	}
 
-	for member := range s.members {
-		delete(s.members, member)
+	for member := range s.members.all() {
+		s.members.del(member)
 		return member, true
 	}
 
 	return "", false
 }
+
+func (s *Set) Clone() *Set {
+	return &Set{members: s.members.clone()}
+}
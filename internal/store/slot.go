@@ -0,0 +1,113 @@
+package store
+
+import "strings"
+
+// ClusterSlots is the number of hash slots keys are sharded over in cluster
+// mode.
+const ClusterSlots = 16384
+
+// crc16Table holds the CRC16-CCITT (XMODEM) of every byte value.
+var crc16Table = func() (table [256]uint16) {
+	for i := range table {
+		crc := uint16(i) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// KeySlot returns the hash slot of key. Only the part between the first {
+// and the next } is hashed when it is not empty, so that keys sharing such
+// a hash tag land in the same slot.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) & (ClusterSlots - 1)
+}
+
+// slotIndex tracks the keys in every hash slot, for cluster mode.
+type slotIndex [ClusterSlots]map[string]struct{}
+
+func (x *slotIndex) add(key string) {
+	slot := KeySlot(key)
+	if x[slot] == nil {
+		x[slot] = make(map[string]struct{})
+	}
+	x[slot][key] = struct{}{}
+}
+
+func (x *slotIndex) remove(key string) {
+	slot := KeySlot(key)
+	delete(x[slot], key)
+	if len(x[slot]) == 0 {
+		x[slot] = nil
+	}
+}
+
+// TrackSlots makes the store index its keys by hash slot, as cluster mode
+// needs to count and list the keys of a slot.
+func (s *Store) TrackSlots() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.slots = &slotIndex{}
+	for key := range s.data.all() {
+		s.slots.add(key)
+	}
+}
+
+func (s *Store) CountKeysInSlot(slot int) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.slots == nil {
+		return 0
+	}
+	return len(s.slots[slot])
+}
+
+func (s *Store) GetKeysInSlot(slot, count int) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	if s.slots == nil {
+		return keys
+	}
+	for key := range s.slots[slot] {
+		if len(keys) == count {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// setKey and delKey change the keyspace, keeping the slot index in step.
+func (s *Store) setKey(key string, obj *RedisObject) {
+	if s.data.set(key, obj) && s.slots != nil {
+		s.slots.add(key)
+	}
+}
+
+func (s *Store) delKey(key string) {
+	if s.data.del(key) && s.slots != nil {
+		s.slots.remove(key)
+	}
+}
@@ -0,0 +1,54 @@
+package store
+
+import "testing"
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"", 0},
+		// The check value of CRC16-XMODEM in the cluster specification is
+		// 0x31C3, which is slot 12739.
+		{"123456789", 12739},
+		{"user1000", 3443},
+		{"{user1000}.following", 3443},
+		{"{}foo", 9500},
+		{"foo{}{bar}", 8363},
+		{"foo{{bar}}", 4015},
+	}
+	for _, tt := range tests {
+		if got := KeySlot(tt.key); got != tt.slot {
+			t.Errorf("KeySlot(%q) = %d, want %d", tt.key, got, tt.slot)
+		}
+	}
+	if KeySlot("{user1000}.following") != KeySlot("{user1000}.followers") {
+		t.Error("Expected keys sharing a hash tag to share a slot")
+	}
+}
+
+func TestSlotIndex(t *testing.T) {
+	s := NewStore()
+	s.TrackSlots()
+	s.Set("foo", "1")
+	s.Set("{foo}.a", "2")
+	s.Set("bar", "3")
+
+	if count := s.CountKeysInSlot(12182); count != 2 {
+		t.Errorf("Expected 2 keys in slot 12182, got %d", count)
+	}
+	if keys := s.GetKeysInSlot(12182, 1); len(keys) != 1 {
+		t.Errorf("Expected the count to be honored, got %v", keys)
+	}
+
+	s.Delete("foo")
+	if keys := s.GetKeysInSlot(12182, 10); len(keys) != 1 || keys[0] != "{foo}.a" {
+		t.Errorf("Expected only {foo}.a left in slot 12182, got %v", keys)
+	}
+	s.FlushDB()
+	if count := s.CountKeysInSlot(5061); count != 0 {
+		t.Errorf("Expected the index to be cleared by FLUSHDB, got %d", count)
+	}
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// Snapshot is a consistent, read-only view of the store taken by
+// BeginSnapshot. Taking one copies nothing: the key maps are frozen, and
+// the live store keeps its changes apart until the last snapshot is
+// released. The objects are shared too, and the live store clones an object
+// the first time it is mutated while a view that may reference it is open
+// (copy-on-write at object granularity, the same trade-off fork() makes at
+// page granularity).
+type Snapshot struct {
+	owner   *Store
+	data    dict[*RedisObject]
+	expires dict[time.Time]
+	release sync.Once
+}
+
+func (s *Store) BeginSnapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := &Snapshot{
+		owner:   s,
+		data:    s.data.freeze(),
+		expires: s.expires.freeze(),
+	}
+
+	// Objects stamped with an older epoch may now be referenced by this
+	// snapshot and must be cloned before they are changed.
+	s.snapshotEpoch++
+	s.openSnapshots++
+	return snapshot
+}
+
+// Len returns the number of keys in the snapshot whose TTL has not passed.
+func (sn *Snapshot) Len() int {
+	return sn.Store().Size()
+}
+
+// Store returns a detached store holding the snapshot's keys, for passing to
+// the persistence writers. Keys whose TTL has passed read as missing, and
+// what the store expires or is written to stays out of the snapshot.
+func (sn *Snapshot) Store() *Store {
+	detached := NewStore()
+	detached.data = sn.data
+	detached.data.freeze()
+	detached.expires = sn.expires
+	detached.expires.freeze()
+	return detached
+}
+
+// Release tells the live store the snapshot is no longer read, so it can
+// stop cloning objects on write. It is safe to call more than once.
+func (sn *Snapshot) Release() {
+	sn.release.Do(func() {
+		sn.owner.mu.Lock()
+		defer sn.owner.mu.Unlock()
+		sn.owner.openSnapshots--
+		if sn.owner.openSnapshots == 0 {
+			sn.owner.data.thaw()
+			sn.owner.expires.thaw()
+		}
+	})
+}
+
+// writable returns an object for key that may be mutated in place, cloning
+// it first if an open snapshot might still reference it. Callers must hold
+// the write lock.
+func (s *Store) writable(key string, obj *RedisObject) *RedisObject {
+	if !s.shared(obj) {
+		return obj
+	}
+
+	clone := *obj
+	clone.epoch = s.snapshotEpoch
+	switch ptr := obj.Ptr.(type) {
+	case *Quicklist:
+		clone.Ptr = ptr.Clone()
+	case *HashTable:
+		clone.Ptr = ptr.Clone()
+	case *Set:
+		clone.Ptr = ptr.Clone()
+	case *ZSet:
+		clone.Ptr = ptr.Clone()
+	}
+	s.setKey(key, &clone)
+	return &clone
+}
+
+// shared reports whether an open snapshot might reference obj.
+func (s *Store) shared(obj *RedisObject) bool {
+	return s.openSnapshots > 0 && obj.epoch != s.snapshotEpoch
+}
+
+// lookupWrite returns the live object for key ready to be mutated, expiring
+// it first if its TTL has passed. Callers must hold the write lock.
+func (s *Store) lookupWrite(key string) (*RedisObject, bool) {
+	if s.expireIfNeeded(key) {
+		return nil, false
+	}
+
+	obj, exists := s.data.get(key)
+	if !exists {
+		return nil, false
+	}
+	return s.writable(key, obj), true
+}
+
+func (s *Store) newObject(objType ObjectType, encoding ObjectEncoding, ptr any) *RedisObject {
+	return &RedisObject{
+		Type:     objType,
+		Encoding: encoding,
+		Ptr:      ptr,
+		epoch:    s.snapshotEpoch,
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotIsolation(t *testing.T) {
+	s := NewStore()
+	s.Set("str", "before")
+	s.RPush("list", "a", "b")
+	s.HSet("hash", "f", "before")
+	s.SAdd("set", "a")
+	s.ZAdd("zset", 1, "a")
+	s.SetWithExpiry("gone", "x", time.Now().Add(-time.Second))
+
+	snapshot := s.BeginSnapshot()
+	defer snapshot.Release()
+
+	s.Set("str", "after")
+	s.RPush("list", "c")
+	s.LPop("list")
+	s.HSet("hash", "f", "after")
+	s.HSet("hash", "g", "new")
+	s.SAdd("set", "b")
+	s.ZAdd("zset", 5, "a")
+	s.ZAdd("zset", 2, "b")
+	s.Delete("str")
+	s.Set("created", "later")
+
+	view := snapshot.Store()
+	if view.Exists("created") || view.Exists("gone") {
+		t.Error("Snapshot should only hold keys that were live when it was taken")
+	}
+	if value, _ := view.Get("str"); value != "before" {
+		t.Errorf("Expected snapshot string 'before', got %q", value)
+	}
+	if list, _ := view.LRange("list", 0, -1); strings.Join(list, ",") != "a,b" {
+		t.Errorf("Expected snapshot list a,b, got %v", list)
+	}
+	if all, _ := view.HGetAll("hash"); len(all) != 1 || all["f"] != "before" {
+		t.Errorf("Expected snapshot hash {f: before}, got %v", all)
+	}
+	if members, _ := view.SMembers("set"); len(members) != 1 {
+		t.Errorf("Expected 1 snapshot set member, got %v", members)
+	}
+	if score, _ := view.ZScore("zset", "a"); score != 1 {
+		t.Errorf("Expected snapshot score 1, got %v", score)
+	}
+	if card, _ := view.ZCard("zset"); card != 1 {
+		t.Errorf("Expected 1 snapshot zset member, got %d", card)
+	}
+
+	list, _ := s.LRange("list", 0, -1)
+	if strings.Join(list, ",") != "b,c" {
+		t.Errorf("Expected live list b,c, got %v", list)
+	}
+	members, _ := s.SMembers("set")
+	sort.Strings(members)
+	if strings.Join(members, ",") != "a,b" {
+		t.Errorf("Expected live set a,b, got %v", members)
+	}
+}
+
+func TestSnapshotClonesOnlyOnce(t *testing.T) {
+	s := NewStore()
+	s.RPush("list", "a")
+
+	snapshot := s.BeginSnapshot()
+	s.RPush("list", "b")
+	first, _ := s.data.get("list")
+	s.RPush("list", "c")
+	if obj, _ := s.data.get("list"); obj != first {
+		t.Error("An object cloned for a snapshot should not be cloned again")
+	}
+
+	snapshot.Release()
+	snapshot.Release()
+	if s.openSnapshots != 0 {
+		t.Errorf("Expected no open snapshots, got %d", s.openSnapshots)
+	}
+
+	s.BeginSnapshot().Release()
+	s.RPush("list", "d")
+	if obj, _ := s.data.get("list"); obj != first {
+		t.Error("Objects should be mutated in place once snapshots are released")
+	}
+}
+
+func TestSnapshotConcurrentWrites(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 100; i++ {
+		s.RPush("list", "x")
+		s.HSet("hash", "field"+strconv.Itoa(i), "v")
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s.RPush("list", "y")
+				s.LPop("list")
+				s.HSet("hash", "extra", "w")
+				s.HDel("hash", "extra")
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		snapshot := s.BeginSnapshot()
+		view := snapshot.Store()
+		before, _ := view.LRange("list", 0, -1)
+		fields, _ := view.HGetAll("hash")
+		delete(fields, "extra")
+		time.Sleep(time.Millisecond)
+		after, _ := view.LRange("list", 0, -1)
+		if len(before) != len(after) || len(fields) != 100 {
+			t.Errorf("Snapshot changed under the reader: %d -> %d items, %d fields", len(before), len(after), len(fields))
+		}
+		snapshot.Release()
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestSnapshotsShareKeyspace(t *testing.T) {
+	s := NewStore()
+	s.Set("a", "1")
+	s.Set("b", "1")
+	s.SetWithExpiry("ttl", "1", time.Now().Add(time.Hour))
+
+	first := s.BeginSnapshot()
+	s.Set("c", "1")
+	s.Delete("a")
+	second := s.BeginSnapshot()
+	s.Set("a", "2")
+	s.Delete("b")
+	later := time.Now().Add(2 * time.Hour).Truncate(time.Millisecond)
+	s.PExpireAt("ttl", later)
+
+	if keys := first.Store().Keys(); len(keys) != 3 || first.Len() != 3 {
+		t.Errorf("Expected the first snapshot to hold a, b and ttl, got %v", keys)
+	}
+	if view := second.Store(); view.Exists("a") || !view.Exists("c") {
+		t.Error("Expected the second snapshot to see the writes made before it")
+	}
+
+	first.Release()
+	if s.data.base == nil {
+		t.Error("The keyspace should stay frozen while a snapshot is open")
+	}
+	second.Release()
+	if s.data.base != nil || s.expires.base != nil {
+		t.Error("Expected the keyspace to be thawed once every snapshot is released")
+	}
+
+	keys := s.Keys()
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "a,c,ttl" {
+		t.Errorf("Expected a, c and ttl, got %v", keys)
+	}
+	if value, _ := s.Get("a"); value != "2" {
+		t.Errorf("Expected a=2, got %q", value)
+	}
+	if _, expiry, _ := s.Object("ttl"); !expiry.Equal(later) {
+		t.Errorf("Expected the TTL set during the snapshot, got %v", expiry)
+	}
+}
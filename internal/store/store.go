@@ -44,22 +44,34 @@ type RedisObject struct {
 	LRU      uint32
 	RefCount int32
 	Ptr      any
+
+	epoch uint64
 }
 
 type KeyModifiedCallback func(key string)
 
+// KeyRemovedCallback is called for the keys the store deletes by itself,
+// because they expired or were evicted.
+type KeyRemovedCallback func(key string)
+
 type Store struct {
-	data               map[string]*RedisObject
-	expires            map[string]time.Time
+	data               dict[*RedisObject]
+	expires            dict[time.Time]
 	mu                 sync.RWMutex
 	keyModifiedHandler KeyModifiedCallback
+	keyRemovedHandler  KeyRemovedCallback
 	evictionConfig     *EvictionConfig
+	evictedKeys        int64
+	snapshotEpoch      uint64
+	openSnapshots      int
+	resize             resizeState
+	slots              *slotIndex
 }
 
 func NewStore() *Store {
 	return &Store{
-		data:    make(map[string]*RedisObject),
-		expires: make(map[string]time.Time),
+		data:    newDict[*RedisObject](0),
+		expires: newDict[time.Time](0),
 	}
 }
 
@@ -67,6 +79,10 @@ func (s *Store) SetKeyModifiedHandler(handler KeyModifiedCallback) {
 	s.keyModifiedHandler = handler
 }
 
+func (s *Store) SetKeyRemovedHandler(handler KeyRemovedCallback) {
+	s.keyRemovedHandler = handler
+}
+
 func (s *Store) SetEvictionConfig(config *EvictionConfig) {
 	s.evictionConfig = config
 }
@@ -82,11 +98,17 @@ func (s *Store) notifyKeyModified(key string) {
 	}
 }
 
+func (s *Store) notifyKeyRemoved(key string) {
+	if s.keyRemovedHandler != nil {
+		s.keyRemovedHandler(key)
+	}
+}
+
 func (s *Store) Set(key string, value string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	existingObj, keyExists := s.data[key]
+	existingObj, keyExists := s.data.get(key)
 	oldSize := int64(0)
 	if keyExists {
 		oldSize = EstimateObjectSize(existingObj) + EstimateKeySize(key)
@@ -104,7 +126,7 @@ func (s *Store) Set(key string, value string) error {
 	}
 
 	s.updateLRU(obj)
-	s.data[key] = obj
+	s.setKey(key, obj)
 	s.notifyKeyModified(key)
 	return nil
 }
@@ -113,7 +135,7 @@ func (s *Store) SetWithExpiry(key string, value string, expiry time.Time) error
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	existingObj, keyExists := s.data[key]
+	existingObj, keyExists := s.data.get(key)
 	oldSize := int64(0)
 	if keyExists {
 		oldSize = EstimateObjectSize(existingObj) + EstimateKeySize(key)
@@ -131,8 +153,8 @@ func (s *Store) SetWithExpiry(key string, value string, expiry time.Time) error
 	}
 
 	s.updateLRU(obj)
-	s.data[key] = obj
-	s.expires[key] = expiry
+	s.setKey(key, obj)
+	s.expires.set(key, expiry)
 	s.notifyKeyModified(key)
 	return nil
 }
@@ -141,11 +163,11 @@ func (s *Store) Get(key string) (string, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.isExpired(key) {
+	if s.expireIfNeeded(key) {
 		return "", false
 	}
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return "", false
 	}
@@ -167,7 +189,7 @@ func (s *Store) Exists(key string) bool {
 		return false
 	}
 
-	_, exists := s.data[key]
+	_, exists := s.data.get(key)
 	return exists
 }
 
@@ -175,7 +197,7 @@ func (s *Store) Delete(key string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return false
 	}
@@ -185,8 +207,8 @@ func (s *Store) Delete(key string) bool {
 		s.evictionConfig.currentMemory -= EstimateKeySize(key)
 	}
 
-	delete(s.data, key)
-	delete(s.expires, key)
+	s.delKey(key)
+	s.expires.del(key)
 	s.notifyKeyModified(key)
 	return true
 }
@@ -195,11 +217,11 @@ func (s *Store) SetNX(key string, value string) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.data[key]; exists && !s.isExpired(key) {
+	if _, exists := s.data.get(key); exists && !s.expireIfNeeded(key) {
 		return false, nil
 	}
 
-	existingObj, keyExists := s.data[key]
+	existingObj, keyExists := s.data.get(key)
 	oldSize := int64(0)
 	if keyExists {
 		oldSize = EstimateObjectSize(existingObj) + EstimateKeySize(key)
@@ -217,8 +239,8 @@ func (s *Store) SetNX(key string, value string) (bool, error) {
 	}
 
 	s.updateLRU(obj)
-	s.data[key] = obj
-	delete(s.expires, key)
+	s.setKey(key, obj)
+	s.expires.del(key)
 	s.notifyKeyModified(key)
 	return true, nil
 }
@@ -227,11 +249,11 @@ func (s *Store) SetXX(key string, value string) (bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.data[key]; !exists || s.isExpired(key) {
+	if _, exists := s.data.get(key); !exists || s.expireIfNeeded(key) {
 		return false, nil
 	}
 
-	existingObj := s.data[key]
+	existingObj, _ := s.data.get(key)
 	oldSize := EstimateObjectSize(existingObj) + EstimateKeySize(key)
 
 	obj := createStringObject(value)
@@ -246,7 +268,7 @@ func (s *Store) SetXX(key string, value string) (bool, error) {
 	}
 
 	s.updateLRU(obj)
-	s.data[key] = obj
+	s.setKey(key, obj)
 	s.notifyKeyModified(key)
 	return true, nil
 }
@@ -259,7 +281,7 @@ func (s *Store) GetType(key string) (ObjectType, bool) {
 		return 0, false
 	}
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return 0, false
 	}
@@ -305,10 +327,11 @@ func (s *Store) LPop(key string) (string, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	list, ok := s.getList(key)
-	if !ok {
+	obj, exists := s.lookupWrite(key)
+	if !exists || obj.Type != ObjList {
 		return "", false
 	}
+	list := obj.Ptr.(*Quicklist)
 
 	value, ok := list.PopHead()
 	if !ok {
@@ -316,8 +339,8 @@ func (s *Store) LPop(key string) (string, bool) {
 	}
 
 	if list.Len() == 0 {
-		delete(s.data, key)
-		delete(s.expires, key)
+		s.delKey(key)
+		s.expires.del(key)
 	}
 
 	s.notifyKeyModified(key)
@@ -328,10 +351,11 @@ func (s *Store) RPop(key string) (string, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	list, ok := s.getList(key)
-	if !ok {
+	obj, exists := s.lookupWrite(key)
+	if !exists || obj.Type != ObjList {
 		return "", false
 	}
+	list := obj.Ptr.(*Quicklist)
 
 	value, ok := list.PopTail()
 	if !ok {
@@ -339,8 +363,8 @@ func (s *Store) RPop(key string) (string, bool) {
 	}
 
 	if list.Len() == 0 {
-		delete(s.data, key)
-		delete(s.expires, key)
+		s.delKey(key)
+		s.expires.del(key)
 	}
 
 	s.notifyKeyModified(key)
@@ -355,7 +379,7 @@ func (s *Store) LLen(key string) (int64, error) {
 		return 0, nil
 	}
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return 0, nil
 	}
@@ -389,7 +413,7 @@ func (s *Store) getList(key string) (*Quicklist, bool) {
 		return nil, false
 	}
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return nil, false
 	}
@@ -403,20 +427,11 @@ func (s *Store) getList(key string) (*Quicklist, bool) {
 }
 
 func (s *Store) getOrCreateList(key string) (*Quicklist, error) {
-	if s.isExpired(key) {
-		delete(s.data, key)
-		delete(s.expires, key)
-	}
-
-	obj, exists := s.data[key]
+	obj, exists := s.lookupWrite(key)
 	if !exists {
 
 		list := NewQuicklist()
-		s.data[key] = &RedisObject{
-			Type:     ObjList,
-			Encoding: EncodingQuicklist,
-			Ptr:      list,
-		}
+		s.setKey(key, s.newObject(ObjList, EncodingQuicklist, list))
 		return list, nil
 	}
 
@@ -465,11 +480,7 @@ func (s *Store) HDel(key string, fields ...string) (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.isExpired(key) {
-		return 0, nil
-	}
-
-	obj, exists := s.data[key]
+	obj, exists := s.lookupWrite(key)
 	if !exists {
 		return 0, nil
 	}
@@ -491,12 +502,13 @@ func (s *Store) HDel(key string, fields ...string) (int64, error) {
 	}
 
 	if hash.Len() == 0 {
-		delete(s.data, key)
-		delete(s.expires, key)
+		s.delKey(key)
+		s.expires.del(key)
 	}
 
 	if count > 0 {
 		s.notifyKeyModified(key)
+		s.noteShrink(key, obj)
 	}
 	return count, nil
 }
@@ -521,7 +533,7 @@ func (s *Store) HLen(key string) (int64, error) {
 		return 0, nil
 	}
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return 0, nil
 	}
@@ -579,7 +591,7 @@ func (s *Store) getHash(key string) (*HashTable, bool) {
 		return nil, false
 	}
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return nil, false
 	}
@@ -593,20 +605,11 @@ func (s *Store) getHash(key string) (*HashTable, bool) {
 }
 
 func (s *Store) getOrCreateHash(key string) (*HashTable, error) {
-	if s.isExpired(key) {
-		delete(s.data, key)
-		delete(s.expires, key)
-	}
-
-	obj, exists := s.data[key]
+	obj, exists := s.lookupWrite(key)
 	if !exists {
 
 		hash := NewHashTable()
-		s.data[key] = &RedisObject{
-			Type:     ObjHash,
-			Encoding: EncodingHT,
-			Ptr:      hash,
-		}
+		s.setKey(key, s.newObject(ObjHash, EncodingHT, hash))
 		return hash, nil
 	}
 
@@ -642,11 +645,7 @@ func (s *Store) SRem(key string, members ...string) (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.isExpired(key) {
-		return 0, nil
-	}
-
-	obj, exists := s.data[key]
+	obj, exists := s.lookupWrite(key)
 	if !exists {
 		return 0, nil
 	}
@@ -663,12 +662,13 @@ func (s *Store) SRem(key string, members ...string) (int64, error) {
 	removed := set.Remove(members...)
 
 	if set.Card() == 0 {
-		delete(s.data, key)
-		delete(s.expires, key)
+		s.delKey(key)
+		s.expires.del(key)
 	}
 
 	if removed > 0 {
 		s.notifyKeyModified(key)
+		s.noteShrink(key, obj)
 	}
 	return int64(removed), nil
 }
@@ -705,7 +705,7 @@ func (s *Store) SCard(key string) (int64, error) {
 		return 0, nil
 	}
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return 0, nil
 	}
@@ -726,10 +726,11 @@ func (s *Store) SPop(key string) (string, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	set, ok := s.getSet(key)
-	if !ok {
+	obj, exists := s.lookupWrite(key)
+	if !exists || obj.Type != ObjSet {
 		return "", false
 	}
+	set := obj.Ptr.(*Set)
 
 	member, ok := set.Pop()
 	if !ok {
@@ -737,11 +738,12 @@ func (s *Store) SPop(key string) (string, bool) {
 	}
 
 	if set.Card() == 0 {
-		delete(s.data, key)
-		delete(s.expires, key)
+		s.delKey(key)
+		s.expires.del(key)
 	}
 
 	s.notifyKeyModified(key)
+	s.noteShrink(key, obj)
 	return member, true
 }
 
@@ -750,7 +752,7 @@ func (s *Store) getSet(key string) (*Set, bool) {
 		return nil, false
 	}
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return nil, false
 	}
@@ -764,20 +766,11 @@ func (s *Store) getSet(key string) (*Set, bool) {
 }
 
 func (s *Store) getOrCreateSet(key string) (*Set, error) {
-	if s.isExpired(key) {
-		delete(s.data, key)
-		delete(s.expires, key)
-	}
-
-	obj, exists := s.data[key]
+	obj, exists := s.lookupWrite(key)
 	if !exists {
 
 		set := NewSet()
-		s.data[key] = &RedisObject{
-			Type:     ObjSet,
-			Encoding: EncodingHT,
-			Ptr:      set,
-		}
+		s.setKey(key, s.newObject(ObjSet, EncodingHT, set))
 		return set, nil
 	}
 
@@ -814,11 +807,7 @@ func (s *Store) ZRem(key string, member string) (int64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.isExpired(key) {
-		return 0, nil
-	}
-
-	obj, exists := s.data[key]
+	obj, exists := s.lookupWrite(key)
 	if !exists {
 		return 0, nil
 	}
@@ -835,12 +824,13 @@ func (s *Store) ZRem(key string, member string) (int64, error) {
 	removed := zset.Remove(member)
 
 	if zset.Card() == 0 {
-		delete(s.data, key)
-		delete(s.expires, key)
+		s.delKey(key)
+		s.expires.del(key)
 	}
 
 	if removed {
 		s.notifyKeyModified(key)
+		s.noteShrink(key, obj)
 		return 1, nil
 	}
 	return 0, nil
@@ -866,7 +856,7 @@ func (s *Store) ZCard(key string) (int64, error) {
 		return 0, nil
 	}
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return 0, nil
 	}
@@ -912,7 +902,7 @@ func (s *Store) getZSet(key string) (*ZSet, bool) {
 		return nil, false
 	}
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return nil, false
 	}
@@ -926,20 +916,11 @@ func (s *Store) getZSet(key string) (*ZSet, bool) {
 }
 
 func (s *Store) getOrCreateZSet(key string) (*ZSet, error) {
-	if s.isExpired(key) {
-		delete(s.data, key)
-		delete(s.expires, key)
-	}
-
-	obj, exists := s.data[key]
+	obj, exists := s.lookupWrite(key)
 	if !exists {
 
 		zset := NewZSet()
-		s.data[key] = &RedisObject{
-			Type:     ObjZSet,
-			Encoding: EncodingSkiplist,
-			Ptr:      zset,
-		}
+		s.setKey(key, s.newObject(ObjZSet, EncodingSkiplist, zset))
 		return zset, nil
 	}
 
@@ -955,41 +936,110 @@ func (s *Store) getOrCreateZSet(key string) (*ZSet, error) {
 	return zset, nil
 }
 
+// isExpired reports whether the key's TTL has passed without touching the
+// key, so it is safe under the read lock.
 func (s *Store) isExpired(key string) bool {
-	expiry, hasExpiry := s.expires[key]
-	if !hasExpiry {
-		return false
-	}
-
-	if time.Now().After(expiry) {
+	expiry, hasExpiry := s.expires.get(key)
+	return hasExpiry && time.Now().After(expiry)
+}
 
-		delete(s.data, key)
-		delete(s.expires, key)
-		return true
+// expireIfNeeded deletes the key if its TTL has passed. Callers must hold
+// the write lock.
+func (s *Store) expireIfNeeded(key string) bool {
+	if !s.isExpired(key) {
+		return false
 	}
 
-	return false
+	s.delKey(key)
+	s.expires.del(key)
+	s.notifyKeyModified(key)
+	s.notifyKeyRemoved(key)
+	return true
 }
 
 func (s *Store) SetObject(key string, obj *RedisObject) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data[key] = obj
+	s.setKey(key, obj)
 	s.notifyKeyModified(key)
 }
 
 func (s *Store) SetObjectExpire(key string, expiry time.Time) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.expires[key] = expiry
+	s.expires.set(key, expiry)
+}
+
+// PExpireAt sets the expiry of an existing key; a time already past
+// deletes it. It reports whether the key existed.
+func (s *Store) PExpireAt(key string, expiry time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.data.get(key); !exists || s.expireIfNeeded(key) {
+		return false
+	}
+	s.expires.set(key, expiry)
+	if !s.expireIfNeeded(key) {
+		s.notifyKeyModified(key)
+	}
+	return true
+}
+
+// Object returns the object stored at key and its expiry, zero if it has
+// none. The object must not be changed.
+func (s *Store) Object(key string) (*RedisObject, time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, exists := s.data.get(key)
+	if !exists || s.isExpired(key) {
+		return nil, time.Time{}, false
+	}
+	expiry, _ := s.expires.get(key)
+	return obj, expiry, true
+}
+
+// RestoreObject stores obj at key with the given expiry, zero for none. It
+// fails when the key exists, unless replace is set. An obj with no LRU clock
+// counts as just accessed.
+func (s *Store) RestoreObject(key string, obj *RedisObject, expiry time.Time, replace bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exists := s.data.get(key)
+	if exists && !s.expireIfNeeded(key) {
+		if !replace {
+			return false
+		}
+		if s.evictionConfig != nil && s.evictionConfig.memoryTracking {
+			s.evictionConfig.currentMemory -= EstimateObjectSize(old) + EstimateKeySize(key)
+		}
+		s.delKey(key)
+		s.expires.del(key)
+	}
+
+	obj.epoch = s.snapshotEpoch
+	if obj.LRU == 0 {
+		s.updateLRU(obj)
+	}
+	s.setKey(key, obj)
+	if !expiry.IsZero() {
+		s.expires.set(key, expiry)
+	}
+	if s.evictionConfig != nil && s.evictionConfig.memoryTracking {
+		s.evictionConfig.currentMemory += EstimateObjectSize(obj) + EstimateKeySize(key)
+	}
+	s.notifyKeyModified(key)
+	return true
 }
 
 func (s *Store) Keys() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	keys := make([]string, 0, len(s.data))
-	for key := range s.data {
+	keys := make([]string, 0, s.data.len())
+	for key := range s.data.all() {
 		if !s.isExpired(key) {
 			keys = append(keys, key)
 		}
@@ -1002,7 +1052,7 @@ func (s *Store) Size() int {
 	defer s.mu.RUnlock()
 
 	count := 0
-	for key := range s.data {
+	for key := range s.data.all() {
 		if !s.isExpired(key) {
 			count++
 		}
@@ -1014,13 +1064,13 @@ func (s *Store) Snapshot() (map[string]*RedisObject, map[string]time.Time) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	dataCopy := make(map[string]*RedisObject, len(s.data))
-	expiresCopy := make(map[string]time.Time, len(s.expires))
+	dataCopy := make(map[string]*RedisObject, s.data.len())
+	expiresCopy := make(map[string]time.Time, s.expires.len())
 
-	for key, obj := range s.data {
+	for key, obj := range s.data.all() {
 		if !s.isExpired(key) {
 			dataCopy[key] = obj
-			if exp, ok := s.expires[key]; ok {
+			if exp, ok := s.expires.get(key); ok {
 				expiresCopy[key] = exp
 			}
 		}
@@ -1033,16 +1083,33 @@ func (s *Store) RestoreSnapshot(data map[string]*RedisObject, expires map[string
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.data = data
-	s.expires = expires
+	s.replaceKeyspace(dictOf(data), dictOf(expires))
 }
 
 func (s *Store) FlushDB() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.data = make(map[string]*RedisObject)
-	s.expires = make(map[string]time.Time)
+	for key := range s.data.all() {
+		s.notifyKeyModified(key)
+	}
+
+	s.replaceKeyspace(newDict[*RedisObject](0), newDict[time.Time](0))
+}
+
+// Replace swaps the keys of the store for those of other, as when a replica
+// loads the dataset sent by its master. other must not be used afterwards.
+func (s *Store) Replace(other *Store) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.data.all() {
+		s.notifyKeyModified(key)
+	}
+	s.replaceKeyspace(other.data, other.expires)
+	for key := range s.data.all() {
+		s.notifyKeyModified(key)
+	}
 }
 
 func createStringObject(value string) *RedisObject {
@@ -1089,7 +1156,7 @@ func (s *Store) GetEncoding(key string) (ObjectEncoding, bool) {
 		return 0, false
 	}
 
-	obj, exists := s.data[key]
+	obj, exists := s.data.get(key)
 	if !exists {
 		return 0, false
 	}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"fmt"
 	"testing"
 	"time"
 )
@@ -1197,3 +1198,62 @@ func TestStoreZSetWrongType(t *testing.T) {
 		t.Error("Expected error when getting cardinality of non-zset key")
 	}
 }
+
+func TestKeyModifiedOnExpiry(t *testing.T) {
+	store := NewStore()
+	store.SetWithExpiry("session", "value", time.Now().Add(-time.Second))
+
+	var modified []string
+	store.SetKeyModifiedHandler(func(key string) {
+		modified = append(modified, key)
+	})
+
+	store.Get("session")
+
+	if len(modified) != 1 || modified[0] != "session" {
+		t.Errorf("Expected expiry to notify 'session', got %v", modified)
+	}
+}
+
+func TestKeyModifiedOnFlushDB(t *testing.T) {
+	store := NewStore()
+	store.Set("a", "1")
+	store.Set("b", "2")
+
+	modified := make(map[string]bool)
+	store.SetKeyModifiedHandler(func(key string) {
+		modified[key] = true
+	})
+
+	store.FlushDB()
+
+	if len(modified) != 2 || !modified["a"] || !modified["b"] {
+		t.Errorf("Expected FLUSHDB to notify every key, got %v", modified)
+	}
+}
+
+func TestStoreKeyRemovedHandler(t *testing.T) {
+	s := NewStore()
+	var removed []string
+	s.SetKeyRemovedHandler(func(key string) { removed = append(removed, key) })
+
+	s.SetWithExpiry("expired", "value", time.Now().Add(-time.Second))
+	s.Set("kept", "value")
+	s.Delete("kept")
+	if len(removed) != 0 {
+		t.Errorf("Expected no key removed before a write touches it, got %v", removed)
+	}
+	s.LPush("expired", "element")
+	if len(removed) != 1 || removed[0] != "expired" {
+		t.Errorf("Expected the expired key to be reported, got %v", removed)
+	}
+
+	removed = nil
+	s.SetEvictionConfig(NewEvictionConfig(300, EvictionAllKeysRandom, 5))
+	for i := 0; i < 10; i++ {
+		s.Set(fmt.Sprintf("key%d", i), string(make([]byte, 50)))
+	}
+	if int64(len(removed)) != s.EvictedKeys() || len(removed) == 0 {
+		t.Errorf("Expected every evicted key to be reported, got %d reported and %d evicted", len(removed), s.EvictedKeys())
+	}
+}
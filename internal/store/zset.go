@@ -1,57 +1,57 @@
 package store
 
 type ZSet struct {
-	dict map[string]float64
+	dict dict[float64]
 	zsl  *skiplist
 }
 
 func NewZSet() *ZSet {
 	return &ZSet{
-		dict: make(map[string]float64),
+		dict: newDict[float64](0),
 		zsl:  newSkiplist(),
 	}
 }
 
 func (zs *ZSet) Add(score float64, member string) bool {
-	oldScore, exists := zs.dict[member]
+	oldScore, exists := zs.dict.get(member)
 
 	if exists {
 		if oldScore != score {
 
 			zs.zsl.delete(oldScore, member)
 			zs.zsl.insert(score, member)
-			zs.dict[member] = score
+			zs.dict.set(member, score)
 		}
 		return false
 	}
 
 	zs.zsl.insert(score, member)
-	zs.dict[member] = score
+	zs.dict.set(member, score)
 	return true
 }
 
 func (zs *ZSet) Remove(member string) bool {
-	score, exists := zs.dict[member]
+	score, exists := zs.dict.get(member)
 	if !exists {
 		return false
 	}
 
 	zs.zsl.delete(score, member)
-	delete(zs.dict, member)
+	zs.dict.del(member)
 	return true
 }
 
 func (zs *ZSet) Score(member string) (float64, bool) {
-	score, exists := zs.dict[member]
+	score, exists := zs.dict.get(member)
 	return score, exists
 }
 
 func (zs *ZSet) Card() int {
-	return len(zs.dict)
+	return zs.dict.len()
 }
 
 func (zs *ZSet) Rank(member string) (int64, bool) {
-	score, exists := zs.dict[member]
+	score, exists := zs.dict.get(member)
 	if !exists {
 		return -1, false
 	}
@@ -81,3 +81,12 @@ type ZSetMember struct {
 	Member string
 	Score  float64
 }
+
+func (zs *ZSet) Clone() *ZSet {
+	clone := NewZSet()
+	for node := zs.zsl.first(); node != nil; node = node.level[0].forward {
+		clone.zsl.insert(node.score, node.member)
+		clone.dict.set(node.member, node.score)
+	}
+	return clone
+}